@@ -0,0 +1,576 @@
+package wasi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// Accounting wraps a System to account for the resources used by a guest:
+// the calls made to each method of the system, the number of bytes read and
+// written on stdio, files and sockets, the peak number of file descriptors
+// opened by the guest, and the wall and CPU time elapsed until the system is
+// closed. The accounting is retrieved with the Report method of the returned
+// system.
+//
+// Like TraceSummary, the system is not safe to use concurrently, Report must
+// not be called while other methods of the system are running.
+func Accounting(s System) *AccountingSystem {
+	return &AccountingSystem{
+		system:   s,
+		calls:    make(map[string]int),
+		fds:      make(map[FD]struct{}),
+		start:    time.Now(),
+		startCPU: cpuTime(),
+	}
+}
+
+// AccountingSystem is the System returned by Accounting.
+type AccountingSystem struct {
+	system   System
+	calls    map[string]int
+	fds      map[FD]struct{}
+	peakFDs  int
+	stdio    IOBytes
+	files    IOBytes
+	sockets  IOBytes
+	start    time.Time
+	startCPU time.Duration
+	wallTime time.Duration
+	cpuTime  time.Duration
+	closed   bool
+}
+
+var _ System = (*AccountingSystem)(nil)
+
+// AccountingReport is the report of the resources used by a guest, returned
+// by AccountingSystem.Report.
+type AccountingReport struct {
+	// Calls is the number of calls made to each method of the system.
+	Calls map[string]int `json:"calls"`
+	// PeakOpenFDs is the maximum number of file descriptors that the guest
+	// had opened at the same time; the file descriptors that the guest was
+	// started with are not counted.
+	PeakOpenFDs int `json:"peakOpenFDs"`
+	// Bytes read and written on stdio (file descriptors 0, 1 and 2), sockets
+	// and other files.
+	Stdio   IOBytes `json:"stdio"`
+	Files   IOBytes `json:"files"`
+	Sockets IOBytes `json:"sockets"`
+	// WallTime is the time elapsed between the creation of the system and
+	// the first call to Close, or the call to Report if the system is still
+	// open. CPUTime is the user and system CPU time of the host process
+	// during the same period, which includes the time spent running the
+	// guest and the host functions.
+	WallTime time.Duration `json:"wallTime"`
+	CPUTime  time.Duration `json:"cpuTime"`
+}
+
+// IOBytes is the number of bytes read and written by a guest.
+type IOBytes struct {
+	Read    uint64 `json:"read"`
+	Written uint64 `json:"written"`
+}
+
+// Report returns the accounting of the resources used by the guest.
+func (s *AccountingSystem) Report() AccountingReport {
+	r := AccountingReport{
+		Calls:       make(map[string]int, len(s.calls)),
+		PeakOpenFDs: s.peakFDs,
+		Stdio:       s.stdio,
+		Files:       s.files,
+		Sockets:     s.sockets,
+		WallTime:    s.wallTime,
+		CPUTime:     s.cpuTime,
+	}
+	for name, calls := range s.calls {
+		r.Calls[name] = calls
+	}
+	if !s.closed {
+		r.WallTime = time.Since(s.start)
+		r.CPUTime = cpuTime() - s.startCPU
+	}
+	return r
+}
+
+func (s *AccountingSystem) ArgsSizesGet(ctx context.Context) (int, int, Errno) {
+	s.calls["ArgsSizesGet"]++
+	return s.system.ArgsSizesGet(ctx)
+}
+
+func (s *AccountingSystem) ArgsGet(ctx context.Context) ([]string, Errno) {
+	s.calls["ArgsGet"]++
+	return s.system.ArgsGet(ctx)
+}
+
+func (s *AccountingSystem) EnvironSizesGet(ctx context.Context) (int, int, Errno) {
+	s.calls["EnvironSizesGet"]++
+	return s.system.EnvironSizesGet(ctx)
+}
+
+func (s *AccountingSystem) EnvironGet(ctx context.Context) ([]string, Errno) {
+	s.calls["EnvironGet"]++
+	return s.system.EnvironGet(ctx)
+}
+
+func (s *AccountingSystem) ClockResGet(ctx context.Context, id ClockID) (Timestamp, Errno) {
+	s.calls["ClockResGet"]++
+	return s.system.ClockResGet(ctx, id)
+}
+
+func (s *AccountingSystem) ClockTimeGet(ctx context.Context, id ClockID, precision Timestamp) (Timestamp, Errno) {
+	s.calls["ClockTimeGet"]++
+	return s.system.ClockTimeGet(ctx, id, precision)
+}
+
+func (s *AccountingSystem) FDAdvise(ctx context.Context, fd FD, offset FileSize, length FileSize, advice Advice) Errno {
+	s.calls["FDAdvise"]++
+	return s.system.FDAdvise(ctx, fd, offset, length, advice)
+}
+
+func (s *AccountingSystem) FDAllocate(ctx context.Context, fd FD, offset FileSize, length FileSize) Errno {
+	s.calls["FDAllocate"]++
+	return s.system.FDAllocate(ctx, fd, offset, length)
+}
+
+func (s *AccountingSystem) FDClose(ctx context.Context, fd FD) Errno {
+	s.calls["FDClose"]++
+	errno := s.system.FDClose(ctx, fd)
+	if errno == ESUCCESS {
+		delete(s.fds, fd)
+	}
+	return errno
+}
+
+func (s *AccountingSystem) FDDataSync(ctx context.Context, fd FD) Errno {
+	s.calls["FDDataSync"]++
+	return s.system.FDDataSync(ctx, fd)
+}
+
+func (s *AccountingSystem) FDStatGet(ctx context.Context, fd FD) (FDStat, Errno) {
+	s.calls["FDStatGet"]++
+	return s.system.FDStatGet(ctx, fd)
+}
+
+func (s *AccountingSystem) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags) Errno {
+	s.calls["FDStatSetFlags"]++
+	return s.system.FDStatSetFlags(ctx, fd, flags)
+}
+
+func (s *AccountingSystem) FDStatSwapNonBlock(ctx context.Context, fd FD, nonBlock bool) (bool, Errno) {
+	s.calls["FDStatSwapNonBlock"]++
+	return s.system.FDStatSwapNonBlock(ctx, fd, nonBlock)
+}
+
+func (s *AccountingSystem) FDStatSetRights(ctx context.Context, fd FD, rightsBase, rightsInheriting Rights) Errno {
+	s.calls["FDStatSetRights"]++
+	return s.system.FDStatSetRights(ctx, fd, rightsBase, rightsInheriting)
+}
+
+func (s *AccountingSystem) FDFileStatGet(ctx context.Context, fd FD) (FileStat, Errno) {
+	s.calls["FDFileStatGet"]++
+	return s.system.FDFileStatGet(ctx, fd)
+}
+
+func (s *AccountingSystem) FDFileStatSetSize(ctx context.Context, fd FD, size FileSize) Errno {
+	s.calls["FDFileStatSetSize"]++
+	return s.system.FDFileStatSetSize(ctx, fd, size)
+}
+
+func (s *AccountingSystem) FDFileStatSetTimes(ctx context.Context, fd FD, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	s.calls["FDFileStatSetTimes"]++
+	return s.system.FDFileStatSetTimes(ctx, fd, accessTime, modifyTime, flags)
+}
+
+func (s *AccountingSystem) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	s.calls["FDPread"]++
+	n, errno := s.system.FDPread(ctx, fd, iovecs, offset)
+	if errno == ESUCCESS {
+		s.countRead(ctx, fd, n)
+	}
+	return n, errno
+}
+
+func (s *AccountingSystem) FDPreStatGet(ctx context.Context, fd FD) (PreStat, Errno) {
+	s.calls["FDPreStatGet"]++
+	return s.system.FDPreStatGet(ctx, fd)
+}
+
+func (s *AccountingSystem) FDPreStatDirName(ctx context.Context, fd FD) (string, Errno) {
+	s.calls["FDPreStatDirName"]++
+	return s.system.FDPreStatDirName(ctx, fd)
+}
+
+func (s *AccountingSystem) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	s.calls["FDPwrite"]++
+	n, errno := s.system.FDPwrite(ctx, fd, iovecs, offset)
+	if errno == ESUCCESS {
+		s.countWritten(ctx, fd, n)
+	}
+	return n, errno
+}
+
+func (s *AccountingSystem) FDCopyRange(ctx context.Context, srcFD, dstFD FD, srcOffset, dstOffset, length FileSize) (FileSize, Errno) {
+	s.calls["FDCopyRange"]++
+	return s.system.FDCopyRange(ctx, srcFD, dstFD, srcOffset, dstOffset, length)
+}
+
+func (s *AccountingSystem) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	s.calls["FDRead"]++
+	n, errno := s.system.FDRead(ctx, fd, iovecs)
+	if errno == ESUCCESS {
+		s.countRead(ctx, fd, n)
+	}
+	return n, errno
+}
+
+func (s *AccountingSystem) FDReadDir(ctx context.Context, fd FD, entries []DirEntry, cookie DirCookie, bufferSizeBytes int) (int, Errno) {
+	s.calls["FDReadDir"]++
+	return s.system.FDReadDir(ctx, fd, entries, cookie, bufferSizeBytes)
+}
+
+func (s *AccountingSystem) FDRenumber(ctx context.Context, from, to FD) Errno {
+	s.calls["FDRenumber"]++
+	errno := s.system.FDRenumber(ctx, from, to)
+	if errno == ESUCCESS {
+		if _, ok := s.fds[from]; ok {
+			delete(s.fds, from)
+			s.open(to)
+		} else {
+			delete(s.fds, to)
+		}
+	}
+	return errno
+}
+
+func (s *AccountingSystem) FDSeek(ctx context.Context, fd FD, offset FileDelta, whence Whence) (FileSize, Errno) {
+	s.calls["FDSeek"]++
+	return s.system.FDSeek(ctx, fd, offset, whence)
+}
+
+func (s *AccountingSystem) FDSync(ctx context.Context, fd FD) Errno {
+	s.calls["FDSync"]++
+	return s.system.FDSync(ctx, fd)
+}
+
+func (s *AccountingSystem) FDTell(ctx context.Context, fd FD) (FileSize, Errno) {
+	s.calls["FDTell"]++
+	return s.system.FDTell(ctx, fd)
+}
+
+func (s *AccountingSystem) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	s.calls["FDWrite"]++
+	n, errno := s.system.FDWrite(ctx, fd, iovecs)
+	if errno == ESUCCESS {
+		s.countWritten(ctx, fd, n)
+	}
+	return n, errno
+}
+
+func (s *AccountingSystem) PathCreateDirectory(ctx context.Context, fd FD, path string) Errno {
+	s.calls["PathCreateDirectory"]++
+	return s.system.PathCreateDirectory(ctx, fd, path)
+}
+
+func (s *AccountingSystem) PathFileStatGet(ctx context.Context, fd FD, lookupFlags LookupFlags, path string) (FileStat, Errno) {
+	s.calls["PathFileStatGet"]++
+	return s.system.PathFileStatGet(ctx, fd, lookupFlags, path)
+}
+
+func (s *AccountingSystem) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	s.calls["PathFileStatSetTimes"]++
+	return s.system.PathFileStatSetTimes(ctx, fd, lookupFlags, path, accessTime, modifyTime, flags)
+}
+
+func (s *AccountingSystem) PathGetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, buffer []byte) (int, Errno) {
+	s.calls["PathGetXattr"]++
+	return s.system.PathGetXattr(ctx, fd, lookupFlags, path, name, buffer)
+}
+
+func (s *AccountingSystem) PathListXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, buffer []byte) (int, Errno) {
+	s.calls["PathListXattr"]++
+	return s.system.PathListXattr(ctx, fd, lookupFlags, path, buffer)
+}
+
+func (s *AccountingSystem) PathSetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, value []byte) Errno {
+	s.calls["PathSetXattr"]++
+	return s.system.PathSetXattr(ctx, fd, lookupFlags, path, name, value)
+}
+
+func (s *AccountingSystem) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
+	s.calls["PathLink"]++
+	return s.system.PathLink(ctx, oldFD, oldFlags, oldPath, newFD, newPath)
+}
+
+func (s *AccountingSystem) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
+	s.calls["PathOpen"]++
+	newFD, errno := s.system.PathOpen(ctx, fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+	if errno == ESUCCESS {
+		s.open(newFD)
+	}
+	return newFD, errno
+}
+
+func (s *AccountingSystem) PathReadLink(ctx context.Context, fd FD, path string, buffer []byte) (int, Errno) {
+	s.calls["PathReadLink"]++
+	return s.system.PathReadLink(ctx, fd, path, buffer)
+}
+
+func (s *AccountingSystem) PathRemoveDirectory(ctx context.Context, fd FD, path string) Errno {
+	s.calls["PathRemoveDirectory"]++
+	return s.system.PathRemoveDirectory(ctx, fd, path)
+}
+
+func (s *AccountingSystem) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD, newPath string) Errno {
+	s.calls["PathRename"]++
+	return s.system.PathRename(ctx, fd, oldPath, newFD, newPath)
+}
+
+func (s *AccountingSystem) PathSymlink(ctx context.Context, oldPath string, fd FD, newPath string) Errno {
+	s.calls["PathSymlink"]++
+	return s.system.PathSymlink(ctx, oldPath, fd, newPath)
+}
+
+func (s *AccountingSystem) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
+	s.calls["PathUnlinkFile"]++
+	return s.system.PathUnlinkFile(ctx, fd, path)
+}
+
+func (s *AccountingSystem) PollOneOff(ctx context.Context, subscriptions []Subscription, events []Event) (int, Errno) {
+	s.calls["PollOneOff"]++
+	return s.system.PollOneOff(ctx, subscriptions, events)
+}
+
+func (s *AccountingSystem) ProcExit(ctx context.Context, exitCode ExitCode) Errno {
+	s.calls["ProcExit"]++
+	return s.system.ProcExit(ctx, exitCode)
+}
+
+func (s *AccountingSystem) ProcRaise(ctx context.Context, signal Signal) Errno {
+	s.calls["ProcRaise"]++
+	return s.system.ProcRaise(ctx, signal)
+}
+
+func (s *AccountingSystem) SchedYield(ctx context.Context) Errno {
+	s.calls["SchedYield"]++
+	return s.system.SchedYield(ctx)
+}
+
+func (s *AccountingSystem) RandomGet(ctx context.Context, b []byte) Errno {
+	s.calls["RandomGet"]++
+	return s.system.RandomGet(ctx, b)
+}
+
+func (s *AccountingSystem) SockOpen(ctx context.Context, family ProtocolFamily, socketType SocketType, protocol Protocol, rightsBase, rightsInheriting Rights) (FD, Errno) {
+	s.calls["SockOpen"]++
+	newFD, errno := s.system.SockOpen(ctx, family, socketType, protocol, rightsBase, rightsInheriting)
+	if errno == ESUCCESS {
+		s.open(newFD)
+	}
+	return newFD, errno
+}
+
+func (s *AccountingSystem) SockBind(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
+	s.calls["SockBind"]++
+	return s.system.SockBind(ctx, fd, addr)
+}
+
+func (s *AccountingSystem) SockConnect(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
+	s.calls["SockConnect"]++
+	return s.system.SockConnect(ctx, fd, addr)
+}
+
+func (s *AccountingSystem) SockListen(ctx context.Context, fd FD, backlog int) Errno {
+	s.calls["SockListen"]++
+	return s.system.SockListen(ctx, fd, backlog)
+}
+
+func (s *AccountingSystem) SockAccept(ctx context.Context, fd FD, flags FDFlags) (FD, SocketAddress, SocketAddress, Errno) {
+	s.calls["SockAccept"]++
+	newFD, addr, peer, errno := s.system.SockAccept(ctx, fd, flags)
+	if errno == ESUCCESS {
+		s.open(newFD)
+	}
+	return newFD, addr, peer, errno
+}
+
+func (s *AccountingSystem) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, Errno) {
+	s.calls["SockRecv"]++
+	n, rflags, errno := s.system.SockRecv(ctx, fd, iovecs, flags)
+	if errno == ESUCCESS {
+		s.countRead(ctx, fd, n)
+	}
+	return n, rflags, errno
+}
+
+func (s *AccountingSystem) SockSend(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags) (Size, Errno) {
+	s.calls["SockSend"]++
+	n, errno := s.system.SockSend(ctx, fd, iovecs, flags)
+	if errno == ESUCCESS {
+		s.countWritten(ctx, fd, n)
+	}
+	return n, errno
+}
+
+func (s *AccountingSystem) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags, addr SocketAddress) (Size, Errno) {
+	s.calls["SockSendTo"]++
+	n, errno := s.system.SockSendTo(ctx, fd, iovecs, flags, addr)
+	if errno == ESUCCESS {
+		s.countWritten(ctx, fd, n)
+	}
+	return n, errno
+}
+
+func (s *AccountingSystem) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, SocketAddress, Errno) {
+	s.calls["SockRecvFrom"]++
+	n, rflags, addr, errno := s.system.SockRecvFrom(ctx, fd, iovecs, flags)
+	if errno == ESUCCESS {
+		s.countRead(ctx, fd, n)
+	}
+	return n, rflags, addr, errno
+}
+
+func (s *AccountingSystem) SockGetOpt(ctx context.Context, fd FD, option SocketOption) (SocketOptionValue, Errno) {
+	s.calls["SockGetOpt"]++
+	return s.system.SockGetOpt(ctx, fd, option)
+}
+
+func (s *AccountingSystem) SockSetOpt(ctx context.Context, fd FD, option SocketOption, value SocketOptionValue) Errno {
+	s.calls["SockSetOpt"]++
+	return s.system.SockSetOpt(ctx, fd, option, value)
+}
+
+func (s *AccountingSystem) SockLocalAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	s.calls["SockLocalAddress"]++
+	return s.system.SockLocalAddress(ctx, fd)
+}
+
+func (s *AccountingSystem) SockRemoteAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	s.calls["SockRemoteAddress"]++
+	return s.system.SockRemoteAddress(ctx, fd)
+}
+
+func (s *AccountingSystem) SockAddressInfo(ctx context.Context, name, service string, hints AddressInfo, results []AddressInfo) (int, Errno) {
+	s.calls["SockAddressInfo"]++
+	return s.system.SockAddressInfo(ctx, name, service, hints, results)
+}
+
+func (s *AccountingSystem) SockSplice(ctx context.Context, inFD, outFD FD, maxBytes Size) (Size, Errno) {
+	s.calls["SockSplice"]++
+	return s.system.SockSplice(ctx, inFD, outFD, maxBytes)
+}
+
+func (s *AccountingSystem) FDSendFile(ctx context.Context, outFD, inFD FD, offset FileSize, count Size) (Size, Errno) {
+	s.calls["FDSendFile"]++
+	return s.system.FDSendFile(ctx, outFD, inFD, offset, count)
+}
+
+func (s *AccountingSystem) SockShutdown(ctx context.Context, fd FD, flags SDFlags) Errno {
+	s.calls["SockShutdown"]++
+	return s.system.SockShutdown(ctx, fd, flags)
+}
+
+func (s *AccountingSystem) Close(ctx context.Context) error {
+	err := s.system.Close(ctx)
+	// The system may be closed multiple times (e.g. by the host module and
+	// the application), the times are only measured on the first call.
+	if !s.closed {
+		s.closed = true
+		s.wallTime = time.Since(s.start)
+		s.cpuTime = cpuTime() - s.startCPU
+	}
+	return err
+}
+
+// WriteTo writes the report to w in a human-readable form, listing methods by
+// decreasing number of calls like TraceSummary.
+func (r *AccountingReport) WriteTo(w io.Writer) (int64, error) {
+	type count struct {
+		name  string
+		calls int
+	}
+	counts := make([]count, 0, len(r.Calls))
+	total := 0
+	for name, calls := range r.Calls {
+		counts = append(counts, count{name, calls})
+		total += calls
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].calls != counts[j].calls {
+			return counts[i].calls > counts[j].calls
+		}
+		return counts[i].name < counts[j].name
+	})
+
+	p := &countingWriter{w: w}
+	fmt.Fprintf(p, "%10s  %s\n", "calls", "function")
+	for _, c := range counts {
+		fmt.Fprintf(p, "%10d  %s\n", c.calls, c.name)
+	}
+	fmt.Fprintf(p, "%10d  %s\n", total, "total")
+	fmt.Fprintf(p, "\n%-10s %15s %15s\n", "bytes", "read", "written")
+	fmt.Fprintf(p, "%-10s %15d %15d\n", "stdio", r.Stdio.Read, r.Stdio.Written)
+	fmt.Fprintf(p, "%-10s %15d %15d\n", "files", r.Files.Read, r.Files.Written)
+	fmt.Fprintf(p, "%-10s %15d %15d\n", "sockets", r.Sockets.Read, r.Sockets.Written)
+	fmt.Fprintf(p, "\npeak open fds: %d\n", r.PeakOpenFDs)
+	fmt.Fprintf(p, "wall time:     %s\n", r.WallTime)
+	fmt.Fprintf(p, "cpu time:      %s\n", r.CPUTime)
+	return p.n, p.err
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
+
+func (s *AccountingSystem) open(fd FD) {
+	s.fds[fd] = struct{}{}
+	if len(s.fds) > s.peakFDs {
+		s.peakFDs = len(s.fds)
+	}
+}
+
+// bytes returns the counters of the class of file that fd refers to.
+func (s *AccountingSystem) bytes(ctx context.Context, fd FD) *IOBytes {
+	if fd <= 2 {
+		return &s.stdio
+	}
+	stat, errno := s.system.FDStatGet(ctx, fd)
+	if errno == ESUCCESS {
+		switch stat.FileType {
+		case SocketStreamType, SocketDGramType:
+			return &s.sockets
+		}
+	}
+	return &s.files
+}
+
+func (s *AccountingSystem) countRead(ctx context.Context, fd FD, n Size) {
+	s.bytes(ctx, fd).Read += uint64(n)
+}
+
+func (s *AccountingSystem) countWritten(ctx context.Context, fd FD, n Size) {
+	s.bytes(ctx, fd).Written += uint64(n)
+}
+
+func cpuTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
@@ -0,0 +1,111 @@
+package wasi_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+func TestAccounting(t *testing.T) {
+	ctx := context.Background()
+	base := newFakeSystem()
+	base.preopen(3, "/")
+	base.open(4, wasi.SocketStreamType, "")
+	base.open(0, wasi.CharacterDeviceType, strings.Repeat("x", 5))
+	base.paths["input"] = newFakeFile(wasi.RegularFileType)
+	base.paths["input"].data = make([]byte, 300)
+	base.paths["output"] = newFakeFile(wasi.RegularFileType)
+	s := wasi.Accounting(base)
+
+	// Read a file and copy it to stdout, then to the socket.
+	f, _ := s.PathOpen(ctx, 3, 0, "input", 0, wasi.FDReadRight, 0, 0)
+	g, _ := s.PathOpen(ctx, 3, 0, "output", 0, wasi.FDWriteRight, 0, 0)
+	s.PathOpen(ctx, 3, 0, "missing", 0, wasi.FDReadRight, 0, 0)
+	buffer := make([]byte, 100)
+	for i := 0; i < 3; i++ {
+		s.FDRead(ctx, f, []wasi.IOVec{buffer})
+		s.FDWrite(ctx, 1, []wasi.IOVec{buffer[:10]})
+		s.FDWrite(ctx, g, []wasi.IOVec{buffer[:20]})
+	}
+	s.SockSend(ctx, 4, []wasi.IOVec{buffer[:50]}, 0)
+	s.FDRead(ctx, 0, []wasi.IOVec{buffer[:5]})
+	s.FDClose(ctx, f)
+	s.FDClose(ctx, g)
+	h, _ := s.PathOpen(ctx, 3, 0, "input", 0, wasi.FDReadRight, 0, 0)
+	s.FDClose(ctx, h)
+
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r := s.Report()
+
+	wantCalls := map[string]int{
+		"PathOpen": 4,
+		"FDRead":   4,
+		"FDWrite":  6,
+		"SockSend": 1,
+		"FDClose":  3,
+	}
+	if len(r.Calls) != len(wantCalls) {
+		t.Errorf("wrong number of methods called: want %d, got %d (%v)", len(wantCalls), len(r.Calls), r.Calls)
+	}
+	for name, want := range wantCalls {
+		if got := r.Calls[name]; got != want {
+			t.Errorf("%s: wrong number of calls: want %d, got %d", name, want, got)
+		}
+	}
+
+	if r.PeakOpenFDs != 2 {
+		t.Errorf("wrong peak number of open file descriptors: want 2, got %d", r.PeakOpenFDs)
+	}
+	if want := (wasi.IOBytes{Read: 5, Written: 30}); r.Stdio != want {
+		t.Errorf("wrong stdio bytes: want %+v, got %+v", want, r.Stdio)
+	}
+	if want := (wasi.IOBytes{Read: 300, Written: 60}); r.Files != want {
+		t.Errorf("wrong file bytes: want %+v, got %+v", want, r.Files)
+	}
+	if want := (wasi.IOBytes{Written: 50}); r.Sockets != want {
+		t.Errorf("wrong socket bytes: want %+v, got %+v", want, r.Sockets)
+	}
+	if r.WallTime <= 0 {
+		t.Errorf("wrong wall time: %s", r.WallTime)
+	}
+
+	// The times are frozen when the system is closed.
+	if again := s.Report(); again.WallTime != r.WallTime || again.CPUTime != r.CPUTime {
+		t.Errorf("times changed after the system was closed: %s/%s -> %s/%s", r.WallTime, r.CPUTime, again.WallTime, again.CPUTime)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := r.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	want := []string{
+		"     calls  function",
+		"         6  FDWrite",
+		"         4  FDRead",
+		"         4  PathOpen",
+		"         3  FDClose",
+		"         1  SockSend",
+		"        18  total",
+		"",
+		"bytes                 read         written",
+		"stdio                    5              30",
+		"files                  300              60",
+		"sockets                  0              50",
+		"",
+		"peak open fds: 2",
+	}
+	if len(lines) < len(want) {
+		t.Fatalf("report is too short:\n%s", buf)
+	}
+	for i, line := range want {
+		if lines[i] != line {
+			t.Errorf("wrong line %d of the report: want %q, got %q", i, line, lines[i])
+		}
+	}
+}
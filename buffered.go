@@ -0,0 +1,246 @@
+package wasi
+
+import "context"
+
+// BufferedSockets wraps a System to buffer reads on stream sockets.
+//
+// Guest calls to SockRecv, SockRecvFrom and FDRead on stream sockets are
+// served from a host-side buffer of the given size, which is refilled with
+// a single call to the underlying system when it becomes empty. This reduces
+// the number of system calls made by applications which issue many small
+// reads, for example to parse framed messages.
+//
+// Reads larger than the buffer size bypass the buffer when it is empty.
+// Datagram sockets are never buffered so message boundaries and the
+// RecvDataTruncated flag are preserved. Peeking at a stream socket returns
+// buffered data without consuming it.
+//
+// Buffering means that the host may read data from the kernel before the
+// guest asked for it; data sitting in the buffer is reported as ready for
+// reading by PollOneOff.
+func BufferedSockets(system System, bufferSize int) System {
+	return &bufferedSockets{
+		System:     system,
+		bufferSize: bufferSize,
+		buffers:    make(map[FD]*socketBuffer),
+	}
+}
+
+type bufferedSockets struct {
+	System
+	bufferSize int
+	// buffers holds the buffers of stream sockets, and nil for the file
+	// descriptors known not to be stream sockets so their type is only
+	// looked up once.
+	buffers map[FD]*socketBuffer
+}
+
+type socketBuffer struct {
+	buf []byte
+	off int
+	// rights are the base rights of the socket, kept up to date so the
+	// buffered data is not served to a file descriptor which lost the
+	// right to read.
+	rights Rights
+}
+
+func (b *socketBuffer) len() int {
+	return len(b.buf) - b.off
+}
+
+func (b *socketBuffer) read(iovecs []IOVec, peek bool) (n int) {
+	off := b.off
+	for _, iov := range iovecs {
+		c := copy(iov, b.buf[off:])
+		off += c
+		n += c
+		if off == len(b.buf) {
+			break
+		}
+	}
+	if !peek {
+		b.off = off
+	}
+	return n
+}
+
+func iovecsLen(iovecs []IOVec) (n int) {
+	for _, iov := range iovecs {
+		n += len(iov)
+	}
+	return n
+}
+
+// buffer returns the buffer of fd, or nil if fd is not a stream socket. The
+// memory of the buffer is allocated when it is first filled.
+func (s *bufferedSockets) buffer(ctx context.Context, fd FD) (*socketBuffer, Errno) {
+	if b, ok := s.buffers[fd]; ok {
+		return b, ESUCCESS
+	}
+	stat, errno := s.System.FDStatGet(ctx, fd)
+	if errno != ESUCCESS {
+		return nil, errno
+	}
+	var b *socketBuffer
+	if stat.FileType == SocketStreamType {
+		b = &socketBuffer{rights: stat.RightsBase}
+	}
+	s.buffers[fd] = b
+	return b, ESUCCESS
+}
+
+// recv serves reads on buffered stream sockets. The boolean return value is
+// false if fd is not a buffered socket, in which case the caller must forward
+// the call to the underlying system.
+func (s *bufferedSockets) recv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, Errno, bool) {
+	b, _ := s.buffer(ctx, fd)
+	if b == nil {
+		return 0, ESUCCESS, false
+	}
+	peek := flags.Has(RecvPeek)
+	size := iovecsLen(iovecs)
+
+	if b.len() == 0 {
+		if size >= s.bufferSize || flags.Has(RecvWaitAll) {
+			return 0, ESUCCESS, false
+		}
+		if b.buf == nil {
+			b.buf = make([]byte, 0, s.bufferSize)
+		}
+		n, _, errno := s.System.SockRecv(ctx, fd, []IOVec{b.buf[:cap(b.buf)]}, 0)
+		if errno != ESUCCESS {
+			return 0, errno, true
+		}
+		b.buf, b.off = b.buf[:n], 0
+	}
+
+	n := b.read(iovecs, peek)
+	if flags.Has(RecvWaitAll) && n < size && !peek {
+		// The buffered data was not enough to satisfy the request, we must
+		// block on the socket until the remaining bytes have been received.
+		rest := make([]IOVec, 0, len(iovecs))
+		skip := n
+		for _, iov := range iovecs {
+			if skip >= len(iov) {
+				skip -= len(iov)
+				continue
+			}
+			rest = append(rest, iov[skip:])
+			skip = 0
+		}
+		r, _, errno := s.System.SockRecv(ctx, fd, rest, RecvWaitAll)
+		n += int(r)
+		if errno != ESUCCESS && n == 0 {
+			return 0, errno, true
+		}
+	}
+	return Size(n), ESUCCESS, true
+}
+
+func (s *bufferedSockets) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	if b := s.buffers[fd]; b != nil && b.len() > 0 {
+		return Size(b.read(iovecs, false)), ESUCCESS
+	}
+	return s.System.FDRead(ctx, fd, iovecs)
+}
+
+func (s *bufferedSockets) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, Errno) {
+	if n, errno, ok := s.recv(ctx, fd, iovecs, flags); ok {
+		return n, 0, errno
+	}
+	return s.System.SockRecv(ctx, fd, iovecs, flags)
+}
+
+func (s *bufferedSockets) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, SocketAddress, Errno) {
+	if b := s.buffers[fd]; b != nil && b.len() > 0 {
+		addr, errno := s.System.SockRemoteAddress(ctx, fd)
+		if errno != ESUCCESS {
+			return 0, 0, nil, errno
+		}
+		n, errno, _ := s.recv(ctx, fd, iovecs, flags)
+		return n, 0, addr, errno
+	}
+	return s.System.SockRecvFrom(ctx, fd, iovecs, flags)
+}
+
+func (s *bufferedSockets) SockSplice(ctx context.Context, inFD, outFD FD, maxBytes Size) (Size, Errno) {
+	b := s.buffers[inFD]
+	if b == nil || b.len() == 0 {
+		return s.System.SockSplice(ctx, inFD, outFD, maxBytes)
+	}
+	// The buffered data must be sent before the data waiting to be read from
+	// the kernel; it is written to the output socket with a regular send.
+	if !b.rights.Has(FDReadRight) {
+		return 0, ENOTCAPABLE
+	}
+	out, errno := s.buffer(ctx, outFD)
+	if errno != ESUCCESS {
+		return 0, errno
+	}
+	if out == nil {
+		return 0, ENOTSUP
+	}
+	data := b.buf[b.off:]
+	if len(data) > int(maxBytes) {
+		data = data[:maxBytes]
+	}
+	n, errno := s.System.SockSend(ctx, outFD, []IOVec{data}, 0)
+	if errno != ESUCCESS {
+		return 0, errno
+	}
+	b.off += int(n)
+	return n, ESUCCESS
+}
+
+func (s *bufferedSockets) PollOneOff(ctx context.Context, subscriptions []Subscription, events []Event) (int, Errno) {
+	// Subscriptions to read events on sockets with buffered data complete
+	// immediately; there is no need to wait on the underlying system.
+	n := 0
+	for i := range subscriptions {
+		sub := &subscriptions[i]
+		if sub.EventType != FDReadEvent {
+			continue
+		}
+		fd := sub.GetFDReadWrite().FD
+		if b := s.buffers[fd]; b != nil && b.len() > 0 && n < len(events) {
+			events[n] = Event{
+				UserData:    sub.UserData,
+				EventType:   FDReadEvent,
+				FDReadWrite: EventFDReadWrite{NBytes: FileSize(b.len())},
+			}
+			n++
+		}
+	}
+	if n > 0 {
+		return n, ESUCCESS
+	}
+	return s.System.PollOneOff(ctx, subscriptions, events)
+}
+
+func (s *bufferedSockets) FDClose(ctx context.Context, fd FD) Errno {
+	delete(s.buffers, fd)
+	return s.System.FDClose(ctx, fd)
+}
+
+func (s *bufferedSockets) FDRenumber(ctx context.Context, from, to FD) Errno {
+	errno := s.System.FDRenumber(ctx, from, to)
+	if errno == ESUCCESS {
+		if b, ok := s.buffers[from]; ok {
+			s.buffers[to] = b
+			delete(s.buffers, from)
+		} else {
+			delete(s.buffers, to)
+		}
+	}
+	return errno
+}
+
+func (s *bufferedSockets) FDStatSetRights(ctx context.Context, fd FD, rightsBase, rightsInheriting Rights) Errno {
+	errno := s.System.FDStatSetRights(ctx, fd, rightsBase, rightsInheriting)
+	if errno == ESUCCESS {
+		if b := s.buffers[fd]; b != nil {
+			b.rights = rightsBase
+		}
+	}
+	return errno
+}
@@ -0,0 +1,202 @@
+package wasi_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+// newSocketSystem returns a fakeSystem with a stream socket open on fd 3,
+// and a datagram socket on fd 4, both with data ready to be read.
+func newSocketSystem(data string) *fakeSystem {
+	s := newFakeSystem()
+	s.open(3, wasi.SocketStreamType, data)
+	s.open(4, wasi.SocketDGramType, "").datagrams = []fakeDatagram{{data: []byte(data)}}
+	return s
+}
+
+func TestBufferedSockets(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("small reads are served from the buffer", func(t *testing.T) {
+		r := newSocketSystem("0123456789abcdef")
+		s := wasi.BufferedSockets(r, 64)
+
+		var got []byte
+		for {
+			b := make([]byte, 3)
+			n, _, errno := s.SockRecv(ctx, 3, []wasi.IOVec{b}, 0)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if n == 0 {
+				break
+			}
+			got = append(got, b[:n]...)
+		}
+		if string(got) != "0123456789abcdef" {
+			t.Errorf("wrong data: %q", got)
+		}
+		if r.calls["SockRecv"] != 2 { // one read for the data, one read for EOF
+			t.Errorf("wrong number of calls to SockRecv: %d", r.calls["SockRecv"])
+		}
+	})
+
+	t.Run("peeking does not consume buffered data", func(t *testing.T) {
+		r := newSocketSystem("hello world")
+		s := wasi.BufferedSockets(r, 64)
+
+		b := make([]byte, 5)
+		n, _, errno := s.SockRecv(ctx, 3, []wasi.IOVec{b}, wasi.RecvPeek)
+		if errno != wasi.ESUCCESS || string(b[:n]) != "hello" {
+			t.Fatalf("wrong peek: %q (%s)", b[:n], errno)
+		}
+		b = make([]byte, 11)
+		n, _, errno = s.SockRecv(ctx, 3, []wasi.IOVec{b[:6], b[6:]}, 0)
+		if errno != wasi.ESUCCESS || string(b[:n]) != "hello world" {
+			t.Fatalf("wrong read: %q (%s)", b[:n], errno)
+		}
+	})
+
+	t.Run("fd_read consumes buffered data", func(t *testing.T) {
+		r := newSocketSystem("hello world")
+		s := wasi.BufferedSockets(r, 64)
+
+		b := make([]byte, 6)
+		if _, _, errno := s.SockRecv(ctx, 3, []wasi.IOVec{b}, 0); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		n, errno := s.FDRead(ctx, 3, []wasi.IOVec{b})
+		if errno != wasi.ESUCCESS || string(b[:n]) != "world" {
+			t.Fatalf("wrong read: %q (%s)", b[:n], errno)
+		}
+	})
+
+	t.Run("large reads bypass the buffer", func(t *testing.T) {
+		r := newSocketSystem(strings.Repeat("x", 100))
+		s := wasi.BufferedSockets(r, 16)
+
+		b := make([]byte, 100)
+		n, _, errno := s.SockRecv(ctx, 3, []wasi.IOVec{b}, 0)
+		if errno != wasi.ESUCCESS || n != 100 {
+			t.Fatalf("wrong read: %d (%s)", n, errno)
+		}
+	})
+
+	t.Run("datagram sockets are not buffered", func(t *testing.T) {
+		r := newSocketSystem("hello world")
+		s := wasi.BufferedSockets(r, 64)
+
+		b := make([]byte, 5)
+		n, roflags, errno := s.SockRecv(ctx, 4, []wasi.IOVec{b}, 0)
+		if errno != wasi.ESUCCESS || string(b[:n]) != "hello" {
+			t.Fatalf("wrong read: %q (%s)", b[:n], errno)
+		}
+		if !roflags.Has(wasi.RecvDataTruncated) {
+			t.Error("truncation was not reported")
+		}
+	})
+
+	t.Run("the type of file descriptors is looked up once", func(t *testing.T) {
+		r := newSocketSystem("")
+		s := wasi.BufferedSockets(r, 64)
+
+		b := make([]byte, 5)
+		for i := 0; i < 3; i++ {
+			s.SockRecv(ctx, 4, []wasi.IOVec{b}, 0)
+		}
+		if r.calls["FDStatGet"] != 1 {
+			t.Errorf("wrong number of calls to FDStatGet: want 1, got %d", r.calls["FDStatGet"])
+		}
+
+		// The file descriptor number is reused by a stream socket after
+		// the datagram socket was closed.
+		if errno := s.FDClose(ctx, 4); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		r.open(4, wasi.SocketStreamType, "hello world")
+		if n, _, errno := s.SockRecv(ctx, 4, []wasi.IOVec{b}, 0); errno != wasi.ESUCCESS || string(b[:n]) != "hello" {
+			t.Fatalf("wrong read: %q (%s)", b[:n], errno)
+		}
+		if len(r.files[4].data) != 0 {
+			t.Error("the stream socket was not buffered")
+		}
+	})
+
+	t.Run("buffered data is spliced before the data of the socket", func(t *testing.T) {
+		r := newSocketSystem("hello world")
+		r.open(5, wasi.SocketStreamType, "")
+		s := wasi.BufferedSockets(r, 8)
+
+		b := make([]byte, 2)
+		if _, _, errno := s.SockRecv(ctx, 3, []wasi.IOVec{b}, 0); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		for {
+			n, errno := s.SockSplice(ctx, 3, 5, 4)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if n == 0 {
+				break
+			}
+		}
+		if got := string(b) + string(r.files[5].data); got != "hello world" {
+			t.Errorf("wrong data: %q", got)
+		}
+	})
+
+	t.Run("buffered data is ready for reading", func(t *testing.T) {
+		r := newSocketSystem("hello world")
+		s := wasi.BufferedSockets(r, 64)
+
+		b := make([]byte, 6)
+		if _, _, errno := s.SockRecv(ctx, 3, []wasi.IOVec{b}, 0); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		subscriptions := []wasi.Subscription{
+			wasi.MakeSubscriptionFDReadWrite(42, wasi.FDReadEvent, wasi.SubscriptionFDReadWrite{FD: 3}),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+		n, errno := s.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS || n != 1 {
+			t.Fatalf("wrong poll result: %d (%s)", n, errno)
+		}
+		if events[0].UserData != 42 || events[0].FDReadWrite.NBytes != 5 {
+			t.Errorf("wrong event: %+v", events[0])
+		}
+	})
+}
+
+func BenchmarkBufferedSocketsSmallReads(b *testing.B) {
+	ctx := context.Background()
+	data := strings.Repeat("0123456789abcdef", 1024)
+	buf := make([]byte, 4)
+
+	for _, bufferSize := range []int{0, 4096} {
+		name := "unbuffered"
+		if bufferSize > 0 {
+			name = "buffered"
+		}
+		b.Run(name, func(b *testing.B) {
+			calls := 0
+			for i := 0; i < b.N; i++ {
+				r := newSocketSystem(data)
+				s := wasi.System(r)
+				if bufferSize > 0 {
+					s = wasi.BufferedSockets(r, bufferSize)
+				}
+				for {
+					n, _, _ := s.SockRecv(ctx, 3, []wasi.IOVec{buf}, 0)
+					if n == 0 {
+						break
+					}
+				}
+				calls += r.calls["SockRecv"]
+			}
+			b.ReportMetric(float64(calls)/float64(b.N), "syscalls/op")
+		})
+	}
+}
@@ -2,18 +2,27 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/fs"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"path/filepath"
+	"runtime"
 	"runtime/debug"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/stealthrocket/wasi-go"
 	"github.com/stealthrocket/wasi-go/imports"
 	"github.com/stealthrocket/wasi-go/imports/wasi_http"
+	"github.com/stealthrocket/wasi-go/imports/wasi_snapshot_preview1"
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/sys"
 )
@@ -35,15 +44,48 @@ OPTIONS:
    --dir <DIR>
       Grant access to the specified host directory
 
+   --cwd <DIR>
+      Set the working directory of the module, which must be within
+      one of the directories granted with --dir
+
    --listen <ADDR:PORT>
       Grant access to a socket listening on the specified address
 
-   --dial <ADDR:PORT>
-      Grant access to a socket connected to the specified address
+   --dial <[NAME=]ADDR:PORT>
+      Grant access to a socket connected to the specified address; the
+      file descriptors are listed as NAME=FD pairs in the DIAL_FDS
+      environment variable, the name defaults to the address
+
+   --accept <ADDR:PORT>
+      Accept connections on the specified address and run an instance
+      of the module for each connection, passing the connected socket
+      as file descriptor 3 (like inetd)
+
+   --inherit-fd <HOSTFD:GUESTPATH:TYPE>
+      Grant access to a file descriptor inherited from the parent
+      process (e.g. for socket activation), where the type is one
+      of {file, dir, chardev, stream, dgram, listen}
 
    --dns-server <ADDR:PORT>
       Sets the address of the DNS server to use for name resolution
 
+   --hosts <FILE>
+      Resolves the names listed in FILE, in the format of /etc/hosts,
+      to the addresses it specifies instead of querying the resolver
+
+   --net <MODE>
+      Restrict the addresses that sockets may bind, connect, or send
+      to, either {all, loopback, none}; unix sockets are always allowed
+
+   --allow-connect <CIDR:PORT>
+      Only allow sockets to connect or send to addresses in the network
+      and on the port (or * for all ports); may be repeated, IPv6
+      networks are written in brackets, e.g. [2001:db8::/32]:443
+
+   --invoke <FUNCTION>
+      Call the specified exported function instead of _start; a
+      function returning normally exits with status code 0
+
    --env-inherit
       Inherits all environment variables from the calling process
 
@@ -55,21 +97,90 @@ OPTIONS:
       Enable a sockets extension, either {none, auto, path_open,
       wasmedgev1, wasmedgev2}
 
+   --sockets-diagnostics
+      Print a warning when the module calls a socket function which
+      is not supported because no sockets extension is enabled
+
+   --print-capabilities
+      Print the capabilities granted to the module as JSON and exit
+      without running it
+
+   --inspect
+      Run the module without granting access to any path or network
+      address, and print those it attempted to access when it exits;
+      combine with --dir / to observe all the paths
+
    --pprof-addr <ADDR:PORT>
       Start a pprof server listening on the specified address
 
+   --cpu-profile <FILE>
+      Write a CPU profile of the host to the specified file
+
+   --mem-profile <FILE>
+      Write a heap profile of the host to the specified file after the
+      module exited
+
+   --rate-limit <FD:BYTES_PER_SEC>
+      Limit the rate at which the module may read from and write to
+      the specified file descriptor
+
    --trace
       Enable logging of system calls (like strace)
 
+   --trace-json <FILE>
+      Append a JSON record of each system call to the specified file,
+      which can be replayed with wasitrace
+
+   --trace-summary
+      Print the number of system calls, bytes read and written, and
+      time spent polling when the module exits (like strace -c)
+
+   --report
+      Print the resources used by the module when it exits: system
+      calls, bytes transferred on stdio, files and sockets, peak
+      number of open file descriptors, and wall and CPU time
+
+   --random-source <SOURCE>
+      Select the source of the data returned by random_get, either
+      {crypto, getrandom, urandom} (default: crypto); getrandom uses
+      the blocking pool of the Linux kernel
+
+   --clock <MODE>
+      Select the clocks exposed to the module, either {real, frozen,
+      step:DURATION} (default: real); frozen clocks always return the
+      Unix epoch, step clocks start at the epoch and advance by the
+      duration on each call to clock_time_get
+
+   --stdin <FILE>
+      Read the stdin of the module from the specified file instead
+      of the stdin of the process
+
+   --stdin-string <STRING>
+      Read the stdin of the module from the specified string instead
+      of the stdin of the process
+
    --non-blocking-stdio
       Enable non-blocking stdio
 
+   --create-mode <MODE>
+      Set the permission mode of the files created by the module, in
+      octal (default: 0644); the umask of the process still applies
+
    --max-open-files <N>
       Limit the number of files that may be opened by the module
 
+   --fd-leak-diagnostics
+      When the limit set by --max-open-files is reached, print the
+      oldest file descriptors that the module opened and did not
+      close, with the path or address each of them was opened from
+
    --max-open-dirs <N>
       Limit the number of directories that may be opened by the module
 
+   --max-iovecs <N>
+      Limit the number of buffers passed to vectored I/O functions
+      such as fd_read and fd_write (default: 0, no limit)
+
    --http <MODE>
       Optionally enable wasi-http client support and select a
       version {none, auto, v1}
@@ -83,7 +194,11 @@ OPTIONS:
 	  on this URL prefix path. Default is '/'	
 
    -v, --version
-      Print the version and exit
+      Print the version, the WASI extensions supported and the host
+      platform, then exit
+
+   --json
+      Print the version as JSON, only used with --version
 
    -h, --help
       Show this usage information
@@ -94,20 +209,45 @@ var (
 	envInherit       bool
 	envs             stringList
 	dirs             stringList
+	workingDir       string
 	listens          stringList
 	dials            stringList
+	inheritFDs       stringList
+	rateLimits       stringList
+	allowConnects    stringList
+	acceptAddr       string
+	invokeFunc       string
 	dnsServer        string
+	hostsFile        string
+	netPolicy        string
+	createMode       string
 	socketExt        string
+	socketsDiag      bool
 	pprofAddr        string
+	cpuProfile       string
+	memProfile       string
 	wasiHttp         string
 	wasiHttpAddr     string
 	wasiHttpPath     string
 	trace            bool
+	traceJSON        string
+	traceSummary     bool
+	report           bool
+	printCaps        bool
+	inspect          bool
 	tracerStringSize int
 	nonBlockingStdio bool
+	randomSource     string
+	clockMode        string
+	stdinFile        string
+	stdinString      string
+	stdinStringSet   bool
 	version          bool
+	versionJSON      bool
 	maxOpenFiles     int
+	fdLeakDiag       bool
 	maxOpenDirs      int
+	maxIOVecs        int
 )
 
 func main() {
@@ -117,28 +257,54 @@ func main() {
 	flagSet.BoolVar(&envInherit, "env-inherit", false, "")
 	flagSet.Var(&envs, "env", "")
 	flagSet.Var(&dirs, "dir", "")
+	flagSet.StringVar(&workingDir, "cwd", "", "")
 	flagSet.Var(&listens, "listen", "")
 	flagSet.Var(&dials, "dial", "")
+	flagSet.Var(&inheritFDs, "inherit-fd", "")
+	flagSet.Var(&rateLimits, "rate-limit", "")
+	flagSet.StringVar(&acceptAddr, "accept", "", "")
+	flagSet.StringVar(&invokeFunc, "invoke", "", "")
 	flagSet.StringVar(&dnsServer, "dns-server", "", "")
+	flagSet.StringVar(&hostsFile, "hosts", "", "")
+	flagSet.StringVar(&netPolicy, "net", "all", "")
+	flagSet.Var(&allowConnects, "allow-connect", "")
 	flagSet.StringVar(&socketExt, "sockets", "auto", "")
+	flagSet.BoolVar(&socketsDiag, "sockets-diagnostics", false, "")
 	flagSet.StringVar(&pprofAddr, "pprof-addr", "", "")
+	flagSet.StringVar(&cpuProfile, "cpu-profile", "", "")
+	flagSet.StringVar(&memProfile, "mem-profile", "", "")
 	flagSet.StringVar(&wasiHttp, "http", "auto", "")
 	flagSet.StringVar(&wasiHttpAddr, "http-server-addr", "", "")
 	flagSet.StringVar(&wasiHttpPath, "http-server-path", "/", "")
 	flagSet.BoolVar(&trace, "trace", false, "")
+	flagSet.StringVar(&traceJSON, "trace-json", "", "")
+	flagSet.BoolVar(&traceSummary, "trace-summary", false, "")
+	flagSet.BoolVar(&report, "report", false, "")
+	flagSet.BoolVar(&printCaps, "print-capabilities", false, "")
+	flagSet.BoolVar(&inspect, "inspect", false, "")
 	flagSet.IntVar(&tracerStringSize, "tracer-string-size", 32, "")
 	flagSet.BoolVar(&nonBlockingStdio, "non-blocking-stdio", false, "")
+	flagSet.StringVar(&randomSource, "random-source", "crypto", "")
+	flagSet.StringVar(&clockMode, "clock", "real", "")
+	flagSet.StringVar(&stdinFile, "stdin", "", "")
+	flagSet.Func("stdin-string", "", func(s string) error {
+		stdinString, stdinStringSet = s, true
+		return nil
+	})
 	flagSet.BoolVar(&version, "version", false, "")
 	flagSet.BoolVar(&version, "v", false, "")
+	flagSet.BoolVar(&versionJSON, "json", false, "")
+	flagSet.StringVar(&createMode, "create-mode", "", "")
 	flagSet.IntVar(&maxOpenFiles, "max-open-files", 1024, "")
+	flagSet.BoolVar(&fdLeakDiag, "fd-leak-diagnostics", false, "")
 	flagSet.IntVar(&maxOpenDirs, "max-open-dirs", 1024, "")
+	flagSet.IntVar(&maxIOVecs, "max-iovecs", 0, "")
 	flagSet.Parse(os.Args[1:])
 
 	if version {
-		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "(devel)" {
-			fmt.Println("wasirun", info.Main.Version)
-		} else {
-			fmt.Println("wasirun", "devel")
+		if err := printVersion(versionJSON); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
 		}
 		os.Exit(0)
 	}
@@ -171,7 +337,7 @@ func main() {
 		}
 	}
 
-	if err := run(args[0], args[1:]); err != nil {
+	if err := runProfiled(args[0], args[1:]); err != nil {
 		if exitErr, ok := err.(*sys.ExitError); ok {
 			os.Exit(int(exitErr.ExitCode()))
 		}
@@ -180,6 +346,44 @@ func main() {
 	}
 }
 
+// runProfiled calls run, capturing the profiles requested on the command line.
+// The profiles are written when run returns, which includes the case where the
+// module called proc_exit, and before main calls os.Exit.
+func runProfiled(wasmFile string, args []string) error {
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return fmt.Errorf("could not create CPU profile: %w", err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return fmt.Errorf("could not start CPU profile: %w", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+	if memProfile != "" {
+		defer func() {
+			if err := writeHeapProfile(memProfile); err != nil {
+				fmt.Fprintf(os.Stderr, "error: could not write heap profile: %v\n", err)
+			}
+		}()
+	}
+	return run(wasmFile, args)
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	runtime.GC() // get up-to-date statistics
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
 func run(wasmFile string, args []string) error {
 	wasmName := filepath.Base(wasmFile)
 	wasmCode, err := os.ReadFile(wasmFile)
@@ -196,7 +400,40 @@ func run(wasmFile string, args []string) error {
 	}
 
 	ctx := context.Background()
-	runtime := wazero.NewRuntime(ctx)
+	if acceptAddr == "" {
+		return runModule(ctx, wazero.NewRuntimeConfig(), wasmName, wasmCode, args, nil)
+	}
+
+	l, err := net.Listen("tcp", acceptAddr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	// Each connection runs in its own runtime, compilation is shared across
+	// instances of the module by using the same compilation cache.
+	cache := wazero.NewCompilationCache()
+	defer cache.Close(ctx)
+	config := wazero.NewRuntimeConfig().WithCompilationCache(cache)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := runModule(ctx, config, wasmName, wasmCode, args, conn.(*net.TCPConn)); err != nil {
+				if exitErr, ok := err.(*sys.ExitError); !ok || exitErr.ExitCode() != 0 {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", conn.RemoteAddr(), err)
+				}
+			}
+		}()
+	}
+}
+
+func runModule(ctx context.Context, config wazero.RuntimeConfig, wasmName string, wasmCode []byte, args []string, conn *net.TCPConn) error {
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
 	defer runtime.Close(ctx)
 
 	wasmModule, err := runtime.CompileModule(ctx, wasmCode)
@@ -210,13 +447,149 @@ func run(wasmFile string, args []string) error {
 		WithArgs(args...).
 		WithEnv(envs...).
 		WithDirs(dirs...).
+		WithWorkingDirectory(workingDir).
 		WithListens(listens...).
 		WithDials(dials...).
+		WithHostsFile(hostsFile).
+		WithNetworkPolicy(netPolicy).
 		WithNonBlockingStdio(nonBlockingStdio).
 		WithSocketsExtension(socketExt, wasmModule).
 		WithTracer(trace, os.Stderr, wasi.WithTracerStringSize(tracerStringSize)).
 		WithMaxOpenFiles(maxOpenFiles).
-		WithMaxOpenDirs(maxOpenDirs)
+		WithMaxOpenDirs(maxOpenDirs).
+		WithMaxIOVecs(maxIOVecs)
+
+	if socketsDiag {
+		builder = builder.WithSocketsDiagnostics(os.Stderr)
+	}
+
+	if fdLeakDiag {
+		builder = builder.WithFDLeakDiagnostics(os.Stderr)
+	}
+
+	if traceJSON != "" {
+		f, err := os.OpenFile(traceJSON, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		builder = builder.WithTraceJSON(f)
+	}
+
+	if traceSummary {
+		builder = builder.WithTraceSummary(os.Stderr)
+	}
+
+	if report {
+		builder = builder.WithAccounting(func(r wasi.AccountingReport) {
+			r.WriteTo(os.Stderr)
+		})
+	}
+
+	if inspect {
+		builder = builder.WithWrappers(func(system wasi.System) wasi.System {
+			return wasi.Inspect(os.Stderr, system)
+		})
+	}
+
+	if createMode != "" {
+		mode, err := strconv.ParseUint(createMode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid file creation mode %q, expected an octal number such as 0600", createMode)
+		}
+		builder = builder.WithCreateFileMode(fs.FileMode(mode))
+	}
+
+	if len(rateLimits) > 0 {
+		rates, err := parseRateLimits(rateLimits)
+		if err != nil {
+			return err
+		}
+		builder = builder.WithRateLimiter(wasi.TokenBucket(rates))
+	}
+
+	if len(allowConnects) > 0 {
+		rules := make([]wasi.DialRule, len(allowConnects))
+		for i, allowConnect := range allowConnects {
+			rule, err := wasi.ParseDialRule(allowConnect)
+			if err != nil {
+				return err
+			}
+			rules[i] = rule
+		}
+		builder = builder.WithDialPolicy(wasi.AllowDial(rules...))
+	}
+
+	switch randomSource {
+	case "", "crypto":
+	case "getrandom":
+		if getrandom == nil {
+			return fmt.Errorf("getrandom is not available on this platform")
+		}
+		builder = builder.WithRandomSource(getrandom)
+	case "urandom":
+		f, err := os.Open("/dev/urandom")
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		builder = builder.WithRandomSource(f)
+	default:
+		return fmt.Errorf("invalid value for --random-source '%v', expected 'crypto', 'getrandom' or 'urandom'", randomSource)
+	}
+
+	if clockMode != "" && clockMode != "real" {
+		realtime, monotonic, err := parseClock(clockMode)
+		if err != nil {
+			return err
+		}
+		builder = builder.
+			WithRealtimeClock(realtime, 0).
+			WithMonotonicClock(monotonic, 0)
+	}
+
+	switch {
+	case stdinFile != "" && stdinStringSet:
+		return fmt.Errorf("--stdin and --stdin-string cannot be used together")
+	case stdinFile != "":
+		// The file is opened for each instance of the module so they all
+		// read it from the beginning when accepting connections.
+		f, err := os.Open(stdinFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		builder = builder.WithStdin(f)
+	case stdinStringSet:
+		builder = builder.WithStdin(strings.NewReader(stdinString))
+	}
+
+	for _, inheritFD := range inheritFDs {
+		fd, path, stat, err := parseInheritFD(inheritFD)
+		if err != nil {
+			return err
+		}
+		builder = builder.WithInheritedFD(path, fd, stat)
+	}
+
+	if conn != nil {
+		f, err := conn.File()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		builder = builder.WithSocketFD(conn.RemoteAddr().String(), int(f.Fd()))
+	}
+
+	if printCaps {
+		caps, err := builder.Capabilities()
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(caps)
+	}
 
 	var system wasi.System
 	ctx, system, err = builder.Instantiate(ctx, runtime)
@@ -244,10 +617,33 @@ func run(wasmFile string, args []string) error {
 		}
 	}
 
-	instance, err := runtime.InstantiateModule(ctx, wasmModule, wazero.NewModuleConfig())
+	moduleConfig := wazero.NewModuleConfig()
+	if invokeFunc != "" {
+		// Reactor modules may export an _initialize function which must be
+		// called before any other export, _start is not called since the
+		// module is expected to run the invoked function instead.
+		moduleConfig = moduleConfig.WithStartFunctions("_initialize")
+	}
+
+	instance, err := runtime.InstantiateModule(ctx, wasmModule, moduleConfig)
 	if err != nil {
 		return err
 	}
+	if invokeFunc != "" {
+		// The function may call proc_exit, in which case the exit error is
+		// returned and handled the same way as when calling _start. A
+		// function returning without calling proc_exit is a successful
+		// execution.
+		fn := instance.ExportedFunction(invokeFunc)
+		if fn == nil {
+			instance.Close(ctx)
+			return fmt.Errorf("function %q is not exported by %s", invokeFunc, wasmName)
+		}
+		if _, err := fn.Call(ctx); err != nil {
+			instance.Close(ctx)
+			return err
+		}
+	}
 	if len(wasiHttpAddr) > 0 {
 		handler := wasiHTTP.MakeHandler(ctx, instance)
 		http.Handle(wasiHttpPath, handler)
@@ -258,6 +654,92 @@ func run(wasmFile string, args []string) error {
 
 type stringList []string
 
+func parseRateLimits(rateLimits []string) (map[wasi.FD]int, error) {
+	rates := make(map[wasi.FD]int, len(rateLimits))
+	for _, rateLimit := range rateLimits {
+		fd, rate, ok := strings.Cut(rateLimit, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, expected FD:BYTES_PER_SEC", rateLimit)
+		}
+		n, err := strconv.Atoi(fd)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid file descriptor in rate limit %q", rateLimit)
+		}
+		r, err := strconv.Atoi(rate)
+		if err != nil || r <= 0 {
+			return nil, fmt.Errorf("invalid rate in rate limit %q", rateLimit)
+		}
+		rates[wasi.FD(n)] = r
+	}
+	return rates, nil
+}
+
+// parseClock returns the realtime and monotonic clocks of the --clock mode.
+// Each clock has its own state, the step of one clock does not advance the
+// other.
+func parseClock(mode string) (realtime, monotonic func(context.Context) (uint64, error), err error) {
+	var step time.Duration
+	switch {
+	case mode == "frozen":
+	case strings.HasPrefix(mode, "step:"):
+		step, err = time.ParseDuration(strings.TrimPrefix(mode, "step:"))
+		if err != nil || step <= 0 {
+			return nil, nil, fmt.Errorf("invalid duration in --clock '%v'", mode)
+		}
+	default:
+		return nil, nil, fmt.Errorf("invalid value for --clock '%v', expected 'real', 'frozen' or 'step:DURATION'", mode)
+	}
+	return steppedClock(step), steppedClock(step), nil
+}
+
+func steppedClock(step time.Duration) func(context.Context) (uint64, error) {
+	var t atomic.Uint64
+	return func(context.Context) (uint64, error) {
+		return t.Add(uint64(step)) - uint64(step), nil
+	}
+}
+
+func parseInheritFD(inheritFD string) (fd int, path string, stat wasi.FDStat, err error) {
+	hostFD, pathAndType, ok := strings.Cut(inheritFD, ":")
+	i := strings.LastIndexByte(pathAndType, ':')
+	if !ok || i < 0 {
+		return -1, "", stat, fmt.Errorf("invalid inherited file descriptor %q, expected HOSTFD:GUESTPATH:TYPE", inheritFD)
+	}
+	fd, err = strconv.Atoi(hostFD)
+	if err != nil || fd < 0 {
+		return -1, "", stat, fmt.Errorf("invalid file descriptor in %q", inheritFD)
+	}
+	path, fileType := pathAndType[:i], pathAndType[i+1:]
+	switch fileType {
+	case "file":
+		stat.FileType = wasi.RegularFileType
+		stat.RightsBase = wasi.FileRights
+	case "dir":
+		stat.FileType = wasi.DirectoryType
+		stat.RightsBase = wasi.DirectoryRights
+		stat.RightsInheriting = wasi.DirectoryRights | wasi.FileRights
+	case "chardev":
+		stat.FileType = wasi.CharacterDeviceType
+		stat.RightsBase = wasi.TTYRights
+	case "stream":
+		stat.FileType = wasi.SocketStreamType
+		stat.Flags = wasi.NonBlock
+		stat.RightsBase = wasi.SockConnectionRights
+	case "dgram":
+		stat.FileType = wasi.SocketDGramType
+		stat.Flags = wasi.NonBlock
+		stat.RightsBase = wasi.SockConnectionRights
+	case "listen":
+		stat.FileType = wasi.SocketStreamType
+		stat.Flags = wasi.NonBlock
+		stat.RightsBase = wasi.SockListenRights
+		stat.RightsInheriting = wasi.SockConnectionRights
+	default:
+		return -1, "", stat, fmt.Errorf("invalid file type in %q, expected one of {file, dir, chardev, stream, dgram, listen}", inheritFD)
+	}
+	return fd, path, stat, nil
+}
+
 func (s stringList) String() string {
 	return fmt.Sprintf("%v", []string(s))
 }
@@ -266,3 +748,36 @@ func (s *stringList) Set(value string) error {
 	*s = append(*s, value)
 	return nil
 }
+
+// versionInfo is the output of --version --json.
+type versionInfo struct {
+	Version    string   `json:"version"`
+	WASI       []string `json:"wasi"`
+	Extensions []string `json:"extensions"`
+	OS         string   `json:"os"`
+	Arch       string   `json:"arch"`
+}
+
+func printVersion(asJSON bool) error {
+	v := versionInfo{
+		Version:    "devel",
+		WASI:       []string{wasi_snapshot_preview1.HostModuleName},
+		Extensions: imports.SupportedExtensions(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "(devel)" {
+		v.Version = info.Main.Version
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	fmt.Println("wasirun", v.Version)
+	fmt.Println("wasi:", strings.Join(v.WASI, ", "))
+	fmt.Println("extensions:", strings.Join(v.Extensions, ", "))
+	fmt.Println("platform:", v.OS+"/"+v.Arch)
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// invokeModule is a module importing proc_exit and exporting two functions:
+// "run", which returns without calling proc_exit, and "exit", which calls
+// proc_exit(3).
+var invokeModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// type section: (i32) -> (), () -> ()
+	0x01, 0x08, 0x02, 0x60, 0x01, 0x7f, 0x00, 0x60, 0x00, 0x00,
+	// import section: wasi_snapshot_preview1.proc_exit
+	0x02, 0x24, 0x01,
+	0x16, 'w', 'a', 's', 'i', '_', 's', 'n', 'a', 'p', 's', 'h', 'o', 't', '_', 'p', 'r', 'e', 'v', 'i', 'e', 'w', '1',
+	0x09, 'p', 'r', 'o', 'c', '_', 'e', 'x', 'i', 't',
+	0x00, 0x00,
+	// function section
+	0x03, 0x03, 0x02, 0x01, 0x01,
+	// export section: run, exit
+	0x07, 0x0e, 0x02,
+	0x03, 'r', 'u', 'n', 0x00, 0x01,
+	0x04, 'e', 'x', 'i', 't', 0x00, 0x02,
+	// code section
+	0x0a, 0x0b, 0x02,
+	0x02, 0x00, 0x0b,
+	0x06, 0x00, 0x41, 0x03, 0x10, 0x00, 0x0b,
+}
+
+func TestInvoke(t *testing.T) {
+	defer func(f, h string) { invokeFunc, wasiHttp = f, h }(invokeFunc, wasiHttp)
+	wasiHttp = "none"
+	ctx := context.Background()
+
+	tests := []struct {
+		function string
+		exitCode uint32
+	}{
+		{function: "run", exitCode: 0},
+		{function: "exit", exitCode: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.function, func(t *testing.T) {
+			invokeFunc = test.function
+
+			err := runModule(ctx, wazero.NewRuntimeConfig(), "test.wasm", invokeModule, nil, nil)
+			var exitCode uint32
+			if err != nil {
+				exitErr, ok := err.(*sys.ExitError)
+				if !ok {
+					t.Fatal(err)
+				}
+				exitCode = exitErr.ExitCode()
+			}
+			if exitCode != test.exitCode {
+				t.Errorf("wrong exit code: want %d, got %d", test.exitCode, exitCode)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		invokeFunc = "missing"
+
+		if err := runModule(ctx, wazero.NewRuntimeConfig(), "test.wasm", invokeModule, nil, nil); err == nil {
+			t.Error("expected an error invoking a function that is not exported")
+		}
+	})
+}
+
+func TestProfilesWrittenOnExit(t *testing.T) {
+	defer func(f, h, c, m string) {
+		invokeFunc, wasiHttp, cpuProfile, memProfile = f, h, c, m
+	}(invokeFunc, wasiHttp, cpuProfile, memProfile)
+
+	tmp := t.TempDir()
+	wasmFile := filepath.Join(tmp, "test.wasm")
+	if err := os.WriteFile(wasmFile, invokeModule, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	invokeFunc = "exit"
+	wasiHttp = "none"
+	cpuProfile = filepath.Join(tmp, "cpu.pprof")
+	memProfile = filepath.Join(tmp, "mem.pprof")
+
+	err := runProfiled(wasmFile, nil)
+	if exitErr, ok := err.(*sys.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Fatalf("expected the module to exit with status code 3, got %v", err)
+	}
+
+	for _, path := range []string{cpuProfile, memProfile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 {
+			t.Errorf("%s: profile is empty", filepath.Base(path))
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		mode string
+		want []uint64
+	}{
+		{mode: "frozen", want: []uint64{0, 0, 0}},
+		{mode: "step:1ms", want: []uint64{0, 1e6, 2e6}},
+		{mode: "step:1h", want: []uint64{0, 3600e9, 7200e9}},
+	} {
+		t.Run(test.mode, func(t *testing.T) {
+			realtime, monotonic, err := parseClock(test.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The clocks are independent, reading one does not advance
+			// the other.
+			for name, clock := range map[string]func(context.Context) (uint64, error){
+				"realtime":  realtime,
+				"monotonic": monotonic,
+			} {
+				for i, want := range test.want {
+					got, err := clock(ctx)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got != want {
+						t.Errorf("%s: wrong timestamp at call %d: want %d, got %d", name, i, want, got)
+					}
+				}
+			}
+		})
+	}
+
+	for _, mode := range []string{"", "fake", "step:", "step:0s", "step:-1s", "step:1"} {
+		if _, _, err := parseClock(mode); err == nil {
+			t.Errorf("%q: expected an error", mode)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build unix
+
+package main
+
+import "github.com/stealthrocket/wasi-go/systems/unix"
+
+var getrandom = unix.Getrandom
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/imports"
+	"github.com/tetratelabs/wazero"
+)
+
+func printUsage() {
+	fmt.Printf(`wasitrace - Replay a trace of system calls and compare the results
+
+USAGE:
+   wasitrace [OPTIONS]... <TRACE> [--] [MODULE [ARGS]...]
+
+ARGS:
+   <TRACE>
+      The path of a trace recorded with wasirun --trace-json
+
+   [MODULE [ARGS]...]
+      The name and arguments of the module that the trace was recorded
+      from, returned by the replayed args_get
+
+OPTIONS:
+   --dir <DIR>
+      Grant access to the specified host directory; the calls are
+      replayed for real, so they may modify the files in it
+
+   --cwd <DIR>
+      Set the working directory of the module, which must be within
+      one of the directories granted with --dir
+
+   --listen <ADDR:PORT>
+      Grant access to a socket listening on the specified address
+
+   --dial <[NAME=]ADDR:PORT>
+      Grant access to a socket connected to the specified address
+
+   --env <NAME=VALUE>
+      Pass an environment variable to the module
+
+   --env-inherit
+      Inherits all environment variables from the calling process
+
+   --stdin <FILE>
+      Read the stdin of the module from the specified file instead
+      of an empty input; the output of the module is discarded
+
+   -h, --help
+      Show this usage information
+
+Each call of the trace is made to a fresh system configured with the
+options, and its results are compared to the recorded results. Calls to
+proc_exit and proc_raise are not replayed, and the results of
+clock_time_get are not compared. The device, inode and times of file
+stats are not compared either, since they differ between file systems.
+
+The differences are printed to stdout, the exit code is 1 if there are
+any.
+`)
+}
+
+var (
+	envInherit bool
+	envs       stringList
+	dirs       stringList
+	workingDir string
+	listens    stringList
+	dials      stringList
+	stdinFile  string
+)
+
+func main() {
+	flagSet := flag.NewFlagSet("wasitrace", flag.ExitOnError)
+	flagSet.Usage = printUsage
+
+	flagSet.BoolVar(&envInherit, "env-inherit", false, "")
+	flagSet.Var(&envs, "env", "")
+	flagSet.Var(&dirs, "dir", "")
+	flagSet.StringVar(&workingDir, "cwd", "", "")
+	flagSet.Var(&listens, "listen", "")
+	flagSet.Var(&dials, "dial", "")
+	flagSet.StringVar(&stdinFile, "stdin", "", "")
+	flagSet.Parse(os.Args[1:])
+
+	args := flagSet.Args()
+	if len(args) == 0 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	if envInherit {
+		envs = append(append([]string{}, os.Environ()...), envs...)
+	}
+
+	diffs, err := run(args[0], args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if diffs > 0 {
+		os.Exit(1)
+	}
+}
+
+func run(traceFile string, args []string) (int, error) {
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	trace, err := os.Open(traceFile)
+	if err != nil {
+		return 0, err
+	}
+	defer trace.Close()
+
+	// The output of the module is discarded and its input is empty unless a
+	// file is given with --stdin.
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer null.Close()
+
+	stdin := null
+	if stdinFile != "" {
+		f, err := os.Open(stdinFile)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		stdin = f
+	}
+
+	builder := imports.NewBuilder().
+		WithEnv(envs...).
+		WithDirs(dirs...).
+		WithWorkingDirectory(workingDir).
+		WithListens(listens...).
+		WithDials(dials...).
+		WithStdio(int(stdin.Fd()), int(null.Fd()), int(null.Fd()))
+
+	if len(args) > 0 {
+		builder = builder.WithName(args[0]).WithArgs(args[1:]...)
+	}
+
+	ctx, system, err := builder.Instantiate(ctx, runtime)
+	if err != nil {
+		return 0, err
+	}
+	defer system.Close(ctx)
+
+	return replay(ctx, system, traceFile, trace, os.Stdout)
+}
+
+// replay calls the system with each record read from r, and writes the calls
+// whose results differ from the recorded results to w. It returns the number
+// of differences.
+func replay(ctx context.Context, system wasi.System, name string, r io.Reader, w io.Writer) (int, error) {
+	calls, diffs := 0, 0
+	lines := bufio.NewScanner(r)
+	lines.Buffer(nil, 64*1024*1024)
+
+	for lineno := 1; lines.Scan(); lineno++ {
+		line := bytes.TrimSpace(lines.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record wasi.TraceRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return diffs, fmt.Errorf("%s:%d: %w", name, lineno, err)
+		}
+		switch record.Call {
+		case "ProcExit", "ProcRaise":
+			// The calls would terminate or signal this process.
+			continue
+		}
+		results, err := record.Replay(ctx, system)
+		if err != nil {
+			return diffs, fmt.Errorf("%s:%d: %w", name, lineno, err)
+		}
+		calls++
+		if record.Call == "ClockTimeGet" || equalResults(record.Results, results) {
+			continue
+		}
+		diffs++
+		fmt.Fprintf(w, "%s:%d: %s(%s) => recorded %s, replayed %s\n",
+			name, lineno, record.Call, join(record.Args), formatResults(record.Results), formatResults(results))
+	}
+	if err := lines.Err(); err != nil {
+		return diffs, fmt.Errorf("%s: %w", name, err)
+	}
+
+	fmt.Fprintf(w, "%d calls replayed, %d differences\n", calls, diffs)
+	return diffs, nil
+}
+
+func equalResults(recorded, replayed []json.RawMessage) bool {
+	if len(recorded) != len(replayed) {
+		return false
+	}
+	for i := range recorded {
+		if !bytes.Equal(normalize(recorded[i]), normalize(replayed[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// fileStatFields are the fields of wasi.FileStat which depend on the file
+// system or on when the calls were made, rather than on the calls.
+var fileStatFields = [...]string{"Device", "INode", "AccessTime", "ModifyTime", "ChangeTime"}
+
+func normalize(result json.RawMessage) json.RawMessage {
+	var stat map[string]json.RawMessage
+	if json.Unmarshal(result, &stat) != nil {
+		return result
+	}
+	if _, ok := stat["INode"]; !ok {
+		return result
+	}
+	for _, field := range fileStatFields {
+		delete(stat, field)
+	}
+	b, _ := json.Marshal(stat)
+	return b
+}
+
+func join(values []json.RawMessage) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = string(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+// formatResults formats the results of a call, with the name of the errno
+// that the methods of wasi.System return last.
+func formatResults(results []json.RawMessage) string {
+	if len(results) == 0 {
+		return "()"
+	}
+	s := join(results[:len(results)-1])
+	var errno wasi.Errno
+	if json.Unmarshal(results[len(results)-1], &errno) != nil {
+		return join(results)
+	}
+	if s != "" {
+		s += " "
+	}
+	return s + errno.Name()
+}
+
+type stringList []string
+
+func (s stringList) String() string {
+	return fmt.Sprintf("%v", []string(s))
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/imports"
+	"github.com/tetratelabs/wazero"
+)
+
+func instantiate(t *testing.T, dir string, trace io.Writer) (context.Context, wasi.System) {
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { runtime.Close(ctx) })
+
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { null.Close() })
+
+	builder := imports.NewBuilder().
+		WithDirs(dir).
+		WithStdio(int(null.Fd()), int(null.Fd()), int(null.Fd()))
+	if trace != nil {
+		builder = builder.WithTraceJSON(trace)
+	}
+	ctx, system, err := builder.Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { system.Close(ctx) })
+	return ctx, system
+}
+
+func TestReplay(t *testing.T) {
+	trace := new(bytes.Buffer)
+	ctx, system := instantiate(t, t.TempDir(), trace)
+
+	const dirFD = 3
+	fd, errno := system.PathOpen(ctx, dirFD, 0, "hello.txt", wasi.OpenCreate|wasi.OpenExclusive, wasi.FileRights, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	system.FDWrite(ctx, fd, []wasi.IOVec{[]byte("hello, world")})
+	system.FDFileStatGet(ctx, fd)
+	system.FDClose(ctx, fd)
+	system.PathFileStatGet(ctx, dirFD, 0, "hello.txt")
+	system.ClockTimeGet(ctx, wasi.Realtime, 1)
+	system.FDClose(ctx, 42)
+
+	// Replaying against a fresh directory yields the same results, even if
+	// the inodes and times of the files differ.
+	dir := t.TempDir()
+	out := new(bytes.Buffer)
+	ctx, system = instantiate(t, dir, nil)
+	diffs, err := replay(ctx, system, "trace.json", bytes.NewReader(trace.Bytes()), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs != 0 {
+		t.Errorf("unexpected differences:\n%s", out)
+	}
+	if got, want := out.String(), "7 calls replayed, 0 differences\n"; got != want {
+		t.Errorf("wrong output: want %q, got %q", want, got)
+	}
+
+	// Replaying against the same directory fails to create the file, which
+	// already exists.
+	out.Reset()
+	ctx, system = instantiate(t, dir, nil)
+	diffs, err = replay(ctx, system, "trace.json", bytes.NewReader(trace.Bytes()), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs == 0 {
+		t.Fatalf("no differences replaying against a modified directory:\n%s", out)
+	}
+	want := fmt.Sprintf(`trace.json:1: PathOpen(3, 0, "hello.txt", 5, %d, 0, 0) => recorded 4 ESUCCESS, replayed -1 EEXIST`, wasi.FileRights)
+	if line, _, _ := strings.Cut(out.String(), "\n"); line != want {
+		t.Errorf("wrong difference:\nwant: %s\ngot:  %s", want, line)
+	}
+}
+
+func TestReplayInvalidRecord(t *testing.T) {
+	ctx, system := instantiate(t, t.TempDir(), nil)
+	trace := strings.NewReader("{\"call\":\"FDClose\",\"args\":[42],\"results\":[8]}\n{\"call\":\"Close\"}\n")
+
+	_, err := replay(ctx, system, "trace.json", trace, io.Discard)
+	if err == nil || !strings.HasPrefix(err.Error(), "trace.json:2: ") {
+		t.Errorf("wrong error replaying an invalid record: %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package wasi
+
+import "context"
+
+// maxDatagramSize is the size of the buffer used to receive datagrams when
+// draining sockets, large enough to hold any UDP payload.
+const maxDatagramSize = 65536
+
+// BufferedDatagrams wraps a System to queue datagrams received on datagram
+// sockets in host memory.
+//
+// Every time the guest calls PollOneOff or one of the SockRecv, SockRecvFrom,
+// SockSend and SockSendTo functions, datagrams pending in the kernel receive
+// buffers of the sockets are drained into per-socket queues holding up to
+// capacity messages. Draining the kernel buffers promptly reduces the chances
+// that a burst of datagrams overflows them and gets dropped. Once a queue is
+// full, datagrams are left in the kernel until the guest consumes some of the
+// queued messages.
+//
+// Queued datagrams are returned in the order they were received, and sockets
+// with queued datagrams are reported as ready for reading by PollOneOff.
+// Message boundaries are preserved: reading a datagram into buffers that are
+// too small discards the rest of the message and sets RecvDataTruncated.
+func BufferedDatagrams(system System, capacity int) System {
+	return &bufferedDatagrams{
+		System:   system,
+		capacity: capacity,
+		queues:   make(map[FD]*datagramQueue),
+	}
+}
+
+type bufferedDatagrams struct {
+	System
+	capacity int
+	queues   map[FD]*datagramQueue
+	buffer   []byte
+	subs     []Subscription
+	events   []Event
+}
+
+type datagram struct {
+	data    []byte
+	addr    SocketAddress
+	roflags ROFlags
+}
+
+// datagramQueue is a ring buffer of datagrams.
+type datagramQueue struct {
+	items []datagram
+	head  int
+	size  int
+}
+
+func (q *datagramQueue) len() int {
+	return q.size
+}
+
+func (q *datagramQueue) full() bool {
+	return q.size == len(q.items)
+}
+
+func (q *datagramQueue) push(d datagram) {
+	q.items[(q.head+q.size)%len(q.items)] = d
+	q.size++
+}
+
+func (q *datagramQueue) peek() *datagram {
+	return &q.items[q.head]
+}
+
+func (q *datagramQueue) pop() {
+	q.items[q.head] = datagram{}
+	q.head = (q.head + 1) % len(q.items)
+	q.size--
+}
+
+func (s *bufferedDatagrams) queue(ctx context.Context, fd FD) *datagramQueue {
+	if q := s.queues[fd]; q != nil {
+		return q
+	}
+	stat, errno := s.System.FDStatGet(ctx, fd)
+	if errno != ESUCCESS || stat.FileType != SocketDGramType {
+		return nil
+	}
+	return s.track(fd)
+}
+
+func (s *bufferedDatagrams) track(fd FD) *datagramQueue {
+	q := &datagramQueue{items: make([]datagram, s.capacity)}
+	s.queues[fd] = q
+	return q
+}
+
+// drain moves the datagrams pending on the tracked sockets to their queues,
+// until the queues are full or no more datagrams can be received without
+// blocking.
+func (s *bufferedDatagrams) drain(ctx context.Context) {
+	for {
+		// A zero timeout clock subscription guarantees that the poll does
+		// not block when none of the sockets are ready for reading.
+		s.subs = append(s.subs[:0], MakeSubscriptionClock(0, SubscriptionClock{ID: Monotonic}))
+		for fd, q := range s.queues {
+			if !q.full() {
+				s.subs = append(s.subs, MakeSubscriptionFDReadWrite(UserData(fd), FDReadEvent, SubscriptionFDReadWrite{FD: fd}))
+			}
+		}
+		if len(s.subs) == 1 {
+			return
+		}
+		if cap(s.events) < len(s.subs) {
+			s.events = make([]Event, len(s.subs))
+		}
+		events := s.events[:len(s.subs)]
+
+		n, errno := s.System.PollOneOff(ctx, s.subs, events)
+		if errno != ESUCCESS {
+			return
+		}
+
+		received := false
+		for _, e := range events[:n] {
+			if e.EventType != FDReadEvent || e.Errno != ESUCCESS {
+				continue
+			}
+			if s.buffer == nil {
+				s.buffer = make([]byte, maxDatagramSize)
+			}
+			fd := FD(e.UserData)
+			size, roflags, addr, errno := s.System.SockRecvFrom(ctx, fd, []IOVec{s.buffer}, 0)
+			if errno != ESUCCESS {
+				// Leave the error to be reported when the guest
+				// attempts to receive from the socket.
+				continue
+			}
+			s.queues[fd].push(datagram{
+				data:    append([]byte(nil), s.buffer[:size]...),
+				addr:    addr,
+				roflags: roflags,
+			})
+			received = true
+		}
+		if !received {
+			return
+		}
+	}
+}
+
+func (s *bufferedDatagrams) recv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, SocketAddress, Errno, bool) {
+	q := s.queue(ctx, fd)
+	if q == nil {
+		return 0, 0, nil, ESUCCESS, false
+	}
+	s.drain(ctx)
+	if q.len() == 0 {
+		return 0, 0, nil, ESUCCESS, false
+	}
+	d := q.peek()
+	n, data := 0, d.data
+	for _, iov := range iovecs {
+		c := copy(iov, data)
+		data = data[c:]
+		n += c
+	}
+	roflags := d.roflags
+	if len(data) > 0 {
+		roflags |= RecvDataTruncated
+	}
+	addr := d.addr
+	if !flags.Has(RecvPeek) {
+		q.pop()
+	}
+	return Size(n), roflags, addr, ESUCCESS, true
+}
+
+func (s *bufferedDatagrams) SockOpen(ctx context.Context, family ProtocolFamily, socketType SocketType, protocol Protocol, rightsBase, rightsInheriting Rights) (FD, Errno) {
+	fd, errno := s.System.SockOpen(ctx, family, socketType, protocol, rightsBase, rightsInheriting)
+	if errno == ESUCCESS && socketType == DatagramSocket {
+		s.track(fd)
+	}
+	return fd, errno
+}
+
+func (s *bufferedDatagrams) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	if q := s.queues[fd]; q != nil && q.len() > 0 {
+		n, _, _, errno, _ := s.recv(ctx, fd, iovecs, 0)
+		return n, errno
+	}
+	return s.System.FDRead(ctx, fd, iovecs)
+}
+
+func (s *bufferedDatagrams) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, Errno) {
+	if n, roflags, _, errno, ok := s.recv(ctx, fd, iovecs, flags); ok {
+		return n, roflags, errno
+	}
+	return s.System.SockRecv(ctx, fd, iovecs, flags)
+}
+
+func (s *bufferedDatagrams) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, SocketAddress, Errno) {
+	if n, roflags, addr, errno, ok := s.recv(ctx, fd, iovecs, flags); ok {
+		return n, roflags, addr, errno
+	}
+	return s.System.SockRecvFrom(ctx, fd, iovecs, flags)
+}
+
+func (s *bufferedDatagrams) SockSend(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags) (Size, Errno) {
+	n, errno := s.System.SockSend(ctx, fd, iovecs, flags)
+	s.drain(ctx)
+	return n, errno
+}
+
+func (s *bufferedDatagrams) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags, addr SocketAddress) (Size, Errno) {
+	n, errno := s.System.SockSendTo(ctx, fd, iovecs, flags, addr)
+	s.drain(ctx)
+	return n, errno
+}
+
+func (s *bufferedDatagrams) PollOneOff(ctx context.Context, subscriptions []Subscription, events []Event) (int, Errno) {
+	for i := range subscriptions {
+		if sub := &subscriptions[i]; sub.EventType == FDReadEvent {
+			s.queue(ctx, sub.GetFDReadWrite().FD)
+		}
+	}
+	s.drain(ctx)
+
+	// Subscriptions to read events on sockets with queued datagrams complete
+	// immediately; there is no need to wait on the underlying system.
+	n := 0
+	for i := range subscriptions {
+		sub := &subscriptions[i]
+		if sub.EventType != FDReadEvent {
+			continue
+		}
+		if q := s.queues[sub.GetFDReadWrite().FD]; q != nil && q.len() > 0 && n < len(events) {
+			events[n] = Event{
+				UserData:    sub.UserData,
+				EventType:   FDReadEvent,
+				FDReadWrite: EventFDReadWrite{NBytes: FileSize(len(q.peek().data))},
+			}
+			n++
+		}
+	}
+	if n > 0 {
+		return n, ESUCCESS
+	}
+	return s.System.PollOneOff(ctx, subscriptions, events)
+}
+
+func (s *bufferedDatagrams) FDClose(ctx context.Context, fd FD) Errno {
+	delete(s.queues, fd)
+	return s.System.FDClose(ctx, fd)
+}
+
+func (s *bufferedDatagrams) FDRenumber(ctx context.Context, from, to FD) Errno {
+	errno := s.System.FDRenumber(ctx, from, to)
+	if errno == ESUCCESS {
+		if q, ok := s.queues[from]; ok {
+			s.queues[to] = q
+			delete(s.queues, from)
+		} else {
+			delete(s.queues, to)
+		}
+	}
+	return errno
+}
@@ -0,0 +1,118 @@
+package wasi_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+func TestBufferedDatagrams(t *testing.T) {
+	ctx := context.Background()
+
+	burst := func(t *testing.T, s wasi.System, count int) (received []string) {
+		fd, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.DatagramSocket, wasi.UDPProtocol, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		for i := 0; i < count; i++ {
+			msg := []byte(fmt.Sprintf("message %d", i))
+			if _, errno := s.SockSendTo(ctx, fd, []wasi.IOVec{msg}, 0, &wasi.Inet4Address{Port: 4242}); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+		}
+		for {
+			b := make([]byte, 64)
+			n, _, _, errno := s.SockRecvFrom(ctx, fd, []wasi.IOVec{b}, 0)
+			if errno == wasi.EAGAIN {
+				return received
+			}
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			received = append(received, string(b[:n]))
+		}
+	}
+
+	t.Run("bursts of datagrams are dropped without buffering", func(t *testing.T) {
+		d := newFakeSystem()
+		d.maxDatagrams = 8
+		if received := burst(t, d, 100); len(received) != 8 || d.dropped != 92 {
+			t.Errorf("wrong number of datagrams: received=%d dropped=%d", len(received), d.dropped)
+		}
+	})
+
+	t.Run("bursts of datagrams are all received with buffering", func(t *testing.T) {
+		d := newFakeSystem()
+		d.maxDatagrams = 8
+		received := burst(t, wasi.BufferedDatagrams(d, 128), 100)
+		if d.dropped != 0 {
+			t.Errorf("%d datagrams were dropped", d.dropped)
+		}
+		if len(received) != 100 {
+			t.Fatalf("wrong number of datagrams received: %d", len(received))
+		}
+		for i, msg := range received {
+			if want := fmt.Sprintf("message %d", i); msg != want {
+				t.Errorf("wrong datagram at index %d: want %q, got %q", i, want, msg)
+			}
+		}
+	})
+
+	t.Run("datagrams are left in the kernel when the queue is full", func(t *testing.T) {
+		d := newFakeSystem()
+		d.maxDatagrams = 8
+		received := burst(t, wasi.BufferedDatagrams(d, 4), 100)
+		if len(received) != 12 || d.dropped != 88 {
+			t.Errorf("wrong number of datagrams: received=%d dropped=%d", len(received), d.dropped)
+		}
+	})
+
+	t.Run("queued datagrams preserve message boundaries", func(t *testing.T) {
+		d := newFakeSystem()
+		d.maxDatagrams = 8
+		s := wasi.BufferedDatagrams(d, 8)
+		fd, _ := s.SockOpen(ctx, wasi.InetFamily, wasi.DatagramSocket, wasi.UDPProtocol, wasi.AllRights, wasi.AllRights)
+		s.SockSendTo(ctx, fd, []wasi.IOVec{[]byte("hello world")}, 0, &wasi.Inet4Address{Port: 4242})
+		s.SockSendTo(ctx, fd, []wasi.IOVec{[]byte("second")}, 0, &wasi.Inet4Address{Port: 4242})
+
+		b := make([]byte, 5)
+		n, roflags, errno := s.SockRecv(ctx, fd, []wasi.IOVec{b}, wasi.RecvPeek)
+		if errno != wasi.ESUCCESS || string(b[:n]) != "hello" || !roflags.Has(wasi.RecvDataTruncated) {
+			t.Fatalf("wrong peek: %q (%s, %s)", b[:n], roflags, errno)
+		}
+		n, roflags, errno = s.SockRecv(ctx, fd, []wasi.IOVec{b}, 0)
+		if errno != wasi.ESUCCESS || string(b[:n]) != "hello" || !roflags.Has(wasi.RecvDataTruncated) {
+			t.Fatalf("wrong read: %q (%s, %s)", b[:n], roflags, errno)
+		}
+		b = make([]byte, 64)
+		n, roflags, errno = s.SockRecv(ctx, fd, []wasi.IOVec{b}, 0)
+		if errno != wasi.ESUCCESS || string(b[:n]) != "second" || roflags.Has(wasi.RecvDataTruncated) {
+			t.Fatalf("wrong read: %q (%s, %s)", b[:n], roflags, errno)
+		}
+	})
+
+	t.Run("queued datagrams are ready for reading", func(t *testing.T) {
+		d := newFakeSystem()
+		d.maxDatagrams = 8
+		s := wasi.BufferedDatagrams(d, 8)
+		fd, _ := s.SockOpen(ctx, wasi.InetFamily, wasi.DatagramSocket, wasi.UDPProtocol, wasi.AllRights, wasi.AllRights)
+		s.SockSendTo(ctx, fd, []wasi.IOVec{[]byte("hello")}, 0, &wasi.Inet4Address{Port: 4242})
+		if len(d.files[fd].datagrams) != 0 {
+			t.Fatal("datagram was not drained from the kernel buffer")
+		}
+
+		subscriptions := []wasi.Subscription{
+			wasi.MakeSubscriptionFDReadWrite(42, wasi.FDReadEvent, wasi.SubscriptionFDReadWrite{FD: fd}),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+		n, errno := s.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS || n != 1 {
+			t.Fatalf("wrong poll result: %d (%s)", n, errno)
+		}
+		if events[0].UserData != 42 || events[0].FDReadWrite.NBytes != 5 {
+			t.Errorf("wrong event: %+v", events[0])
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package wasi
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// DialPolicy is a function deciding whether a guest may connect sockets, or
+// send datagrams, to the given address. The function returns ESUCCESS to
+// permit the operation, or the error code that the operation fails with.
+type DialPolicy func(addr SocketAddress) Errno
+
+// DialRule matches destination addresses in a network prefix, and on a
+// specific port. A zero port matches all ports.
+type DialRule struct {
+	Prefix netip.Prefix
+	Port   int
+}
+
+func (r DialRule) String() string {
+	port := "*"
+	if r.Port != 0 {
+		port = strconv.Itoa(r.Port)
+	}
+	if r.Prefix.Addr().Is6() {
+		return "[" + r.Prefix.String() + "]:" + port
+	}
+	return r.Prefix.String() + ":" + port
+}
+
+// Match returns true if addr is an internet address matched by the rule.
+func (r DialRule) Match(addr SocketAddress) bool {
+	var ip netip.Addr
+	var port int
+	switch a := addr.(type) {
+	case *Inet4Address:
+		ip, port = netip.AddrFrom4(a.Addr), a.Port
+	case *Inet6Address:
+		ip, port = netip.AddrFrom16(a.Addr).Unmap(), a.Port
+	default:
+		return false
+	}
+	return r.Prefix.Contains(ip) && (r.Port == 0 || r.Port == port)
+}
+
+// ParseDialRule parses a rule in the form PREFIX:PORT, where PREFIX is an IP
+// address or a network in CIDR notation, and PORT is a port number or "*" to
+// match all ports. IPv6 prefixes must be enclosed in square brackets, for
+// example [2001:db8::/32]:443.
+func ParseDialRule(s string) (DialRule, error) {
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return DialRule{}, fmt.Errorf("invalid dial rule %q, expected PREFIX:PORT", s)
+	}
+	prefix, port := s[:i], s[i+1:]
+	if strings.HasPrefix(prefix, "[") && strings.HasSuffix(prefix, "]") {
+		prefix = prefix[1 : len(prefix)-1]
+	}
+
+	var rule DialRule
+	var err error
+	if addr, parseErr := netip.ParseAddr(prefix); parseErr == nil {
+		addr = addr.Unmap()
+		rule.Prefix = netip.PrefixFrom(addr, addr.BitLen())
+	} else if rule.Prefix, err = netip.ParsePrefix(prefix); err != nil {
+		return DialRule{}, fmt.Errorf("invalid prefix in dial rule %q: %w", s, err)
+	}
+	rule.Prefix = rule.Prefix.Masked()
+
+	if port != "*" {
+		rule.Port, err = strconv.Atoi(port)
+		if err != nil || rule.Port <= 0 || rule.Port > 65535 {
+			return DialRule{}, fmt.Errorf("invalid port in dial rule %q", s)
+		}
+	}
+	return rule, nil
+}
+
+// AllowDial returns a DialPolicy which permits internet addresses matched by
+// at least one of the rules, and unix addresses. Other addresses are denied
+// with EACCES.
+func AllowDial(rules ...DialRule) DialPolicy {
+	return func(addr SocketAddress) Errno {
+		if _, ok := addr.(*UnixAddress); ok {
+			return ESUCCESS
+		}
+		for _, rule := range rules {
+			if rule.Match(addr) {
+				return ESUCCESS
+			}
+		}
+		return EACCES
+	}
+}
@@ -0,0 +1,97 @@
+package wasi_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+func TestParseDialRule(t *testing.T) {
+	for _, test := range []struct {
+		rule string
+		want wasi.DialRule
+	}{
+		{"10.0.0.0/8:443", wasi.DialRule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Port: 443}},
+		{"10.1.2.3/8:443", wasi.DialRule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Port: 443}},
+		{"192.168.1.1:*", wasi.DialRule{Prefix: netip.MustParsePrefix("192.168.1.1/32")}},
+		{"[2001:db8::/32]:80", wasi.DialRule{Prefix: netip.MustParsePrefix("2001:db8::/32"), Port: 80}},
+		{"[::1]:8080", wasi.DialRule{Prefix: netip.MustParsePrefix("::1/128"), Port: 8080}},
+		{"[::ffff:10.0.0.1]:53", wasi.DialRule{Prefix: netip.MustParsePrefix("10.0.0.1/32"), Port: 53}},
+	} {
+		rule, err := wasi.ParseDialRule(test.rule)
+		if err != nil {
+			t.Errorf("%s: %v", test.rule, err)
+		} else if rule != test.want {
+			t.Errorf("%s: want %s, got %s", test.rule, test.want, rule)
+		}
+	}
+
+	for _, rule := range []string{
+		"",
+		"10.0.0.0/8",
+		"10.0.0.0/33:443",
+		"10.0.0.0/8:0",
+		"10.0.0.0/8:65536",
+		"10.0.0.0/8:http",
+		"2001:db8::/32",
+		"example.com:443",
+	} {
+		if _, err := wasi.ParseDialRule(rule); err == nil {
+			t.Errorf("%q: expected an error", rule)
+		}
+	}
+}
+
+func TestAllowDial(t *testing.T) {
+	var rules []wasi.DialRule
+	for _, rule := range []string{
+		"10.0.0.0/8:443",
+		"192.168.1.1:*",
+		"[2001:db8::/32]:443",
+		"[::1]:8080",
+	} {
+		r, err := wasi.ParseDialRule(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	policy := wasi.AllowDial(rules...)
+
+	inet4 := func(a, b, c, d byte, port int) wasi.SocketAddress {
+		return &wasi.Inet4Address{Addr: [4]byte{a, b, c, d}, Port: port}
+	}
+	inet6 := func(addr string, port int) wasi.SocketAddress {
+		return &wasi.Inet6Address{Addr: netip.MustParseAddr(addr).As16(), Port: port}
+	}
+
+	for _, test := range []struct {
+		addr wasi.SocketAddress
+		want wasi.Errno
+	}{
+		{inet4(10, 0, 0, 1, 443), wasi.ESUCCESS},
+		{inet4(10, 255, 255, 255, 443), wasi.ESUCCESS},
+		{inet4(10, 0, 0, 1, 80), wasi.EACCES},
+		{inet4(11, 0, 0, 1, 443), wasi.EACCES},
+		{inet4(192, 168, 1, 1, 22), wasi.ESUCCESS},
+		{inet4(192, 168, 1, 2, 22), wasi.EACCES},
+		{inet6("::ffff:10.1.2.3", 443), wasi.ESUCCESS},
+		{inet6("::ffff:10.1.2.3", 80), wasi.EACCES},
+		{inet6("2001:db8::1", 443), wasi.ESUCCESS},
+		{inet6("2001:db8:ffff::1", 443), wasi.ESUCCESS},
+		{inet6("2001:db9::1", 443), wasi.EACCES},
+		{inet6("2001:db8::1", 80), wasi.EACCES},
+		{inet6("::1", 8080), wasi.ESUCCESS},
+		{inet6("::1", 8081), wasi.EACCES},
+		{&wasi.UnixAddress{Name: "/tmp/socket"}, wasi.ESUCCESS},
+	} {
+		if errno := policy(test.addr); errno != test.want {
+			t.Errorf("%s: want %s, got %s", test.addr, test.want, errno)
+		}
+	}
+
+	if errno := wasi.AllowDial()(inet4(127, 0, 0, 1, 80)); errno != wasi.EACCES {
+		t.Errorf("empty policy: want EACCES, got %s", errno)
+	}
+}
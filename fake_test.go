@@ -0,0 +1,666 @@
+package wasi_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+// fakeSystem is an in-memory wasi.System used to test the wrappers of this
+// package.
+//
+// Files are buffers: writes append data to the buffer of the file descriptor
+// and reads consume it, so sockets are connected to themselves. Reads on an
+// empty socket return EAGAIN if it is non-blocking, and zero bytes (EOF)
+// otherwise. Datagram sockets hold a queue of messages instead of a buffer,
+// reads on an empty queue always return EAGAIN.
+//
+// Files opened with PathOpen are looked up by name in paths, the directory
+// is only checked to be a directory. The methods that the fake does not
+// emulate return ENOSYS.
+type fakeSystem struct {
+	files map[wasi.FD]*fakeFile
+	paths map[string]*fakeFile
+	// calls counts the calls to each method of the system.
+	calls map[string]int
+	// closed counts the calls to Close.
+	closed int
+
+	// maxIO limits the number of bytes transferred by each read and write,
+	// zero means no limit.
+	maxIO int
+	// maxDatagrams limits the number of messages queued on datagram
+	// sockets, zero means no limit. Messages sent to a full queue are
+	// dropped and counted in dropped.
+	maxDatagrams int
+	dropped      int
+}
+
+type fakeFile struct {
+	stat      wasi.FDStat
+	data      []byte
+	datagrams []fakeDatagram
+	preopen   string
+	addr      wasi.SocketAddress
+	options   map[wasi.SocketOption]wasi.SocketOptionValue
+}
+
+type fakeDatagram struct {
+	data []byte
+	addr wasi.SocketAddress
+}
+
+var _ wasi.System = (*fakeSystem)(nil)
+
+// newFakeSystem returns a fakeSystem with stdio open on file descriptors 0, 1
+// and 2.
+func newFakeSystem() *fakeSystem {
+	s := &fakeSystem{
+		files: make(map[wasi.FD]*fakeFile),
+		paths: make(map[string]*fakeFile),
+		calls: make(map[string]int),
+	}
+	for fd := wasi.FD(0); fd <= 2; fd++ {
+		s.open(fd, wasi.CharacterDeviceType, "")
+	}
+	return s
+}
+
+// open opens a file of the given type on fd, replacing the file that was
+// open on it, with data ready to be read.
+func (s *fakeSystem) open(fd wasi.FD, fileType wasi.FileType, data string) *fakeFile {
+	f := newFakeFile(fileType)
+	f.data = []byte(data)
+	s.files[fd] = f
+	return f
+}
+
+// preopen opens a directory named path on fd.
+func (s *fakeSystem) preopen(fd wasi.FD, path string) *fakeFile {
+	f := s.open(fd, wasi.DirectoryType, "")
+	f.preopen = path
+	return f
+}
+
+func newFakeFile(fileType wasi.FileType) *fakeFile {
+	f := &fakeFile{stat: wasi.FDStat{FileType: fileType}}
+	switch fileType {
+	case wasi.DirectoryType:
+		f.stat.RightsBase = wasi.DirectoryRights
+		f.stat.RightsInheriting = wasi.DirectoryRights | wasi.FileRights
+	case wasi.SocketStreamType, wasi.SocketDGramType:
+		f.stat.RightsBase = wasi.SockConnectionRights | wasi.SockListenRights
+	default:
+		f.stat.RightsBase = wasi.FileRights
+	}
+	return f
+}
+
+func (s *fakeSystem) register(f *fakeFile) wasi.FD {
+	fd := wasi.FD(3)
+	for s.files[fd] != nil {
+		fd++
+	}
+	s.files[fd] = f
+	return fd
+}
+
+func (s *fakeSystem) lookup(method string, fd wasi.FD) (*fakeFile, wasi.Errno) {
+	s.calls[method]++
+	f := s.files[fd]
+	if f == nil {
+		return nil, wasi.EBADF
+	}
+	return f, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) socket(method string, fd wasi.FD) (*fakeFile, wasi.Errno) {
+	f, errno := s.lookup(method, fd)
+	if errno != wasi.ESUCCESS {
+		return nil, errno
+	}
+	if !f.isSocket() {
+		return nil, wasi.ENOTSOCK
+	}
+	return f, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) notImplemented(method string) wasi.Errno {
+	s.calls[method]++
+	return wasi.ENOSYS
+}
+
+func (f *fakeFile) isSocket() bool {
+	return f.stat.FileType == wasi.SocketStreamType || f.stat.FileType == wasi.SocketDGramType
+}
+
+func (s *fakeSystem) limit(n int) int {
+	if s.maxIO > 0 && n > s.maxIO {
+		return s.maxIO
+	}
+	return n
+}
+
+func (s *fakeSystem) read(f *fakeFile, iovecs []wasi.IOVec, peek bool) (wasi.Size, wasi.ROFlags, wasi.SocketAddress, wasi.Errno) {
+	var data []byte
+	var addr wasi.SocketAddress
+	if f.stat.FileType == wasi.SocketDGramType {
+		if len(f.datagrams) == 0 {
+			return ^wasi.Size(0), 0, nil, wasi.EAGAIN
+		}
+		data, addr = f.datagrams[0].data, f.datagrams[0].addr
+	} else {
+		if len(f.data) == 0 && f.isSocket() && f.stat.Flags.Has(wasi.NonBlock) {
+			return ^wasi.Size(0), 0, nil, wasi.EAGAIN
+		}
+		data = f.data[:s.limit(len(f.data))]
+	}
+	n := 0
+	for _, iov := range iovecs {
+		n += copy(iov, data[n:])
+	}
+	var roflags wasi.ROFlags
+	if f.stat.FileType == wasi.SocketDGramType {
+		if n < len(data) {
+			roflags |= wasi.RecvDataTruncated
+		}
+		if !peek {
+			f.datagrams = f.datagrams[1:]
+		}
+	} else if !peek {
+		f.data = f.data[n:]
+	}
+	return wasi.Size(n), roflags, addr, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) write(f *fakeFile, iovecs []wasi.IOVec, addr wasi.SocketAddress) (wasi.Size, wasi.Errno) {
+	var data []byte
+	for _, iov := range iovecs {
+		data = append(data, iov...)
+	}
+	if f.stat.FileType == wasi.SocketDGramType {
+		if s.maxDatagrams > 0 && len(f.datagrams) >= s.maxDatagrams {
+			s.dropped++
+		} else {
+			f.datagrams = append(f.datagrams, fakeDatagram{data, addr})
+		}
+		return wasi.Size(len(data)), wasi.ESUCCESS
+	}
+	data = data[:s.limit(len(data))]
+	f.data = append(f.data, data...)
+	return wasi.Size(len(data)), wasi.ESUCCESS
+}
+
+func (s *fakeSystem) ArgsSizesGet(ctx context.Context) (int, int, wasi.Errno) {
+	s.calls["ArgsSizesGet"]++
+	return 0, 0, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) ArgsGet(ctx context.Context) ([]string, wasi.Errno) {
+	s.calls["ArgsGet"]++
+	return nil, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) EnvironSizesGet(ctx context.Context) (int, int, wasi.Errno) {
+	s.calls["EnvironSizesGet"]++
+	return 0, 0, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) EnvironGet(ctx context.Context) ([]string, wasi.Errno) {
+	s.calls["EnvironGet"]++
+	return nil, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) ClockResGet(ctx context.Context, id wasi.ClockID) (wasi.Timestamp, wasi.Errno) {
+	return 0, s.notImplemented("ClockResGet")
+}
+
+func (s *fakeSystem) ClockTimeGet(ctx context.Context, id wasi.ClockID, precision wasi.Timestamp) (wasi.Timestamp, wasi.Errno) {
+	return 0, s.notImplemented("ClockTimeGet")
+}
+
+func (s *fakeSystem) FDAdvise(ctx context.Context, fd wasi.FD, offset, length wasi.FileSize, advice wasi.Advice) wasi.Errno {
+	return s.notImplemented("FDAdvise")
+}
+
+func (s *fakeSystem) FDAllocate(ctx context.Context, fd wasi.FD, offset, length wasi.FileSize) wasi.Errno {
+	return s.notImplemented("FDAllocate")
+}
+
+func (s *fakeSystem) FDClose(ctx context.Context, fd wasi.FD) wasi.Errno {
+	if _, errno := s.lookup("FDClose", fd); errno != wasi.ESUCCESS {
+		return errno
+	}
+	delete(s.files, fd)
+	return wasi.ESUCCESS
+}
+
+func (s *fakeSystem) FDDataSync(ctx context.Context, fd wasi.FD) wasi.Errno {
+	_, errno := s.lookup("FDDataSync", fd)
+	return errno
+}
+
+func (s *fakeSystem) FDStatGet(ctx context.Context, fd wasi.FD) (wasi.FDStat, wasi.Errno) {
+	f, errno := s.lookup("FDStatGet", fd)
+	if errno != wasi.ESUCCESS {
+		return wasi.FDStat{}, errno
+	}
+	return f.stat, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) FDStatSetFlags(ctx context.Context, fd wasi.FD, flags wasi.FDFlags) wasi.Errno {
+	f, errno := s.lookup("FDStatSetFlags", fd)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	f.stat.Flags = flags
+	return wasi.ESUCCESS
+}
+
+func (s *fakeSystem) FDStatSwapNonBlock(ctx context.Context, fd wasi.FD, nonBlock bool) (bool, wasi.Errno) {
+	f, errno := s.lookup("FDStatSwapNonBlock", fd)
+	if errno != wasi.ESUCCESS {
+		return false, errno
+	}
+	prev := f.stat.Flags.Has(wasi.NonBlock)
+	if nonBlock {
+		f.stat.Flags |= wasi.NonBlock
+	} else {
+		f.stat.Flags &^= wasi.NonBlock
+	}
+	return prev, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) FDStatSetRights(ctx context.Context, fd wasi.FD, rightsBase, rightsInheriting wasi.Rights) wasi.Errno {
+	f, errno := s.lookup("FDStatSetRights", fd)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	if !f.stat.RightsBase.Has(rightsBase) || !f.stat.RightsInheriting.Has(rightsInheriting) {
+		return wasi.ENOTCAPABLE
+	}
+	f.stat.RightsBase, f.stat.RightsInheriting = rightsBase, rightsInheriting
+	return wasi.ESUCCESS
+}
+
+func (s *fakeSystem) FDFileStatGet(ctx context.Context, fd wasi.FD) (wasi.FileStat, wasi.Errno) {
+	f, errno := s.lookup("FDFileStatGet", fd)
+	if errno != wasi.ESUCCESS {
+		return wasi.FileStat{}, errno
+	}
+	return wasi.FileStat{FileType: f.stat.FileType, NLink: 1, Size: wasi.FileSize(len(f.data))}, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) FDFileStatSetSize(ctx context.Context, fd wasi.FD, size wasi.FileSize) wasi.Errno {
+	f, errno := s.lookup("FDFileStatSetSize", fd)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	if f.stat.FileType != wasi.RegularFileType {
+		return wasi.EINVAL
+	}
+	if int(size) <= len(f.data) {
+		f.data = f.data[:size]
+	} else {
+		f.data = append(f.data, make([]byte, int(size)-len(f.data))...)
+	}
+	return wasi.ESUCCESS
+}
+
+func (s *fakeSystem) FDFileStatSetTimes(ctx context.Context, fd wasi.FD, accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) wasi.Errno {
+	return s.notImplemented("FDFileStatSetTimes")
+}
+
+func (s *fakeSystem) FDPread(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
+	return ^wasi.Size(0), s.notImplemented("FDPread")
+}
+
+func (s *fakeSystem) FDPreStatGet(ctx context.Context, fd wasi.FD) (wasi.PreStat, wasi.Errno) {
+	f, errno := s.lookup("FDPreStatGet", fd)
+	if errno != wasi.ESUCCESS {
+		return wasi.PreStat{}, errno
+	}
+	if f.preopen == "" {
+		return wasi.PreStat{}, wasi.EBADF
+	}
+	return wasi.PreStat{
+		Type:       wasi.PreOpenDir,
+		PreStatDir: wasi.PreStatDir{NameLength: wasi.Size(len(f.preopen))},
+	}, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) FDPreStatDirName(ctx context.Context, fd wasi.FD) (string, wasi.Errno) {
+	f, errno := s.lookup("FDPreStatDirName", fd)
+	if errno != wasi.ESUCCESS {
+		return "", errno
+	}
+	if f.preopen == "" {
+		return "", wasi.EBADF
+	}
+	return f.preopen, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) FDPwrite(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
+	return ^wasi.Size(0), s.notImplemented("FDPwrite")
+}
+
+func (s *fakeSystem) FDCopyRange(ctx context.Context, srcFD, dstFD wasi.FD, srcOffset, dstOffset, length wasi.FileSize) (wasi.FileSize, wasi.Errno) {
+	return 0, s.notImplemented("FDCopyRange")
+}
+
+func (s *fakeSystem) FDRead(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	f, errno := s.lookup("FDRead", fd)
+	if errno != wasi.ESUCCESS {
+		return ^wasi.Size(0), errno
+	}
+	n, _, _, errno := s.read(f, iovecs, false)
+	return n, errno
+}
+
+func (s *fakeSystem) FDReadDir(ctx context.Context, fd wasi.FD, entries []wasi.DirEntry, cookie wasi.DirCookie, bufferSizeBytes int) (int, wasi.Errno) {
+	return 0, s.notImplemented("FDReadDir")
+}
+
+func (s *fakeSystem) FDRenumber(ctx context.Context, from, to wasi.FD) wasi.Errno {
+	f, errno := s.lookup("FDRenumber", from)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	if s.files[to] == nil {
+		return wasi.EBADF
+	}
+	delete(s.files, from)
+	s.files[to] = f
+	return wasi.ESUCCESS
+}
+
+func (s *fakeSystem) FDSeek(ctx context.Context, fd wasi.FD, offset wasi.FileDelta, whence wasi.Whence) (wasi.FileSize, wasi.Errno) {
+	return 0, s.notImplemented("FDSeek")
+}
+
+func (s *fakeSystem) FDSync(ctx context.Context, fd wasi.FD) wasi.Errno {
+	_, errno := s.lookup("FDSync", fd)
+	return errno
+}
+
+func (s *fakeSystem) FDTell(ctx context.Context, fd wasi.FD) (wasi.FileSize, wasi.Errno) {
+	return 0, s.notImplemented("FDTell")
+}
+
+func (s *fakeSystem) FDWrite(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	f, errno := s.lookup("FDWrite", fd)
+	if errno != wasi.ESUCCESS {
+		return ^wasi.Size(0), errno
+	}
+	return s.write(f, iovecs, nil)
+}
+
+func (s *fakeSystem) PathCreateDirectory(ctx context.Context, fd wasi.FD, path string) wasi.Errno {
+	return s.notImplemented("PathCreateDirectory")
+}
+
+func (s *fakeSystem) PathFileStatGet(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path string) (wasi.FileStat, wasi.Errno) {
+	return wasi.FileStat{}, s.notImplemented("PathFileStatGet")
+}
+
+func (s *fakeSystem) PathFileStatSetTimes(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path string, accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) wasi.Errno {
+	return s.notImplemented("PathFileStatSetTimes")
+}
+
+func (s *fakeSystem) PathGetXattr(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path, name string, buffer []byte) (int, wasi.Errno) {
+	return 0, s.notImplemented("PathGetXattr")
+}
+
+func (s *fakeSystem) PathListXattr(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path string, buffer []byte) (int, wasi.Errno) {
+	return 0, s.notImplemented("PathListXattr")
+}
+
+func (s *fakeSystem) PathSetXattr(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path, name string, value []byte) wasi.Errno {
+	return s.notImplemented("PathSetXattr")
+}
+
+func (s *fakeSystem) PathLink(ctx context.Context, oldFD wasi.FD, oldFlags wasi.LookupFlags, oldPath string, newFD wasi.FD, newPath string) wasi.Errno {
+	return s.notImplemented("PathLink")
+}
+
+func (s *fakeSystem) PathOpen(ctx context.Context, fd wasi.FD, dirFlags wasi.LookupFlags, path string, openFlags wasi.OpenFlags, rightsBase, rightsInheriting wasi.Rights, fdFlags wasi.FDFlags) (wasi.FD, wasi.Errno) {
+	d, errno := s.lookup("PathOpen", fd)
+	if errno != wasi.ESUCCESS {
+		return -1, errno
+	}
+	if d.stat.FileType != wasi.DirectoryType {
+		return -1, wasi.ENOTDIR
+	}
+	f := s.paths[path]
+	if f == nil {
+		if !openFlags.Has(wasi.OpenCreate) {
+			return -1, wasi.ENOENT
+		}
+		f = newFakeFile(wasi.RegularFileType)
+		s.paths[path] = f
+	}
+	return s.register(f), wasi.ESUCCESS
+}
+
+func (s *fakeSystem) PathReadLink(ctx context.Context, fd wasi.FD, path string, buffer []byte) (int, wasi.Errno) {
+	return 0, s.notImplemented("PathReadLink")
+}
+
+func (s *fakeSystem) PathRemoveDirectory(ctx context.Context, fd wasi.FD, path string) wasi.Errno {
+	return s.notImplemented("PathRemoveDirectory")
+}
+
+func (s *fakeSystem) PathRename(ctx context.Context, fd wasi.FD, oldPath string, newFD wasi.FD, newPath string) wasi.Errno {
+	return s.notImplemented("PathRename")
+}
+
+func (s *fakeSystem) PathSymlink(ctx context.Context, oldPath string, fd wasi.FD, newPath string) wasi.Errno {
+	return s.notImplemented("PathSymlink")
+}
+
+func (s *fakeSystem) PathUnlinkFile(ctx context.Context, fd wasi.FD, path string) wasi.Errno {
+	return s.notImplemented("PathUnlinkFile")
+}
+
+// PollOneOff reports the file descriptors ready for reading and writing.
+// When none are ready, it sleeps until the earliest timeout of the clock
+// subscriptions, which are all interpreted as relative timeouts.
+func (s *fakeSystem) PollOneOff(ctx context.Context, subscriptions []wasi.Subscription, events []wasi.Event) (int, wasi.Errno) {
+	s.calls["PollOneOff"]++
+	if len(subscriptions) == 0 || len(events) < len(subscriptions) {
+		return 0, wasi.EINVAL
+	}
+	n := 0
+	timeout := time.Duration(-1)
+	for i := range subscriptions {
+		sub := &subscriptions[i]
+		if sub.EventType == wasi.ClockEvent {
+			if t := sub.GetClock().Timeout.Duration(); timeout < 0 || t < timeout {
+				timeout = t
+			}
+			continue
+		}
+		event := wasi.Event{UserData: sub.UserData, EventType: sub.EventType}
+		f := s.files[sub.GetFDReadWrite().FD]
+		switch {
+		case f == nil:
+			event.Errno = wasi.EBADF
+		case sub.EventType == wasi.FDReadEvent && len(f.datagrams) > 0:
+			event.FDReadWrite.NBytes = wasi.FileSize(len(f.datagrams[0].data))
+		case sub.EventType == wasi.FDReadEvent && (f.isSocket() && len(f.data) == 0):
+			continue
+		case sub.EventType == wasi.FDReadEvent:
+			event.FDReadWrite.NBytes = wasi.FileSize(len(f.data))
+		}
+		events[n] = event
+		n++
+	}
+	if n > 0 || timeout < 0 {
+		return n, wasi.ESUCCESS
+	}
+	time.Sleep(timeout)
+	for i := range subscriptions {
+		sub := &subscriptions[i]
+		if sub.EventType == wasi.ClockEvent && sub.GetClock().Timeout.Duration() == timeout {
+			events[n] = wasi.Event{UserData: sub.UserData, EventType: wasi.ClockEvent}
+			n++
+		}
+	}
+	return n, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) ProcExit(ctx context.Context, exitCode wasi.ExitCode) wasi.Errno {
+	return s.notImplemented("ProcExit")
+}
+
+func (s *fakeSystem) ProcRaise(ctx context.Context, signal wasi.Signal) wasi.Errno {
+	return s.notImplemented("ProcRaise")
+}
+
+func (s *fakeSystem) SchedYield(ctx context.Context) wasi.Errno {
+	s.calls["SchedYield"]++
+	return wasi.ESUCCESS
+}
+
+func (s *fakeSystem) RandomGet(ctx context.Context, b []byte) wasi.Errno {
+	return s.notImplemented("RandomGet")
+}
+
+func (s *fakeSystem) SockOpen(ctx context.Context, family wasi.ProtocolFamily, socketType wasi.SocketType, protocol wasi.Protocol, rightsBase, rightsInheriting wasi.Rights) (wasi.FD, wasi.Errno) {
+	s.calls["SockOpen"]++
+	switch socketType {
+	case wasi.StreamSocket:
+		return s.register(newFakeFile(wasi.SocketStreamType)), wasi.ESUCCESS
+	case wasi.DatagramSocket:
+		return s.register(newFakeFile(wasi.SocketDGramType)), wasi.ESUCCESS
+	default:
+		return -1, wasi.EPROTOTYPE
+	}
+}
+
+func (s *fakeSystem) SockBind(ctx context.Context, fd wasi.FD, addr wasi.SocketAddress) (wasi.SocketAddress, wasi.Errno) {
+	f, errno := s.socket("SockBind", fd)
+	if errno != wasi.ESUCCESS {
+		return nil, errno
+	}
+	f.addr = addr
+	return addr, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) SockConnect(ctx context.Context, fd wasi.FD, peer wasi.SocketAddress) (wasi.SocketAddress, wasi.Errno) {
+	return nil, s.notImplemented("SockConnect")
+}
+
+func (s *fakeSystem) SockListen(ctx context.Context, fd wasi.FD, backlog int) wasi.Errno {
+	return s.notImplemented("SockListen")
+}
+
+func (s *fakeSystem) SockAccept(ctx context.Context, fd wasi.FD, flags wasi.FDFlags) (wasi.FD, wasi.SocketAddress, wasi.SocketAddress, wasi.Errno) {
+	return -1, nil, nil, s.notImplemented("SockAccept")
+}
+
+func (s *fakeSystem) SockRecv(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, flags wasi.RIFlags) (wasi.Size, wasi.ROFlags, wasi.Errno) {
+	f, errno := s.socket("SockRecv", fd)
+	if errno != wasi.ESUCCESS {
+		return ^wasi.Size(0), 0, errno
+	}
+	n, roflags, _, errno := s.read(f, iovecs, flags.Has(wasi.RecvPeek))
+	return n, roflags, errno
+}
+
+func (s *fakeSystem) SockSend(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, flags wasi.SIFlags) (wasi.Size, wasi.Errno) {
+	f, errno := s.socket("SockSend", fd)
+	if errno != wasi.ESUCCESS {
+		return ^wasi.Size(0), errno
+	}
+	return s.write(f, iovecs, f.addr)
+}
+
+func (s *fakeSystem) SockSendTo(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, flags wasi.SIFlags, addr wasi.SocketAddress) (wasi.Size, wasi.Errno) {
+	f, errno := s.socket("SockSendTo", fd)
+	if errno != wasi.ESUCCESS {
+		return ^wasi.Size(0), errno
+	}
+	return s.write(f, iovecs, addr)
+}
+
+func (s *fakeSystem) SockRecvFrom(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, flags wasi.RIFlags) (wasi.Size, wasi.ROFlags, wasi.SocketAddress, wasi.Errno) {
+	f, errno := s.socket("SockRecvFrom", fd)
+	if errno != wasi.ESUCCESS {
+		return ^wasi.Size(0), 0, nil, errno
+	}
+	return s.read(f, iovecs, flags.Has(wasi.RecvPeek))
+}
+
+func (s *fakeSystem) SockGetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketOption) (wasi.SocketOptionValue, wasi.Errno) {
+	f, errno := s.socket("SockGetOpt", fd)
+	if errno != wasi.ESUCCESS {
+		return nil, errno
+	}
+	value, ok := f.options[option]
+	if !ok {
+		return nil, wasi.ENOPROTOOPT
+	}
+	return value, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) SockSetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketOption, value wasi.SocketOptionValue) wasi.Errno {
+	f, errno := s.socket("SockSetOpt", fd)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	if f.options == nil {
+		f.options = make(map[wasi.SocketOption]wasi.SocketOptionValue)
+	}
+	f.options[option] = value
+	return wasi.ESUCCESS
+}
+
+func (s *fakeSystem) SockLocalAddress(ctx context.Context, fd wasi.FD) (wasi.SocketAddress, wasi.Errno) {
+	f, errno := s.socket("SockLocalAddress", fd)
+	if errno != wasi.ESUCCESS {
+		return nil, errno
+	}
+	return f.addr, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) SockRemoteAddress(ctx context.Context, fd wasi.FD) (wasi.SocketAddress, wasi.Errno) {
+	f, errno := s.socket("SockRemoteAddress", fd)
+	if errno != wasi.ESUCCESS {
+		return nil, errno
+	}
+	return f.addr, wasi.ESUCCESS
+}
+
+func (s *fakeSystem) SockAddressInfo(ctx context.Context, name, service string, hints wasi.AddressInfo, results []wasi.AddressInfo) (int, wasi.Errno) {
+	return 0, s.notImplemented("SockAddressInfo")
+}
+
+func (s *fakeSystem) SockSplice(ctx context.Context, inFD, outFD wasi.FD, maxBytes wasi.Size) (wasi.Size, wasi.Errno) {
+	in, errno := s.socket("SockSplice", inFD)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	out := s.files[outFD]
+	if out == nil || !out.isSocket() {
+		return 0, wasi.EBADF
+	}
+	n := min(int(maxBytes), len(in.data))
+	out.data = append(out.data, in.data[:n]...)
+	in.data = in.data[n:]
+	return wasi.Size(n), wasi.ESUCCESS
+}
+
+func (s *fakeSystem) FDSendFile(ctx context.Context, outFD, inFD wasi.FD, offset wasi.FileSize, count wasi.Size) (wasi.Size, wasi.Errno) {
+	return 0, s.notImplemented("FDSendFile")
+}
+
+func (s *fakeSystem) SockShutdown(ctx context.Context, fd wasi.FD, flags wasi.SDFlags) wasi.Errno {
+	return s.notImplemented("SockShutdown")
+}
+
+func (s *fakeSystem) Close(ctx context.Context) error {
+	s.closed++
+	return nil
+}
@@ -385,6 +385,17 @@ const (
 
 	// OpenTruncate means truncate file to size 0.
 	OpenTruncate
+
+	// OpenTemporary means create an unnamed regular file in the directory
+	// named by the path, like O_TMPFILE on Linux. The file is removed when
+	// it is closed, unless it was given a name by calling PathLink with the
+	// file descriptor of the temporary file and an empty path, which requires
+	// PathLinkSourceRight on the file. Systems which emulate the flag may not
+	// support giving a name to temporary files.
+	//
+	// This flag is not part of WASI preview 1. It must be used with rights
+	// to write the file, and cannot be combined with the other flags.
+	OpenTemporary
 )
 
 // Has is true if the flag is set.
@@ -397,6 +408,7 @@ var openflagsStrings = [...]string{
 	"OpenDirectory",
 	"OpenExclusive",
 	"OpenTruncate",
+	"OpenTemporary",
 }
 
 func (flags OpenFlags) String() (s string) {
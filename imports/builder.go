@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -17,11 +20,19 @@ type Builder struct {
 	name               string
 	args               []string
 	env                []string
+	argsFunc           func() []string
+	envFunc            func() []string
 	mounts             []mount
+	filesystems        []filesystem
 	listens            []string
-	dials              []string
+	dials              []dial
+	sockets            []socket
+	inherited          []inheritedFD
 	customStdio        bool
 	stdin              int
+	stdinReader        io.Reader
+	stdoutWriter       io.Writer
+	stderrWriter       io.Writer
 	stdout             int
 	stderr             int
 	realtime           func(context.Context) (uint64, error)
@@ -33,15 +44,41 @@ type Builder struct {
 	raise              func(context.Context, int) error
 	rand               io.Reader
 	socketsExtension   *wasi_snapshot_preview1.Extension
+	copyRange          bool
+	splice             bool
+	sendFile           bool
+	xattr              bool
+	swapNonBlock       bool
 	pathOpenSockets    bool
+	rawSockets         bool
+	crossDeviceRename  bool
+	createFileMode     fs.FileMode
+	umask              fs.FileMode
+	nameResolver       func(context.Context, string, string, wasi.AddressInfo) ([]wasi.AddressInfo, bool, error)
+	hostsFile          string
+	networkPolicy      string
+	dialPolicy         wasi.DialPolicy
 	nonBlockingStdio   bool
+	socketBufferSize   int
+	datagramQueueSize  int
+	fileStatCache      bool
+	rateLimiter        wasi.RateLimiter
 	tracer             io.Writer
 	tracerOptions      []wasi.TracerOption
+	traceSummary       io.Writer
+	traceJSON          io.Writer
+	accounting         func(wasi.AccountingReport)
+	memoryFaults       io.Writer
+	socketsWarnings    io.Writer
+	fdLeaks            io.Writer
 	decorators         []wasi_snapshot_preview1.Decorator
 	wrappers           []func(wasi.System) wasi.System
 	errors             []error
 	maxOpenFiles       int
 	maxOpenDirs        int
+	maxIOVecs          int
+	restore            []wasi.FDInfo
+	workingDir         string
 }
 
 // NewBuilder creates a Builder.
@@ -54,6 +91,54 @@ type mount struct {
 	mode int
 }
 
+func (m mount) rights() (rightsBase, rightsInheriting wasi.Rights) {
+	rightsBase = wasi.DirectoryRights
+	rightsInheriting = wasi.DirectoryRights | wasi.FileRights
+	if m.mode == 'r' {
+		rightsBase &^= wasi.WriteRights
+		rightsInheriting &^= wasi.WriteRights
+	}
+	return rightsBase, rightsInheriting
+}
+
+// mountRights returns the rights of a preopened directory, including the
+// rights of the extensions that were enabled on the builder.
+func (b *Builder) mountRights(m mount) (rightsBase, rightsInheriting wasi.Rights) {
+	rightsBase, rightsInheriting = m.rights()
+	if b.xattr {
+		rightsBase |= wasi.PathXattrRight
+	}
+	return rightsBase, rightsInheriting
+}
+
+type filesystem struct {
+	path string
+	fsys fs.FS
+}
+
+// rights returns the rights of a preopened fs.FS, which are those of
+// read-only directories.
+func (filesystem) rights() (rightsBase, rightsInheriting wasi.Rights) {
+	return mount{mode: 'r'}.rights()
+}
+
+type socket struct {
+	path string
+	fd   int
+	conn net.Conn
+}
+
+type dial struct {
+	name string
+	addr string
+}
+
+type inheritedFD struct {
+	path string
+	fd   int
+	stat wasi.FDStat
+}
+
 // WithName sets the name of the module, which is exposed to the module
 // as argv[0].
 func (b *Builder) WithName(name string) *Builder {
@@ -73,6 +158,22 @@ func (b *Builder) WithEnv(env ...string) *Builder {
 	return b
 }
 
+// WithArgsFunc sets a function called to compute the command line arguments
+// each time the module reads them, instead of the arguments set by WithArgs.
+// The module name is prepended to the arguments returned by the function.
+func (b *Builder) WithArgsFunc(f func() []string) *Builder {
+	b.argsFunc = f
+	return b
+}
+
+// WithEnvFunc sets a function called to compute the environment variables
+// each time the module reads them, instead of the variables set by WithEnv.
+// This allows long-lived hosts to inject values which change over time.
+func (b *Builder) WithEnvFunc(f func() []string) *Builder {
+	b.envFunc = f
+	return b
+}
+
 // WithDirs specifies a set of directories to preopen.
 //
 // The directory can either be a path, or a string of the form "path:path[:ro]"
@@ -103,6 +204,72 @@ func (b *Builder) WithDirs(dirs ...string) *Builder {
 	return b
 }
 
+// WithFS preopens the root of fsys as a read-only directory named guestPath,
+// for example to expose assets embedded in the host binary with embed.FS
+// without writing them to disk.
+//
+// The file system is preopened after the directories passed to WithDirs.
+// Functions which would modify it fail with EROFS.
+func (b *Builder) WithFS(guestPath string, fsys fs.FS) *Builder {
+	b.filesystems = append(b.filesystems, filesystem{path: guestPath, fsys: fsys})
+	return b
+}
+
+// WithWorkingDirectory sets the working directory of the module.
+//
+// WASI preview 1 has no notion of working directory, guests resolve paths
+// relative to preopens. The directory is preopened as ".", which libc
+// implementations use to resolve relative paths, after all the other
+// preopens, and its path is exposed to the module in the PWD environment
+// variable. The directory must be within one of the directories passed to
+// WithDirs, from which it inherits the access mode.
+func (b *Builder) WithWorkingDirectory(dir string) *Builder {
+	b.workingDir = dir
+	return b
+}
+
+// workingDirMount returns the preopened directory containing the working
+// directory; when directories are nested, the innermost one is selected.
+func (b *Builder) workingDirMount() (mount, error) {
+	cwd, err := filepath.Abs(b.workingDir)
+	if err != nil {
+		return mount{}, err
+	}
+	found, longest := -1, -1
+	for i, m := range b.mounts {
+		dir, err := filepath.Abs(m.dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(dir, cwd)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(dir) > longest {
+			found, longest = i, len(dir)
+		}
+	}
+	if found < 0 {
+		return mount{}, fmt.Errorf("working directory %q is not within a preopened directory", b.workingDir)
+	}
+	return b.mounts[found], nil
+}
+
+// environ returns the environment variables of the module, with PWD set to
+// the working directory if one was configured.
+func (b *Builder) environ(environ []string) []string {
+	if b.workingDir == "" {
+		return environ
+	}
+	env := make([]string, 0, len(environ)+1)
+	for _, e := range environ {
+		if !strings.HasPrefix(e, "PWD=") {
+			env = append(env, e)
+		}
+	}
+	return append(env, "PWD="+b.workingDir)
+}
+
 // WithListens specifies a list of addresses to listen on before starting
 // the module. The listener sockets are added to the set of preopens.
 func (b *Builder) WithListens(listens ...string) *Builder {
@@ -112,11 +279,96 @@ func (b *Builder) WithListens(listens ...string) *Builder {
 
 // WithDials specifies a list of addresses to dial before starting
 // the module. The connection sockets are added to the set of preopens.
+//
+// Each dial is either ADDR:PORT or NAME=ADDR:PORT. The module discovers the
+// file descriptors of the connections in the DIAL_FDS environment variable,
+// which lists NAME=FD pairs separated by commas, in the order of the dials.
+// The name of a dial defaults to its address.
 func (b *Builder) WithDials(dials ...string) *Builder {
-	b.dials = dials
+	b.dials = b.dials[:0]
+	names := make(map[string]struct{}, len(dials))
+	for _, d := range dials {
+		name, addr, ok := strings.Cut(d, "=")
+		if !ok {
+			name, addr = d, d
+		}
+		if name == "" || strings.Contains(name, ",") {
+			b.errors = append(b.errors, fmt.Errorf("invalid dial name in %q", d))
+		} else if _, dup := names[name]; dup {
+			b.errors = append(b.errors, fmt.Errorf("duplicate dial name %q", name))
+		}
+		names[name] = struct{}{}
+		b.dials = append(b.dials, dial{name: name, addr: addr})
+	}
+	return b
+}
+
+// WithSocketFD adds an already connected socket to the set of preopens.
+//
+// Sockets are preopened in the order they were added, immediately after the
+// stdio file descriptors, which means that the first socket is exposed to
+// the module as file descriptor 3. The path is informational only, it is
+// used as the name of the preopen.
+//
+// Note that the file descriptor will be duplicated before the module takes
+// ownership. The caller is responsible for managing the specified
+// descriptor.
+func (b *Builder) WithSocketFD(path string, fd int) *Builder {
+	b.sockets = append(b.sockets, socket{path: path, fd: fd})
+	return b
+}
+
+// WithConn adds an already connected net.Conn to the set of preopens, as a
+// socket named after guestPath. Connections are preopened along with the
+// sockets added by WithSocketFD, in the order they were added.
+//
+// The connection must expose its file descriptor by implementing
+// syscall.Conn, which is the case of the connections from the net package
+// (but not of wrappers such as *tls.Conn or the pipes returned by net.Pipe).
+//
+// The builder takes over the lifetime of the connection: its file descriptor
+// is duplicated and the connection is closed when the module is instantiated.
+// The application must not use the connection after passing it to WithConn.
+func (b *Builder) WithConn(guestPath string, conn net.Conn) *Builder {
+	b.sockets = append(b.sockets, socket{path: guestPath, fd: -1, conn: conn})
 	return b
 }
 
+// WithInheritedFD adds a file descriptor opened by the host process to the
+// set of preopens, exposing it to the module with the file type, flags and
+// rights of stat. This is intended for file descriptors passed by process
+// managers, for example the listening sockets of systemd socket activation.
+//
+// Inherited file descriptors are preopened in the order they were added,
+// after the sockets added by WithSocketFD and WithConn. The path is
+// informational only, it is used as the name of the preopen. The file
+// descriptor is put in non-blocking mode if stat has the NonBlock flag,
+// and in blocking mode otherwise.
+//
+// Note that the file descriptor will be duplicated before the module takes
+// ownership. The caller is responsible for managing the specified
+// descriptor.
+func (b *Builder) WithInheritedFD(path string, fd int, stat wasi.FDStat) *Builder {
+	b.inherited = append(b.inherited, inheritedFD{path: path, fd: fd, stat: stat})
+	return b
+}
+
+// WithPollFD adds a file descriptor opened by the host process that the module
+// may only wait on with poll_oneoff, for example a pidfd to wait for a child
+// process of the host to exit, or an eventfd signaled by the host. A read
+// subscription on the file descriptor triggers when it becomes readable, and
+// the event has the wasi.Hangup flag if poll(2) reported POLLHUP (e.g. after
+// the process of a pidfd was reaped).
+//
+// The file descriptor is preopened like those added by WithInheritedFD, with
+// an unknown file type and the rights to be polled only.
+func (b *Builder) WithPollFD(path string, fd int) *Builder {
+	return b.WithInheritedFD(path, fd, wasi.FDStat{
+		FileType:   wasi.UnknownType,
+		RightsBase: wasi.PollFDReadWriteRight,
+	})
+}
+
 // WithStdio sets stdio file descriptors.
 //
 // Note that the file descriptors will be duplicated before the module takes
@@ -130,6 +382,33 @@ func (b *Builder) WithStdio(stdin, stdout, stderr int) *Builder {
 	return b
 }
 
+// WithStdin sets a reader that the module reads its stdin from, instead of a
+// file descriptor of the host. The data is passed to the module through a
+// pipe, reads return zero bytes (EOF) after the reader returned an error or
+// io.EOF. The stdin file descriptor set with WithStdio is ignored.
+func (b *Builder) WithStdin(r io.Reader) *Builder {
+	b.stdinReader = r
+	return b
+}
+
+// WithStdout sets a writer that receives the data written by the module to
+// its stdout, instead of a file descriptor of the host. The stdout file
+// descriptor set with WithStdio is ignored.
+//
+// The data is passed from the module through a pipe. Closing the system
+// waits until all the data was written to w; writes of the module fail with
+// EPIPE after w returned an error.
+func (b *Builder) WithStdout(w io.Writer) *Builder {
+	b.stdoutWriter = w
+	return b
+}
+
+// WithStderr is like WithStdout but for the stderr of the module.
+func (b *Builder) WithStderr(w io.Writer) *Builder {
+	b.stderrWriter = w
+	return b
+}
+
 // WithRealtimeClock sets the realtime clock and precision.
 func (b *Builder) WithRealtimeClock(clock func(context.Context) (uint64, error), precision time.Duration) *Builder {
 	b.realtime = clock
@@ -157,11 +436,24 @@ func (b *Builder) WithExit(fn func(context.Context, int) error) *Builder {
 }
 
 // WithRaise sets the proc_raise function.
+//
+// By default, SIGABRT, SIGKILL and SIGTERM cause the module to exit with the
+// 128+signal exit code, and other signals are not supported.
 func (b *Builder) WithRaise(fn func(context.Context, int) error) *Builder {
 	b.raise = fn
 	return b
 }
 
+// WithRandomSource sets the source of random data returned by random_get.
+//
+// The reader may return partial reads or EINTR errors, in which case reads
+// are retried until enough data was produced. A reader returning io.EOF
+// causes random_get to fail with ENOSYS, other errors are reported as EIO.
+func (b *Builder) WithRandomSource(rand io.Reader) *Builder {
+	b.rand = rand
+	return b
+}
+
 // WithSocketsExtension enables a sockets extension.
 //
 // The name can be one of:
@@ -171,6 +463,8 @@ func (b *Builder) WithRaise(fn func(context.Context, int) error) *Builder {
 // - path_open: use the extension to the path_open system call (unix.PathOpenSockets)
 // - auto: attempt to detect one of the extensions above
 func (b *Builder) WithSocketsExtension(name string, module wazero.CompiledModule) *Builder {
+	b.socketsExtension = nil
+	b.pathOpenSockets = false
 	switch strings.ToLower(name) {
 	case "none", "":
 		// no sockets extension
@@ -179,7 +473,6 @@ func (b *Builder) WithSocketsExtension(name string, module wazero.CompiledModule
 	case "wasmedgev2":
 		b.socketsExtension = &wasi_snapshot_preview1.WasmEdgeV2
 	case "path_open":
-		b.socketsExtension = nil
 		b.pathOpenSockets = true
 	case "auto":
 		b.socketsExtension = DetectSocketsExtension(module)
@@ -189,6 +482,147 @@ func (b *Builder) WithSocketsExtension(name string, module wazero.CompiledModule
 	return b
 }
 
+// SocketsExtension returns the name of the sockets extension selected by
+// WithSocketsExtension, which is one of none, path_open, wasmedgev1 or
+// wasmedgev2. When the extension was configured with "auto", the method
+// returns the name of the extension that was detected.
+func (b *Builder) SocketsExtension() string {
+	switch {
+	case b.pathOpenSockets:
+		return "path_open"
+	case b.socketsExtension == &wasi_snapshot_preview1.WasmEdgeV1:
+		return "wasmedgev1"
+	case b.socketsExtension == &wasi_snapshot_preview1.WasmEdgeV2:
+		return "wasmedgev2"
+	default:
+		return "none"
+	}
+}
+
+// WithCopyRangeExtension enables the extension adding the fd_copy_range
+// function to the host module (see wasi_snapshot_preview1.CopyRange).
+func (b *Builder) WithCopyRangeExtension(enable bool) *Builder {
+	b.copyRange = enable
+	return b
+}
+
+// WithSpliceExtension enables the extension adding the sock_splice function
+// to the host module (see wasi_snapshot_preview1.Splice).
+func (b *Builder) WithSpliceExtension(enable bool) *Builder {
+	b.splice = enable
+	return b
+}
+
+// WithSendFileExtension enables the extension adding the fd_sendfile function
+// to the host module (see wasi_snapshot_preview1.SendFile).
+func (b *Builder) WithSendFileExtension(enable bool) *Builder {
+	b.sendFile = enable
+	return b
+}
+
+// WithXattrExtension enables the extension adding the path_getxattr,
+// path_listxattr and path_setxattr functions to the host module (see
+// wasi_snapshot_preview1.Xattr). Preopened directories are granted
+// wasi.PathXattrRight when the extension is enabled.
+func (b *Builder) WithXattrExtension(enable bool) *Builder {
+	b.xattr = enable
+	return b
+}
+
+// WithSwapNonBlockExtension enables the extension adding the
+// fd_fdstat_swap_nonblock function to the host module (see
+// wasi_snapshot_preview1.SwapNonBlock).
+func (b *Builder) WithSwapNonBlockExtension(enable bool) *Builder {
+	b.swapNonBlock = enable
+	return b
+}
+
+// WithRawSockets enables or disables the creation of raw sockets by the
+// module, which is disabled by default. The host process usually needs to
+// be privileged for raw sockets to be opened.
+func (b *Builder) WithRawSockets(enable bool) *Builder {
+	b.rawSockets = enable
+	return b
+}
+
+// WithCrossDeviceRename enables or disables moving regular files between
+// directories on different file systems when renaming them, which is disabled
+// by default. When enabled, the file is copied then removed from its source
+// directory, which unlike a rename is not atomic.
+func (b *Builder) WithCrossDeviceRename(enable bool) *Builder {
+	b.crossDeviceRename = enable
+	return b
+}
+
+// WithCreateFileMode sets the permission mode of the files created by the
+// module, which is 0644 by default. The umask set by WithUmask and the umask
+// of the host process are applied to the mode.
+func (b *Builder) WithCreateFileMode(mode fs.FileMode) *Builder {
+	if mode&^fs.ModePerm != 0 {
+		b.errors = append(b.errors, fmt.Errorf("invalid file creation mode %#o", mode))
+	}
+	b.createFileMode = mode
+	return b
+}
+
+// WithUmask sets permission bits cleared from the mode of the files created
+// by the module, without modifying the umask of the host process.
+func (b *Builder) WithUmask(mask fs.FileMode) *Builder {
+	if mask&^fs.ModePerm != 0 {
+		b.errors = append(b.errors, fmt.Errorf("invalid umask %#o", mask))
+	}
+	b.umask = mask
+	return b
+}
+
+// WithNameResolver sets a function consulted before the host resolver when
+// the guest resolves names. When the function returns handled=true, its
+// results are returned to the guest and the host resolver is not used.
+func (b *Builder) WithNameResolver(resolver func(ctx context.Context, name, service string, hints wasi.AddressInfo) (results []wasi.AddressInfo, handled bool, err error)) *Builder {
+	b.nameResolver = resolver
+	return b
+}
+
+// WithHostsFile sets the path of a file in the format of /etc/hosts which
+// overrides the addresses that names resolve to for the guest. Names which
+// are not found in the file are passed to the name resolver, if any, then to
+// the host resolver.
+func (b *Builder) WithHostsFile(path string) *Builder {
+	b.hostsFile = path
+	return b
+}
+
+// WithNetworkPolicy restricts the addresses that the module's sockets may
+// bind, connect, or send datagrams to. The policy must be one of:
+// - all: allow all addresses (the default)
+// - loopback: allow loopback and unix addresses only
+// - none: allow unix addresses only
+//
+// Addresses rejected by the policy fail with EACCES. The policy does not
+// apply to sockets preopened with WithListens, WithDials or WithSocketFD.
+func (b *Builder) WithNetworkPolicy(policy string) *Builder {
+	switch strings.ToLower(policy) {
+	case "all", "":
+		b.networkPolicy = "all"
+	case "loopback":
+		b.networkPolicy = "loopback"
+	case "none":
+		b.networkPolicy = "none"
+	default:
+		b.errors = append(b.errors, fmt.Errorf("invalid network policy %q", policy))
+	}
+	return b
+}
+
+// WithDialPolicy sets a policy consulted when the module connects sockets
+// or sends datagrams, which can deny the operation based on the destination
+// address. See wasi.AllowDial for a policy allowing a list of networks and
+// ports.
+func (b *Builder) WithDialPolicy(policy wasi.DialPolicy) *Builder {
+	b.dialPolicy = policy
+	return b
+}
+
 // WithNonBlockingStdio enables or disables non-blocking stdio.
 // When enabled, stdio file descriptors will have the O_NONBLOCK flag set
 // before the module is started.
@@ -197,6 +631,34 @@ func (b *Builder) WithNonBlockingStdio(enable bool) *Builder {
 	return b
 }
 
+// WithBufferedSockets enables buffering of reads on stream sockets, using
+// buffers of the given size. Zero disables buffering, which is the default.
+//
+// See wasi.BufferedSockets for details.
+func (b *Builder) WithBufferedSockets(bufferSize int) *Builder {
+	b.socketBufferSize = bufferSize
+	return b
+}
+
+// WithBufferedDatagrams enables queuing of datagrams received on datagram
+// sockets, holding up to capacity messages per socket. Zero disables the
+// queues, which is the default.
+//
+// See wasi.BufferedDatagrams for details.
+func (b *Builder) WithBufferedDatagrams(capacity int) *Builder {
+	b.datagramQueueSize = capacity
+	return b
+}
+
+// WithFileStatCache enables caching of the file attributes returned by
+// FDFileStatGet on regular files.
+//
+// See wasi.CachedFileStats for details.
+func (b *Builder) WithFileStatCache(enable bool) *Builder {
+	b.fileStatCache = enable
+	return b
+}
+
 // WithTracer enables the Tracer, and instructs it to write to the
 // specified io.Writer.
 func (b *Builder) WithTracer(enable bool, w io.Writer, options ...wasi.TracerOption) *Builder {
@@ -208,6 +670,70 @@ func (b *Builder) WithTracer(enable bool, w io.Writer, options ...wasi.TracerOpt
 	return b
 }
 
+// WithRateLimiter enables throttling of the data transfers made by the module
+// with the given RateLimiter. A nil limiter disables throttling, which is the
+// default.
+//
+// See wasi.RateLimited for details.
+func (b *Builder) WithRateLimiter(limiter wasi.RateLimiter) *Builder {
+	b.rateLimiter = limiter
+	return b
+}
+
+// WithTraceSummary enables counting of the calls made to the system, and
+// instructs it to write a summary to the specified io.Writer when the system
+// is closed. A nil writer disables the summary, which is the default.
+//
+// The summary is independent of the tracer enabled by WithTracer.
+func (b *Builder) WithTraceSummary(w io.Writer) *Builder {
+	b.traceSummary = w
+	return b
+}
+
+// WithTraceJSON enables recording of the calls made to the system as JSON
+// lines written to the specified io.Writer, which can be replayed against
+// another system with cmd/wasitrace (see wasi.TraceJSON). A nil writer
+// disables the recording, which is the default.
+func (b *Builder) WithTraceJSON(w io.Writer) *Builder {
+	b.traceJSON = w
+	return b
+}
+
+// WithAccounting enables accounting of the resources used by the module (see
+// wasi.Accounting). The report is passed to the given function when the
+// system is closed. A nil function disables accounting, which is the default.
+func (b *Builder) WithAccounting(report func(wasi.AccountingReport)) *Builder {
+	b.accounting = report
+	return b
+}
+
+// WithMemoryFaultDiagnostics enables logging of the offset and length of the
+// memory regions which caused host functions to fail with EFAULT, which helps
+// debugging guests passing invalid pointers. The logs are written to w; nil
+// disables them, which is the default.
+func (b *Builder) WithMemoryFaultDiagnostics(w io.Writer) *Builder {
+	b.memoryFaults = w
+	return b
+}
+
+// WithSocketsDiagnostics enables a one-time warning written to w when the
+// guest calls a socket function which fails with ENOSYS, explaining that the
+// sockets extension may need to be enabled. nil disables the warning, which
+// is the default.
+func (b *Builder) WithSocketsDiagnostics(w io.Writer) *Builder {
+	b.socketsWarnings = w
+	return b
+}
+
+// WithFDLeakDiagnostics enables a one-time report written to w when the guest
+// reaches the limit set by WithMaxOpenFiles, listing the oldest files that it
+// opened and did not close, with the path, socket or connection each of them
+// was opened from. nil disables the report, which is the default.
+func (b *Builder) WithFDLeakDiagnostics(w io.Writer) *Builder {
+	b.fdLeaks = w
+	return b
+}
+
 // WithDecorators sets the host module decorators.
 func (b *Builder) WithDecorators(decorators ...wasi_snapshot_preview1.Decorator) *Builder {
 	b.decorators = decorators
@@ -233,3 +759,22 @@ func (b *Builder) WithMaxOpenDirs(n int) *Builder {
 	b.maxOpenDirs = n
 	return b
 }
+
+// WithMaxIOVecs sets the limit on the number of buffers that the guest module
+// may pass to vectored I/O functions such as fd_read and fd_write. Zero, the
+// default, means no limit.
+func (b *Builder) WithMaxIOVecs(n int) *Builder {
+	b.maxIOVecs = n
+	return b
+}
+
+// WithRestore reopens the file descriptors described by fds when the module
+// is instantiated, after the preopens were created. The list is typically
+// obtained from unix.System.Snapshot when checkpointing another instance of
+// the module, which must have been configured with the same preopens.
+//
+// See unix.System.Restore for details.
+func (b *Builder) WithRestore(fds []wasi.FDInfo) *Builder {
+	b.restore = fds
+	return b
+}
@@ -14,3 +14,11 @@ import (
 func (b *Builder) Instantiate(ctx context.Context, _ wazero.Runtime) (context.Context, wasi.System, error) {
 	return ctx, nil, fmt.Errorf("wasi-go is not available on GOOS=%s", runtime.GOOS)
 }
+
+func stdioRights(fd int) wasi.Rights {
+	return wasi.FileRights
+}
+
+func (s socket) fileType() (wasi.FileType, error) {
+	return wasi.SocketStreamType, nil
+}
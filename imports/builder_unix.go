@@ -6,12 +6,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/stealthrocket/wasi-go"
 	"github.com/stealthrocket/wasi-go/imports/wasi_snapshot_preview1"
 	"github.com/stealthrocket/wasi-go/internal/descriptor"
 	"github.com/stealthrocket/wasi-go/internal/sockets"
+	"github.com/stealthrocket/wasi-go/systems/iofs"
 	"github.com/stealthrocket/wasi-go/systems/unix"
 	"github.com/stealthrocket/wazergo"
 	"github.com/tetratelabs/wazero"
@@ -24,6 +30,13 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 		return ctx, nil, errors.Join(b.errors...)
 	}
 
+	var cwd mount
+	if b.workingDir != "" {
+		if cwd, err = b.workingDirMount(); err != nil {
+			return ctx, nil, err
+		}
+	}
+
 	name := defaultName
 	if b.name != "" {
 		name = b.name
@@ -55,10 +68,7 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 	if b.yield != nil {
 		yield = b.yield
 	}
-	raise := defaultRaise
-	if b.raise != nil {
-		raise = b.raise
-	}
+	raise := b.raise
 	exit := defaultExit
 	if b.exit != nil {
 		exit = b.exit
@@ -70,7 +80,7 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 
 	unixSystem := &unix.System{
 		Args:               append([]string{name}, b.args...),
-		Environ:            b.env,
+		Environ:            b.environ(b.env),
 		Realtime:           realtime,
 		RealtimePrecision:  realtimePrecision,
 		Monotonic:          monotonic,
@@ -80,8 +90,39 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 		Rand:               rand,
 		Exit:               exit,
 	}
+	if raise == nil {
+		unixSystem.Raise = defaultRaise(unixSystem)
+	}
+	if argsFunc := b.argsFunc; argsFunc != nil {
+		unixSystem.ArgsFunc = func() []string { return append([]string{name}, argsFunc()...) }
+	}
+	if envFunc := b.envFunc; envFunc != nil {
+		unixSystem.EnvironFunc = func() []string { return b.environ(envFunc()) }
+	}
 	unixSystem.MaxOpenFiles = b.maxOpenFiles
 	unixSystem.MaxOpenDirs = b.maxOpenDirs
+	unixSystem.FDLeakDiagnostics = b.fdLeaks
+	unixSystem.AllowRawSockets = b.rawSockets
+	unixSystem.CrossDeviceRename = b.crossDeviceRename
+	unixSystem.CreateFileMode = b.createFileMode
+	unixSystem.Umask = b.umask
+	unixSystem.NameResolver = b.nameResolver
+	unixSystem.DialPolicy = b.dialPolicy
+
+	switch b.networkPolicy {
+	case "loopback":
+		unixSystem.NetworkPolicy = unix.NetworkLoopback
+	case "none":
+		unixSystem.NetworkPolicy = unix.NetworkNone
+	}
+
+	if b.hostsFile != "" {
+		hosts, err := readHostsFile(b.hostsFile)
+		if err != nil {
+			return ctx, nil, fmt.Errorf("unable to load hosts file %q: %w", b.hostsFile, err)
+		}
+		unixSystem.NameResolver = chainNameResolvers(hosts.Resolve, b.nameResolver)
+	}
 
 	system := wasi.System(unixSystem)
 	defer func() {
@@ -93,6 +134,44 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 	if b.pathOpenSockets {
 		system = &unix.PathOpenSockets{System: unixSystem}
 	}
+	var mounts *iofs.Mounts
+	if len(b.filesystems) > 0 {
+		// The files of the fs.FS instances are registered in the unix system
+		// with placeholders opened on /dev/null, so they share the same file
+		// descriptor numbers as the other files of the guest.
+		mounts = &iofs.Mounts{
+			System: system,
+			Reserve: func(stat wasi.FDStat) (wasi.FD, wasi.Errno) {
+				fd, err := syscall.Open("/dev/null", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+				if err != nil {
+					return -1, wasi.MakeErrno(err)
+				}
+				return unixSystem.Register(unix.FD(fd), stat), wasi.ESUCCESS
+			},
+		}
+		system = mounts
+	}
+	if b.socketBufferSize > 0 {
+		system = wasi.BufferedSockets(system, b.socketBufferSize)
+	}
+	if b.datagramQueueSize > 0 {
+		system = wasi.BufferedDatagrams(system, b.datagramQueueSize)
+	}
+	if b.fileStatCache {
+		system = wasi.CachedFileStats(system)
+	}
+	if b.rateLimiter != nil {
+		system = wasi.RateLimited(system, b.rateLimiter)
+	}
+	if b.traceSummary != nil {
+		system = wasi.TraceSummary(b.traceSummary, system)
+	}
+	if b.accounting != nil {
+		system = &accountingSystem{AccountingSystem: wasi.Accounting(system), report: b.accounting}
+	}
+	if b.traceJSON != nil {
+		system = wasi.TraceJSON(b.traceJSON, system)
+	}
 	if b.tracer != nil {
 		system = wasi.Trace(b.tracer, system, b.tracerOptions...)
 	}
@@ -100,17 +179,42 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 		system = wrap(system)
 	}
 
+	// Readers and writers set on the builder are exposed to the module as
+	// pipes, the data is copied by goroutines holding the other end.
+	var copies sync.WaitGroup
 	for fd, stdio := range []struct {
-		fd   int
-		open int
-		path string
+		fd     int
+		open   int
+		path   string
+		reader io.Reader
+		writer io.Writer
 	}{
-		{stdin, syscall.O_RDONLY, "/dev/stdin"},
-		{stdout, syscall.O_WRONLY, "/dev/stdout"},
-		{stderr, syscall.O_WRONLY, "/dev/stderr"},
+		{stdin, syscall.O_RDONLY, "/dev/stdin", b.stdinReader, nil},
+		{stdout, syscall.O_WRONLY, "/dev/stdout", nil, b.stdoutWriter},
+		{stderr, syscall.O_WRONLY, "/dev/stderr", nil, b.stderrWriter},
 	} {
 		var err error
-		if stdio.fd < 0 {
+		var p [2]int
+		switch {
+		case stdio.reader != nil:
+			if p, err = pipe(); err == nil {
+				stdio.fd = p[0]
+				go func(f *os.File, r io.Reader) {
+					defer f.Close()
+					_, _ = io.Copy(f, r)
+				}(os.NewFile(uintptr(p[1]), stdio.path), stdio.reader)
+			}
+		case stdio.writer != nil:
+			if p, err = pipe(); err == nil {
+				stdio.fd = p[1]
+				copies.Add(1)
+				go func(f *os.File, w io.Writer) {
+					defer copies.Done()
+					defer f.Close()
+					_, _ = io.Copy(w, f)
+				}(os.NewFile(uintptr(p[0]), stdio.path), stdio.writer)
+			}
+		case stdio.fd < 0:
 			stdio.fd, err = syscall.Open(stdio.path, stdio.open, 0)
 			// Some systems may not allow opening stdio files on /dev, fallback
 			// duplicating the process file descriptors which comes with the
@@ -121,19 +225,15 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 			if errors.Is(err, syscall.EACCES) {
 				stdio.fd, err = dup(fd)
 			}
-		} else {
+		default:
 			stdio.fd, err = dup(stdio.fd)
 		}
 		if err != nil {
 			return ctx, nil, fmt.Errorf("unable to open %s: %w", stdio.path, err)
 		}
-		rights := wasi.FileRights
-		if descriptor.IsATTY(stdio.fd) {
-			rights = wasi.TTYRights
-		}
 		stat := wasi.FDStat{
 			FileType:   wasi.CharacterDeviceType,
-			RightsBase: rights,
+			RightsBase: stdioRights(stdio.fd),
 		}
 		if b.nonBlockingStdio {
 			if err := syscall.SetNonblock(stdio.fd, true); err != nil {
@@ -144,17 +244,56 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 		unixSystem.Preopen(unix.FD(stdio.fd), stdio.path, stat)
 	}
 
+	if b.stdoutWriter != nil || b.stderrWriter != nil {
+		system = &stdioSystem{System: system, copies: &copies}
+	}
+
+	for _, s := range b.sockets {
+		var fd int
+		var err error
+		if s.conn != nil {
+			fd, err = dupConn(s.conn)
+			s.conn.Close()
+		} else {
+			fd, err = dup(s.fd)
+		}
+		if err != nil {
+			return ctx, nil, fmt.Errorf("unable to preopen socket %q: %w", s.path, err)
+		}
+		fileType, err := socketFileType(fd)
+		if err != nil {
+			syscall.Close(fd)
+			return ctx, nil, fmt.Errorf("unable to preopen socket %q: %w", s.path, err)
+		}
+		if err := syscall.SetNonblock(fd, true); err != nil {
+			syscall.Close(fd)
+			return ctx, nil, fmt.Errorf("unable to put socket %q in non-blocking mode: %w", s.path, err)
+		}
+		unixSystem.Preopen(unix.FD(fd), s.path, wasi.FDStat{
+			FileType:   fileType,
+			Flags:      wasi.NonBlock,
+			RightsBase: wasi.SockConnectionRights,
+		})
+	}
+
+	for _, f := range b.inherited {
+		fd, err := dup(f.fd)
+		if err != nil {
+			return ctx, nil, fmt.Errorf("unable to preopen inherited file descriptor %d: %w", f.fd, err)
+		}
+		if err := syscall.SetNonblock(fd, f.stat.Flags.Has(wasi.NonBlock)); err != nil {
+			syscall.Close(fd)
+			return ctx, nil, fmt.Errorf("unable to set the blocking mode of inherited file descriptor %d: %w", f.fd, err)
+		}
+		unixSystem.Preopen(unix.FD(fd), f.path, f.stat)
+	}
+
 	for _, m := range b.mounts {
 		fd, err := syscall.Open(m.dir, syscall.O_DIRECTORY, 0)
 		if err != nil {
 			return ctx, nil, fmt.Errorf("unable to preopen directory %q: %w", m.dir, err)
 		}
-		rightsBase := wasi.DirectoryRights
-		rightsInheriting := wasi.DirectoryRights | wasi.FileRights
-		if m.mode == 'r' {
-			rightsBase &^= wasi.WriteRights
-			rightsInheriting &^= wasi.WriteRights
-		}
+		rightsBase, rightsInheriting := b.mountRights(m)
 		unixSystem.Preopen(unix.FD(fd), m.dir, wasi.FDStat{
 			FileType:         wasi.DirectoryType,
 			RightsBase:       rightsBase,
@@ -162,6 +301,12 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 		})
 	}
 
+	for _, f := range b.filesystems {
+		if _, errno := mounts.Mount(f.path, f.fsys); errno != wasi.ESUCCESS {
+			return ctx, nil, fmt.Errorf("unable to preopen file system %q: %w", f.path, errno)
+		}
+	}
+
 	for _, addr := range b.listens {
 		fd, err := sockets.Listen(addr)
 		if err != nil {
@@ -174,28 +319,76 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 			RightsInheriting: wasi.SockConnectionRights,
 		})
 	}
-	for _, addr := range b.dials {
-		fd, err := sockets.Dial(addr)
-		if err != nil && err != sockets.EINPROGRESS {
-			return ctx, nil, fmt.Errorf("unable to dial %q: %w", addr, err)
+	if len(b.dials) > 0 {
+		dialFDs := make([]string, len(b.dials))
+		for i, d := range b.dials {
+			fd, err := sockets.Dial(d.addr)
+			if err != nil && err != sockets.EINPROGRESS {
+				return ctx, nil, fmt.Errorf("unable to dial %q: %w", d.addr, err)
+			}
+			guestFD := unixSystem.Preopen(unix.FD(fd), d.name, wasi.FDStat{
+				FileType:   wasi.SocketStreamType,
+				Flags:      wasi.NonBlock,
+				RightsBase: wasi.SockConnectionRights,
+			})
+			dialFDs[i] = fmt.Sprintf("%s=%d", d.name, guestFD)
 		}
-		unixSystem.Preopen(unix.FD(fd), addr, wasi.FDStat{
-			FileType:   wasi.SocketStreamType,
-			Flags:      wasi.NonBlock,
-			RightsBase: wasi.SockConnectionRights,
+		// The file descriptors are only known after the connections have
+		// been preopened, the variable is appended to the environment.
+		dialEnv := "DIAL_FDS=" + strings.Join(dialFDs, ",")
+		unixSystem.Environ = append(unixSystem.Environ, dialEnv)
+		if environFunc := unixSystem.EnvironFunc; environFunc != nil {
+			unixSystem.EnvironFunc = func() []string { return append(environFunc(), dialEnv) }
+		}
+	}
+
+	if b.workingDir != "" {
+		fd, err := syscall.Open(b.workingDir, syscall.O_DIRECTORY, 0)
+		if err != nil {
+			return ctx, nil, fmt.Errorf("unable to preopen working directory %q: %w", b.workingDir, err)
+		}
+		rightsBase, rightsInheriting := b.mountRights(cwd)
+		unixSystem.Preopen(unix.FD(fd), ".", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       rightsBase,
+			RightsInheriting: rightsInheriting,
 		})
 	}
 
+	for _, info := range b.restore {
+		if errno := unixSystem.Restore(ctx, info); errno != wasi.ESUCCESS {
+			return ctx, nil, fmt.Errorf("unable to restore file descriptor %d: %w", info.FD, errno)
+		}
+	}
+
 	var extensions []wasi_snapshot_preview1.Extension
 	if b.socketsExtension != nil {
 		extensions = append(extensions, *b.socketsExtension)
 	}
+	if b.copyRange {
+		extensions = append(extensions, wasi_snapshot_preview1.CopyRange)
+	}
+	if b.splice {
+		extensions = append(extensions, wasi_snapshot_preview1.Splice)
+	}
+	if b.sendFile {
+		extensions = append(extensions, wasi_snapshot_preview1.SendFile)
+	}
+	if b.xattr {
+		extensions = append(extensions, wasi_snapshot_preview1.Xattr)
+	}
+	if b.swapNonBlock {
+		extensions = append(extensions, wasi_snapshot_preview1.SwapNonBlock)
+	}
 
 	hostModule := wasi_snapshot_preview1.NewHostModule(extensions...)
 
 	instance := wazergo.MustInstantiate(ctx, runtime,
 		wazergo.Decorate(hostModule, b.decorators...),
 		wasi_snapshot_preview1.WithWASI(system),
+		wasi_snapshot_preview1.WithMemoryFaultDiagnostics(b.memoryFaults),
+		wasi_snapshot_preview1.WithSocketsDiagnostics(b.socketsWarnings),
+		wasi_snapshot_preview1.WithMaxIOVecs(b.maxIOVecs),
 	)
 
 	ctx = wazergo.WithModuleInstance(ctx, instance)
@@ -204,6 +397,104 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 	return ctx, sys, nil
 }
 
+func stdioRights(fd int) wasi.Rights {
+	if descriptor.IsATTY(fd) {
+		return wasi.TTYRights
+	}
+	return wasi.FileRights
+}
+
+func socketFileType(fd int) (wasi.FileType, error) {
+	t, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	if err != nil {
+		return wasi.UnknownType, err
+	}
+	if t == syscall.SOCK_DGRAM || t == syscall.SOCK_RAW {
+		return wasi.SocketDGramType, nil
+	}
+	return wasi.SocketStreamType, nil
+}
+
+func (s socket) fileType() (fileType wasi.FileType, err error) {
+	if s.conn == nil {
+		return socketFileType(s.fd)
+	}
+	err = controlConn(s.conn, func(fd int) error {
+		fileType, err = socketFileType(fd)
+		return err
+	})
+	return fileType, err
+}
+
+func dupConn(conn net.Conn) (newfd int, err error) {
+	newfd = -1
+	err = controlConn(conn, func(fd int) error {
+		newfd, err = dup(fd)
+		return err
+	})
+	return newfd, err
+}
+
+func controlConn(conn net.Conn, fn func(int) error) error {
+	c, ok := conn.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("connection of type %T does not expose a file descriptor", conn)
+	}
+	rawConn, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnerr error
+	if err := rawConn.Control(func(fd uintptr) { fnerr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return fnerr
+}
+
+// stdioSystem wraps a System to wait until the data written by the module to
+// stdout and stderr was copied to the writers set on the builder when the
+// system is closed.
+type stdioSystem struct {
+	wasi.System
+	copies *sync.WaitGroup
+}
+
+func (s *stdioSystem) Close(ctx context.Context) error {
+	err := s.System.Close(ctx)
+	s.copies.Wait()
+	return err
+}
+
+// accountingSystem wraps a wasi.AccountingSystem to pass its report to the
+// function set on the builder when the system is closed.
+type accountingSystem struct {
+	*wasi.AccountingSystem
+	report   func(wasi.AccountingReport)
+	reported bool
+}
+
+func (s *accountingSystem) Close(ctx context.Context) error {
+	err := s.AccountingSystem.Close(ctx)
+	if !s.reported {
+		s.reported = true
+		s.report(s.Report())
+	}
+	return err
+}
+
+func pipe() ([2]int, error) {
+	syscall.ForkLock.Lock()
+	defer syscall.ForkLock.Unlock()
+
+	var p [2]int
+	if err := syscall.Pipe(p[:]); err != nil {
+		return p, err
+	}
+	syscall.CloseOnExec(p[0])
+	syscall.CloseOnExec(p[1])
+	return p, nil
+}
+
 func dup(fd int) (int, error) {
 	syscall.ForkLock.Lock()
 	defer syscall.ForkLock.Unlock()
@@ -215,3 +506,27 @@ func dup(fd int) (int, error) {
 	syscall.CloseOnExec(newfd)
 	return newfd, nil
 }
+
+func readHostsFile(path string) (unix.Hosts, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return unix.ParseHosts(f)
+}
+
+type nameResolver = func(context.Context, string, string, wasi.AddressInfo) ([]wasi.AddressInfo, bool, error)
+
+func chainNameResolvers(first, next nameResolver) nameResolver {
+	if next == nil {
+		return first
+	}
+	return func(ctx context.Context, name, service string, hints wasi.AddressInfo) ([]wasi.AddressInfo, bool, error) {
+		results, handled, err := first(ctx, name, service, hints)
+		if handled || err != nil {
+			return results, handled, err
+		}
+		return next(ctx, name, service, hints)
+	}
+}
@@ -0,0 +1,551 @@
+//go:build unix
+
+package imports
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/tetratelabs/wazero"
+)
+
+func socketPair(t *testing.T) (net.Conn, net.Conn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make([]net.Conn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = c
+	}
+	return conns[0], conns[1]
+}
+
+func TestBuilderWithConn(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	conn, peer := socketPair(t)
+	defer peer.Close()
+
+	ctx, system, err := NewBuilder().
+		WithConn("conn", conn).
+		Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	if _, err := conn.Write([]byte("x")); err == nil {
+		t.Error("connection was not closed after instantiating the module")
+	}
+
+	const fd = wasi.FD(3)
+	stat, errno := system.FDStatGet(ctx, fd)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if stat.FileType != wasi.SocketStreamType {
+		t.Errorf("wrong file type: %s", stat.FileType)
+	}
+
+	if _, err := peer.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Echo the data back to the peer the way a guest would.
+	subscriptions := []wasi.Subscription{
+		wasi.MakeSubscriptionFDReadWrite(0, wasi.FDReadEvent, wasi.SubscriptionFDReadWrite{FD: fd}),
+	}
+	events := make([]wasi.Event, 1)
+	if _, errno := system.PollOneOff(ctx, subscriptions, events); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	buf := make([]byte, 16)
+	n, _, errno := system.SockRecv(ctx, fd, []wasi.IOVec{buf}, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if _, errno := system.SockSend(ctx, fd, []wasi.IOVec{buf[:n]}, 0); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	r, err := peer.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:r]) != "hello" {
+		t.Errorf("wrong echo: %q", buf[:r])
+	}
+}
+
+func TestBuilderWithConnNotSyscallConn(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	if _, _, err := NewBuilder().WithConn("conn", conn).Instantiate(ctx, runtime); err == nil {
+		t.Error("expected an error passing a connection without a file descriptor")
+	}
+}
+
+func TestBuilderWithStdin(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	ctx, system, err := NewBuilder().
+		WithStdin(strings.NewReader("Hello, World!")).
+		Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	var data []byte
+	buf := make([]byte, 4)
+	for {
+		n, errno := system.FDRead(ctx, 0, []wasi.IOVec{buf})
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if n == 0 {
+			break
+		}
+		data = append(data, buf[:n]...)
+	}
+	if string(data) != "Hello, World!" {
+		t.Errorf("wrong data read from stdin: %q", data)
+	}
+}
+
+func TestBuilderWithAccounting(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	var reports []wasi.AccountingReport
+	ctx, system, err := NewBuilder().
+		WithStdin(strings.NewReader("Hello, World!")).
+		WithAccounting(func(r wasi.AccountingReport) { reports = append(reports, r) }).
+		Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	if _, errno := system.FDRead(ctx, 0, []wasi.IOVec{buf}); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	system.Close(ctx)
+	system.Close(ctx)
+
+	if len(reports) != 1 {
+		t.Fatalf("wrong number of reports: want 1, got %d", len(reports))
+	}
+	if r := reports[0]; r.Calls["FDRead"] != 1 || r.Stdio.Read != 13 {
+		t.Errorf("wrong report: %+v", r)
+	}
+}
+
+func TestBuilderWithStdoutAndStderr(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	var stdout, stderr bytes.Buffer
+	ctx, system, err := NewBuilder().
+		WithStdout(&stdout).
+		WithStderr(&stderr).
+		Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, errno := system.FDWrite(ctx, 1, []wasi.IOVec{[]byte("Hello, World!")}); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if _, errno := system.FDWrite(ctx, 2, []wasi.IOVec{[]byte("error")}); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	// Closing the system waits for the data to be copied to the buffers.
+	if err := system.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "Hello, World!" {
+		t.Errorf("wrong data written to stdout: %q", stdout.String())
+	}
+	if stderr.String() != "error" {
+		t.Errorf("wrong data written to stderr: %q", stderr.String())
+	}
+}
+
+func TestBuilderWithInheritedFD(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[1])
+
+	ctx, system, err := NewBuilder().
+		WithInheritedFD("inherited", fds[0], wasi.FDStat{
+			FileType:   wasi.SocketStreamType,
+			RightsBase: wasi.SockConnectionRights,
+		}).
+		Instantiate(ctx, runtime)
+	// The file descriptor is duplicated, the original can be closed.
+	syscall.Close(fds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	const fd = wasi.FD(3)
+	stat, errno := system.FDStatGet(ctx, fd)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if stat.FileType != wasi.SocketStreamType {
+		t.Errorf("wrong file type: %s", stat.FileType)
+	}
+	if stat.RightsBase != wasi.SockConnectionRights {
+		t.Errorf("wrong rights: %s", stat.RightsBase)
+	}
+
+	if _, err := syscall.Write(fds[1], []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, _, errno := system.SockRecv(ctx, fd, []wasi.IOVec{buf}, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("wrong data received: %q", buf[:n])
+	}
+
+	if _, errno := system.SockSend(ctx, fd, []wasi.IOVec{[]byte("world")}, 0); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	r, err := syscall.Read(fds[1], buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:r]) != "world" {
+		t.Errorf("wrong data sent: %q", buf[:r])
+	}
+}
+
+func TestBuilderWithWorkingDirectory(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	tmp := t.TempDir()
+	cwd := filepath.Join(tmp, "work")
+	if err := os.Mkdir(cwd, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cwd, "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, system, err := NewBuilder().
+		WithEnv("PWD=/nowhere", "HOME=/").
+		WithDirs(tmp + ":" + tmp + ":ro").
+		WithWorkingDirectory(cwd).
+		Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	// The working directory is preopened after stdio and the directory
+	// that contains it.
+	const fd = wasi.FD(4)
+	name, errno := system.FDPreStatDirName(ctx, fd)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if name != "." {
+		t.Errorf("wrong preopen name: want %q, got %q", ".", name)
+	}
+	stat, errno := system.FDStatGet(ctx, fd)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if stat.RightsInheriting.Has(wasi.FDWriteRight) {
+		t.Error("the working directory did not inherit the read-only mode")
+	}
+
+	f, errno := system.PathOpen(ctx, fd, 0, "file", 0, wasi.FDReadRight, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	buf := make([]byte, 16)
+	n, errno := system.FDRead(ctx, f, []wasi.IOVec{buf})
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("wrong file content: %q", buf[:n])
+	}
+
+	env, errno := system.EnvironGet(ctx)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if want := []string{"HOME=/", "PWD=" + cwd}; !reflect.DeepEqual(env, want) {
+		t.Errorf("wrong environment: want %q, got %q", want, env)
+	}
+}
+
+func TestBuilderWithFS(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("host"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assets := fstest.MapFS{
+		"index.html":   &fstest.MapFile{Data: []byte("<html></html>")},
+		"css/site.css": &fstest.MapFile{Data: []byte("body {}")},
+	}
+
+	ctx, system, err := NewBuilder().
+		WithDirs(tmp).
+		WithFS("/assets", assets).
+		Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	// The file system is preopened after stdio and the host directory.
+	const dirFD, assetsFD = wasi.FD(3), wasi.FD(4)
+	name, errno := system.FDPreStatDirName(ctx, assetsFD)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if name != "/assets" {
+		t.Errorf("wrong preopen name: want %q, got %q", "/assets", name)
+	}
+
+	readFile := func(dir wasi.FD, path string) string {
+		t.Helper()
+		f, errno := system.PathOpen(ctx, dir, 0, path, 0, wasi.FDReadRight, 0, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatalf("opening %q: %s", path, errno)
+		}
+		defer system.FDClose(ctx, f)
+		buf := make([]byte, 64)
+		n, errno := system.FDRead(ctx, f, []wasi.IOVec{buf})
+		if errno != wasi.ESUCCESS {
+			t.Fatalf("reading %q: %s", path, errno)
+		}
+		return string(buf[:n])
+	}
+
+	// Opening files on both sides checks that the descriptors allocated for
+	// the files of the fs.FS do not collide with those of the host.
+	hostFD, errno := system.PathOpen(ctx, dirFD, 0, "file", 0, wasi.FDReadRight, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	defer system.FDClose(ctx, hostFD)
+
+	if data := readFile(assetsFD, "index.html"); data != "<html></html>" {
+		t.Errorf("wrong file content: %q", data)
+	}
+	if data := readFile(assetsFD, "css/site.css"); data != "body {}" {
+		t.Errorf("wrong file content: %q", data)
+	}
+	if data := readFile(dirFD, "file"); data != "host" {
+		t.Errorf("wrong file content: %q", data)
+	}
+
+	entries := make([]wasi.DirEntry, 8)
+	n, errno := system.FDReadDir(ctx, assetsFD, entries, 0, 4096)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	var names []string
+	for _, e := range entries[:n] {
+		names = append(names, string(e.Name))
+	}
+	if want := []string{"css", "index.html"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("wrong directory entries: want %q, got %q", want, names)
+	}
+
+	if errno := system.PathCreateDirectory(ctx, assetsFD, "new"); errno != wasi.EROFS {
+		t.Errorf("creating a directory: want EROFS, got %s", errno)
+	}
+}
+
+func TestBuilderWithWorkingDirectoryOutsidePreopens(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	tmp := t.TempDir()
+	_, _, err := NewBuilder().
+		WithDirs(filepath.Join(tmp, "a")).
+		WithWorkingDirectory(filepath.Join(tmp, "ab")).
+		Instantiate(ctx, runtime)
+	if err == nil {
+		t.Error("expected an error using a working directory outside of the preopens")
+	}
+}
+
+func TestBuilderWithDials(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	names := []string{"a", "b"}
+	accepted := make(map[string]chan net.Conn)
+	var dials []string
+	for _, name := range names {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		ch := make(chan net.Conn, 1)
+		go func() {
+			c, _ := l.Accept()
+			ch <- c
+		}()
+		accepted[name] = ch
+		dials = append(dials, name+"="+l.Addr().String())
+	}
+
+	ctx, system, err := NewBuilder().
+		WithDials(dials...).
+		Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	// Discover the file descriptors the way a guest would.
+	env, errno := system.EnvironGet(ctx)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	fds := make(map[string]wasi.FD)
+	for _, e := range env {
+		if v, ok := strings.CutPrefix(e, "DIAL_FDS="); ok {
+			for _, pair := range strings.Split(v, ",") {
+				name, fd, _ := strings.Cut(pair, "=")
+				n, err := strconv.Atoi(fd)
+				if err != nil {
+					t.Fatalf("invalid DIAL_FDS entry %q", pair)
+				}
+				fds[name] = wasi.FD(n)
+			}
+		}
+	}
+	if len(fds) != len(names) || fds["a"] == fds["b"] {
+		t.Fatalf("wrong DIAL_FDS in the environment: %q", env)
+	}
+
+	poll := func(fd wasi.FD, event wasi.EventType) {
+		t.Helper()
+		subscriptions := []wasi.Subscription{
+			wasi.MakeSubscriptionFDReadWrite(0, event, wasi.SubscriptionFDReadWrite{FD: fd}),
+		}
+		events := make([]wasi.Event, 1)
+		if _, errno := system.PollOneOff(ctx, subscriptions, events); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+	}
+
+	for _, name := range names {
+		fd := fds[name]
+		upstream := <-accepted[name]
+		if upstream == nil {
+			t.Fatalf("upstream %s did not accept the connection", name)
+		}
+		defer upstream.Close()
+
+		poll(fd, wasi.FDWriteEvent)
+		if _, errno := system.SockSend(ctx, fd, []wasi.IOVec{[]byte("to " + name)}, 0); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		buf := make([]byte, 16)
+		n, err := upstream.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != "to "+name {
+			t.Errorf("upstream %s received %q", name, got)
+		}
+
+		if _, err := upstream.Write([]byte("from " + name)); err != nil {
+			t.Fatal(err)
+		}
+		poll(fd, wasi.FDReadEvent)
+		size, _, errno := system.SockRecv(ctx, fd, []wasi.IOVec{buf}, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if got := string(buf[:size]); got != "from "+name {
+			t.Errorf("connection %s received %q", name, got)
+		}
+	}
+}
+
+func TestBuilderWithDialsInvalidName(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	for _, dials := range [][]string{
+		{"=127.0.0.1:80"},
+		{"a,b=127.0.0.1:80"},
+		{"a=127.0.0.1:80", "a=127.0.0.1:81"},
+	} {
+		if _, _, err := NewBuilder().WithDials(dials...).Instantiate(ctx, runtime); err == nil {
+			t.Errorf("expected an error for dials %q", dials)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package imports
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+// Capabilities is a machine-readable description of the capabilities granted
+// to a module by a Builder.
+//
+// The value is intended to be serialized to JSON, so operators can review what
+// an untrusted module would have access to before running it.
+type Capabilities struct {
+	// Preopens lists the file descriptors that the module starts with, in
+	// the order of their file descriptor numbers.
+	Preopens []Preopen `json:"preopens"`
+
+	// Extensions lists the extensions to WASI preview 1 exposed to the
+	// module.
+	Extensions []string `json:"extensions"`
+
+	// RawSockets is true if the module may open raw sockets.
+	RawSockets bool `json:"rawSockets,omitempty"`
+
+	// Network is the policy restricting the addresses that sockets may use,
+	// one of all, loopback or none.
+	Network string `json:"network,omitempty"`
+
+	// Limits on the number of files and directories that the module may
+	// open, zero means no limit.
+	MaxOpenFiles int `json:"maxOpenFiles,omitempty"`
+	MaxOpenDirs  int `json:"maxOpenDirs,omitempty"`
+}
+
+// Preopen describes a file descriptor preopened by a Builder.
+//
+// For sockets, the path is the address that the socket is listening on or
+// connected to.
+type Preopen struct {
+	FD               wasi.FD  `json:"fd"`
+	Path             string   `json:"path"`
+	Type             string   `json:"type"`
+	Rights           []string `json:"rights"`
+	RightsInheriting []string `json:"rightsInheriting,omitempty"`
+}
+
+// Capabilities returns the set of capabilities that the module would be
+// granted if it was instantiated with the builder.
+//
+// The method does not have side effects, no files are opened and no
+// connections are established.
+func (b *Builder) Capabilities() (*Capabilities, error) {
+	if len(b.errors) > 0 {
+		return nil, errors.Join(b.errors...)
+	}
+
+	c := &Capabilities{
+		Preopens:     []Preopen{},
+		Extensions:   []string{},
+		RawSockets:   b.rawSockets,
+		Network:      b.networkPolicy,
+		MaxOpenFiles: b.maxOpenFiles,
+		MaxOpenDirs:  b.maxOpenDirs,
+	}
+
+	add := func(path string, fileType wasi.FileType, rightsBase, rightsInheriting wasi.Rights) {
+		c.Preopens = append(c.Preopens, Preopen{
+			FD:               wasi.FD(len(c.Preopens)),
+			Path:             path,
+			Type:             fileType.String(),
+			Rights:           rightsBase.Names(),
+			RightsInheriting: rightsInheriting.Names(),
+		})
+	}
+
+	stdio := []int{0, 1, 2}
+	if b.customStdio {
+		stdio = []int{b.stdin, b.stdout, b.stderr}
+	}
+	for i, rw := range []any{b.stdinReader, b.stdoutWriter, b.stderrWriter} {
+		if rw != nil {
+			stdio[i] = -1
+		}
+	}
+	for i, path := range []string{"/dev/stdin", "/dev/stdout", "/dev/stderr"} {
+		add(path, wasi.CharacterDeviceType, stdioRights(stdio[i]), 0)
+	}
+
+	for _, s := range b.sockets {
+		fileType, err := s.fileType()
+		if err != nil {
+			return nil, fmt.Errorf("unable to inspect socket %q: %w", s.path, err)
+		}
+		add(s.path, fileType, wasi.SockConnectionRights, 0)
+	}
+	for _, f := range b.inherited {
+		add(f.path, f.stat.FileType, f.stat.RightsBase, f.stat.RightsInheriting)
+	}
+	for _, m := range b.mounts {
+		rightsBase, rightsInheriting := b.mountRights(m)
+		add(m.dir, wasi.DirectoryType, rightsBase, rightsInheriting)
+	}
+	for _, f := range b.filesystems {
+		rightsBase, rightsInheriting := f.rights()
+		add(f.path, wasi.DirectoryType, rightsBase, rightsInheriting)
+	}
+	for _, addr := range b.listens {
+		add(addr, wasi.SocketStreamType, wasi.SockListenRights, wasi.SockConnectionRights)
+	}
+	for _, d := range b.dials {
+		add(d.name, wasi.SocketStreamType, wasi.SockConnectionRights, 0)
+	}
+	if b.workingDir != "" {
+		m, err := b.workingDirMount()
+		if err != nil {
+			return nil, err
+		}
+		rightsBase, rightsInheriting := b.mountRights(m)
+		add(".", wasi.DirectoryType, rightsBase, rightsInheriting)
+	}
+
+	if ext := b.SocketsExtension(); ext != "none" {
+		c.Extensions = append(c.Extensions, ext)
+	}
+	if b.copyRange {
+		c.Extensions = append(c.Extensions, "fd_copy_range")
+	}
+	if b.splice {
+		c.Extensions = append(c.Extensions, "sock_splice")
+	}
+	if b.sendFile {
+		c.Extensions = append(c.Extensions, "fd_sendfile")
+	}
+	if b.xattr {
+		c.Extensions = append(c.Extensions, "xattr")
+	}
+	if b.swapNonBlock {
+		c.Extensions = append(c.Extensions, "fd_fdstat_swap_nonblock")
+	}
+	return c, nil
+}
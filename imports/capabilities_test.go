@@ -0,0 +1,100 @@
+package imports
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/tetratelabs/wazero"
+)
+
+func TestBuilderCapabilities(t *testing.T) {
+	rw := t.TempDir()
+	ro := t.TempDir()
+
+	builder := NewBuilder().
+		WithDirs(rw, ro+":ro").
+		WithListens("127.0.0.1:0").
+		WithMaxOpenFiles(10)
+
+	caps, err := builder.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(caps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest Capabilities
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&manifest, caps) {
+		t.Fatalf("manifest does not round-trip through JSON:\n%s", b)
+	}
+
+	if manifest.MaxOpenFiles != 10 {
+		t.Errorf("wrong max open files: want 10, got %d", manifest.MaxOpenFiles)
+	}
+	if len(manifest.Preopens) != 6 {
+		t.Fatalf("wrong number of preopens: want 6, got %d", len(manifest.Preopens))
+	}
+	for i, path := range []string{"/dev/stdin", "/dev/stdout", "/dev/stderr", rw, ro, "127.0.0.1:0"} {
+		p := manifest.Preopens[i]
+		if p.FD != wasi.FD(i) || p.Path != path {
+			t.Errorf("wrong preopen at index %d: want %d:%s, got %d:%s", i, i, path, p.FD, p.Path)
+		}
+	}
+
+	hasRight := func(rights []string, right wasi.Rights) bool {
+		for _, r := range rights {
+			if r == right.String() {
+				return true
+			}
+		}
+		return false
+	}
+	if p := manifest.Preopens[3]; p.Type != "DirectoryType" || !hasRight(p.RightsInheriting, wasi.FDWriteRight) {
+		t.Errorf("read-write directory is missing the write right: %+v", p)
+	}
+	if p := manifest.Preopens[4]; p.Type != "DirectoryType" || hasRight(p.RightsInheriting, wasi.FDWriteRight) {
+		t.Errorf("read-only directory has write rights: %+v", p)
+	}
+	if p := manifest.Preopens[5]; p.Type != "SocketStreamType" || !hasRight(p.Rights, wasi.SockAcceptRight) {
+		t.Errorf("listener is missing the accept right: %+v", p)
+	}
+
+	// The manifest must describe the rights that the module is effectively
+	// granted when instantiated.
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	ctx, system, err := builder.Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	for _, p := range manifest.Preopens[3:] {
+		stat, errno := system.FDStatGet(ctx, p.FD)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if got := stat.RightsBase.Names(); !reflect.DeepEqual(got, p.Rights) {
+			t.Errorf("wrong rights for %s: want %v, got %v", p.Path, p.Rights, got)
+		}
+		if got := stat.RightsInheriting.Names(); !reflect.DeepEqual(got, p.RightsInheriting) {
+			t.Errorf("wrong inheriting rights for %s: want %v, got %v", p.Path, p.RightsInheriting, got)
+		}
+	}
+}
+
+func TestBuilderCapabilitiesInvalid(t *testing.T) {
+	if _, err := NewBuilder().WithDirs("a:b").Capabilities(); err == nil {
+		t.Error("expected an error for an invalid configuration")
+	}
+}
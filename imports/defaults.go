@@ -3,9 +3,13 @@ package imports
 import (
 	"context"
 	"crypto/rand"
+	"fmt"
+	"os"
 	"runtime"
+	"runtime/debug"
 	"time"
 
+	"github.com/stealthrocket/wasi-go"
 	"github.com/tetratelabs/wazero/sys"
 )
 
@@ -32,7 +36,35 @@ func defaultYield(ctx context.Context) error {
 	return nil
 }
 
-var defaultRaise func(ctx context.Context, signal int) error = nil
+type shutdowner interface {
+	Shutdown(context.Context) error
+}
+
+// defaultRaise returns the proc_raise function used when none was configured
+// on the builder.
+//
+// Only signals which terminate the process are supported, the process exits
+// with the conventional 128+signal exit code:
+//   - SIGABRT dumps the stack of the host before exiting
+//   - SIGKILL and SIGTERM shut down the system to cancel pending operations
+//
+// Other signals return ENOTSUP.
+func defaultRaise(system shutdowner) func(context.Context, int) error {
+	return func(ctx context.Context, signal int) error {
+		switch wasi.Signal(signal) {
+		case wasi.SIGABRT:
+			fmt.Fprintf(os.Stderr, "wasi: module raised %s\n", wasi.SIGABRT)
+			debug.PrintStack()
+		case wasi.SIGKILL, wasi.SIGTERM:
+			if err := system.Shutdown(ctx); err != nil {
+				return err
+			}
+		default:
+			return wasi.ENOTSUP
+		}
+		panic(sys.NewExitError(128 + uint32(signal)))
+	}
+}
 
 func defaultExit(ctx context.Context, exitCode int) error {
 	panic(sys.NewExitError(uint32(exitCode)))
@@ -0,0 +1,61 @@
+package imports
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+type shutdownRecorder struct{ shutdown bool }
+
+func (s *shutdownRecorder) Shutdown(context.Context) error {
+	s.shutdown = true
+	return nil
+}
+
+func TestDefaultRaise(t *testing.T) {
+	for _, test := range []struct {
+		signal   wasi.Signal
+		exitCode uint32
+		shutdown bool
+	}{
+		{signal: wasi.SIGABRT, exitCode: 134},
+		{signal: wasi.SIGKILL, exitCode: 137, shutdown: true},
+		{signal: wasi.SIGTERM, exitCode: 143, shutdown: true},
+	} {
+		t.Run(test.signal.String(), func(t *testing.T) {
+			system := new(shutdownRecorder)
+			raise := defaultRaise(system)
+
+			defer func() {
+				switch v := recover().(type) {
+				case *sys.ExitError:
+					if exitCode := v.ExitCode(); exitCode != test.exitCode {
+						t.Errorf("wrong exit code: want=%d got=%d", test.exitCode, exitCode)
+					}
+				default:
+					t.Errorf("proc_raise must exit: %v", v)
+				}
+				if system.shutdown != test.shutdown {
+					t.Errorf("wrong shutdown state: want=%t got=%t", test.shutdown, system.shutdown)
+				}
+			}()
+
+			raise(context.Background(), int(test.signal))
+		})
+	}
+
+	for _, signal := range []wasi.Signal{wasi.SIGHUP, wasi.SIGINT, wasi.SIGTRAP, wasi.SIGUSR1} {
+		t.Run(signal.String(), func(t *testing.T) {
+			system := new(shutdownRecorder)
+			if err := defaultRaise(system)(context.Background(), int(signal)); err != wasi.ENOTSUP {
+				t.Errorf("wrong error: want=%v got=%v", wasi.ENOTSUP, err)
+			}
+			if system.shutdown {
+				t.Error("system must not be shut down")
+			}
+		})
+	}
+}
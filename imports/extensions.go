@@ -5,14 +5,77 @@ import (
 	"github.com/tetratelabs/wazero"
 )
 
+// SupportedExtensions returns the names of the extensions to WASI preview 1
+// that the package can expose to modules. The names are the ones accepted by
+// WithSocketsExtension and reported in Capabilities.Extensions.
+func SupportedExtensions() []string {
+	return []string{
+		"wasmedgev1",
+		"wasmedgev2",
+		"path_open",
+		"fd_copy_range",
+		"sock_splice",
+		"fd_sendfile",
+		"xattr",
+		"fd_fdstat_swap_nonblock",
+	}
+}
+
 // DetectExtensions detects extensions to WASI preview 1.
 func DetectExtensions(module wazero.CompiledModule) (ext []wasi_snapshot_preview1.Extension) {
 	if sockets := DetectSocketsExtension(module); sockets != nil {
 		ext = append(ext, *sockets)
 	}
+	if DetectCopyRangeExtension(module) {
+		ext = append(ext, wasi_snapshot_preview1.CopyRange)
+	}
+	if DetectSpliceExtension(module) {
+		ext = append(ext, wasi_snapshot_preview1.Splice)
+	}
+	if DetectSendFileExtension(module) {
+		ext = append(ext, wasi_snapshot_preview1.SendFile)
+	}
+	if DetectSwapNonBlockExtension(module) {
+		ext = append(ext, wasi_snapshot_preview1.SwapNonBlock)
+	}
 	return
 }
 
+// DetectCopyRangeExtension returns true if the WASM module imports the
+// fd_copy_range function of the wasi_snapshot_preview1.CopyRange extension.
+func DetectCopyRangeExtension(module wazero.CompiledModule) bool {
+	return importsHostFunction(module, "fd_copy_range")
+}
+
+// DetectSpliceExtension returns true if the WASM module imports the
+// sock_splice function of the wasi_snapshot_preview1.Splice extension.
+func DetectSpliceExtension(module wazero.CompiledModule) bool {
+	return importsHostFunction(module, "sock_splice")
+}
+
+// DetectSendFileExtension returns true if the WASM module imports the
+// fd_sendfile function of the wasi_snapshot_preview1.SendFile extension.
+func DetectSendFileExtension(module wazero.CompiledModule) bool {
+	return importsHostFunction(module, "fd_sendfile")
+}
+
+// DetectSwapNonBlockExtension returns true if the WASM module imports the
+// fd_fdstat_swap_nonblock function of the wasi_snapshot_preview1.SwapNonBlock
+// extension.
+func DetectSwapNonBlockExtension(module wazero.CompiledModule) bool {
+	return importsHostFunction(module, "fd_fdstat_swap_nonblock")
+}
+
+func importsHostFunction(module wazero.CompiledModule, function string) bool {
+	for _, f := range module.ImportedFunctions() {
+		moduleName, name, ok := f.Import()
+		if ok && moduleName == wasi_snapshot_preview1.HostModuleName && name == function {
+			return true
+		}
+	}
+	return false
+}
+
 // DetectSocketsExtension determines the sockets extension in
 // use by inspecting a WASM module's host imports.
 //
@@ -0,0 +1,95 @@
+package imports
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// importModule returns the bytecode of a WebAssembly module which imports
+// functions with the given names and parameter counts from the WASI host
+// module.
+func importModule(imports map[string]int) []byte {
+	str := func(s string) []byte { return append([]byte{byte(len(s))}, s...) }
+
+	var types, funcs []byte
+	types = append(types, byte(len(imports)))
+	funcs = append(funcs, byte(len(imports)))
+	i := 0
+	for name, params := range imports {
+		types = append(types, 0x60, byte(params))
+		for j := 0; j < params; j++ {
+			types = append(types, 0x7f) // i32
+		}
+		types = append(types, 1, 0x7f)
+		funcs = append(funcs, str("wasi_snapshot_preview1")...)
+		funcs = append(funcs, str(name)...)
+		funcs = append(funcs, 0x00, byte(i))
+		i++
+	}
+
+	module := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	module = append(module, 1, byte(len(types)))
+	module = append(module, types...)
+	module = append(module, 2, byte(len(funcs)))
+	module = append(module, funcs...)
+	return module
+}
+
+func TestBuilderSocketsExtension(t *testing.T) {
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	for _, test := range []struct {
+		name    string
+		imports map[string]int
+		want    string
+	}{
+		{name: "none", want: "none"},
+		{name: "wasmedgev1", want: "wasmedgev1"},
+		{name: "wasmedgev2", want: "wasmedgev2"},
+		{name: "path_open", want: "path_open"},
+		{name: "auto", imports: map[string]int{"fd_write": 4}, want: "none"},
+		{name: "auto", imports: map[string]int{"sock_open": 3, "sock_accept": 3}, want: "wasmedgev2"},
+		{name: "auto", imports: map[string]int{"sock_open": 3, "sock_accept": 2}, want: "wasmedgev1"},
+	} {
+		t.Run(test.name+"/"+test.want, func(t *testing.T) {
+			module, err := runtime.CompileModule(ctx, importModule(test.imports))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer module.Close(ctx)
+
+			b := NewBuilder().WithSocketsExtension(test.name, module)
+			if got := b.SocketsExtension(); got != test.want {
+				t.Errorf("wrong sockets extension: want=%q got=%q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestSupportedExtensions(t *testing.T) {
+	supported := SupportedExtensions()
+
+	for _, name := range []string{"wasmedgev1", "wasmedgev2", "path_open"} {
+		caps, err := NewBuilder().
+			WithSocketsExtension(name, nil).
+			WithCopyRangeExtension(true).
+			WithSpliceExtension(true).
+			WithSendFileExtension(true).
+			WithXattrExtension(true).
+			WithSwapNonBlockExtension(true).
+			Capabilities()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ext := range caps.Extensions {
+			if !slices.Contains(supported, ext) {
+				t.Errorf("extension %q is missing from the supported extensions", ext)
+			}
+		}
+	}
+}
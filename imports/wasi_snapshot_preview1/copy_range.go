@@ -0,0 +1,27 @@
+package wasi_snapshot_preview1
+
+import (
+	"context"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wazergo"
+	. "github.com/stealthrocket/wazergo/types"
+)
+
+// CopyRange is an extension to WASI preview 1 adding a function to copy
+// ranges of bytes between files without moving the data through the memory
+// of the guest:
+//
+//	fd_copy_range(src_fd: fd, dst_fd: fd, src_offset: filesize, dst_offset: filesize, len: filesize, copied: *filesize) -> errno
+var CopyRange = Extension{
+	"fd_copy_range": wazergo.F6((*Module).FDCopyRange),
+}
+
+func (m *Module) FDCopyRange(ctx context.Context, srcFD, dstFD Int32, srcOffset, dstOffset, length Uint64, copied Pointer[Uint64]) Errno {
+	result, errno := m.WASI.FDCopyRange(ctx, wasi.FD(srcFD), wasi.FD(dstFD), wasi.FileSize(srcOffset), wasi.FileSize(dstOffset), wasi.FileSize(length))
+	if errno != wasi.ESUCCESS {
+		return Errno(errno)
+	}
+	copied.Store(Uint64(result))
+	return Errno(wasi.ESUCCESS)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
 
 	"github.com/stealthrocket/wasi-go"
 	"github.com/stealthrocket/wazergo"
@@ -98,6 +99,29 @@ func WithWASI(wasi wasi.System) Option {
 	return wazergo.OptionFunc(func(m *Module) { m.WASI = wasi })
 }
 
+// WithMemoryFaultDiagnostics enables logging of the memory regions which
+// caused host functions to return EFAULT because they were out of bounds of
+// the guest memory. The diagnostics are written to w, which disables them
+// when nil.
+func WithMemoryFaultDiagnostics(w io.Writer) Option {
+	return wazergo.OptionFunc(func(m *Module) { m.faults = w })
+}
+
+// WithSocketsDiagnostics enables a warning written to w the first time that a
+// socket function fails with ENOSYS, which usually means that the guest
+// expected a sockets extension that was not enabled. A nil writer disables
+// the warning, which is the default.
+func WithSocketsDiagnostics(w io.Writer) Option {
+	return wazergo.OptionFunc(func(m *Module) { m.sockets = w })
+}
+
+// WithMaxIOVecs limits the number of buffers that the guest may pass to
+// vectored I/O functions such as fd_read and fd_write, which fail with
+// EINVAL when given more. Zero, the default, means no limit.
+func WithMaxIOVecs(n int) Option {
+	return wazergo.OptionFunc(func(m *Module) { m.maxIOVecs = n })
+}
+
 type functions wazergo.Functions[*Module]
 
 func (f functions) Name() string {
@@ -136,6 +160,41 @@ type Module struct {
 	inet6addr wasi.Inet6Address
 	unixaddr  wasi.UnixAddress
 	addrinfo  []wasi.AddressInfo
+	faults    io.Writer
+	maxIOVecs int
+	sockets   io.Writer
+	warned    bool
+}
+
+// loadIOVecs loads the buffers passed by the guest to a vectored I/O
+// function into m.iovecs, returning EINVAL if there are more than the
+// configured maximum.
+func (m *Module) loadIOVecs(iovecs List[wasi.IOVec]) Errno {
+	m.iovecs = iovecs.Append(m.iovecs[:0])
+	if m.maxIOVecs > 0 && len(m.iovecs) > m.maxIOVecs {
+		return Errno(wasi.EINVAL)
+	}
+	return Errno(wasi.ESUCCESS)
+}
+
+// fault returns EFAULT, reporting the memory region which could not be
+// accessed if memory fault diagnostics are enabled.
+func (m *Module) fault(function string, memory api.Memory, offset, length uint32) Errno {
+	if m.faults != nil {
+		fmt.Fprintf(m.faults, "%s: memory fault: offset=%d length=%d memory size=%d\n", function, offset, length, memory.Size())
+	}
+	return Errno(wasi.EFAULT)
+}
+
+// socketErrno converts the error returned by a socket function of the system.
+// When socket diagnostics are enabled, a warning is written the first time
+// that the system reports that sockets are not supported.
+func (m *Module) socketErrno(function string, errno wasi.Errno) Errno {
+	if errno == wasi.ENOSYS && m.sockets != nil && !m.warned {
+		m.warned = true
+		fmt.Fprintf(m.sockets, "warning: %s: sockets are not supported (ENOSYS); the sockets extension may not be enabled, try running with --sockets=auto\n", function)
+	}
+	return Errno(errno)
 }
 
 func (m *Module) ArgsGet(ctx context.Context, argv Pointer[Uint32], buf Pointer[Uint8]) Errno {
@@ -143,7 +202,7 @@ func (m *Module) ArgsGet(ctx context.Context, argv Pointer[Uint32], buf Pointer[
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
 	}
-	return m.storeArgs(args, argv, buf)
+	return m.storeArgs("args_get", args, argv, buf)
 }
 
 func (m *Module) ArgsSizesGet(ctx context.Context, argc, bufLen Pointer[Int32]) Errno {
@@ -161,7 +220,7 @@ func (m *Module) EnvironGet(ctx context.Context, envv Pointer[Uint32], buf Point
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
 	}
-	return m.storeArgs(env, envv, buf)
+	return m.storeArgs("environ_get", env, envv, buf)
 }
 
 func (m *Module) EnvironSizesGet(ctx context.Context, envc, bufLen Pointer[Int32]) Errno {
@@ -174,14 +233,14 @@ func (m *Module) EnvironSizesGet(ctx context.Context, envc, bufLen Pointer[Int32
 	return Errno(wasi.ESUCCESS)
 }
 
-func (m *Module) storeArgs(args []string, argv Pointer[Uint32], buf Pointer[Uint8]) Errno {
+func (m *Module) storeArgs(function string, args []string, argv Pointer[Uint32], buf Pointer[Uint8]) Errno {
 	offset := buf.Offset()
 	memory := buf.Memory()
 	for i, arg := range args {
 		length := uint32(len(arg) + 1)
 		b, ok := memory.Read(offset, length)
 		if !ok {
-			return Errno(wasi.EFAULT)
+			return m.fault(function, memory, offset, length)
 		}
 		copy(b, arg)
 		b[len(arg)] = 0
@@ -260,7 +319,9 @@ func (m *Module) FDFileStatSetTimes(ctx context.Context, fd Int32, accessTime, m
 }
 
 func (m *Module) FDPread(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], offset Uint64, nread Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	if errno := m.loadIOVecs(iovecs); errno != Errno(wasi.ESUCCESS) {
+		return errno
+	}
 	result, errno := m.WASI.FDPread(ctx, wasi.FD(fd), m.iovecs, wasi.FileSize(offset))
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
@@ -291,7 +352,9 @@ func (m *Module) FDPreStatDirName(ctx context.Context, fd Int32, dirName Bytes)
 }
 
 func (m *Module) FDPwrite(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], offset Uint64, nwritten Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	if errno := m.loadIOVecs(iovecs); errno != Errno(wasi.ESUCCESS) {
+		return errno
+	}
 	result, errno := m.WASI.FDPwrite(ctx, wasi.FD(fd), m.iovecs, wasi.FileSize(offset))
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
@@ -301,7 +364,9 @@ func (m *Module) FDPwrite(ctx context.Context, fd Int32, iovecs List[wasi.IOVec]
 }
 
 func (m *Module) FDRead(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], nread Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	if errno := m.loadIOVecs(iovecs); errno != Errno(wasi.ESUCCESS) {
+		return errno
+	}
 	result, errno := m.WASI.FDRead(ctx, wasi.FD(fd), m.iovecs)
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
@@ -370,7 +435,9 @@ func (m *Module) FDTell(ctx context.Context, fd Int32, size Pointer[Uint64]) Err
 }
 
 func (m *Module) FDWrite(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], nwritten Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	if errno := m.loadIOVecs(iovecs); errno != Errno(wasi.ESUCCESS) {
+		return errno
+	}
 	result, errno := m.WASI.FDWrite(ctx, wasi.FD(fd), m.iovecs)
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
@@ -438,6 +505,9 @@ func (m *Module) PollOneOff(ctx context.Context, in Pointer[wasi.Subscription],
 	if nSubscriptions <= 0 {
 		return Errno(wasi.EINVAL)
 	}
+	// The subscriptions and events are views of the guest memory, they are
+	// passed to the system without being copied or converted, which keeps
+	// the calls free of allocations (see BenchmarkPollOneOff).
 	n, errno := m.WASI.PollOneOff(ctx,
 		in.UnsafeSlice(int(nSubscriptions)),
 		out.UnsafeSlice(int(nSubscriptions)),
@@ -477,17 +547,19 @@ func (m *Module) RandomGet(ctx context.Context, buf Bytes) Errno {
 func (m *Module) SockAccept(ctx context.Context, fd Int32, flags Int32, connfd Pointer[Int32]) Errno {
 	result, _, _, errno := m.WASI.SockAccept(ctx, wasi.FD(fd), wasi.FDFlags(flags))
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
+		return m.socketErrno("sock_accept", errno)
 	}
 	connfd.Store(Int32(result))
 	return Errno(wasi.ESUCCESS)
 }
 
 func (m *Module) SockRecv(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], iflags Int32, nread Pointer[Int32], oflags Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	if errno := m.loadIOVecs(iovecs); errno != Errno(wasi.ESUCCESS) {
+		return errno
+	}
 	size, roflags, errno := m.WASI.SockRecv(ctx, wasi.FD(fd), m.iovecs, wasi.RIFlags(iflags))
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
+		return m.socketErrno("sock_recv", errno)
 	}
 	nread.Store(Int32(size))
 	oflags.Store(Int32(roflags))
@@ -495,17 +567,19 @@ func (m *Module) SockRecv(ctx context.Context, fd Int32, iovecs List[wasi.IOVec]
 }
 
 func (m *Module) SockSend(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], flags Int32, nwritten Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	if errno := m.loadIOVecs(iovecs); errno != Errno(wasi.ESUCCESS) {
+		return errno
+	}
 	size, errno := m.WASI.SockSend(ctx, wasi.FD(fd), m.iovecs, wasi.SIFlags(flags))
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
+		return m.socketErrno("sock_send", errno)
 	}
 	nwritten.Store(Int32(size))
 	return Errno(wasi.ESUCCESS)
 }
 
 func (m *Module) SockShutdown(ctx context.Context, fd Int32, flags Int32) Errno {
-	return Errno(m.WASI.SockShutdown(ctx, wasi.FD(fd), wasi.SDFlags(flags)))
+	return m.socketErrno("sock_shutdown", m.WASI.SockShutdown(ctx, wasi.FD(fd), wasi.SDFlags(flags)))
 }
 
 func (m *Module) Close(ctx context.Context) error {
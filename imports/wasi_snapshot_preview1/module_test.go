@@ -0,0 +1,109 @@
+package wasi_snapshot_preview1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+	. "github.com/stealthrocket/wazergo/types"
+	"github.com/tetratelabs/wazero"
+)
+
+// memoryModule is a module exporting a memory of one page.
+var memoryModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// memory section: one memory with a minimum of one page
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	// export section: "mem"
+	0x07, 0x07, 0x01, 0x03, 'm', 'e', 'm', 0x02, 0x00,
+}
+
+type argsSystem struct {
+	wasi.System
+	args []string
+}
+
+func (s *argsSystem) ArgsGet(ctx context.Context) ([]string, wasi.Errno) {
+	return s.args, wasi.ESUCCESS
+}
+
+func TestMemoryFaultDiagnostics(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	instance, err := runtime.Instantiate(ctx, memoryModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := instance.ExportedMemory("mem")
+
+	var diagnostics strings.Builder
+	m := &Module{WASI: &argsSystem{args: []string{"hello"}}}
+	WithMemoryFaultDiagnostics(&diagnostics).Configure(m)
+
+	// The buffer starts two bytes before the end of the memory, which is not
+	// enough to hold the first argument.
+	errno := m.ArgsGet(ctx, Ptr[Uint32](memory, 0), Ptr[Uint8](memory, 65534))
+	if errno != Errno(wasi.EFAULT) {
+		t.Fatalf("wrong error: want EFAULT, got %v", errno)
+	}
+
+	const want = "args_get: memory fault: offset=65534 length=6 memory size=65536\n"
+	if got := diagnostics.String(); got != want {
+		t.Errorf("wrong diagnostics:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+// noSocketsSystem is a wasi.System which does not support sockets, like the
+// systems embedding wasi.SocketsNotSupported.
+type noSocketsSystem struct {
+	wasi.System
+}
+
+func (s *noSocketsSystem) SockOpen(ctx context.Context, family wasi.ProtocolFamily, socketType wasi.SocketType, protocol wasi.Protocol, rightsBase, rightsInheriting wasi.Rights) (wasi.FD, wasi.Errno) {
+	return -1, wasi.ENOSYS
+}
+
+func (s *noSocketsSystem) SockShutdown(ctx context.Context, fd wasi.FD, flags wasi.SDFlags) wasi.Errno {
+	return wasi.ENOSYS
+}
+
+func TestSocketsDiagnostics(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	instance, err := runtime.Instantiate(ctx, memoryModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := instance.ExportedMemory("mem")
+
+	var diagnostics strings.Builder
+	m := &Module{WASI: &noSocketsSystem{}}
+	WithSocketsDiagnostics(&diagnostics).Configure(m)
+
+	fd := Ptr[Int32](memory, 0)
+	for i := 0; i < 2; i++ {
+		errno := m.WasmEdgeSockOpen(ctx, Int32(wasi.InetFamily), Int32(wasi.StreamSocket), fd)
+		if errno != Errno(wasi.ENOSYS) {
+			t.Fatalf("wrong error: want ENOSYS, got %v", errno)
+		}
+	}
+	if errno := m.SockShutdown(ctx, 3, Int32(wasi.ShutdownRD)); errno != Errno(wasi.ENOSYS) {
+		t.Fatalf("wrong error: want ENOSYS, got %v", errno)
+	}
+
+	// The warning is only written once.
+	got := diagnostics.String()
+	if strings.Count(got, "\n") != 1 {
+		t.Fatalf("the warning was not written exactly once: %q", got)
+	}
+	if !strings.HasPrefix(got, "warning: sock_open: ") || !strings.Contains(got, "--sockets") {
+		t.Errorf("wrong diagnostics: %q", got)
+	}
+}
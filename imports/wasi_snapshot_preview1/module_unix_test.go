@@ -0,0 +1,109 @@
+//go:build unix
+
+package wasi_snapshot_preview1
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"unsafe"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/systems/unix"
+	"github.com/stealthrocket/wazergo"
+	. "github.com/stealthrocket/wazergo/types"
+	"github.com/tetratelabs/wazero"
+	sysunix "golang.org/x/sys/unix"
+)
+
+// pollModule is a module importing poll_oneoff, exporting a memory of 32
+// pages, large enough to hold 10k subscriptions and events, and a function
+// "poll" which calls poll_oneoff with its arguments.
+var pollModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// type section: (i32, i32, i32, i32) -> i32
+	0x01, 0x09, 0x01, 0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f,
+	// import section: wasi_snapshot_preview1.poll_oneoff
+	0x02, 0x26, 0x01,
+	0x16, 'w', 'a', 's', 'i', '_', 's', 'n', 'a', 'p', 's', 'h', 'o', 't', '_', 'p', 'r', 'e', 'v', 'i', 'e', 'w', '1',
+	0x0b, 'p', 'o', 'l', 'l', '_', 'o', 'n', 'e', 'o', 'f', 'f',
+	0x00, 0x00,
+	// function section
+	0x03, 0x02, 0x01, 0x00,
+	// memory section: one memory with a minimum of 32 pages
+	0x05, 0x03, 0x01, 0x00, 0x20,
+	// export section: mem, poll
+	0x07, 0x0e, 0x02,
+	0x03, 'm', 'e', 'm', 0x02, 0x00,
+	0x04, 'p', 'o', 'l', 'l', 0x00, 0x01,
+	// code section
+	0x0a, 0x0e, 0x01,
+	0x0c, 0x00, 0x20, 0x00, 0x20, 0x01, 0x20, 0x02, 0x20, 0x03, 0x10, 0x00, 0x0b,
+}
+
+func BenchmarkPollOneOff(b *testing.B) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	system := &unix.System{}
+	defer system.Close(ctx)
+
+	host := wazergo.MustInstantiate(ctx, runtime, NewHostModule(), WithWASI(system))
+	defer host.Close(ctx)
+	ctx = wazergo.WithModuleInstance(ctx, host)
+
+	instance, err := runtime.Instantiate(ctx, pollModule)
+	if err != nil {
+		b.Fatal(err)
+	}
+	memory := instance.ExportedMemory("mem")
+	poll := instance.ExportedFunction("poll")
+
+	// The subscriptions all wait on the same socket, which is ready for
+	// writing, so every call reports as many events as there are
+	// subscriptions.
+	fds, err := sysunix.Socketpair(sysunix.AF_UNIX, sysunix.SOCK_STREAM, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sysunix.Close(fds[1])
+	fd := system.Preopen(unix.FD(fds[0]), "fd0", wasi.FDStat{
+		FileType:   wasi.SocketStreamType,
+		RightsBase: wasi.AllRights,
+	})
+
+	for _, n := range []int{1, 100, 10000} {
+		b.Run(fmt.Sprintf("subscriptions=%d", n), func(b *testing.B) {
+			const subscriptionsOffset = 0
+			eventsOffset := uint32(n) * uint32(unsafe.Sizeof(wasi.Subscription{}))
+			nEventsOffset := eventsOffset + uint32(n)*uint32(unsafe.Sizeof(wasi.Event{}))
+
+			subscriptions := Ptr[wasi.Subscription](memory, subscriptionsOffset).UnsafeSlice(n)
+			for i := range subscriptions {
+				subscriptions[i] = wasi.MakeSubscriptionFDReadWrite(wasi.UserData(i), wasi.FDWriteEvent, wasi.SubscriptionFDReadWrite{FD: fd})
+			}
+			nEvents := Ptr[Int32](memory, nEventsOffset)
+			stack := make([]uint64, 4)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				stack[0] = subscriptionsOffset
+				stack[1] = uint64(eventsOffset)
+				stack[2] = uint64(n)
+				stack[3] = uint64(nEventsOffset)
+				if err := poll.CallWithStack(ctx, stack); err != nil {
+					b.Fatal(err)
+				}
+				if errno := wasi.Errno(stack[0]); errno != wasi.ESUCCESS {
+					b.Fatal(errno)
+				}
+				if numEvents := nEvents.Load(); int(numEvents) != n {
+					b.Fatalf("wrong number of events: want %d, got %d", n, numEvents)
+				}
+			}
+		})
+	}
+}
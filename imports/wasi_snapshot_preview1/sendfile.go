@@ -0,0 +1,27 @@
+package wasi_snapshot_preview1
+
+import (
+	"context"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wazergo"
+	. "github.com/stealthrocket/wazergo/types"
+)
+
+// SendFile is an extension to WASI preview 1 adding a function to send the
+// content of a file on a stream socket without moving the data through the
+// memory of the guest:
+//
+//	fd_sendfile(out_fd: fd, in_fd: fd, offset: filesize, count: size, sent: *size) -> errno
+var SendFile = Extension{
+	"fd_sendfile": wazergo.F5((*Module).FDSendFile),
+}
+
+func (m *Module) FDSendFile(ctx context.Context, outFD, inFD Int32, offset Uint64, count Uint32, sent Pointer[Uint32]) Errno {
+	n, errno := m.WASI.FDSendFile(ctx, wasi.FD(outFD), wasi.FD(inFD), wasi.FileSize(offset), wasi.Size(count))
+	if errno != wasi.ESUCCESS {
+		return Errno(errno)
+	}
+	sent.Store(Uint32(n))
+	return Errno(wasi.ESUCCESS)
+}
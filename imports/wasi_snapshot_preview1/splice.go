@@ -0,0 +1,27 @@
+package wasi_snapshot_preview1
+
+import (
+	"context"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wazergo"
+	. "github.com/stealthrocket/wazergo/types"
+)
+
+// Splice is an extension to WASI preview 1 adding a function to move bytes
+// between two stream sockets without moving the data through the memory of
+// the guest:
+//
+//	sock_splice(in_fd: fd, out_fd: fd, max_bytes: size, moved: *size) -> errno
+var Splice = Extension{
+	"sock_splice": wazergo.F4((*Module).SockSplice),
+}
+
+func (m *Module) SockSplice(ctx context.Context, inFD, outFD Int32, maxBytes Uint32, moved Pointer[Uint32]) Errno {
+	n, errno := m.WASI.SockSplice(ctx, wasi.FD(inFD), wasi.FD(outFD), wasi.Size(maxBytes))
+	if errno != wasi.ESUCCESS {
+		return Errno(errno)
+	}
+	moved.Store(Uint32(n))
+	return Errno(wasi.ESUCCESS)
+}
@@ -0,0 +1,27 @@
+package wasi_snapshot_preview1
+
+import (
+	"context"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wazergo"
+	. "github.com/stealthrocket/wazergo/types"
+)
+
+// SwapNonBlock is an extension to WASI preview 1 adding a function to set or
+// clear the non-blocking flag of a file descriptor, returning the previous
+// mode in the same call:
+//
+//	fd_fdstat_swap_nonblock(fd: fd, nonblock: bool, prev: *bool) -> errno
+var SwapNonBlock = Extension{
+	"fd_fdstat_swap_nonblock": wazergo.F3((*Module).FDStatSwapNonBlock),
+}
+
+func (m *Module) FDStatSwapNonBlock(ctx context.Context, fd Int32, nonBlock Bool, prev Pointer[Bool]) Errno {
+	result, errno := m.WASI.FDStatSwapNonBlock(ctx, wasi.FD(fd), bool(nonBlock))
+	if errno != wasi.ESUCCESS {
+		return Errno(errno)
+	}
+	prev.Store(Bool(result))
+	return Errno(wasi.ESUCCESS)
+}
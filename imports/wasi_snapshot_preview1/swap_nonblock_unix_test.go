@@ -0,0 +1,80 @@
+//go:build unix
+
+package wasi_snapshot_preview1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/systems/unix"
+	. "github.com/stealthrocket/wazergo/types"
+	"github.com/tetratelabs/wazero"
+	sysunix "golang.org/x/sys/unix"
+)
+
+func TestFDStatSwapNonBlock(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	instance, err := runtime.Instantiate(ctx, memoryModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := instance.ExportedMemory("mem")
+
+	var fds [2]int
+	if err := sysunix.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+	defer sysunix.Close(fds[1])
+
+	system := &unix.System{}
+	defer system.Close(ctx)
+	fd := system.Preopen(unix.FD(fds[0]), "pipe", wasi.FDStat{
+		FileType:   wasi.CharacterDeviceType,
+		RightsBase: wasi.FDReadRight | wasi.FDStatSetFlagsRight,
+	})
+	m := &Module{WASI: system}
+
+	const prevOffset = 64
+	for i, test := range []struct {
+		nonBlock bool
+		prev     bool
+	}{
+		{nonBlock: true, prev: false},
+		{nonBlock: true, prev: true},
+		{nonBlock: false, prev: true},
+		{nonBlock: false, prev: false},
+	} {
+		// Start from a value which differs from the expected one so the test
+		// fails if the previous mode is not written to memory.
+		memory.WriteByte(prevOffset, 0xFF)
+
+		prev := Ptr[Bool](memory, prevOffset)
+		if errno := m.FDStatSwapNonBlock(ctx, Int32(fd), Bool(test.nonBlock), prev); errno != Errno(wasi.ESUCCESS) {
+			t.Fatalf("%d: fd_fdstat_swap_nonblock: %v", i, errno)
+		}
+		if got := bool(prev.Load()); got != test.prev {
+			t.Errorf("%d: wrong previous mode: want %t, got %t", i, test.prev, got)
+		}
+		if b, _ := memory.ReadByte(prevOffset); b > 1 {
+			t.Errorf("%d: previous mode was not stored: %#x", i, b)
+		}
+
+		fl, err := sysunix.FcntlInt(uintptr(fds[0]), sysunix.F_GETFL, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nonBlock := (fl & sysunix.O_NONBLOCK) != 0; nonBlock != test.nonBlock {
+			t.Errorf("%d: wrong O_NONBLOCK flag: want %t, got %t", i, test.nonBlock, nonBlock)
+		}
+	}
+
+	prev := Ptr[Bool](memory, prevOffset)
+	if errno := m.FDStatSwapNonBlock(ctx, Int32(fd+1), true, prev); errno != Errno(wasi.EBADF) {
+		t.Errorf("wrong error for invalid file descriptor: want EBADF, got %v", errno)
+	}
+}
@@ -59,7 +59,7 @@ func (m *Module) WasmEdgeSockOpen(ctx context.Context, family Int32, sockType In
 	rightsInheriting := wasi.SockConnectionRights
 	result, errno := m.WASI.SockOpen(ctx, wasi.ProtocolFamily(family), wasi.SocketType(sockType), wasi.IPProtocol, rightsBase, rightsInheriting)
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
+		return m.socketErrno("sock_open", errno)
 	}
 	openfd.Store(Int32(result))
 	return Errno(wasi.ESUCCESS)
@@ -71,7 +71,7 @@ func (m *Module) WasmEdgeSockBind(ctx context.Context, fd Int32, addr Pointer[wa
 		return Errno(wasi.EINVAL)
 	}
 	_, errno := m.WASI.SockBind(ctx, wasi.FD(fd), socketAddr)
-	return Errno(errno)
+	return m.socketErrno("sock_bind", errno)
 }
 
 func (m *Module) WasmEdgeSockConnect(ctx context.Context, fd Int32, addr Pointer[wasmEdgeAddress], port Uint32) Errno {
@@ -80,11 +80,11 @@ func (m *Module) WasmEdgeSockConnect(ctx context.Context, fd Int32, addr Pointer
 		return Errno(wasi.EINVAL)
 	}
 	_, errno := m.WASI.SockConnect(ctx, wasi.FD(fd), socketAddr)
-	return Errno(errno)
+	return m.socketErrno("sock_connect", errno)
 }
 
 func (m *Module) WasmEdgeSockListen(ctx context.Context, fd Int32, backlog Int32) Errno {
-	return Errno(m.WASI.SockListen(ctx, wasi.FD(fd), int(backlog)))
+	return m.socketErrno("sock_listen", m.WASI.SockListen(ctx, wasi.FD(fd), int(backlog)))
 }
 
 func (m *Module) WasmEdgeSockSendTo(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], addr Pointer[wasmEdgeAddress], port Int32, flags Uint32, nwritten Pointer[Int32]) Errno {
@@ -92,20 +92,24 @@ func (m *Module) WasmEdgeSockSendTo(ctx context.Context, fd Int32, iovecs List[w
 	if !ok {
 		return Errno(wasi.EINVAL)
 	}
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	if errno := m.loadIOVecs(iovecs); errno != Errno(wasi.ESUCCESS) {
+		return errno
+	}
 	size, errno := m.WASI.SockSendTo(ctx, wasi.FD(fd), m.iovecs, wasi.SIFlags(flags), socketAddr)
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
+		return m.socketErrno("sock_send_to", errno)
 	}
 	nwritten.Store(Int32(size))
 	return Errno(wasi.ESUCCESS)
 }
 
 func (m *Module) WasmEdgeV1SockRecvFrom(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], addr Pointer[wasmEdgeAddress], iflags Uint32, nread Pointer[Int32], oflags Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	if errno := m.loadIOVecs(iovecs); errno != Errno(wasi.ESUCCESS) {
+		return errno
+	}
 	size, roflags, sa, errno := m.WASI.SockRecvFrom(ctx, wasi.FD(fd), m.iovecs, wasi.RIFlags(iflags))
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
+		return m.socketErrno("sock_recv_from", errno)
 	}
 	if _, _, ok := m.wasmEdgeV1PutSocketAddress(addr.Load(), sa); !ok {
 		return Errno(wasi.EINVAL)
@@ -116,10 +120,12 @@ func (m *Module) WasmEdgeV1SockRecvFrom(ctx context.Context, fd Int32, iovecs Li
 }
 
 func (m *Module) WasmEdgeV2SockRecvFrom(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], addr Pointer[wasmEdgeAddress], iflags Uint32, port Pointer[Uint32], nread Pointer[Int32], oflags Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	if errno := m.loadIOVecs(iovecs); errno != Errno(wasi.ESUCCESS) {
+		return errno
+	}
 	size, roflags, sa, errno := m.WASI.SockRecvFrom(ctx, wasi.FD(fd), m.iovecs, wasi.RIFlags(iflags))
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
+		return m.socketErrno("sock_recv_from", errno)
 	}
 	portint, ok := m.wasmEdgeV2PutSocketAddress(addr.Load(), sa)
 	if !ok {
@@ -163,7 +169,7 @@ func (m *Module) WasmEdgeSockSetOpt(ctx context.Context, fd Int32, level Int32,
 		val = wasi.BytesValue(value)
 	}
 
-	return Errno(m.WASI.SockSetOpt(ctx, wasi.FD(fd), opt, val))
+	return m.socketErrno("sock_setsockopt", m.WASI.SockSetOpt(ctx, wasi.FD(fd), opt, val))
 }
 
 func (m *Module) WasmEdgeSockGetOpt(ctx context.Context, fd Int32, level Int32, option Int32, value Pointer[Int32], valueLen Int32) Errno {
@@ -180,7 +186,7 @@ func (m *Module) WasmEdgeSockGetOpt(ctx context.Context, fd Int32, level Int32,
 	}
 	result, errno := m.WASI.SockGetOpt(ctx, wasi.FD(fd), opt)
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
+		return m.socketErrno("sock_getsockopt", errno)
 	}
 	intval, ok := result.(wasi.IntValue)
 	if !ok {
@@ -193,7 +199,7 @@ func (m *Module) WasmEdgeSockGetOpt(ctx context.Context, fd Int32, level Int32,
 func (m *Module) WasmEdgeV1SockLocalAddr(ctx context.Context, fd Int32, addr Pointer[wasmEdgeAddress], addrType Pointer[Uint32], port Pointer[Uint32]) Errno {
 	sa, errno := m.WASI.SockLocalAddress(ctx, wasi.FD(fd))
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
+		return m.socketErrno("sock_getlocaladdr", errno)
 	}
 	portint, at, ok := m.wasmEdgeV1PutSocketAddress(addr.Load(), sa)
 	if !ok {
@@ -207,7 +213,7 @@ func (m *Module) WasmEdgeV1SockLocalAddr(ctx context.Context, fd Int32, addr Poi
 func (m *Module) WasmEdgeV2SockLocalAddr(ctx context.Context, fd Int32, addr Pointer[wasmEdgeAddress], port Pointer[Uint32]) Errno {
 	sa, errno := m.WASI.SockLocalAddress(ctx, wasi.FD(fd))
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
+		return m.socketErrno("sock_getlocaladdr", errno)
 	}
 	portint, ok := m.wasmEdgeV2PutSocketAddress(addr.Load(), sa)
 	if !ok {
@@ -220,7 +226,7 @@ func (m *Module) WasmEdgeV2SockLocalAddr(ctx context.Context, fd Int32, addr Poi
 func (m *Module) WasmEdgeV1SockPeerAddr(ctx context.Context, fd Int32, addr Pointer[wasmEdgeAddress], addrType Pointer[Uint32], port Pointer[Uint32]) Errno {
 	sa, errno := m.WASI.SockRemoteAddress(ctx, wasi.FD(fd))
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
+		return m.socketErrno("sock_getpeeraddr", errno)
 	}
 	portint, at, ok := m.wasmEdgeV1PutSocketAddress(addr.Load(), sa)
 	if !ok {
@@ -234,7 +240,7 @@ func (m *Module) WasmEdgeV1SockPeerAddr(ctx context.Context, fd Int32, addr Poin
 func (m *Module) WasmEdgeV2SockPeerAddr(ctx context.Context, fd Int32, addr Pointer[wasmEdgeAddress], port Pointer[Uint32]) Errno {
 	sa, errno := m.WASI.SockRemoteAddress(ctx, wasi.FD(fd))
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
+		return m.socketErrno("sock_getpeeraddr", errno)
 	}
 	portint, ok := m.wasmEdgeV2PutSocketAddress(addr.Load(), sa)
 	if !ok {
@@ -267,8 +273,12 @@ func (m *Module) WasmEdgeSockAddrInfo(ctx context.Context, name String, service
 	}
 	n, errno := m.WASI.SockAddressInfo(ctx, string(name), string(service), hints, m.addrinfo[:maxResLength])
 	if errno != wasi.ESUCCESS {
-		return Errno(errno)
-	} else if n == 0 {
+		return m.socketErrno("sock_getaddrinfo", errno)
+	}
+	// WasmEdge has no way to report that more results were available, they
+	// are truncated to the size of the guest buffer.
+	n = min(n, int(maxResLength))
+	if n == 0 {
 		resLengthPtr.Store(0)
 		return Errno(wasi.ESUCCESS)
 	}
@@ -294,16 +304,16 @@ func (m *Module) WasmEdgeSockAddrInfo(ctx context.Context, name String, service
 	for _, addrinfo := range m.addrinfo[:n] {
 		res := resPtr.Load()
 		if res.Address == 0 {
-			return Errno(wasi.EFAULT)
+			return m.fault("sock_getaddrinfo", mem, res.Address, 16)
 		}
 		res.AddressLength = 16 // sizeof(WasiSockaddr)
 		addrDataFamily, ok := mem.Read(res.Address, 1)
 		if !ok {
-			return Errno(wasi.EFAULT)
+			return m.fault("sock_getaddrinfo", mem, res.Address, 1)
 		}
 		addrDataLen, ok := mem.ReadUint32Le(res.Address + 4)
 		if !ok {
-			return Errno(wasi.EFAULT)
+			return m.fault("sock_getaddrinfo", mem, res.Address+4, 4)
 		}
 		// WasmEdge lies.
 		if addrDataLen == 14 {
@@ -311,16 +321,16 @@ func (m *Module) WasmEdgeSockAddrInfo(ctx context.Context, name String, service
 		}
 		addrDataPtr, ok := mem.ReadUint32Le(res.Address + 8)
 		if !ok {
-			return Errno(wasi.EFAULT)
+			return m.fault("sock_getaddrinfo", mem, res.Address+8, 4)
 		}
 		addrData, ok := mem.Read(addrDataPtr, addrDataLen)
 		if !ok {
-			return Errno(wasi.EFAULT)
+			return m.fault("sock_getaddrinfo", mem, addrDataPtr, addrDataLen)
 		}
 		switch addr := addrinfo.Address.(type) {
 		case *wasi.Inet4Address:
 			if len(addrData) < 6 {
-				return Errno(wasi.EFAULT)
+				return m.fault("sock_getaddrinfo", mem, addrDataPtr, 6)
 			}
 			binary.BigEndian.PutUint16(addrData, uint16(addr.Port))
 			copy(addrData[2:], addr.Addr[:])
@@ -328,7 +338,7 @@ func (m *Module) WasmEdgeSockAddrInfo(ctx context.Context, name String, service
 			// mem.WriteUint32Le(res.Address+4, 6) // WasmEdge writes 16?
 		case *wasi.Inet6Address:
 			if len(addrData) < 18 {
-				return Errno(wasi.EFAULT)
+				return m.fault("sock_getaddrinfo", mem, addrDataPtr, 18)
 			}
 			binary.BigEndian.PutUint16(addrData, uint16(addr.Port))
 			copy(addrData[2:], addr.Addr[:])
@@ -347,6 +357,13 @@ func (m *Module) WasmEdgeSockAddrInfo(ctx context.Context, name String, service
 	return Errno(wasi.ESUCCESS)
 }
 
+// wasmEdgeGetSocketAddress decodes addresses passed by the guest to
+// sock_bind, sock_connect and sock_send_to. Both versions of the extension
+// use the same function names and signatures, the layout is determined by
+// the size of the address buffer, like WasmEdge does: V1 addresses are the
+// 4 or 16 bytes of IPv4 or IPv6 addresses, V2 addresses are 128 bytes long
+// and start with a 16 bits little-endian address family followed by the
+// address data.
 func (m *Module) wasmEdgeGetSocketAddress(b wasmEdgeAddress, port int) (sa wasi.SocketAddress, ok bool) {
 	// V2 addresses.
 	if len(b) == 128 {
@@ -0,0 +1,183 @@
+package wasi_snapshot_preview1
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+func wasmEdgeV2Address(family wasi.ProtocolFamily, data []byte) wasmEdgeAddress {
+	b := make(wasmEdgeAddress, 128)
+	binary.LittleEndian.PutUint16(b, uint16(family))
+	copy(b[2:], data)
+	return b
+}
+
+func TestWasmEdgeGetSocketAddress(t *testing.T) {
+	tests := []struct {
+		scenario string
+		addr     wasmEdgeAddress
+		port     int
+		want     wasi.SocketAddress
+	}{
+		{
+			scenario: "v1 ipv4 address",
+			addr:     wasmEdgeAddress{127, 0, 0, 1},
+			port:     80,
+			want:     &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}, Port: 80},
+		},
+		{
+			scenario: "v1 ipv6 address",
+			addr:     wasmEdgeAddress{15: 1},
+			port:     443,
+			want:     &wasi.Inet6Address{Addr: [16]byte{15: 1}, Port: 443},
+		},
+		{
+			scenario: "v1 address of invalid length",
+			addr:     wasmEdgeAddress{127, 0, 0, 1, 0, 0, 0, 0},
+		},
+		{
+			scenario: "v2 ipv4 address",
+			addr:     wasmEdgeV2Address(wasi.InetFamily, []byte{10, 0, 0, 1}),
+			port:     8080,
+			want:     &wasi.Inet4Address{Addr: [4]byte{10, 0, 0, 1}, Port: 8080},
+		},
+		{
+			scenario: "v2 ipv6 address",
+			addr:     wasmEdgeV2Address(wasi.Inet6Family, []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}),
+			port:     53,
+			want:     &wasi.Inet6Address{Addr: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}, Port: 53},
+		},
+		{
+			scenario: "v2 unix address",
+			addr:     wasmEdgeV2Address(wasi.UnixFamily, []byte("/tmp/socket")),
+			want:     &wasi.UnixAddress{Name: "/tmp/socket"},
+		},
+		{
+			scenario: "v2 unix address without a null terminator",
+			addr:     wasmEdgeV2Address(wasi.UnixFamily, bytes.Repeat([]byte{'a'}, 126)),
+		},
+		{
+			scenario: "v2 address of unknown family",
+			addr:     wasmEdgeV2Address(42, []byte{127, 0, 0, 1}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			m := new(Module)
+			sa, ok := m.wasmEdgeGetSocketAddress(test.addr, test.port)
+			if test.want == nil {
+				if ok {
+					t.Fatalf("expected an invalid address, got %#v", sa)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("expected a valid address")
+			}
+			if !reflect.DeepEqual(sa, test.want) {
+				t.Errorf("wrong address: want %#v, got %#v", test.want, sa)
+			}
+		})
+	}
+}
+
+func TestWasmEdgeV1PutSocketAddress(t *testing.T) {
+	m := new(Module)
+
+	b := make(wasmEdgeAddress, 16)
+	port, addressType, ok := m.wasmEdgeV1PutSocketAddress(b, &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}, Port: 80})
+	if !ok || port != 80 || addressType != 4 {
+		t.Fatalf("wrong result for ipv4 address: %d, %d, %t", port, addressType, ok)
+	}
+	if !bytes.Equal(b[:4], []byte{127, 0, 0, 1}) {
+		t.Errorf("wrong ipv4 address bytes: %v", b[:4])
+	}
+
+	b = make(wasmEdgeAddress, 16)
+	port, addressType, ok = m.wasmEdgeV1PutSocketAddress(b, &wasi.Inet6Address{Addr: [16]byte{15: 1}, Port: 443})
+	if !ok || port != 443 || addressType != 6 {
+		t.Fatalf("wrong result for ipv6 address: %d, %d, %t", port, addressType, ok)
+	}
+	if !bytes.Equal(b, []byte{15: 1}) {
+		t.Errorf("wrong ipv6 address bytes: %v", b)
+	}
+
+	if _, _, ok := m.wasmEdgeV1PutSocketAddress(b, &wasi.UnixAddress{Name: "/tmp/socket"}); ok {
+		t.Error("unix addresses cannot be represented in v1 addresses")
+	}
+	if _, _, ok := m.wasmEdgeV1PutSocketAddress(make(wasmEdgeAddress, 128), &wasi.Inet6Address{}); ok {
+		t.Error("v1 addresses must be 16 bytes long")
+	}
+}
+
+func TestWasmEdgeV2PutSocketAddress(t *testing.T) {
+	tests := []struct {
+		scenario string
+		addr     wasi.SocketAddress
+		want     wasmEdgeAddress
+		port     int
+	}{
+		{
+			scenario: "ipv4 address",
+			addr:     &wasi.Inet4Address{Addr: [4]byte{10, 0, 0, 1}, Port: 8080},
+			want:     wasmEdgeV2Address(wasi.InetFamily, []byte{10, 0, 0, 1}),
+			port:     8080,
+		},
+		{
+			scenario: "ipv6 address",
+			addr:     &wasi.Inet6Address{Addr: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}, Port: 53},
+			want:     wasmEdgeV2Address(wasi.Inet6Family, []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}),
+			port:     53,
+		},
+		{
+			scenario: "unix address",
+			addr:     &wasi.UnixAddress{Name: "/tmp/socket"},
+			want:     wasmEdgeV2Address(wasi.UnixFamily, []byte("/tmp/socket")),
+		},
+		{
+			scenario: "unix address too long",
+			addr:     &wasi.UnixAddress{Name: strings.Repeat("a", 126)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			m := new(Module)
+			b := make(wasmEdgeAddress, 128)
+			port, ok := m.wasmEdgeV2PutSocketAddress(b, test.addr)
+			if test.want == nil {
+				if ok {
+					t.Fatal("expected the address to be rejected")
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("expected the address to be accepted")
+			}
+			if port != test.port {
+				t.Errorf("wrong port: want %d, got %d", test.port, port)
+			}
+			if !bytes.Equal(b, test.want) {
+				t.Errorf("wrong address bytes:\nwant %v\ngot  %v", test.want, b)
+			}
+
+			// Addresses written by the host decode back to the same value
+			// when passed to functions like sock_connect.
+			sa, ok := m.wasmEdgeGetSocketAddress(b, port)
+			if !ok || !reflect.DeepEqual(sa, test.addr) {
+				t.Errorf("address does not round-trip: want %#v, got %#v", test.addr, sa)
+			}
+		})
+	}
+
+	m := new(Module)
+	if _, ok := m.wasmEdgeV2PutSocketAddress(make(wasmEdgeAddress, 16), &wasi.Inet4Address{}); ok {
+		t.Error("v2 addresses must be 128 bytes long")
+	}
+}
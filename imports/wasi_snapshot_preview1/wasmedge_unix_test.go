@@ -0,0 +1,159 @@
+//go:build unix
+
+package wasi_snapshot_preview1
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/systems/unix"
+	. "github.com/stealthrocket/wazergo/types"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Offsets in the guest memory used by the tests of the WasmEdge socket
+// address functions.
+const (
+	wasmEdgeFDOffset       = 0
+	wasmEdgePortOffset     = 4
+	wasmEdgeAddrTypeOffset = 8
+	wasmEdgeAddrOffset     = 16  // struct { buf: u32, size: u32 }
+	wasmEdgeAddrBufOffset  = 128 // address data pointed to by buf
+)
+
+func TestWasmEdgeSockLocalAndPeerAddr(t *testing.T) {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	instance, err := runtime.Instantiate(ctx, memoryModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := instance.ExportedMemory("mem")
+
+	for _, version := range []string{"v1", "v2"} {
+		t.Run(version, func(t *testing.T) {
+			system := &unix.System{}
+			defer system.Close(ctx)
+			m := &Module{WASI: system}
+
+			// setAddress writes an address struct pointing to a buffer
+			// holding 127.0.0.1 in the layout of the extension version.
+			setAddress := func() Pointer[wasmEdgeAddress] {
+				var buf []byte
+				if version == "v1" {
+					buf = []byte{127, 0, 0, 1}
+				} else {
+					buf = make([]byte, 128)
+					binary.LittleEndian.PutUint16(buf, uint16(wasi.InetFamily))
+					copy(buf[2:], []byte{127, 0, 0, 1})
+				}
+				writeWasmEdgeAddress(t, memory, buf)
+				return Ptr[wasmEdgeAddress](memory, wasmEdgeAddrOffset)
+			}
+
+			// getAddress reads back an address with sock_getlocaladdr or
+			// sock_getpeeraddr, returning the IPv4 address and port.
+			getAddress := func(fd Int32, peer bool) (addr [4]byte, port int) {
+				t.Helper()
+				size := 128
+				if version == "v1" {
+					size = 16
+				}
+				writeWasmEdgeAddress(t, memory, make([]byte, size))
+				addrPtr := Ptr[wasmEdgeAddress](memory, wasmEdgeAddrOffset)
+				portPtr := Ptr[Uint32](memory, wasmEdgePortOffset)
+				addrTypePtr := Ptr[Uint32](memory, wasmEdgeAddrTypeOffset)
+
+				var errno Errno
+				switch {
+				case version == "v1" && peer:
+					errno = m.WasmEdgeV1SockPeerAddr(ctx, fd, addrPtr, addrTypePtr, portPtr)
+				case version == "v1":
+					errno = m.WasmEdgeV1SockLocalAddr(ctx, fd, addrPtr, addrTypePtr, portPtr)
+				case peer:
+					errno = m.WasmEdgeV2SockPeerAddr(ctx, fd, addrPtr, portPtr)
+				default:
+					errno = m.WasmEdgeV2SockLocalAddr(ctx, fd, addrPtr, portPtr)
+				}
+				if errno != Errno(wasi.ESUCCESS) {
+					t.Fatalf("reading socket address: %v", errno)
+				}
+
+				b, _ := memory.Read(wasmEdgeAddrBufOffset, uint32(size))
+				if version == "v1" {
+					if addrType := addrTypePtr.Load(); addrType != 4 {
+						t.Errorf("wrong address type: want 4, got %d", addrType)
+					}
+					copy(addr[:], b)
+				} else {
+					if family := binary.LittleEndian.Uint16(b); family != uint16(wasi.InetFamily) {
+						t.Errorf("wrong address family: want %d, got %d", wasi.InetFamily, family)
+					}
+					copy(addr[:], b[2:])
+				}
+				return addr, int(portPtr.Load())
+			}
+
+			sockOpen := func() Int32 {
+				t.Helper()
+				fdPtr := Ptr[Int32](memory, wasmEdgeFDOffset)
+				if errno := m.WasmEdgeSockOpen(ctx, Int32(wasi.InetFamily), Int32(wasi.StreamSocket), fdPtr); errno != Errno(wasi.ESUCCESS) {
+					t.Fatalf("sock_open: %v", errno)
+				}
+				return fdPtr.Load()
+			}
+
+			server := sockOpen()
+			if errno := m.WasmEdgeSockBind(ctx, server, setAddress(), 0); errno != Errno(wasi.ESUCCESS) {
+				t.Fatalf("sock_bind: %v", errno)
+			}
+			if errno := m.WasmEdgeSockListen(ctx, server, 1); errno != Errno(wasi.ESUCCESS) {
+				t.Fatalf("sock_listen: %v", errno)
+			}
+
+			addr, port := getAddress(server, false)
+			if addr != [4]byte{127, 0, 0, 1} {
+				t.Errorf("wrong local address: %v", addr)
+			}
+			if port == 0 {
+				t.Fatal("the port assigned when binding to port 0 was not returned")
+			}
+			sa, errno := system.SockLocalAddress(ctx, wasi.FD(server))
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if want := sa.(*wasi.Inet4Address).Port; port != want {
+				t.Errorf("wrong local port: want %d, got %d", want, port)
+			}
+
+			client := sockOpen()
+			if errno := m.WasmEdgeSockConnect(ctx, client, setAddress(), Uint32(port)); errno != Errno(wasi.ESUCCESS) {
+				t.Fatalf("sock_connect: %v", errno)
+			}
+			addr, peerPort := getAddress(client, true)
+			if addr != [4]byte{127, 0, 0, 1} {
+				t.Errorf("wrong peer address: %v", addr)
+			}
+			if peerPort != port {
+				t.Errorf("wrong peer port: want %d, got %d", port, peerPort)
+			}
+		})
+	}
+}
+
+func writeWasmEdgeAddress(t *testing.T, memory api.Memory, buf []byte) {
+	t.Helper()
+	if !memory.Write(wasmEdgeAddrBufOffset, buf) {
+		t.Fatal("writing address buffer out of memory bounds")
+	}
+	if !memory.WriteUint32Le(wasmEdgeAddrOffset, wasmEdgeAddrBufOffset) ||
+		!memory.WriteUint32Le(wasmEdgeAddrOffset+4, uint32(len(buf))) {
+		t.Fatal("writing address struct out of memory bounds")
+	}
+}
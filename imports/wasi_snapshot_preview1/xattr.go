@@ -0,0 +1,44 @@
+package wasi_snapshot_preview1
+
+import (
+	"context"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wazergo"
+	. "github.com/stealthrocket/wazergo/types"
+)
+
+// Xattr is an extension to WASI preview 1 adding functions to read and write
+// the extended attributes of files and directories. The directory file
+// descriptor must have wasi.PathXattrRight, which is not granted by default:
+//
+//	path_getxattr(fd: fd, flags: lookupflags, path: string, name: string, buf: *u8, buf_len: size, nread: *size) -> errno
+//	path_listxattr(fd: fd, flags: lookupflags, path: string, buf: *u8, buf_len: size, nread: *size) -> errno
+//	path_setxattr(fd: fd, flags: lookupflags, path: string, name: string, value: *u8, value_len: size) -> errno
+var Xattr = Extension{
+	"path_getxattr":  wazergo.F6((*Module).PathGetXattr),
+	"path_listxattr": wazergo.F5((*Module).PathListXattr),
+	"path_setxattr":  wazergo.F5((*Module).PathSetXattr),
+}
+
+func (m *Module) PathGetXattr(ctx context.Context, fd Int32, lookupFlags Int32, path, name String, buf Bytes, nread Pointer[Int32]) Errno {
+	n, errno := m.WASI.PathGetXattr(ctx, wasi.FD(fd), wasi.LookupFlags(lookupFlags), string(path), string(name), buf)
+	if errno != wasi.ESUCCESS {
+		return Errno(errno)
+	}
+	nread.Store(Int32(n))
+	return Errno(wasi.ESUCCESS)
+}
+
+func (m *Module) PathListXattr(ctx context.Context, fd Int32, lookupFlags Int32, path String, buf Bytes, nread Pointer[Int32]) Errno {
+	n, errno := m.WASI.PathListXattr(ctx, wasi.FD(fd), wasi.LookupFlags(lookupFlags), string(path), buf)
+	if errno != wasi.ESUCCESS {
+		return Errno(errno)
+	}
+	nread.Store(Int32(n))
+	return Errno(wasi.ESUCCESS)
+}
+
+func (m *Module) PathSetXattr(ctx context.Context, fd Int32, lookupFlags Int32, path, name String, value Bytes) Errno {
+	return Errno(m.WASI.PathSetXattr(ctx, wasi.FD(fd), wasi.LookupFlags(lookupFlags), string(path), string(name), value))
+}
@@ -0,0 +1,152 @@
+package wasi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+)
+
+// Inspect wraps a System to record the paths and socket addresses that the
+// guest attempts to access, without granting access to any of them. Path
+// operations, SockConnect, SockSendTo and SockBind fail with ENOTCAPABLE, and
+// the list of paths and addresses is written to the given io.Writer when the
+// system is closed.
+//
+// Paths are only observed if the guest can resolve them to a preopened
+// directory; since all path operations are denied, it is safe to preopen
+// the root directory to observe all the paths that the guest accesses.
+func Inspect(w io.Writer, s System) System {
+	return &inspection{
+		System:   s,
+		writer:   w,
+		paths:    make(map[string]struct{}),
+		connects: make(map[string]struct{}),
+		binds:    make(map[string]struct{}),
+	}
+}
+
+type inspection struct {
+	System
+	writer   io.Writer
+	paths    map[string]struct{}
+	connects map[string]struct{}
+	binds    map[string]struct{}
+	closed   bool
+}
+
+func (s *inspection) recordPath(ctx context.Context, fd FD, name string) Errno {
+	if !path.IsAbs(name) {
+		if dir, errno := s.System.FDPreStatDirName(ctx, fd); errno == ESUCCESS {
+			name = path.Join(dir, name)
+		}
+	}
+	s.paths[name] = struct{}{}
+	return ENOTCAPABLE
+}
+
+func (s *inspection) PathCreateDirectory(ctx context.Context, fd FD, path string) Errno {
+	return s.recordPath(ctx, fd, path)
+}
+
+func (s *inspection) PathFileStatGet(ctx context.Context, fd FD, lookupFlags LookupFlags, path string) (FileStat, Errno) {
+	return FileStat{}, s.recordPath(ctx, fd, path)
+}
+
+func (s *inspection) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	return s.recordPath(ctx, fd, path)
+}
+
+func (s *inspection) PathGetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, buffer []byte) (int, Errno) {
+	return 0, s.recordPath(ctx, fd, path)
+}
+
+func (s *inspection) PathListXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, buffer []byte) (int, Errno) {
+	return 0, s.recordPath(ctx, fd, path)
+}
+
+func (s *inspection) PathSetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, value []byte) Errno {
+	return s.recordPath(ctx, fd, path)
+}
+
+func (s *inspection) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
+	s.recordPath(ctx, oldFD, oldPath)
+	return s.recordPath(ctx, newFD, newPath)
+}
+
+func (s *inspection) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
+	return -1, s.recordPath(ctx, fd, path)
+}
+
+func (s *inspection) PathReadLink(ctx context.Context, fd FD, path string, buffer []byte) (int, Errno) {
+	return 0, s.recordPath(ctx, fd, path)
+}
+
+func (s *inspection) PathRemoveDirectory(ctx context.Context, fd FD, path string) Errno {
+	return s.recordPath(ctx, fd, path)
+}
+
+func (s *inspection) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD, newPath string) Errno {
+	s.recordPath(ctx, fd, oldPath)
+	return s.recordPath(ctx, newFD, newPath)
+}
+
+func (s *inspection) PathSymlink(ctx context.Context, oldPath string, fd FD, newPath string) Errno {
+	return s.recordPath(ctx, fd, newPath)
+}
+
+func (s *inspection) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
+	return s.recordPath(ctx, fd, path)
+}
+
+func (s *inspection) SockBind(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
+	s.binds[addr.String()] = struct{}{}
+	return nil, ENOTCAPABLE
+}
+
+func (s *inspection) SockConnect(ctx context.Context, fd FD, peer SocketAddress) (SocketAddress, Errno) {
+	s.connects[peer.String()] = struct{}{}
+	return nil, ENOTCAPABLE
+}
+
+func (s *inspection) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags, addr SocketAddress) (Size, Errno) {
+	s.connects[addr.String()] = struct{}{}
+	return 0, ENOTCAPABLE
+}
+
+func (s *inspection) Close(ctx context.Context) error {
+	err := s.System.Close(ctx)
+	// The system may be closed multiple times (e.g. by the host module and
+	// the application), the report is only written once.
+	if !s.closed {
+		s.closed = true
+		s.writeReport()
+	}
+	return err
+}
+
+func (s *inspection) writeReport() {
+	for _, section := range []struct {
+		name    string
+		entries map[string]struct{}
+	}{
+		{"paths", s.paths},
+		{"connect", s.connects},
+		{"bind", s.binds},
+	} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		entries := make([]string, 0, len(section.entries))
+		for entry := range section.entries {
+			entries = append(entries, entry)
+		}
+		sort.Strings(entries)
+
+		fmt.Fprintf(s.writer, "%s:\n", section.name)
+		for _, entry := range entries {
+			fmt.Fprintf(s.writer, "  %s\n", entry)
+		}
+	}
+}
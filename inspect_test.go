@@ -0,0 +1,79 @@
+package wasi_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+func TestInspect(t *testing.T) {
+	ctx := context.Background()
+
+	var report strings.Builder
+	base := newFakeSystem()
+	base.preopen(3, "/data")
+	s := wasi.Inspect(&report, base)
+
+	if _, errno := s.PathOpen(ctx, 3, 0, "config.json", 0, wasi.FDReadRight, 0, 0); errno != wasi.ENOTCAPABLE {
+		t.Errorf("path_open: want ENOTCAPABLE, got %s", errno)
+	}
+	if _, errno := s.PathOpen(ctx, 3, 0, "../etc/hosts", 0, wasi.FDReadRight, 0, 0); errno != wasi.ENOTCAPABLE {
+		t.Errorf("path_open: want ENOTCAPABLE, got %s", errno)
+	}
+	if _, errno := s.PathFileStatGet(ctx, 3, 0, "config.json"); errno != wasi.ENOTCAPABLE {
+		t.Errorf("path_filestat_get: want ENOTCAPABLE, got %s", errno)
+	}
+	if errno := s.PathRename(ctx, 3, "a", 3, "b"); errno != wasi.ENOTCAPABLE {
+		t.Errorf("path_rename: want ENOTCAPABLE, got %s", errno)
+	}
+	if errno := s.PathUnlinkFile(ctx, 42, "tmp/file"); errno != wasi.ENOTCAPABLE {
+		t.Errorf("path_unlink_file: want ENOTCAPABLE, got %s", errno)
+	}
+
+	remote := &wasi.Inet4Address{Addr: [4]byte{93, 184, 216, 34}, Port: 443}
+	if _, errno := s.SockConnect(ctx, 4, remote); errno != wasi.ENOTCAPABLE {
+		t.Errorf("sock_connect: want ENOTCAPABLE, got %s", errno)
+	}
+	if _, errno := s.SockConnect(ctx, 5, remote); errno != wasi.ENOTCAPABLE {
+		t.Errorf("sock_connect: want ENOTCAPABLE, got %s", errno)
+	}
+	dns := &wasi.Inet6Address{Addr: [16]byte{15: 1}, Port: 53}
+	if _, errno := s.SockSendTo(ctx, 6, []wasi.IOVec{[]byte("query")}, 0, dns); errno != wasi.ENOTCAPABLE {
+		t.Errorf("sock_send_to: want ENOTCAPABLE, got %s", errno)
+	}
+	if _, errno := s.SockBind(ctx, 7, &wasi.Inet4Address{Port: 8080}); errno != wasi.ENOTCAPABLE {
+		t.Errorf("sock_bind: want ENOTCAPABLE, got %s", errno)
+	}
+
+	if report.Len() != 0 {
+		t.Errorf("report written before the system was closed: %q", report.String())
+	}
+
+	// The report is written once even if the system is closed twice.
+	for i := 0; i < 2; i++ {
+		if err := s.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if base.closed != 2 {
+		t.Errorf("wrong number of calls to close the underlying system: want 2, got %d", base.closed)
+	}
+
+	const want = `paths:
+  /data/a
+  /data/b
+  /data/config.json
+  /etc/hosts
+  tmp/file
+connect:
+  93.184.216.34:443
+  [::1]:53
+bind:
+  0.0.0.0:8080
+`
+	if got := report.String(); got != want {
+		t.Errorf("wrong report:\nwant:\n%s\ngot:\n%s", want, got)
+	}
+}
@@ -68,6 +68,38 @@ func (s *Subscription) GetClock() SubscriptionClock {
 type SubscriptionFDReadWrite struct {
 	// FD is the file descriptor to wait on.
 	FD FD
+
+	// Flags request additional notifications about the file descriptor.
+	//
+	// This field is an extension to WASI preview 1, where the subscription
+	// only contains the file descriptor. Guests that are not aware of it
+	// leave it zero.
+	Flags SubscriptionFDReadWriteFlags
+}
+
+// SubscriptionFDReadWriteFlags are flags of SubscriptionFDReadWrite.
+type SubscriptionFDReadWriteFlags uint16
+
+const (
+	// PollPriority requests that FDReadEvent subscriptions be notified when
+	// priority data is available on the file descriptor (e.g. out-of-band
+	// data on TCP sockets), which is reported with the Priority flag of the
+	// event.
+	PollPriority SubscriptionFDReadWriteFlags = 1 << iota
+)
+
+// Has is true if the flag is set.
+func (flags SubscriptionFDReadWriteFlags) Has(f SubscriptionFDReadWriteFlags) bool {
+	return (flags & f) == f
+}
+
+func (flags SubscriptionFDReadWriteFlags) String() string {
+	switch flags {
+	case PollPriority:
+		return "PollPriority"
+	default:
+		return fmt.Sprintf("SubscriptionFDReadWriteFlags(%d)", flags)
+	}
 }
 
 // SubscriptionClock is the contents of a subscription when event type is
@@ -180,6 +212,11 @@ const (
 	// Hangup is a flag that indicates that the peer of this socket
 	// has closed or disconnected.
 	Hangup EventFDReadWriteFlags = 1 << iota
+
+	// Priority is a flag that indicates that priority data is available on
+	// the file descriptor. It is only reported to subscriptions with the
+	// PollPriority flag.
+	Priority
 )
 
 // Has is true if the flag is set.
@@ -187,11 +224,23 @@ func (flags EventFDReadWriteFlags) Has(f EventFDReadWriteFlags) bool {
 	return (flags & f) == f
 }
 
-func (flags EventFDReadWriteFlags) String() string {
-	switch flags {
-	case Hangup:
-		return "Hangup"
-	default:
+var eventFDReadWriteFlagsStrings = [...]string{
+	"Hangup",
+	"Priority",
+}
+
+func (flags EventFDReadWriteFlags) String() (s string) {
+	for i, name := range eventFDReadWriteFlagsStrings {
+		if !flags.Has(1 << i) {
+			continue
+		}
+		if len(s) > 0 {
+			s += "|"
+		}
+		s += name
+	}
+	if len(s) == 0 {
 		return fmt.Sprintf("EventFDReadWriteFlags(%d)", flags)
 	}
+	return
 }
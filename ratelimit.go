@@ -0,0 +1,236 @@
+package wasi
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RateDirection is the direction of a data transfer accounted by a
+// RateLimiter.
+type RateDirection uint8
+
+const (
+	// RateRead accounts data read by the guest with FDRead or SockRecv.
+	RateRead RateDirection = iota
+
+	// RateWrite accounts data written by the guest with FDWrite or SockSend.
+	RateWrite
+)
+
+func (d RateDirection) String() string {
+	switch d {
+	case RateRead:
+		return "RateRead"
+	case RateWrite:
+		return "RateWrite"
+	default:
+		return fmt.Sprintf("RateDirection(%d)", d)
+	}
+}
+
+// RateLimiter is an interface used to throttle the rate at which a guest
+// transfers data on its file descriptors.
+type RateLimiter interface {
+	// Limit is called after size bytes were transferred on fd in the given
+	// direction, and returns how long the guest must wait before the next
+	// transfer in that direction. A zero or negative delay does not throttle
+	// the file descriptor.
+	Limit(fd FD, direction RateDirection, size int) time.Duration
+}
+
+// RateLimited wraps a System to throttle calls to FDRead, FDWrite, SockRecv
+// and SockSend according to the delays returned by the given RateLimiter.
+//
+// When a file descriptor is throttled, the next transfer in the same direction
+// blocks until the delay expired if the file descriptor is in blocking mode,
+// or fails with EAGAIN in non-blocking mode. Subscriptions to throttled file
+// descriptors passed to PollOneOff do not complete until the delay expired.
+func RateLimited(system System, limiter RateLimiter) System {
+	return &rateLimited{
+		System:  system,
+		limiter: limiter,
+		until:   make(map[rateKey]time.Time),
+	}
+}
+
+type rateKey struct {
+	fd        FD
+	direction RateDirection
+}
+
+type rateLimited struct {
+	System
+	limiter RateLimiter
+	until   map[rateKey]time.Time
+}
+
+// wait enforces the delay of throttled file descriptors before a transfer.
+func (s *rateLimited) wait(ctx context.Context, fd FD, direction RateDirection) Errno {
+	key := rateKey{fd, direction}
+	until, ok := s.until[key]
+	if !ok {
+		return ESUCCESS
+	}
+	delay := time.Until(until)
+	if delay <= 0 {
+		delete(s.until, key)
+		return ESUCCESS
+	}
+	stat, errno := s.System.FDStatGet(ctx, fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	if stat.Flags.Has(NonBlock) {
+		return EAGAIN
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return makeErrno(ctx.Err())
+	}
+	delete(s.until, key)
+	return ESUCCESS
+}
+
+// account charges the bytes transferred to the rate limiter.
+func (s *rateLimited) account(fd FD, direction RateDirection, size Size, errno Errno) {
+	if errno != ESUCCESS || size == 0 {
+		return
+	}
+	if delay := s.limiter.Limit(fd, direction, int(size)); delay > 0 {
+		s.until[rateKey{fd, direction}] = time.Now().Add(delay)
+	}
+}
+
+func (s *rateLimited) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	if errno := s.wait(ctx, fd, RateRead); errno != ESUCCESS {
+		return ^Size(0), errno
+	}
+	n, errno := s.System.FDRead(ctx, fd, iovecs)
+	s.account(fd, RateRead, n, errno)
+	return n, errno
+}
+
+func (s *rateLimited) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	if errno := s.wait(ctx, fd, RateWrite); errno != ESUCCESS {
+		return ^Size(0), errno
+	}
+	n, errno := s.System.FDWrite(ctx, fd, iovecs)
+	s.account(fd, RateWrite, n, errno)
+	return n, errno
+}
+
+func (s *rateLimited) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, Errno) {
+	if errno := s.wait(ctx, fd, RateRead); errno != ESUCCESS {
+		return ^Size(0), 0, errno
+	}
+	n, roflags, errno := s.System.SockRecv(ctx, fd, iovecs, flags)
+	if !flags.Has(RecvPeek) {
+		s.account(fd, RateRead, n, errno)
+	}
+	return n, roflags, errno
+}
+
+func (s *rateLimited) SockSend(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags) (Size, Errno) {
+	if errno := s.wait(ctx, fd, RateWrite); errno != ESUCCESS {
+		return ^Size(0), errno
+	}
+	n, errno := s.System.SockSend(ctx, fd, iovecs, flags)
+	s.account(fd, RateWrite, n, errno)
+	return n, errno
+}
+
+func (s *rateLimited) FDClose(ctx context.Context, fd FD) Errno {
+	delete(s.until, rateKey{fd, RateRead})
+	delete(s.until, rateKey{fd, RateWrite})
+	return s.System.FDClose(ctx, fd)
+}
+
+func (s *rateLimited) PollOneOff(ctx context.Context, subscriptions []Subscription, events []Event) (int, Errno) {
+	// Subscriptions to throttled file descriptors are replaced by clock
+	// subscriptions expiring when the file descriptors can be used again,
+	// which are then reported as the original event types.
+	var throttled map[UserData]EventType
+	var subs []Subscription
+	now := time.Now()
+	for i := range subscriptions {
+		sub := &subscriptions[i]
+		if sub.EventType != FDReadEvent && sub.EventType != FDWriteEvent {
+			continue
+		}
+		direction := RateRead
+		if sub.EventType == FDWriteEvent {
+			direction = RateWrite
+		}
+		until, ok := s.until[rateKey{sub.GetFDReadWrite().FD, direction}]
+		if !ok || !until.After(now) {
+			continue
+		}
+		if subs == nil {
+			subs = append([]Subscription{}, subscriptions...)
+			throttled = make(map[UserData]EventType)
+		}
+		subs[i] = MakeSubscriptionClock(sub.UserData, SubscriptionClock{
+			ID:      Monotonic,
+			Timeout: Timestamp(until.Sub(now)),
+		})
+		throttled[sub.UserData] = sub.EventType
+	}
+	if subs == nil {
+		return s.System.PollOneOff(ctx, subscriptions, events)
+	}
+	n, errno := s.System.PollOneOff(ctx, subs, events)
+	for i := range events[:n] {
+		e := &events[i]
+		if eventType, ok := throttled[e.UserData]; ok && e.EventType == ClockEvent {
+			e.EventType = eventType
+		}
+	}
+	return n, errno
+}
+
+// TokenBucket returns a RateLimiter allowing a sustained transfer rate on
+// the file descriptors present in rates, expressed in bytes per second.
+// Reads and writes are limited independently, each of them with a burst
+// size of one second worth of data. File descriptors absent from rates are
+// not limited.
+func TokenBucket(rates map[FD]int) RateLimiter {
+	return &tokenBucket{
+		rates:   rates,
+		buckets: make(map[rateKey]*bucket),
+	}
+}
+
+type tokenBucket struct {
+	rates   map[FD]int
+	buckets map[rateKey]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	time   time.Time
+}
+
+func (t *tokenBucket) Limit(fd FD, direction RateDirection, size int) time.Duration {
+	rate := float64(t.rates[fd])
+	if rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	key := rateKey{fd, direction}
+	b := t.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: rate, time: now}
+		t.buckets[key] = b
+	}
+	b.tokens = min(b.tokens+rate*now.Sub(b.time).Seconds(), rate)
+	b.tokens -= float64(size)
+	b.time = now
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
@@ -0,0 +1,109 @@
+package wasi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+// newTransferSystem returns a fakeSystem with a blocking socket open on fd 3,
+// and a non-blocking socket on fd 4.
+func newTransferSystem() *fakeSystem {
+	s := newFakeSystem()
+	s.open(3, wasi.SocketStreamType, "")
+	s.open(4, wasi.SocketStreamType, "").stat.Flags = wasi.NonBlock
+	return s
+}
+
+func TestRateLimited(t *testing.T) {
+	ctx := context.Background()
+	const rate = 256 * 1024
+	buf := make([]byte, 16*1024)
+
+	t.Run("throughput is capped on blocking file descriptors", func(t *testing.T) {
+		s := wasi.RateLimited(newTransferSystem(), wasi.TokenBucket(map[wasi.FD]int{
+			3: rate,
+		}))
+
+		// The first second worth of data is transferred immediately, the
+		// rest at the configured rate.
+		const size = rate + rate/2
+		start := time.Now()
+		for n := 0; n < size; n += len(buf) {
+			if _, errno := s.FDWrite(ctx, 3, []wasi.IOVec{buf}); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+		}
+		// The next call blocks until the data already written was paid
+		// for.
+		if _, errno := s.FDWrite(ctx, 3, []wasi.IOVec{buf[:0]}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		elapsed := time.Since(start)
+
+		const want = 500 * time.Millisecond
+		if elapsed < want-want/10 || elapsed > want+want/2 {
+			t.Errorf("throughput not capped: wrote %d bytes in %s, want ~%s", size, elapsed, want)
+		}
+
+		// Reads are limited independently of writes.
+		start = time.Now()
+		if _, errno := s.FDRead(ctx, 3, []wasi.IOVec{buf}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+			t.Errorf("read was throttled by writes: %s", elapsed)
+		}
+	})
+
+	t.Run("non-blocking file descriptors return EAGAIN when throttled", func(t *testing.T) {
+		s := wasi.RateLimited(newTransferSystem(), wasi.TokenBucket(map[wasi.FD]int{
+			4: 4 * len(buf),
+		}))
+
+		// Exceed the burst size by a quarter of a second worth of data.
+		iovecs := []wasi.IOVec{buf, buf, buf, buf, buf}
+		if _, errno := s.FDWrite(ctx, 4, iovecs); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		size, errno := s.FDWrite(ctx, 4, []wasi.IOVec{buf})
+		if size != ^wasi.Size(0) || errno != wasi.EAGAIN {
+			t.Fatalf("wrong result: want -1/EAGAIN, got %d/%s", int32(size), errno)
+		}
+
+		// Polling waits until the file descriptor can be written again.
+		subscriptions := []wasi.Subscription{
+			wasi.MakeSubscriptionFDReadWrite(42, wasi.FDWriteEvent, wasi.SubscriptionFDReadWrite{FD: 4}),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+		start := time.Now()
+		n, errno := s.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if n != 1 || events[0].UserData != 42 || events[0].EventType != wasi.FDWriteEvent {
+			t.Fatalf("wrong events: %+v", events[:n])
+		}
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Errorf("poll returned before the file descriptor was writable: %s", elapsed)
+		}
+		if subscriptions[0].EventType != wasi.FDWriteEvent {
+			t.Error("poll_oneoff: altered subscriptions")
+		}
+
+		if _, errno := s.FDWrite(ctx, 4, []wasi.IOVec{buf}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+	})
+
+	t.Run("file descriptors without a rate are not limited", func(t *testing.T) {
+		s := wasi.RateLimited(newTransferSystem(), wasi.TokenBucket(nil))
+		for i := 0; i < 1000; i++ {
+			if _, errno := s.FDWrite(ctx, 4, []wasi.IOVec{buf}); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+		}
+	})
+}
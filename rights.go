@@ -1,6 +1,9 @@
 package wasi
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Rights are file descriptor rights, determining which actions may be performed.
 type Rights uint64
@@ -127,6 +130,14 @@ const (
 	// SockAccessRight is the right to invoke SockAccept
 	SockAcceptRight
 
+	// PathXattrRight is the right to invoke PathGetXattr, PathListXattr and
+	// PathSetXattr.
+	//
+	// This right is not part of WASI preview 1 and is not included in
+	// AllRights; it must be granted explicitly to preopened directories and
+	// is not inherited by the files that the guest opens.
+	PathXattrRight
+
 	// AllRights is the set of all available rights
 	AllRights Rights = (1 << 30) - 1
 
@@ -201,6 +212,7 @@ var rightsStrings = [...]string{
 	"PollFDReadWriteRight",
 	"SockShutdownRight",
 	"SockAcceptRight",
+	"PathXattrRight",
 }
 
 func (flags Rights) String() (s string) {
@@ -224,17 +236,21 @@ func (flags Rights) String() (s string) {
 	case flags == SockConnectionRights|SockListenRights:
 		return "SockConnectionRights|SockListenRights"
 	}
-	for i, name := range rightsStrings {
-		if !flags.Has(1 << i) {
-			continue
-		}
-		if len(s) > 0 {
-			s += "|"
-		}
-		s += name
-	}
+	s = strings.Join(flags.Names(), "|")
 	if len(s) == 0 {
 		return fmt.Sprintf("Rights(%d)", flags)
 	}
 	return
 }
+
+// Names returns the names of the individual rights set in flags, in the order
+// of their bit positions.
+func (flags Rights) Names() []string {
+	var names []string
+	for i, name := range rightsStrings {
+		if flags.Has(1 << i) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -20,6 +20,16 @@ const (
 	// RecvWaitAll indicates that on byte-stream sockets, SockRecv should block
 	// until the full amount of data can be returned.
 	RecvWaitAll
+
+	// RecvDontWait indicates that SockRecv should not block if no data is
+	// available, even if the socket is in blocking mode. This flag is an
+	// extension to WASI preview 1.
+	RecvDontWait
+
+	// RecvOOB indicates that SockRecv should receive out-of-band data which
+	// would not be received in the normal data stream (e.g. the urgent byte
+	// of TCP sockets). This flag is an extension to WASI preview 1.
+	RecvOOB
 )
 
 // Has is true if the flag is set.
@@ -30,6 +40,8 @@ func (flags RIFlags) Has(f RIFlags) bool {
 var riflagsStrings = [...]string{
 	"RecvPeek",
 	"RecvWaitAll",
+	"RecvDontWait",
+	"RecvOOB",
 }
 
 func (flags RIFlags) String() (s string) {
@@ -75,16 +87,46 @@ func (flags ROFlags) String() string {
 
 // SIFlags are flags provided to SockSend.
 //
-// As there are currently no flags defined, it must be set to zero.
+// WASI preview 1 does not define any flags, the flags below are extensions.
 type SIFlags uint16
 
+const (
+	// SendDontWait indicates that SockSend should not block if the data
+	// cannot be sent immediately, even if the socket is in blocking mode.
+	SendDontWait SIFlags = 1 << iota
+
+	// SendOOB indicates that SockSend should send the data out-of-band on
+	// sockets that support it (e.g. as urgent data on TCP sockets).
+	SendOOB
+)
+
 // Has is true if the flag is set.
 func (flags SIFlags) Has(f SIFlags) bool {
 	return (flags & f) == f
 }
 
-func (flags SIFlags) String() string {
-	return fmt.Sprintf("SIFlags(%d)", flags)
+var siflagsStrings = [...]string{
+	"SendDontWait",
+	"SendOOB",
+}
+
+func (flags SIFlags) String() (s string) {
+	if flags == 0 {
+		return "SIFlags(0)"
+	}
+	for i, name := range siflagsStrings {
+		if !flags.Has(1 << i) {
+			continue
+		}
+		if len(s) > 0 {
+			s += "|"
+		}
+		s += name
+	}
+	if len(s) == 0 {
+		return fmt.Sprintf("SIFlags(%d)", flags)
+	}
+	return
 }
 
 // SDFlags are flags provided to SockShutdown which indicate which channels
@@ -276,6 +318,8 @@ const (
 	IPProtocol Protocol = iota
 	TCPProtocol
 	UDPProtocol
+	ICMPProtocol
+	ICMPv6Protocol
 )
 
 func (p Protocol) String() string {
@@ -286,6 +330,10 @@ func (p Protocol) String() string {
 		return "TCPProtocol"
 	case UDPProtocol:
 		return "UDPProtocol"
+	case ICMPProtocol:
+		return "ICMPProtocol"
+	case ICMPv6Protocol:
+		return "ICMPv6Protocol"
 	default:
 		return fmt.Sprintf("Protocol(%d)", p)
 	}
@@ -298,6 +346,7 @@ const (
 	AnySocket SocketType = iota
 	DatagramSocket
 	StreamSocket
+	RawSocket
 )
 
 func (st SocketType) String() string {
@@ -308,6 +357,8 @@ func (st SocketType) String() string {
 		return "DatagramSocket"
 	case StreamSocket:
 		return "StreamSocket"
+	case RawSocket:
+		return "RawSocket"
 	default:
 		return fmt.Sprintf("SocketType(%d)", st)
 	}
@@ -318,16 +369,22 @@ func (st SocketType) String() string {
 type SocketOptionLevel int32
 
 const (
-	SocketLevel SocketOptionLevel = 0 // SOL_SOCKET
-	TcpLevel    SocketOptionLevel = 6 // IPPROTO_TCP
+	SocketLevel SocketOptionLevel = 0  // SOL_SOCKET
+	IPLevel     SocketOptionLevel = 4  // IPPROTO_IPV4, IPPROTO_IP is zero like SOL_SOCKET
+	TcpLevel    SocketOptionLevel = 6  // IPPROTO_TCP
+	IPv6Level   SocketOptionLevel = 41 // IPPROTO_IPV6
 )
 
 func (sl SocketOptionLevel) String() string {
 	switch sl {
 	case SocketLevel:
 		return "SocketLevel"
+	case IPLevel:
+		return "IPLevel"
 	case TcpLevel:
 		return "TcpLevel"
+	case IPv6Level:
+		return "IPv6Level"
 	default:
 		return fmt.Sprintf("SocketOptionLevel(%d)", sl)
 	}
@@ -366,6 +423,35 @@ const (
 // IPPROTO_TCP level options
 const (
 	TcpNoDelay SocketOption = (SocketOption(TcpLevel) << 32) | (15)
+
+	// TcpCork holds partial segments while it is set, batching small writes
+	// until it is cleared, which flushes pending data. It maps to TCP_CORK
+	// on Linux and TCP_NOPUSH on BSD systems; systems which do not support
+	// it return ENOPROTOOPT.
+	TcpCork SocketOption = (SocketOption(TcpLevel) << 32) | (18)
+
+	// TcpFastOpen enables TCP Fast Open on a listening socket, accepting data
+	// carried in the SYN of incoming connections. It must be set before the
+	// socket starts listening; on Linux the value is the maximum number of
+	// pending fast open requests.
+	TcpFastOpen SocketOption = (SocketOption(TcpLevel) << 32) | (19)
+
+	// TcpFastOpenConnect defers connecting the socket until data is first
+	// sent, so the data can be carried in the SYN with TCP Fast Open. It maps
+	// to TCP_FASTOPEN_CONNECT on Linux; systems which do not support it
+	// return ENOPROTOOPT. When it is enabled, sock_send_to on a stream socket
+	// which is not connected connects it and carries the data in the SYN.
+	TcpFastOpenConnect SocketOption = (SocketOption(TcpLevel) << 32) | (20)
+)
+
+// IPPROTO_IP level options
+const (
+	IPTypeOfService SocketOption = (SocketOption(IPLevel) << 32) | (16)
+)
+
+// IPPROTO_IPV6 level options
+const (
+	IPv6TrafficClass SocketOption = (SocketOption(IPv6Level) << 32) | (17)
 )
 
 func (so SocketOption) String() string {
@@ -402,6 +488,16 @@ func (so SocketOption) String() string {
 		return "BindToDevice"
 	case TcpNoDelay:
 		return "TcpNoDelay"
+	case TcpCork:
+		return "TcpCork"
+	case TcpFastOpen:
+		return "TcpFastOpen"
+	case TcpFastOpenConnect:
+		return "TcpFastOpenConnect"
+	case IPTypeOfService:
+		return "IPTypeOfService"
+	case IPv6TrafficClass:
+		return "IPv6TrafficClass"
 	default:
 		return fmt.Sprintf("SocketOption(%d|%d)", so.Level(), int32(so))
 	}
@@ -545,11 +641,11 @@ func (SocketsNotSupported) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOV
 	return 0, 0, nil, ENOSYS
 }
 
-func (SocketsNotSupported) SockGetOpt(ctx context.Context, fd FD, level SocketOptionLevel, option SocketOption) (SocketOptionValue, Errno) {
+func (SocketsNotSupported) SockGetOpt(ctx context.Context, fd FD, option SocketOption) (SocketOptionValue, Errno) {
 	return nil, ENOSYS
 }
 
-func (SocketsNotSupported) SockSetOpt(ctx context.Context, fd FD, level SocketOptionLevel, option SocketOption, value SocketOptionValue) Errno {
+func (SocketsNotSupported) SockSetOpt(ctx context.Context, fd FD, option SocketOption, value SocketOptionValue) Errno {
 	return ENOSYS
 }
 
@@ -565,6 +661,14 @@ func (SocketsNotSupported) SockAddressInfo(ctx context.Context, name, service st
 	return 0, ENOSYS
 }
 
+func (SocketsNotSupported) SockSplice(ctx context.Context, inFD, outFD FD, maxBytes Size) (Size, Errno) {
+	return 0, ENOSYS
+}
+
+func (SocketsNotSupported) FDSendFile(ctx context.Context, outFD, inFD FD, offset FileSize, count Size) (Size, Errno) {
+	return 0, ENOSYS
+}
+
 func (SocketsNotSupported) SockShutdown(ctx context.Context, fd FD, flags SDFlags) Errno {
 	return ENOSYS
 }
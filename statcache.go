@@ -0,0 +1,125 @@
+package wasi
+
+import "context"
+
+// CachedFileStats wraps a System to cache the results of FDFileStatGet.
+//
+// The first call to FDFileStatGet on a regular file stores the result, which
+// is returned by subsequent calls on the same file descriptor until an
+// operation that may change the file attributes is made through it (FDWrite,
+// FDPwrite, FDFileStatSetSize, FDFileStatSetTimes, FDAllocate, or being the
+// destination of FDCopyRange). Operations on paths which may change the
+// attributes of open files, such as renaming, linking, unlinking or truncating
+// files, discard all the cached entries.
+//
+// Stdio file descriptors (0, 1 and 2) are never cached since other processes
+// commonly write to the files they refer to.
+//
+// The cache does not observe changes made by other processes, or by writing
+// to the file through a different file descriptor, and it does not account
+// for the access time updated when reading files. It is intended for guests
+// which stat the same files repeatedly, and that can tolerate these stale
+// attributes.
+func CachedFileStats(system System) System {
+	return &cachedFileStats{
+		System: system,
+		stats:  make(map[FD]FileStat),
+	}
+}
+
+type cachedFileStats struct {
+	System
+	stats map[FD]FileStat
+}
+
+func (s *cachedFileStats) invalidate(fd FD) {
+	delete(s.stats, fd)
+}
+
+func (s *cachedFileStats) reset() {
+	clear(s.stats)
+}
+
+func (s *cachedFileStats) FDFileStatGet(ctx context.Context, fd FD) (FileStat, Errno) {
+	if stat, ok := s.stats[fd]; ok {
+		return stat, ESUCCESS
+	}
+	stat, errno := s.System.FDFileStatGet(ctx, fd)
+	if errno == ESUCCESS && fd > 2 && stat.FileType == RegularFileType {
+		s.stats[fd] = stat
+	}
+	return stat, errno
+}
+
+func (s *cachedFileStats) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	s.invalidate(fd)
+	return s.System.FDWrite(ctx, fd, iovecs)
+}
+
+func (s *cachedFileStats) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	s.invalidate(fd)
+	return s.System.FDPwrite(ctx, fd, iovecs, offset)
+}
+
+func (s *cachedFileStats) FDFileStatSetSize(ctx context.Context, fd FD, size FileSize) Errno {
+	s.invalidate(fd)
+	return s.System.FDFileStatSetSize(ctx, fd, size)
+}
+
+func (s *cachedFileStats) FDFileStatSetTimes(ctx context.Context, fd FD, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	s.invalidate(fd)
+	return s.System.FDFileStatSetTimes(ctx, fd, accessTime, modifyTime, flags)
+}
+
+func (s *cachedFileStats) FDAllocate(ctx context.Context, fd FD, offset, length FileSize) Errno {
+	s.invalidate(fd)
+	return s.System.FDAllocate(ctx, fd, offset, length)
+}
+
+func (s *cachedFileStats) FDCopyRange(ctx context.Context, srcFD, dstFD FD, srcOffset, dstOffset, length FileSize) (FileSize, Errno) {
+	s.invalidate(dstFD)
+	return s.System.FDCopyRange(ctx, srcFD, dstFD, srcOffset, dstOffset, length)
+}
+
+func (s *cachedFileStats) FDClose(ctx context.Context, fd FD) Errno {
+	s.invalidate(fd)
+	return s.System.FDClose(ctx, fd)
+}
+
+func (s *cachedFileStats) FDRenumber(ctx context.Context, from, to FD) Errno {
+	s.invalidate(from)
+	s.invalidate(to)
+	return s.System.FDRenumber(ctx, from, to)
+}
+
+func (s *cachedFileStats) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	s.reset()
+	return s.System.PathFileStatSetTimes(ctx, fd, lookupFlags, path, accessTime, modifyTime, flags)
+}
+
+func (s *cachedFileStats) PathSetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, value []byte) Errno {
+	s.reset()
+	return s.System.PathSetXattr(ctx, fd, lookupFlags, path, name, value)
+}
+
+func (s *cachedFileStats) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
+	s.reset()
+	return s.System.PathLink(ctx, oldFD, oldFlags, oldPath, newFD, newPath)
+}
+
+func (s *cachedFileStats) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
+	if openFlags.Has(OpenTruncate) {
+		s.reset()
+	}
+	return s.System.PathOpen(ctx, fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+}
+
+func (s *cachedFileStats) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD, newPath string) Errno {
+	s.reset()
+	return s.System.PathRename(ctx, fd, oldPath, newFD, newPath)
+}
+
+func (s *cachedFileStats) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
+	s.reset()
+	return s.System.PathUnlinkFile(ctx, fd, path)
+}
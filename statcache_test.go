@@ -0,0 +1,115 @@
+package wasi_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+// newStatSystem returns a fakeSystem with a regular file of the given size
+// open on fd 3.
+func newStatSystem(size int) *fakeSystem {
+	s := newFakeSystem()
+	s.open(3, wasi.RegularFileType, strings.Repeat("x", size))
+	return s
+}
+
+func TestCachedFileStats(t *testing.T) {
+	ctx := context.Background()
+
+	stat := func(t *testing.T, s wasi.System, fd wasi.FD) wasi.FileStat {
+		t.Helper()
+		stat, errno := s.FDFileStatGet(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		return stat
+	}
+
+	t.Run("repeated calls are served from the cache", func(t *testing.T) {
+		f := newStatSystem(42)
+		s := wasi.CachedFileStats(f)
+		for i := 0; i < 10; i++ {
+			if stat := stat(t, s, 3); stat.Size != 42 {
+				t.Fatalf("wrong size: want 42, got %d", stat.Size)
+			}
+		}
+		if f.calls["FDFileStatGet"] != 1 {
+			t.Errorf("wrong number of calls: want 1, got %d", f.calls["FDFileStatGet"])
+		}
+	})
+
+	t.Run("changing the size invalidates the cache", func(t *testing.T) {
+		f := newStatSystem(42)
+		s := wasi.CachedFileStats(f)
+		stat(t, s, 3)
+
+		if errno := s.FDFileStatSetSize(ctx, 3, 1234); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat := stat(t, s, 3); stat.Size != 1234 {
+			t.Errorf("wrong size after truncation: want 1234, got %d", stat.Size)
+		}
+
+		if _, errno := s.FDWrite(ctx, 3, []wasi.IOVec{[]byte("hello")}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat := stat(t, s, 3); stat.Size != 1239 {
+			t.Errorf("wrong size after write: want 1239, got %d", stat.Size)
+		}
+	})
+
+	t.Run("stdio is never cached", func(t *testing.T) {
+		f := newStatSystem(0)
+		s := wasi.CachedFileStats(f)
+		for fd := wasi.FD(0); fd <= 2; fd++ {
+			stat(t, s, fd)
+			stat(t, s, fd)
+		}
+		if f.calls["FDFileStatGet"] != 6 {
+			t.Errorf("wrong number of calls: want 6, got %d", f.calls["FDFileStatGet"])
+		}
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		f := newStatSystem(0)
+		s := wasi.CachedFileStats(f)
+		for i := 0; i < 2; i++ {
+			if _, errno := s.FDFileStatGet(ctx, 42); errno != wasi.EBADF {
+				t.Fatalf("wrong errno: want EBADF, got %s", errno)
+			}
+		}
+		if f.calls["FDFileStatGet"] != 2 {
+			t.Errorf("wrong number of calls: want 2, got %d", f.calls["FDFileStatGet"])
+		}
+	})
+}
+
+func BenchmarkCachedFileStats(b *testing.B) {
+	ctx := context.Background()
+
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			f := newStatSystem(4096)
+			s := wasi.System(f)
+			if cached {
+				s = wasi.CachedFileStats(f)
+			}
+			for i := 0; i < b.N; i++ {
+				// A stat-heavy workload, checking the size of the file
+				// before each write.
+				for j := 0; j < 16; j++ {
+					s.FDFileStatGet(ctx, 3)
+				}
+				s.FDWrite(ctx, 3, []wasi.IOVec{[]byte("0123456789abcdef")})
+			}
+			b.ReportMetric(float64(f.calls["FDFileStatGet"])/float64(b.N), "fstat/op")
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package wasi
+
+import (
+	"context"
+	"sync"
+)
+
+// Synchronized wraps a System to make it safe for concurrent use by multiple
+// goroutines, which is needed when a guest runs multiple threads, or when the
+// host invokes functions of a module concurrently with a shared System.
+//
+// All the methods are serialized by a single mutex. This works with any
+// System, but it means that the calls made by concurrent goroutines are never
+// run in parallel, and that calls which block hold the mutex until they
+// return: a call to PollOneOff waiting for a timeout, or a read on a blocking
+// file descriptor, prevents other goroutines from using the system, and may
+// deadlock if they were expected to produce the event that is waited on.
+// Guests sharing a system across threads should use non-blocking file
+// descriptors and short poll timeouts.
+//
+// Methods of the underlying system which are already safe for concurrent use,
+// like unix.System.Shutdown, may still be called directly to interrupt calls
+// that are blocked.
+func Synchronized(s System) System {
+	return &synchronized{system: s}
+}
+
+type synchronized struct {
+	mutex  sync.Mutex
+	system System
+}
+
+func (s *synchronized) ArgsSizesGet(ctx context.Context) (int, int, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.ArgsSizesGet(ctx)
+}
+
+func (s *synchronized) ArgsGet(ctx context.Context) ([]string, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.ArgsGet(ctx)
+}
+
+func (s *synchronized) EnvironSizesGet(ctx context.Context) (int, int, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.EnvironSizesGet(ctx)
+}
+
+func (s *synchronized) EnvironGet(ctx context.Context) ([]string, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.EnvironGet(ctx)
+}
+
+func (s *synchronized) ClockResGet(ctx context.Context, id ClockID) (Timestamp, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.ClockResGet(ctx, id)
+}
+
+func (s *synchronized) ClockTimeGet(ctx context.Context, id ClockID, precision Timestamp) (Timestamp, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.ClockTimeGet(ctx, id, precision)
+}
+
+func (s *synchronized) FDAdvise(ctx context.Context, fd FD, offset FileSize, length FileSize, advice Advice) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDAdvise(ctx, fd, offset, length, advice)
+}
+
+func (s *synchronized) FDAllocate(ctx context.Context, fd FD, offset FileSize, length FileSize) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDAllocate(ctx, fd, offset, length)
+}
+
+func (s *synchronized) FDClose(ctx context.Context, fd FD) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDClose(ctx, fd)
+}
+
+func (s *synchronized) FDDataSync(ctx context.Context, fd FD) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDDataSync(ctx, fd)
+}
+
+func (s *synchronized) FDStatGet(ctx context.Context, fd FD) (FDStat, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDStatGet(ctx, fd)
+}
+
+func (s *synchronized) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDStatSetFlags(ctx, fd, flags)
+}
+
+func (s *synchronized) FDStatSwapNonBlock(ctx context.Context, fd FD, nonBlock bool) (bool, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDStatSwapNonBlock(ctx, fd, nonBlock)
+}
+
+func (s *synchronized) FDStatSetRights(ctx context.Context, fd FD, rightsBase, rightsInheriting Rights) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDStatSetRights(ctx, fd, rightsBase, rightsInheriting)
+}
+
+func (s *synchronized) FDFileStatGet(ctx context.Context, fd FD) (FileStat, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDFileStatGet(ctx, fd)
+}
+
+func (s *synchronized) FDFileStatSetSize(ctx context.Context, fd FD, size FileSize) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDFileStatSetSize(ctx, fd, size)
+}
+
+func (s *synchronized) FDFileStatSetTimes(ctx context.Context, fd FD, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDFileStatSetTimes(ctx, fd, accessTime, modifyTime, flags)
+}
+
+func (s *synchronized) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDPread(ctx, fd, iovecs, offset)
+}
+
+func (s *synchronized) FDPreStatGet(ctx context.Context, fd FD) (PreStat, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDPreStatGet(ctx, fd)
+}
+
+func (s *synchronized) FDPreStatDirName(ctx context.Context, fd FD) (string, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDPreStatDirName(ctx, fd)
+}
+
+func (s *synchronized) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDPwrite(ctx, fd, iovecs, offset)
+}
+
+func (s *synchronized) FDCopyRange(ctx context.Context, srcFD, dstFD FD, srcOffset, dstOffset, length FileSize) (FileSize, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDCopyRange(ctx, srcFD, dstFD, srcOffset, dstOffset, length)
+}
+
+func (s *synchronized) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDRead(ctx, fd, iovecs)
+}
+
+func (s *synchronized) FDReadDir(ctx context.Context, fd FD, entries []DirEntry, cookie DirCookie, bufferSizeBytes int) (int, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDReadDir(ctx, fd, entries, cookie, bufferSizeBytes)
+}
+
+func (s *synchronized) FDRenumber(ctx context.Context, from, to FD) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDRenumber(ctx, from, to)
+}
+
+func (s *synchronized) FDSeek(ctx context.Context, fd FD, offset FileDelta, whence Whence) (FileSize, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDSeek(ctx, fd, offset, whence)
+}
+
+func (s *synchronized) FDSync(ctx context.Context, fd FD) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDSync(ctx, fd)
+}
+
+func (s *synchronized) FDTell(ctx context.Context, fd FD) (FileSize, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDTell(ctx, fd)
+}
+
+func (s *synchronized) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDWrite(ctx, fd, iovecs)
+}
+
+func (s *synchronized) PathCreateDirectory(ctx context.Context, fd FD, path string) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathCreateDirectory(ctx, fd, path)
+}
+
+func (s *synchronized) PathFileStatGet(ctx context.Context, fd FD, lookupFlags LookupFlags, path string) (FileStat, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathFileStatGet(ctx, fd, lookupFlags, path)
+}
+
+func (s *synchronized) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathFileStatSetTimes(ctx, fd, lookupFlags, path, accessTime, modifyTime, flags)
+}
+
+func (s *synchronized) PathGetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, buffer []byte) (int, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathGetXattr(ctx, fd, lookupFlags, path, name, buffer)
+}
+
+func (s *synchronized) PathListXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, buffer []byte) (int, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathListXattr(ctx, fd, lookupFlags, path, buffer)
+}
+
+func (s *synchronized) PathSetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, value []byte) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathSetXattr(ctx, fd, lookupFlags, path, name, value)
+}
+
+func (s *synchronized) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathLink(ctx, oldFD, oldFlags, oldPath, newFD, newPath)
+}
+
+func (s *synchronized) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathOpen(ctx, fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+}
+
+func (s *synchronized) PathReadLink(ctx context.Context, fd FD, path string, buffer []byte) (int, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathReadLink(ctx, fd, path, buffer)
+}
+
+func (s *synchronized) PathRemoveDirectory(ctx context.Context, fd FD, path string) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathRemoveDirectory(ctx, fd, path)
+}
+
+func (s *synchronized) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD, newPath string) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathRename(ctx, fd, oldPath, newFD, newPath)
+}
+
+func (s *synchronized) PathSymlink(ctx context.Context, oldPath string, fd FD, newPath string) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathSymlink(ctx, oldPath, fd, newPath)
+}
+
+func (s *synchronized) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PathUnlinkFile(ctx, fd, path)
+}
+
+func (s *synchronized) PollOneOff(ctx context.Context, subscriptions []Subscription, events []Event) (int, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.PollOneOff(ctx, subscriptions, events)
+}
+
+func (s *synchronized) ProcExit(ctx context.Context, exitCode ExitCode) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.ProcExit(ctx, exitCode)
+}
+
+func (s *synchronized) ProcRaise(ctx context.Context, signal Signal) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.ProcRaise(ctx, signal)
+}
+
+func (s *synchronized) SchedYield(ctx context.Context) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SchedYield(ctx)
+}
+
+func (s *synchronized) RandomGet(ctx context.Context, b []byte) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.RandomGet(ctx, b)
+}
+
+func (s *synchronized) SockOpen(ctx context.Context, family ProtocolFamily, socketType SocketType, protocol Protocol, rightsBase, rightsInheriting Rights) (FD, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockOpen(ctx, family, socketType, protocol, rightsBase, rightsInheriting)
+}
+
+func (s *synchronized) SockBind(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockBind(ctx, fd, addr)
+}
+
+func (s *synchronized) SockConnect(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockConnect(ctx, fd, addr)
+}
+
+func (s *synchronized) SockListen(ctx context.Context, fd FD, backlog int) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockListen(ctx, fd, backlog)
+}
+
+func (s *synchronized) SockAccept(ctx context.Context, fd FD, flags FDFlags) (FD, SocketAddress, SocketAddress, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockAccept(ctx, fd, flags)
+}
+
+func (s *synchronized) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockRecv(ctx, fd, iovecs, flags)
+}
+
+func (s *synchronized) SockSend(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags) (Size, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockSend(ctx, fd, iovecs, flags)
+}
+
+func (s *synchronized) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags, addr SocketAddress) (Size, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockSendTo(ctx, fd, iovecs, flags, addr)
+}
+
+func (s *synchronized) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, SocketAddress, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockRecvFrom(ctx, fd, iovecs, flags)
+}
+
+func (s *synchronized) SockGetOpt(ctx context.Context, fd FD, option SocketOption) (SocketOptionValue, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockGetOpt(ctx, fd, option)
+}
+
+func (s *synchronized) SockSetOpt(ctx context.Context, fd FD, option SocketOption, value SocketOptionValue) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockSetOpt(ctx, fd, option, value)
+}
+
+func (s *synchronized) SockLocalAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockLocalAddress(ctx, fd)
+}
+
+func (s *synchronized) SockRemoteAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockRemoteAddress(ctx, fd)
+}
+
+func (s *synchronized) SockAddressInfo(ctx context.Context, name, service string, hints AddressInfo, results []AddressInfo) (int, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockAddressInfo(ctx, name, service, hints, results)
+}
+
+func (s *synchronized) SockSplice(ctx context.Context, inFD, outFD FD, maxBytes Size) (Size, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockSplice(ctx, inFD, outFD, maxBytes)
+}
+
+func (s *synchronized) FDSendFile(ctx context.Context, outFD, inFD FD, offset FileSize, count Size) (Size, Errno) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.FDSendFile(ctx, outFD, inFD, offset, count)
+}
+
+func (s *synchronized) SockShutdown(ctx context.Context, fd FD, flags SDFlags) Errno {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.SockShutdown(ctx, fd, flags)
+}
+
+func (s *synchronized) Close(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.system.Close(ctx)
+}
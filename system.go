@@ -75,6 +75,15 @@ type System interface {
 	// Note: This is similar to fcntl(fd, F_SETFL, flags) in POSIX.
 	FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags) Errno
 
+	// FDStatSwapNonBlock sets or clears the NonBlock flag of a file
+	// descriptor, returning whether the flag was set before the call. The file
+	// descriptor must have the rights to call FDStatSetFlags.
+	//
+	// Note: This function is not part of WASI preview 1, it lets guests which
+	// frequently toggle the blocking mode of their sockets do it in one call
+	// instead of calling FDStatGet and FDStatSetFlags.
+	FDStatSwapNonBlock(ctx context.Context, fd FD, nonBlock bool) (bool, Errno)
+
 	// FDStatSetRights adjusts the rights associated with a file descriptor.
 	//
 	// This can only be used to remove rights, and returns ENOTCAPABLE if
@@ -127,6 +136,19 @@ type System interface {
 	// process should not be interleaved while pwrite is executed.
 	FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno)
 
+	// FDCopyRange copies up to length bytes from srcFD at srcOffset to dstFD
+	// at dstOffset, without using and updating the file descriptors' offsets.
+	//
+	// On success, it returns the number of bytes copied, which may be less
+	// than length if the end of the source file was reached. The source must
+	// have the rights to call FDPread and the destination the rights to call
+	// FDPwrite.
+	//
+	// Note: This is similar to copy_file_range in Linux. This function is not
+	// part of WASI preview 1, it lets guests copy files without moving the
+	// data through their linear memory.
+	FDCopyRange(ctx context.Context, srcFD, dstFD FD, srcOffset, dstOffset, length FileSize) (FileSize, Errno)
+
 	// FDRead reads from a file descriptor.
 	//
 	// On success, it returns the number of bytes read. On failure, it returns
@@ -170,6 +192,11 @@ type System interface {
 
 	// FDTell returns the current offset of a file descriptor.
 	//
+	// The offset of a file descriptor with the Append flag is not moved to
+	// the end of the file until data is written to it; FDTell reports the
+	// offset that reads start from, which is the end of the file after
+	// each write.
+	//
 	// Note: This is similar to lseek(fd, 0, SEEK_CUR) in POSIX.
 	FDTell(ctx context.Context, fd FD) (FileSize, Errno)
 
@@ -197,6 +224,29 @@ type System interface {
 	// Note: This is similar to utimensat in POSIX.
 	PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno
 
+	// PathGetXattr reads the value of the extended attribute name of a file
+	// or directory into buffer, returning the size of the value. ERANGE is
+	// returned if the buffer is too small to hold the value.
+	//
+	// Note: This is similar to getxattr in Linux. This function is not part
+	// of WASI preview 1, the directory must have PathXattrRight.
+	PathGetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, buffer []byte) (int, Errno)
+
+	// PathListXattr writes the null-terminated names of the extended
+	// attributes of a file or directory into buffer, returning the number of
+	// bytes written. ERANGE is returned if the buffer is too small.
+	//
+	// Note: This is similar to listxattr in Linux. This function is not part
+	// of WASI preview 1, the directory must have PathXattrRight.
+	PathListXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, buffer []byte) (int, Errno)
+
+	// PathSetXattr sets the value of the extended attribute name of a file
+	// or directory, creating it if it did not exist.
+	//
+	// Note: This is similar to setxattr in Linux. This function is not part
+	// of WASI preview 1, the directory must have PathXattrRight.
+	PathSetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, value []byte) Errno
+
 	// PathLink creates a hard link.
 	//
 	// Note: This is similar to linkat in POSIX.
@@ -391,8 +441,11 @@ type System interface {
 	// host name and service.
 	//
 	// The function populates the AddressInfo.Address fields of the provided
-	// results slice, and returns a count indicating how many results were
-	// written.
+	// results slice, and returns the total number of results available. When
+	// the count is greater than len(results), only the first len(results)
+	// entries were written and the caller may call the function again with
+	// a slice large enough to receive all the results; an empty slice can be
+	// passed to only retrieve the count.
 	//
 	// The returned addresses are only valid until the next call on this
 	// interface. Assume that any method may invalidate the addresses.
@@ -400,6 +453,41 @@ type System interface {
 	// Note: This is similar to getaddrinfo in POSIX.
 	SockAddressInfo(ctx context.Context, name, service string, hints AddressInfo, results []AddressInfo) (int, Errno)
 
+	// SockSplice moves up to maxBytes bytes received on the socket inFD to
+	// the socket outFD, without moving the data through the memory of the
+	// guest.
+	//
+	// On success, it returns the number of bytes moved, which is zero if the
+	// peer of inFD closed its write end. The call may move fewer bytes than
+	// requested, even if more data is available. Both sockets must be stream
+	// sockets; inFD must have the rights to call FDRead and outFD the rights
+	// to call FDWrite. If inFD is in non-blocking mode and has no data to
+	// read, the call returns EAGAIN.
+	//
+	// Data received on inFD is always written to outFD in full before the
+	// call returns, which means that it may block waiting for outFD to be
+	// writable even if it is in non-blocking mode.
+	//
+	// Note: This is similar to splice in Linux. This function is not part
+	// of WASI preview 1, it lets guests proxy connections without moving the
+	// data through their linear memory.
+	SockSplice(ctx context.Context, inFD, outFD FD, maxBytes Size) (Size, Errno)
+
+	// FDSendFile sends up to count bytes read from the file inFD, starting at
+	// offset, on the socket outFD, without moving the data through the memory
+	// of the guest. The file offset of inFD is not changed.
+	//
+	// On success, it returns the number of bytes sent, which is zero if offset
+	// is at or past the end of the file. The call may send fewer bytes than
+	// requested. outFD must be a stream socket with the rights to call FDWrite
+	// and inFD a regular file with the rights to call FDRead. If outFD is in
+	// non-blocking mode and its send buffer is full, the call returns EAGAIN.
+	//
+	// Note: This is similar to sendfile in Linux. This function is not part
+	// of WASI preview 1, it lets guests serve files over the network without
+	// copying them to their linear memory.
+	FDSendFile(ctx context.Context, outFD, inFD FD, offset FileSize, count Size) (Size, Errno)
+
 	// SockShutdown shuts down a socket's send and/or receive channels.
 	//
 	// Note: This is similar to shutdown in POSIX.
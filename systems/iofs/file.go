@@ -0,0 +1,389 @@
+package iofs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+// File is an implementation of the wasi.File interface for files and
+// directories of an fs.FS.
+//
+// Since fs.FS is a read-only interface, all the methods which would modify
+// the file system fail with EROFS.
+type File struct {
+	fsys fs.FS
+	name string
+	file fs.File
+	typ  wasi.FileType
+}
+
+// FileType returns the type of a file opened with PathOpen.
+func (f *File) FileType() wasi.FileType {
+	return f.typ
+}
+
+func (f *File) open() (fs.File, wasi.Errno) {
+	if f.file == nil {
+		file, err := f.fsys.Open(f.name)
+		if err != nil {
+			return nil, makeErrno(err)
+		}
+		f.file = file
+	}
+	return f.file, wasi.ESUCCESS
+}
+
+func (f *File) join(name string) (string, wasi.Errno) {
+	name = path.Join(f.name, name)
+	if !fs.ValidPath(name) {
+		return "", wasi.EPERM
+	}
+	return name, wasi.ESUCCESS
+}
+
+func (f *File) FDAdvise(ctx context.Context, offset, length wasi.FileSize, advice wasi.Advice) wasi.Errno {
+	return wasi.ESUCCESS
+}
+
+func (f *File) FDAllocate(ctx context.Context, offset, length wasi.FileSize) wasi.Errno {
+	return wasi.EROFS
+}
+
+func (f *File) FDClose(ctx context.Context) wasi.Errno {
+	if f.file == nil {
+		return wasi.ESUCCESS
+	}
+	file := f.file
+	f.file = nil
+	return makeErrno(file.Close())
+}
+
+func (f *File) FDDataSync(ctx context.Context) wasi.Errno {
+	return wasi.ESUCCESS
+}
+
+func (f *File) FDStatSetFlags(ctx context.Context, flags wasi.FDFlags) wasi.Errno {
+	if (flags &^ (wasi.NonBlock | wasi.RSync)) != 0 {
+		return wasi.ENOTSUP
+	}
+	return wasi.ESUCCESS
+}
+
+func (f *File) FDFileStatGet(ctx context.Context) (wasi.FileStat, wasi.Errno) {
+	file, errno := f.open()
+	if errno != wasi.ESUCCESS {
+		return wasi.FileStat{}, errno
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return wasi.FileStat{}, makeErrno(err)
+	}
+	return makeFileStat(info), wasi.ESUCCESS
+}
+
+func (f *File) FDFileStatSetSize(ctx context.Context, size wasi.FileSize) wasi.Errno {
+	return wasi.EROFS
+}
+
+func (f *File) FDFileStatSetTimes(ctx context.Context, accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) wasi.Errno {
+	return wasi.EROFS
+}
+
+func (f *File) FDPread(ctx context.Context, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
+	if offset > math.MaxInt64 {
+		return 0, wasi.EINVAL
+	}
+	file, errno := f.open()
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	r, ok := file.(io.ReaderAt)
+	if !ok {
+		return 0, wasi.ESPIPE
+	}
+	size := wasi.Size(0)
+	for _, iov := range iovecs {
+		n, err := r.ReadAt(iov, int64(offset)+int64(size))
+		size += wasi.Size(n)
+		if err != nil {
+			return readResult(size, err)
+		}
+	}
+	return size, wasi.ESUCCESS
+}
+
+func (f *File) FDPwrite(ctx context.Context, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
+	return 0, wasi.EROFS
+}
+
+func (f *File) FDCopyRange(ctx context.Context, dst *File, srcOffset, dstOffset, length wasi.FileSize) (wasi.FileSize, wasi.Errno) {
+	return 0, wasi.EROFS
+}
+
+func (f *File) FDRead(ctx context.Context, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	file, errno := f.open()
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	size := wasi.Size(0)
+	for _, iov := range iovecs {
+		n, err := io.ReadFull(file, iov)
+		size += wasi.Size(n)
+		if err != nil {
+			return readResult(size, err)
+		}
+	}
+	return size, wasi.ESUCCESS
+}
+
+// readResult converts the result of a read which stopped on err. Reaching
+// the end of the file is not an error, and data that was read before an
+// error occurred must be returned to the caller.
+func readResult(size wasi.Size, err error) (wasi.Size, wasi.Errno) {
+	if err == io.EOF || err == io.ErrUnexpectedEOF || size > 0 {
+		return size, wasi.ESUCCESS
+	}
+	return 0, makeErrno(err)
+}
+
+func (f *File) FDWrite(ctx context.Context, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	return 0, wasi.EROFS
+}
+
+func (f *File) FDOpenDir(ctx context.Context) (wasi.Dir, wasi.Errno) {
+	entries, err := fs.ReadDir(f.fsys, f.name)
+	if err != nil {
+		return nil, makeErrno(err)
+	}
+	return &dir{entries: entries}, wasi.ESUCCESS
+}
+
+func (f *File) FDSync(ctx context.Context) wasi.Errno {
+	return wasi.ESUCCESS
+}
+
+func (f *File) FDSeek(ctx context.Context, delta wasi.FileDelta, whence wasi.Whence) (wasi.FileSize, wasi.Errno) {
+	file, errno := f.open()
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	s, ok := file.(io.Seeker)
+	if !ok {
+		return 0, wasi.ESPIPE
+	}
+	var offset int64
+	var err error
+	switch whence {
+	case wasi.SeekStart:
+		offset, err = s.Seek(int64(delta), io.SeekStart)
+	case wasi.SeekCurrent:
+		offset, err = s.Seek(int64(delta), io.SeekCurrent)
+	case wasi.SeekEnd:
+		offset, err = s.Seek(int64(delta), io.SeekEnd)
+	default:
+		return 0, wasi.EINVAL
+	}
+	return wasi.FileSize(offset), makeErrno(err)
+}
+
+func (f *File) PathCreateDirectory(ctx context.Context, path string) wasi.Errno {
+	return wasi.EROFS
+}
+
+func (f *File) PathFileStatGet(ctx context.Context, flags wasi.LookupFlags, path string) (wasi.FileStat, wasi.Errno) {
+	name, errno := f.join(path)
+	if errno != wasi.ESUCCESS {
+		return wasi.FileStat{}, errno
+	}
+	info, err := fs.Stat(f.fsys, name)
+	if err != nil {
+		return wasi.FileStat{}, makeErrno(err)
+	}
+	return makeFileStat(info), wasi.ESUCCESS
+}
+
+func (f *File) PathFileStatSetTimes(ctx context.Context, lookupFlags wasi.LookupFlags, path string, accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) wasi.Errno {
+	return wasi.EROFS
+}
+
+func (f *File) PathGetXattr(ctx context.Context, lookupFlags wasi.LookupFlags, path, name string, buffer []byte) (int, wasi.Errno) {
+	return 0, wasi.ENOTSUP
+}
+
+func (f *File) PathListXattr(ctx context.Context, lookupFlags wasi.LookupFlags, path string, buffer []byte) (int, wasi.Errno) {
+	// The fs.FS interface does not expose extended attributes, files that
+	// exist have none.
+	if _, errno := f.PathFileStatGet(ctx, lookupFlags, path); errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	return 0, wasi.ESUCCESS
+}
+
+func (f *File) PathSetXattr(ctx context.Context, lookupFlags wasi.LookupFlags, path, name string, value []byte) wasi.Errno {
+	return wasi.EROFS
+}
+
+func (f *File) PathLink(ctx context.Context, flags wasi.LookupFlags, oldPath string, newDir *File, newPath string) wasi.Errno {
+	return wasi.EROFS
+}
+
+func (f *File) PathOpen(ctx context.Context, lookupFlags wasi.LookupFlags, path string, openFlags wasi.OpenFlags, rightsBase, rightsInheriting wasi.Rights, fdFlags wasi.FDFlags) (*File, wasi.Errno) {
+	if openFlags.Has(wasi.OpenCreate) || openFlags.Has(wasi.OpenTruncate) || openFlags.Has(wasi.OpenTemporary) {
+		return nil, wasi.EROFS
+	}
+	if fdFlags.Has(wasi.Append) || fdFlags.Has(wasi.DSync) || fdFlags.Has(wasi.Sync) {
+		return nil, wasi.EROFS
+	}
+	name, errno := f.join(path)
+	if errno != wasi.ESUCCESS {
+		return nil, errno
+	}
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, makeErrno(err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, makeErrno(err)
+	}
+	if openFlags.Has(wasi.OpenDirectory) && !info.IsDir() {
+		file.Close()
+		return nil, wasi.ENOTDIR
+	}
+	return &File{fsys: f.fsys, name: name, file: file, typ: makeFileType(info.Mode())}, wasi.ESUCCESS
+}
+
+func (f *File) PathReadLink(ctx context.Context, path string, buffer []byte) (int, wasi.Errno) {
+	// The fs.FS interface does not expose symbolic links, files that exist
+	// are never links.
+	if _, errno := f.PathFileStatGet(ctx, 0, path); errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	return 0, wasi.EINVAL
+}
+
+func (f *File) PathRemoveDirectory(ctx context.Context, path string) wasi.Errno {
+	return wasi.EROFS
+}
+
+func (f *File) PathRename(ctx context.Context, oldPath string, newDir *File, newPath string) wasi.Errno {
+	return wasi.EROFS
+}
+
+func (f *File) PathSymlink(ctx context.Context, oldPath string, newPath string) wasi.Errno {
+	return wasi.EROFS
+}
+
+func (f *File) PathUnlinkFile(ctx context.Context, path string) wasi.Errno {
+	return wasi.EROFS
+}
+
+// dir is a snapshot of the entries of a directory taken when the guest
+// starts reading it; cookies are indexes into the list of entries.
+type dir struct {
+	entries []fs.DirEntry
+}
+
+func (d *dir) FDReadDir(ctx context.Context, entries []wasi.DirEntry, cookie wasi.DirCookie, bufferSizeBytes int) (int, wasi.Errno) {
+	n := 0
+	for n < len(entries) && cookie < wasi.DirCookie(len(d.entries)) && bufferSizeBytes > 0 {
+		e := d.entries[cookie]
+		cookie++
+		entries[n] = wasi.DirEntry{
+			Next: cookie,
+			Type: makeFileType(e.Type()),
+			Name: []byte(e.Name()),
+		}
+		bufferSizeBytes -= wasi.SizeOfDirent + len(e.Name())
+		n++
+	}
+	return n, wasi.ESUCCESS
+}
+
+func (d *dir) FDCloseDir(ctx context.Context) wasi.Errno {
+	d.entries = nil
+	return wasi.ESUCCESS
+}
+
+func makeFileType(mode fs.FileMode) wasi.FileType {
+	switch mode.Type() {
+	case 0:
+		return wasi.RegularFileType
+	case fs.ModeDir:
+		return wasi.DirectoryType
+	case fs.ModeSymlink:
+		return wasi.SymbolicLinkType
+	case fs.ModeDevice:
+		return wasi.BlockDeviceType
+	case fs.ModeDevice | fs.ModeCharDevice:
+		return wasi.CharacterDeviceType
+	case fs.ModeSocket:
+		return wasi.SocketStreamType
+	default:
+		return wasi.UnknownType
+	}
+}
+
+func makeFileStat(info fs.FileInfo) wasi.FileStat {
+	modTime := makeTimestamp(info.ModTime())
+	return wasi.FileStat{
+		FileType:   makeFileType(info.Mode()),
+		NLink:      1,
+		Size:       wasi.FileSize(info.Size()),
+		AccessTime: modTime,
+		ModifyTime: modTime,
+		ChangeTime: modTime,
+	}
+}
+
+func makeTimestamp(t time.Time) wasi.Timestamp {
+	if t.IsZero() {
+		return 0
+	}
+	return wasi.Timestamp(t.UnixNano())
+}
+
+var _ wasi.File[*File] = (*File)(nil)
+
+// makeErrno converts errors returned by fs.FS implementations, which are
+// usually not system errors, to WASI error numbers.
+func makeErrno(err error) wasi.Errno {
+	if err == nil {
+		return wasi.ESUCCESS
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return wasi.MakeErrno(errno)
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return wasi.ENOENT
+	case errors.Is(err, fs.ErrExist):
+		return wasi.EEXIST
+	case errors.Is(err, fs.ErrPermission):
+		return wasi.EPERM
+	case errors.Is(err, fs.ErrInvalid):
+		return wasi.EINVAL
+	case errors.Is(err, fs.ErrClosed):
+		return wasi.EBADF
+	case errors.Is(err, context.Canceled):
+		return wasi.ECANCELED
+	case errors.Is(err, context.DeadlineExceeded):
+		return wasi.ETIMEDOUT
+	default:
+		var errno wasi.Errno
+		if errors.As(err, &errno) {
+			return errno
+		}
+		return wasi.EIO
+	}
+}
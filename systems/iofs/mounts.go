@@ -0,0 +1,406 @@
+// Package iofs exposes instances of fs.FS to WASI guests.
+//
+// The package is useful to give guest modules access to a virtual file
+// system, for example an embed.FS or an in-memory fstest.MapFS, next to the
+// files of another wasi.System. The file systems are read-only.
+package iofs
+
+import (
+	"context"
+	"io/fs"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+// Mounts wraps a wasi.System to expose instances of fs.FS to the guest as
+// read-only preopened directories, next to the files of the wrapped system.
+//
+// The files of the mounts are not known to the wrapped system, but their file
+// descriptor numbers are allocated by it so the guest sees a single table of
+// file descriptors: Reserve is called with the stat of each file opened in a
+// mount (including the mounts themselves) and must register a placeholder in
+// the wrapped system, which is released by calling FDClose on the wrapped
+// system when the guest closes the file.
+//
+// Functions operating on two file descriptors fail with EXDEV when only one
+// of them refers to a file of a mount; SockSplice and FDSendFile are not
+// supported on the files of mounts.
+type Mounts struct {
+	wasi.System
+
+	// Reserve allocates a file descriptor number in the wrapped system for a
+	// file of the mounts.
+	Reserve func(wasi.FDStat) (wasi.FD, wasi.Errno)
+
+	files wasi.FileTable[*File]
+	// fds maps the file descriptors of the guest to those of the files table.
+	fds map[wasi.FD]wasi.FD
+}
+
+// Mount preopens the root of fsys as a read-only directory named path.
+func (m *Mounts) Mount(path string, fsys fs.FS) (wasi.FD, wasi.Errno) {
+	return m.register(mountStat, func() wasi.FD {
+		return m.files.Preopen(&File{fsys: fsys, name: "."}, path, mountStat)
+	})
+}
+
+// mountStat is the stat of the root directories of fs.FS instances.
+var mountStat = wasi.FDStat{
+	FileType:         wasi.DirectoryType,
+	RightsBase:       wasi.DirectoryRights &^ wasi.WriteRights,
+	RightsInheriting: (wasi.DirectoryRights | wasi.FileRights) &^ wasi.WriteRights,
+}
+
+func (m *Mounts) register(stat wasi.FDStat, open func() wasi.FD) (wasi.FD, wasi.Errno) {
+	fd, errno := m.Reserve(stat)
+	if errno != wasi.ESUCCESS {
+		return -1, errno
+	}
+	if m.fds == nil {
+		m.fds = make(map[wasi.FD]wasi.FD)
+	}
+	m.fds[fd] = open()
+	return fd, wasi.ESUCCESS
+}
+
+func (m *Mounts) lookup(fd wasi.FD) (wasi.FD, bool) {
+	f, ok := m.fds[fd]
+	return f, ok
+}
+
+// lookup2 resolves the file descriptors of functions operating on two files,
+// which must either both be files of the mounts or both be files of the
+// wrapped system.
+func (m *Mounts) lookup2(fd1, fd2 wasi.FD) (f1, f2 wasi.FD, ok bool, errno wasi.Errno) {
+	f1, ok1 := m.fds[fd1]
+	f2, ok2 := m.fds[fd2]
+	if ok1 != ok2 {
+		return -1, -1, false, wasi.EXDEV
+	}
+	return f1, f2, ok1, wasi.ESUCCESS
+}
+
+func (m *Mounts) FDAdvise(ctx context.Context, fd wasi.FD, offset, length wasi.FileSize, advice wasi.Advice) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDAdvise(ctx, f, offset, length, advice)
+	}
+	return m.System.FDAdvise(ctx, fd, offset, length, advice)
+}
+
+func (m *Mounts) FDAllocate(ctx context.Context, fd wasi.FD, offset, length wasi.FileSize) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDAllocate(ctx, f, offset, length)
+	}
+	return m.System.FDAllocate(ctx, fd, offset, length)
+}
+
+func (m *Mounts) FDClose(ctx context.Context, fd wasi.FD) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		delete(m.fds, fd)
+		m.System.FDClose(ctx, fd)
+		return m.files.FDClose(ctx, f)
+	}
+	return m.System.FDClose(ctx, fd)
+}
+
+func (m *Mounts) FDDataSync(ctx context.Context, fd wasi.FD) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDDataSync(ctx, f)
+	}
+	return m.System.FDDataSync(ctx, fd)
+}
+
+func (m *Mounts) FDStatGet(ctx context.Context, fd wasi.FD) (wasi.FDStat, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDStatGet(ctx, f)
+	}
+	return m.System.FDStatGet(ctx, fd)
+}
+
+func (m *Mounts) FDStatSetFlags(ctx context.Context, fd wasi.FD, flags wasi.FDFlags) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDStatSetFlags(ctx, f, flags)
+	}
+	return m.System.FDStatSetFlags(ctx, fd, flags)
+}
+
+func (m *Mounts) FDStatSwapNonBlock(ctx context.Context, fd wasi.FD, nonBlock bool) (bool, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDStatSwapNonBlock(ctx, f, nonBlock)
+	}
+	return m.System.FDStatSwapNonBlock(ctx, fd, nonBlock)
+}
+
+func (m *Mounts) FDStatSetRights(ctx context.Context, fd wasi.FD, rightsBase, rightsInheriting wasi.Rights) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDStatSetRights(ctx, f, rightsBase, rightsInheriting)
+	}
+	return m.System.FDStatSetRights(ctx, fd, rightsBase, rightsInheriting)
+}
+
+func (m *Mounts) FDFileStatGet(ctx context.Context, fd wasi.FD) (wasi.FileStat, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDFileStatGet(ctx, f)
+	}
+	return m.System.FDFileStatGet(ctx, fd)
+}
+
+func (m *Mounts) FDFileStatSetSize(ctx context.Context, fd wasi.FD, size wasi.FileSize) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDFileStatSetSize(ctx, f, size)
+	}
+	return m.System.FDFileStatSetSize(ctx, fd, size)
+}
+
+func (m *Mounts) FDFileStatSetTimes(ctx context.Context, fd wasi.FD, accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDFileStatSetTimes(ctx, f, accessTime, modifyTime, flags)
+	}
+	return m.System.FDFileStatSetTimes(ctx, fd, accessTime, modifyTime, flags)
+}
+
+func (m *Mounts) FDPread(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDPread(ctx, f, iovecs, offset)
+	}
+	return m.System.FDPread(ctx, fd, iovecs, offset)
+}
+
+func (m *Mounts) FDPreStatGet(ctx context.Context, fd wasi.FD) (wasi.PreStat, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDPreStatGet(ctx, f)
+	}
+	return m.System.FDPreStatGet(ctx, fd)
+}
+
+func (m *Mounts) FDPreStatDirName(ctx context.Context, fd wasi.FD) (string, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDPreStatDirName(ctx, f)
+	}
+	return m.System.FDPreStatDirName(ctx, fd)
+}
+
+func (m *Mounts) FDPwrite(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDPwrite(ctx, f, iovecs, offset)
+	}
+	return m.System.FDPwrite(ctx, fd, iovecs, offset)
+}
+
+func (m *Mounts) FDCopyRange(ctx context.Context, srcFD, dstFD wasi.FD, srcOffset, dstOffset, length wasi.FileSize) (wasi.FileSize, wasi.Errno) {
+	src, dst, ok, errno := m.lookup2(srcFD, dstFD)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	if ok {
+		return m.files.FDCopyRange(ctx, src, dst, srcOffset, dstOffset, length)
+	}
+	return m.System.FDCopyRange(ctx, srcFD, dstFD, srcOffset, dstOffset, length)
+}
+
+func (m *Mounts) FDRead(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDRead(ctx, f, iovecs)
+	}
+	return m.System.FDRead(ctx, fd, iovecs)
+}
+
+func (m *Mounts) FDReadDir(ctx context.Context, fd wasi.FD, entries []wasi.DirEntry, cookie wasi.DirCookie, bufferSizeBytes int) (int, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDReadDir(ctx, f, entries, cookie, bufferSizeBytes)
+	}
+	return m.System.FDReadDir(ctx, fd, entries, cookie, bufferSizeBytes)
+}
+
+func (m *Mounts) FDRenumber(ctx context.Context, from, to wasi.FD) wasi.Errno {
+	f, fromMount := m.lookup(from)
+	g, toMount := m.lookup(to)
+	if !fromMount && !toMount {
+		return m.System.FDRenumber(ctx, from, to)
+	}
+	if m.isPreopen(f, fromMount) || m.isPreopen(g, toMount) {
+		return wasi.ENOTSUP
+	}
+	// The placeholders are renumbered in the wrapped system first, which
+	// validates the file descriptors and releases the placeholder of the
+	// file that gets replaced.
+	if errno := m.System.FDRenumber(ctx, from, to); errno != wasi.ESUCCESS || from == to {
+		return errno
+	}
+	if toMount {
+		delete(m.fds, to)
+		m.files.FDClose(ctx, g)
+	}
+	if fromMount {
+		delete(m.fds, from)
+		m.fds[to] = f
+	}
+	return wasi.ESUCCESS
+}
+
+func (m *Mounts) isPreopen(f wasi.FD, ok bool) bool {
+	if ok {
+		_, ok = m.files.LookupPreopen(f)
+	}
+	return ok
+}
+
+func (m *Mounts) FDSeek(ctx context.Context, fd wasi.FD, delta wasi.FileDelta, whence wasi.Whence) (wasi.FileSize, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDSeek(ctx, f, delta, whence)
+	}
+	return m.System.FDSeek(ctx, fd, delta, whence)
+}
+
+func (m *Mounts) FDSync(ctx context.Context, fd wasi.FD) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDSync(ctx, f)
+	}
+	return m.System.FDSync(ctx, fd)
+}
+
+func (m *Mounts) FDTell(ctx context.Context, fd wasi.FD) (wasi.FileSize, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDTell(ctx, f)
+	}
+	return m.System.FDTell(ctx, fd)
+}
+
+func (m *Mounts) FDWrite(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.FDWrite(ctx, f, iovecs)
+	}
+	return m.System.FDWrite(ctx, fd, iovecs)
+}
+
+func (m *Mounts) PathCreateDirectory(ctx context.Context, fd wasi.FD, path string) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.PathCreateDirectory(ctx, f, path)
+	}
+	return m.System.PathCreateDirectory(ctx, fd, path)
+}
+
+func (m *Mounts) PathFileStatGet(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path string) (wasi.FileStat, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.PathFileStatGet(ctx, f, lookupFlags, path)
+	}
+	return m.System.PathFileStatGet(ctx, fd, lookupFlags, path)
+}
+
+func (m *Mounts) PathFileStatSetTimes(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path string, accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.PathFileStatSetTimes(ctx, f, lookupFlags, path, accessTime, modifyTime, flags)
+	}
+	return m.System.PathFileStatSetTimes(ctx, fd, lookupFlags, path, accessTime, modifyTime, flags)
+}
+
+func (m *Mounts) PathGetXattr(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path, name string, buffer []byte) (int, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.PathGetXattr(ctx, f, lookupFlags, path, name, buffer)
+	}
+	return m.System.PathGetXattr(ctx, fd, lookupFlags, path, name, buffer)
+}
+
+func (m *Mounts) PathListXattr(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path string, buffer []byte) (int, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.PathListXattr(ctx, f, lookupFlags, path, buffer)
+	}
+	return m.System.PathListXattr(ctx, fd, lookupFlags, path, buffer)
+}
+
+func (m *Mounts) PathSetXattr(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path, name string, value []byte) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.PathSetXattr(ctx, f, lookupFlags, path, name, value)
+	}
+	return m.System.PathSetXattr(ctx, fd, lookupFlags, path, name, value)
+}
+
+func (m *Mounts) PathLink(ctx context.Context, oldFD wasi.FD, oldFlags wasi.LookupFlags, oldPath string, newFD wasi.FD, newPath string) wasi.Errno {
+	oldDir, newDir, ok, errno := m.lookup2(oldFD, newFD)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	if ok {
+		return m.files.PathLink(ctx, oldDir, oldFlags, oldPath, newDir, newPath)
+	}
+	return m.System.PathLink(ctx, oldFD, oldFlags, oldPath, newFD, newPath)
+}
+
+func (m *Mounts) PathOpen(ctx context.Context, fd wasi.FD, dirFlags wasi.LookupFlags, path string, openFlags wasi.OpenFlags, rightsBase, rightsInheriting wasi.Rights, fdFlags wasi.FDFlags) (wasi.FD, wasi.Errno) {
+	d, ok := m.lookup(fd)
+	if !ok {
+		return m.System.PathOpen(ctx, fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+	}
+	f, errno := m.files.PathOpen(ctx, d, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+	if errno != wasi.ESUCCESS {
+		return -1, errno
+	}
+	stat, _ := m.files.FDStatGet(ctx, f)
+	newFD, errno := m.register(stat, func() wasi.FD { return f })
+	if errno != wasi.ESUCCESS {
+		m.files.FDClose(ctx, f)
+		return -1, errno
+	}
+	return newFD, wasi.ESUCCESS
+}
+
+func (m *Mounts) PathReadLink(ctx context.Context, fd wasi.FD, path string, buffer []byte) (int, wasi.Errno) {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.PathReadLink(ctx, f, path, buffer)
+	}
+	return m.System.PathReadLink(ctx, fd, path, buffer)
+}
+
+func (m *Mounts) PathRemoveDirectory(ctx context.Context, fd wasi.FD, path string) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.PathRemoveDirectory(ctx, f, path)
+	}
+	return m.System.PathRemoveDirectory(ctx, fd, path)
+}
+
+func (m *Mounts) PathRename(ctx context.Context, fd wasi.FD, oldPath string, newFD wasi.FD, newPath string) wasi.Errno {
+	oldDir, newDir, ok, errno := m.lookup2(fd, newFD)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	if ok {
+		return m.files.PathRename(ctx, oldDir, oldPath, newDir, newPath)
+	}
+	return m.System.PathRename(ctx, fd, oldPath, newFD, newPath)
+}
+
+func (m *Mounts) PathSymlink(ctx context.Context, oldPath string, fd wasi.FD, newPath string) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.PathSymlink(ctx, oldPath, f, newPath)
+	}
+	return m.System.PathSymlink(ctx, oldPath, fd, newPath)
+}
+
+func (m *Mounts) PathUnlinkFile(ctx context.Context, fd wasi.FD, path string) wasi.Errno {
+	if f, ok := m.lookup(fd); ok {
+		return m.files.PathUnlinkFile(ctx, f, path)
+	}
+	return m.System.PathUnlinkFile(ctx, fd, path)
+}
+
+func (m *Mounts) SockSplice(ctx context.Context, inFD, outFD wasi.FD, maxBytes wasi.Size) (wasi.Size, wasi.Errno) {
+	if _, _, ok, errno := m.lookup2(inFD, outFD); errno != wasi.ESUCCESS || ok {
+		return 0, wasi.EXDEV
+	}
+	return m.System.SockSplice(ctx, inFD, outFD, maxBytes)
+}
+
+func (m *Mounts) FDSendFile(ctx context.Context, outFD, inFD wasi.FD, offset wasi.FileSize, count wasi.Size) (wasi.Size, wasi.Errno) {
+	if _, _, ok, errno := m.lookup2(outFD, inFD); errno != wasi.ESUCCESS || ok {
+		return 0, wasi.EXDEV
+	}
+	return m.System.FDSendFile(ctx, outFD, inFD, offset, count)
+}
+
+func (m *Mounts) Close(ctx context.Context) error {
+	m.files.Close(ctx)
+	m.fds = nil
+	return m.System.Close(ctx)
+}
+
+var _ wasi.System = (*Mounts)(nil)
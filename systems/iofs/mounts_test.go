@@ -0,0 +1,234 @@
+//go:build unix
+
+package iofs_test
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/systems/iofs"
+	"github.com/stealthrocket/wasi-go/systems/unix"
+	sysunix "golang.org/x/sys/unix"
+)
+
+//go:embed testdata/assets
+var testdata embed.FS
+
+var testFS = fstest.MapFS{
+	"message.txt": &fstest.MapFile{Data: []byte("hello world\n")},
+	"tmp/one":     &fstest.MapFile{Data: []byte("1")},
+	"tmp/two":     &fstest.MapFile{Data: []byte("2")},
+}
+
+// newMounts returns a Mounts wrapping a unix.System, which has a host
+// directory containing a message.txt file preopened as /base. The file
+// descriptors of the mounts are reserved with placeholders opened on
+// /dev/null, like imports.Builder does.
+func newMounts(t *testing.T) (system *iofs.Mounts, base *unix.System, baseFD wasi.FD) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "message.txt"), []byte("hello world\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := sysunix.Open(dir, sysunix.O_DIRECTORY|sysunix.O_RDONLY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base = &unix.System{}
+	baseFD = base.Preopen(unix.FD(d), "/base", wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsBase:       wasi.DirectoryRights,
+		RightsInheriting: wasi.DirectoryRights | wasi.FileRights,
+	})
+	system = &iofs.Mounts{
+		System: base,
+		Reserve: func(stat wasi.FDStat) (wasi.FD, wasi.Errno) {
+			fd, err := sysunix.Open("/dev/null", sysunix.O_RDONLY|sysunix.O_CLOEXEC, 0)
+			if err != nil {
+				return -1, wasi.MakeErrno(err)
+			}
+			return base.Register(unix.FD(fd), stat), wasi.ESUCCESS
+		},
+	}
+	t.Cleanup(func() { system.Close(context.Background()) })
+	return system, base, baseFD
+}
+
+func TestFS(t *testing.T) {
+	ctx := context.Background()
+	system, _, _ := newMounts(t)
+
+	rootFD, errno := system.Mount("/", testFS)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	fsys := wasi.FS(ctx, system, rootFD)
+
+	if err := fstest.TestFS(fsys, "message.txt", "tmp/one", "tmp/two"); err != nil {
+		t.Skipf("https://go-review.googlesource.com/c/go/+/503175:\n%v", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	system, _, _ := newMounts(t)
+
+	rootFD, errno := system.Mount("/", testFS)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	stat, errno := system.FDStatGet(ctx, rootFD)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, stat.FileType, wasi.DirectoryType)
+	assertEqual(t, stat.RightsBase.Has(wasi.FDWriteRight), false)
+
+	fd, errno := system.PathOpen(ctx, rootFD, 0, "message.txt", 0, wasi.FDReadRight|wasi.FDSeekRight|wasi.FDFileStatGetRight, 0, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	fileStat, errno := system.FDFileStatGet(ctx, fd)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, fileStat.FileType, wasi.RegularFileType)
+	assertEqual(t, fileStat.Size, 12)
+
+	buf := make([]byte, 5)
+	n, errno := system.FDRead(ctx, fd, []wasi.IOVec{buf})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, string(buf[:n]), "hello")
+
+	n, errno = system.FDRead(ctx, fd, []wasi.IOVec{{}})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, n, 0)
+
+	offset, errno := system.FDSeek(ctx, fd, 6, wasi.SeekStart)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, offset, 6)
+
+	buf = make([]byte, 64)
+	n, errno = system.FDRead(ctx, fd, []wasi.IOVec{buf})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, string(buf[:n]), "world\n")
+
+	n, errno = system.FDRead(ctx, fd, []wasi.IOVec{buf})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, n, 0)
+
+	// Offsets which do not fit in a signed 64 bits integer are rejected
+	// instead of wrapping around to negative values.
+	for _, offset := range []wasi.FileSize{1 << 63, math.MaxUint64} {
+		_, errno = system.FDPread(ctx, fd, []wasi.IOVec{buf}, offset)
+		assertEqual(t, errno, wasi.EINVAL)
+	}
+
+	_, errno = system.FDWrite(ctx, fd, []wasi.IOVec{[]byte("nope")})
+	assertEqual(t, errno, wasi.ENOTCAPABLE)
+	assertEqual(t, system.FDClose(ctx, fd), wasi.ESUCCESS)
+
+	_, errno = system.PathOpen(ctx, rootFD, 0, "missing.txt", 0, wasi.FDReadRight, 0, 0)
+	assertEqual(t, errno, wasi.ENOENT)
+
+	_, errno = system.PathOpen(ctx, rootFD, 0, "message.txt", wasi.OpenDirectory, wasi.FDReadRight, 0, 0)
+	assertEqual(t, errno, wasi.ENOTDIR)
+
+	_, errno = system.PathOpen(ctx, rootFD, 0, "../escape", 0, wasi.FDReadRight, 0, 0)
+	assertEqual(t, errno, wasi.EPERM)
+
+	assertEqual(t, system.PathCreateDirectory(ctx, rootFD, "new"), wasi.EROFS)
+}
+
+func TestMounts(t *testing.T) {
+	ctx := context.Background()
+
+	assets, err := fs.Sub(testdata, "testdata/assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The wrapped system has a preopen of its own, the files of the mounts
+	// must get file descriptor numbers that do not collide with it.
+	system, base, baseFD := newMounts(t)
+
+	rootFD, errno := system.Mount("/assets", assets)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, rootFD, baseFD+1)
+
+	name, errno := system.FDPreStatDirName(ctx, rootFD)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, name, "/assets")
+
+	name, errno = system.FDPreStatDirName(ctx, baseFD)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, name, "/base")
+
+	fd, errno := system.PathOpen(ctx, rootFD, 0, "hello.txt", 0, wasi.FDReadRight|wasi.FDSeekRight, 0, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	buf := make([]byte, 64)
+	n, errno := system.FDRead(ctx, fd, []wasi.IOVec{buf})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, string(buf[:n]), "hello from the embedded file system\n")
+
+	n, errno = system.FDPread(ctx, fd, []wasi.IOVec{buf[:5]}, 11)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, string(buf[:n]), "the e")
+
+	// Files of the wrapped system are still reachable.
+	baseFile, errno := system.PathOpen(ctx, baseFD, 0, "message.txt", 0, wasi.FDReadRight, 0, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	n, errno = system.FDRead(ctx, baseFile, []wasi.IOVec{buf})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, string(buf[:n]), "hello world\n")
+
+	fileStat, errno := system.PathFileStatGet(ctx, rootFD, 0, "docs")
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, fileStat.FileType, wasi.DirectoryType)
+
+	dirFD, errno := system.PathOpen(ctx, rootFD, 0, "docs", wasi.OpenDirectory, wasi.FDReadDirRight, 0, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	entries := make([]wasi.DirEntry, 8)
+	n2, errno := system.FDReadDir(ctx, dirFD, entries, 0, 4096)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, n2, 2)
+	assertEqual(t, string(entries[0].Name), "a.txt")
+	assertEqual(t, string(entries[1].Name), "b.txt")
+
+	_, errno = system.PathReadLink(ctx, rootFD, "hello.txt", buf)
+	assertEqual(t, errno, wasi.EINVAL)
+
+	// The mounts are read-only.
+	_, errno = system.PathOpen(ctx, rootFD, 0, "new.txt", wasi.OpenCreate, wasi.FDReadRight, 0, 0)
+	assertEqual(t, errno, wasi.EROFS)
+	_, errno = system.FDWrite(ctx, fd, []wasi.IOVec{[]byte("nope")})
+	assertEqual(t, errno, wasi.ENOTCAPABLE)
+	assertEqual(t, system.PathUnlinkFile(ctx, rootFD, "hello.txt"), wasi.EROFS)
+	assertEqual(t, system.PathRename(ctx, rootFD, "hello.txt", baseFD, "hello.txt"), wasi.EXDEV)
+
+	// Renumbering moves the file of the mount to the new descriptor and
+	// closes the file that it replaces.
+	assertEqual(t, system.FDRenumber(ctx, fd, baseFile), wasi.ESUCCESS)
+	_, errno = system.FDRead(ctx, fd, []wasi.IOVec{buf})
+	assertEqual(t, errno, wasi.EBADF)
+	n, errno = system.FDPread(ctx, baseFile, []wasi.IOVec{buf[:5]}, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, string(buf[:n]), "hello")
+	assertEqual(t, system.FDRenumber(ctx, rootFD, baseFile), wasi.ENOTSUP)
+
+	// Closing files of the mounts releases their descriptor numbers.
+	assertEqual(t, system.FDClose(ctx, dirFD), wasi.ESUCCESS)
+	assertEqual(t, system.FDClose(ctx, baseFile), wasi.ESUCCESS)
+	_, errno = system.FDStatGet(ctx, dirFD)
+	assertEqual(t, errno, wasi.EBADF)
+	_, errno = base.FDStatGet(ctx, dirFD)
+	assertEqual(t, errno, wasi.EBADF)
+}
+
+func assertEqual[T comparable](t *testing.T, got, want T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("%v != %v", got, want)
+	}
+}
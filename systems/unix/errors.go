@@ -0,0 +1,21 @@
+package unix
+
+import "golang.org/x/sys/unix"
+
+// reportError calls the OnError function of the system if err is an error
+// that must be reported, and returns err.
+func (s *System) reportError(op string, err error) error {
+	if s.OnError != nil && isReportedError(err) {
+		s.OnError(op, err)
+	}
+	return err
+}
+
+func isReportedError(err error) bool {
+	switch err {
+	case nil, unix.EAGAIN, unix.EINPROGRESS:
+		return false
+	default:
+		return true
+	}
+}
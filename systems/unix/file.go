@@ -1,7 +1,13 @@
 package unix
 
 import (
+	"bytes"
 	"context"
+	"math"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
 
 	"github.com/stealthrocket/wasi-go"
 	"golang.org/x/sys/unix"
@@ -9,39 +15,57 @@ import (
 
 type FD int
 
-func (fd FD) FDAdvise(ctx context.Context, offset, length wasi.FileSize, advice wasi.Advice) wasi.Errno {
-	err := ignoreEINTR(func() error { return fdadvise(int(fd), int64(offset), int64(length), advice) })
-	return makeErrno(err)
+// file is the type of the entries of the file table of a System. It pairs the
+// host file descriptor with the System, so the methods of files can report
+// errors to its OnError function and apply its permission mode to the files
+// they create.
+//
+// The type of files returned by PathOpen is recorded so the file table does
+// not need to query it again when registering them.
+type file struct {
+	FD
+	sys *System
+	typ wasi.FileType
 }
 
-func (fd FD) FDAllocate(ctx context.Context, offset, length wasi.FileSize) wasi.Errno {
-	err := ignoreEINTR(func() error { return fallocate(int(fd), int64(offset), int64(length)) })
-	return makeErrno(err)
+func (f file) FileType() wasi.FileType {
+	return f.typ
 }
 
-func (fd FD) FDClose(ctx context.Context) wasi.Errno {
+func (f file) FDAdvise(ctx context.Context, offset, length wasi.FileSize, advice wasi.Advice) wasi.Errno {
+	err := ignoreEINTR(func() error { return fdadvise(int(f.FD), int64(offset), int64(length), advice) })
+	return makeErrno(f.sys.reportError("FDAdvise", err))
+}
+
+func (f file) FDAllocate(ctx context.Context, offset, length wasi.FileSize) wasi.Errno {
+	err := ignoreEINTR(func() error { return fallocate(int(f.FD), int64(offset), int64(length)) })
+	return makeErrno(f.sys.reportError("FDAllocate", err))
+}
+
+func (f file) FDClose(ctx context.Context) wasi.Errno {
 	// It's unclear what to do for EINTR on Linux, so do nothing and assume the
 	// file descriptor has been closed.
 	//
 	// See:
 	// - https://man7.org/linux/man-pages/man2/close.2.html
 	// - https://lwn.net/Articles/576478/
-	err := closeTraceEBADF(int(fd))
-	return makeErrno(err)
+	err := closeTraceEBADF(int(f.FD))
+	return makeErrno(f.sys.reportError("FDClose", err))
 }
 
-func (fd FD) FDDataSync(ctx context.Context) wasi.Errno {
-	err := ignoreEINTR(func() error { return fdatasync(int(fd)) })
-	return makeErrno(err)
+func (f file) FDDataSync(ctx context.Context) wasi.Errno {
+	err := ignoreEINTR(func() error { return fdatasync(int(f.FD)) })
+	return makeErrno(f.sys.reportError("FDDataSync", err))
 }
 
-func (fd FD) FDStatSetFlags(ctx context.Context, flags wasi.FDFlags) wasi.Errno {
+func (f file) FDStatSetFlags(ctx context.Context, flags wasi.FDFlags) wasi.Errno {
 	fl, err := ignoreEINTR2(func() (int, error) {
-		return unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+		return unix.FcntlInt(uintptr(f.FD), unix.F_GETFL, 0)
 	})
 	if err != nil {
-		return makeErrno(err)
+		return makeErrno(f.sys.reportError("FDStatSetFlags", err))
 	}
+	oldfl := fl
 	if flags.Has(wasi.Append) {
 		fl |= unix.O_APPEND
 	} else {
@@ -52,84 +76,174 @@ func (fd FD) FDStatSetFlags(ctx context.Context, flags wasi.FDFlags) wasi.Errno
 	} else {
 		fl &^= unix.O_NONBLOCK
 	}
-	_, err = ignoreEINTR2(func() (int, error) {
+	// O_SYNC is a superset of O_DSYNC (and O_RSYNC an alias of O_SYNC) on
+	// some platforms, the flags are computed together so clearing one of
+	// them does not also clear the others.
+	syncFlags := 0
+	if flags.Has(wasi.DSync) {
+		syncFlags |= unix.O_DSYNC
+	}
+	if flags.Has(wasi.Sync) {
+		syncFlags |= unix.O_SYNC
+	}
+	if flags.Has(wasi.RSync) {
+		syncFlags |= __O_RSYNC
+	}
+	fl = (fl &^ (unix.O_SYNC | unix.O_DSYNC)) | syncFlags
+	if err := fcntlSetFL(f.FD, fl); err != nil {
+		return makeErrno(f.sys.reportError("FDStatSetFlags", err))
+	}
+	if (oldfl & (unix.O_SYNC | unix.O_DSYNC)) == syncFlags {
+		return wasi.ESUCCESS
+	}
+	// The kernel may silently ignore changes to O_SYNC and O_DSYNC (Linux
+	// does for all file types), read the flags back to verify that they
+	// were applied, and restore all the original flags if they were not so
+	// the call has no effect when it fails.
+	newfl, err := ignoreEINTR2(func() (int, error) {
+		return unix.FcntlInt(uintptr(f.FD), unix.F_GETFL, 0)
+	})
+	if err != nil {
+		return makeErrno(f.sys.reportError("FDStatSetFlags", err))
+	}
+	if (newfl & (unix.O_SYNC | unix.O_DSYNC)) != syncFlags {
+		fcntlSetFL(f.FD, oldfl)
+		return wasi.ENOTSUP
+	}
+	return wasi.ESUCCESS
+}
+
+func fcntlSetFL(fd FD, fl int) error {
+	_, err := ignoreEINTR2(func() (int, error) {
 		return unix.FcntlInt(uintptr(fd), unix.F_SETFL, fl)
 	})
-	return makeErrno(err)
+	return err
 }
 
-func (fd FD) FDFileStatGet(ctx context.Context) (wasi.FileStat, wasi.Errno) {
+func (f file) FDFileStatGet(ctx context.Context) (wasi.FileStat, wasi.Errno) {
 	var sysStat unix.Stat_t
-	if err := ignoreEINTR(func() error { return unix.Fstat(int(fd), &sysStat) }); err != nil {
-		return wasi.FileStat{}, makeErrno(err)
+	if err := ignoreEINTR(func() error { return unix.Fstat(int(f.FD), &sysStat) }); err != nil {
+		return wasi.FileStat{}, makeErrno(f.sys.reportError("FDFileStatGet", err))
+	}
+	stat, err := makeFileStat(&sysStat)
+	if err != nil {
+		return wasi.FileStat{}, makeErrno(f.sys.reportError("FDFileStatGet", err))
+	}
+	if stat.FileType == wasi.SocketStreamType {
+		sockType, err := ignoreEINTR2(func() (int, error) {
+			return unix.GetsockoptInt(int(f.FD), unix.SOL_SOCKET, unix.SO_TYPE)
+		})
+		if err == nil && sockType == unix.SOCK_DGRAM {
+			stat.FileType = wasi.SocketDGramType
+		}
 	}
-	stat := makeFileStat(&sysStat)
 	return stat, wasi.ESUCCESS
 }
 
-func (fd FD) FDFileStatSetSize(ctx context.Context, size wasi.FileSize) wasi.Errno {
-	err := ignoreEINTR(func() error { return unix.Ftruncate(int(fd), int64(size)) })
-	return makeErrno(err)
+func (f file) FDFileStatSetSize(ctx context.Context, size wasi.FileSize) wasi.Errno {
+	err := ignoreEINTR(func() error { return unix.Ftruncate(int(f.FD), int64(size)) })
+	return makeErrno(f.sys.reportError("FDFileStatSetSize", err))
 }
 
-func (fd FD) FDFileStatSetTimes(ctx context.Context, accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) wasi.Errno {
-	ts := [2]unix.Timespec{
+// makeTimespecs converts the arguments of FDFileStatSetTimes and
+// PathFileStatSetTimes to the timespecs of utimensat(2). The current time is
+// set with UTIME_NOW so the kernel reads the clock, and timestamps that are
+// not changed are set to UTIME_OMIT. Setting a timestamp to both a value and
+// the current time is invalid.
+func makeTimespecs(accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) (ts [2]unix.Timespec, errno wasi.Errno) {
+	if flags.Has(wasi.AccessTime|wasi.AccessTimeNow) || flags.Has(wasi.ModifyTime|wasi.ModifyTimeNow) {
+		return ts, wasi.EINVAL
+	}
+	ts = [2]unix.Timespec{
 		{Nsec: __UTIME_OMIT},
 		{Nsec: __UTIME_OMIT},
 	}
-	if flags.Has(wasi.AccessTime) {
-		if flags.Has(wasi.AccessTimeNow) {
-			ts[0] = unix.Timespec{Nsec: __UTIME_NOW}
-		} else {
-			ts[0] = unix.NsecToTimespec(int64(accessTime))
-		}
+	switch {
+	case flags.Has(wasi.AccessTimeNow):
+		ts[0] = unix.Timespec{Nsec: __UTIME_NOW}
+	case flags.Has(wasi.AccessTime):
+		ts[0] = unix.NsecToTimespec(int64(accessTime))
 	}
-	if flags.Has(wasi.ModifyTime) {
-		if flags.Has(wasi.ModifyTimeNow) {
-			ts[1] = unix.Timespec{Nsec: __UTIME_NOW}
-		} else {
-			ts[1] = unix.NsecToTimespec(int64(modifyTime))
-		}
+	switch {
+	case flags.Has(wasi.ModifyTimeNow):
+		ts[1] = unix.Timespec{Nsec: __UTIME_NOW}
+	case flags.Has(wasi.ModifyTime):
+		ts[1] = unix.NsecToTimespec(int64(modifyTime))
 	}
-	err := ignoreEINTR(func() error { return futimens(int(fd), &ts) })
-	return makeErrno(err)
+	return ts, wasi.ESUCCESS
 }
 
-func (fd FD) FDPread(ctx context.Context, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
-	n, err := handleEINTR(func() (int, error) { return preadv(int(fd), makeIOVecs(iovecs), int64(offset)) })
-	return wasi.Size(n), makeErrno(err)
+func (f file) FDFileStatSetTimes(ctx context.Context, accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) wasi.Errno {
+	ts, errno := makeTimespecs(accessTime, modifyTime, flags)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	err := ignoreEINTR(func() error { return futimens(int(f.FD), &ts) })
+	return makeErrno(f.sys.reportError("FDFileStatSetTimes", err))
 }
 
-func (fd FD) FDPwrite(ctx context.Context, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
-	n, err := handleEINTR(func() (int, error) { return pwritev(int(fd), makeIOVecs(iovecs), int64(offset)) })
-	return wasi.Size(n), makeErrno(err)
+func (f file) FDPread(ctx context.Context, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
+	// Offsets are unsigned in WASI but signed on the host, values which do
+	// not fit in an int64 would become negative.
+	if offset > math.MaxInt64 {
+		return 0, wasi.EINVAL
+	}
+	off := int64(offset)
+	n, err := splitIOVecs(makeIOVecs(iovecs), func(iovs [][]byte) (int, error) {
+		n, err := handleEINTR(func() (int, error) { return preadv(int(f.FD), iovs, off) })
+		off += int64(max(n, 0))
+		return n, err
+	})
+	return wasi.Size(n), makeErrno(f.sys.reportError("FDPread", err))
 }
 
-func (fd FD) FDRead(ctx context.Context, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
-	n, err := handleEINTR(func() (int, error) { return readv(int(fd), makeIOVecs(iovecs)) })
-	return wasi.Size(n), makeErrno(err)
+func (f file) FDPwrite(ctx context.Context, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
+	if offset > math.MaxInt64 {
+		return 0, wasi.EINVAL
+	}
+	off := int64(offset)
+	n, err := splitIOVecs(makeIOVecs(iovecs), func(iovs [][]byte) (int, error) {
+		n, err := handleEINTR(func() (int, error) { return pwritev(int(f.FD), iovs, off) })
+		off += int64(max(n, 0))
+		return n, err
+	})
+	return wasi.Size(n), makeErrno(f.sys.reportError("FDPwrite", err))
+}
+
+func (f file) FDCopyRange(ctx context.Context, dst file, srcOffset, dstOffset, length wasi.FileSize) (wasi.FileSize, wasi.Errno) {
+	n, err := copyFileRange(int(f.FD), int64(srcOffset), int(dst.FD), int64(dstOffset), int64(length))
+	return wasi.FileSize(n), makeErrno(f.sys.reportError("FDCopyRange", err))
 }
 
-func (fd FD) FDWrite(ctx context.Context, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
-	n, err := handleEINTR(func() (int, error) { return writev(int(fd), makeIOVecs(iovecs)) })
-	return wasi.Size(n), makeErrno(err)
+func (f file) FDRead(ctx context.Context, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	n, err := splitIOVecs(makeIOVecs(iovecs), func(iovs [][]byte) (int, error) {
+		return handleEINTR(func() (int, error) { return readv(int(f.FD), iovs) })
+	})
+	return wasi.Size(n), makeErrno(f.sys.reportError("FDRead", err))
+}
+
+func (f file) FDWrite(ctx context.Context, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	n, err := splitIOVecs(makeIOVecs(iovecs), func(iovs [][]byte) (int, error) {
+		return handleEINTR(func() (int, error) { return writev(int(f.FD), iovs) })
+	})
+	return wasi.Size(n), makeSendErrno(f.sys.reportError("FDWrite", err))
 }
 
-func (fd FD) FDOpenDir(ctx context.Context) (wasi.Dir, wasi.Errno) {
+func (f file) FDOpenDir(ctx context.Context) (wasi.Dir, wasi.Errno) {
 	if _, err := ignoreEINTR2(func() (int64, error) {
-		return lseek(int(fd), 0, 0)
+		return lseek(int(f.FD), 0, 0)
 	}); err != nil {
-		return nil, makeErrno(err)
+		return nil, makeErrno(f.sys.reportError("FDReadDir", err))
 	}
-	return &dirbuf{fd: int(fd)}, wasi.ESUCCESS
+	return &dirSnapshot{dir: dirbuf{fd: int(f.FD)}, sys: f.sys}, wasi.ESUCCESS
 }
 
-func (fd FD) FDSync(ctx context.Context) wasi.Errno {
-	err := ignoreEINTR(func() error { return fsync(int(fd)) })
-	return makeErrno(err)
+func (f file) FDSync(ctx context.Context) wasi.Errno {
+	err := ignoreEINTR(func() error { return fsync(int(f.FD)) })
+	return makeErrno(f.sys.reportError("FDSync", err))
 }
 
-func (fd FD) FDSeek(ctx context.Context, delta wasi.FileDelta, whence wasi.Whence) (wasi.FileSize, wasi.Errno) {
+func (f file) FDSeek(ctx context.Context, delta wasi.FileDelta, whence wasi.Whence) (wasi.FileSize, wasi.Errno) {
 	var sysWhence int
 	switch whence {
 	case wasi.SeekStart:
@@ -141,63 +255,115 @@ func (fd FD) FDSeek(ctx context.Context, delta wasi.FileDelta, whence wasi.Whenc
 	default:
 		return 0, wasi.EINVAL
 	}
-	off, err := ignoreEINTR2(func() (int64, error) { return lseek(int(fd), int64(delta), sysWhence) })
-	return wasi.FileSize(off), makeErrno(err)
+	off, err := ignoreEINTR2(func() (int64, error) { return lseek(int(f.FD), int64(delta), sysWhence) })
+	return wasi.FileSize(off), makeErrno(f.sys.reportError("FDSeek", err))
 }
 
-func (fd FD) PathCreateDirectory(ctx context.Context, path string) wasi.Errno {
-	err := ignoreEINTR(func() error { return unix.Mkdirat(int(fd), path, 0755) })
-	return makeErrno(err)
+func (f file) PathCreateDirectory(ctx context.Context, path string) wasi.Errno {
+	err := f.beneath(path, false, func(dirfd int, name string) error {
+		return ignoreEINTR(func() error { return unix.Mkdirat(dirfd, name, 0755) })
+	})
+	return makeErrno(f.sys.reportError("PathCreateDirectory", err))
 }
 
-func (fd FD) PathFileStatGet(ctx context.Context, flags wasi.LookupFlags, path string) (wasi.FileStat, wasi.Errno) {
+func (f file) PathFileStatGet(ctx context.Context, flags wasi.LookupFlags, path string) (wasi.FileStat, wasi.Errno) {
 	var sysStat unix.Stat_t
-	var sysFlags int
-	if !flags.Has(wasi.SymlinkFollow) {
-		sysFlags |= unix.AT_SYMLINK_NOFOLLOW
+	err := f.beneath(path, followSymlinks(flags, path), func(dirfd int, name string) error {
+		return ignoreEINTR(func() error { return unix.Fstatat(dirfd, name, &sysStat, unix.AT_SYMLINK_NOFOLLOW) })
+	})
+	if err != nil {
+		return wasi.FileStat{}, makeErrno(f.sys.reportError("PathFileStatGet", err))
 	}
-	err := ignoreEINTR(func() error { return unix.Fstatat(int(fd), path, &sysStat, sysFlags) })
-	return makeFileStat(&sysStat), makeErrno(err)
+	stat, err := makeFileStat(&sysStat)
+	return stat, makeErrno(f.sys.reportError("PathFileStatGet", err))
 }
 
-func (fd FD) PathFileStatSetTimes(ctx context.Context, lookupFlags wasi.LookupFlags, path string, accessTime, modifyTime wasi.Timestamp, fstFlags wasi.FSTFlags) wasi.Errno {
-	var sysFlags int
-	if !lookupFlags.Has(wasi.SymlinkFollow) {
-		sysFlags |= unix.AT_SYMLINK_NOFOLLOW
-	}
-	ts := [2]unix.Timespec{
-		{Nsec: __UTIME_OMIT},
-		{Nsec: __UTIME_OMIT},
+func (f file) PathFileStatSetTimes(ctx context.Context, lookupFlags wasi.LookupFlags, path string, accessTime, modifyTime wasi.Timestamp, fstFlags wasi.FSTFlags) wasi.Errno {
+	ts, errno := makeTimespecs(accessTime, modifyTime, fstFlags)
+	if errno != wasi.ESUCCESS {
+		return errno
 	}
-	if fstFlags.Has(wasi.AccessTime) {
-		if fstFlags.Has(wasi.AccessTimeNow) {
-			ts[0] = unix.Timespec{Nsec: __UTIME_NOW}
-		} else {
-			ts[0] = unix.NsecToTimespec(int64(accessTime))
-		}
-	}
-	if fstFlags.Has(wasi.ModifyTime) {
-		if fstFlags.Has(wasi.ModifyTimeNow) {
-			ts[1] = unix.Timespec{Nsec: __UTIME_NOW}
-		} else {
-			ts[1] = unix.NsecToTimespec(int64(modifyTime))
-		}
+	err := f.beneath(path, followSymlinks(lookupFlags, path), func(dirfd int, name string) error {
+		return ignoreEINTR(func() error { return unix.UtimesNanoAt(dirfd, name, ts[:], unix.AT_SYMLINK_NOFOLLOW) })
+	})
+	return makeErrno(f.sys.reportError("PathFileStatSetTimes", err))
+}
+
+func (f file) PathGetXattr(ctx context.Context, lookupFlags wasi.LookupFlags, path, name string, buffer []byte) (int, wasi.Errno) {
+	var n int
+	err := f.beneath(path, followSymlinks(lookupFlags, path), func(dirfd int, file string) error {
+		return xattrat(dirfd, file, func(fd int) (err error) {
+			n, err = ignoreEINTR2(func() (int, error) { return unix.Fgetxattr(fd, name, buffer) })
+			return err
+		})
+	})
+	return n, makeErrno(f.sys.reportError("PathGetXattr", err))
+}
+
+func (f file) PathListXattr(ctx context.Context, lookupFlags wasi.LookupFlags, path string, buffer []byte) (int, wasi.Errno) {
+	var n int
+	err := f.beneath(path, followSymlinks(lookupFlags, path), func(dirfd int, file string) error {
+		return xattrat(dirfd, file, func(fd int) (err error) {
+			n, err = ignoreEINTR2(func() (int, error) { return unix.Flistxattr(fd, buffer) })
+			return err
+		})
+	})
+	return n, makeErrno(f.sys.reportError("PathListXattr", err))
+}
+
+func (f file) PathSetXattr(ctx context.Context, lookupFlags wasi.LookupFlags, path, name string, value []byte) wasi.Errno {
+	err := f.beneath(path, followSymlinks(lookupFlags, path), func(dirfd int, file string) error {
+		return xattrat(dirfd, file, func(fd int) error {
+			return ignoreEINTR(func() error { return unix.Fsetxattr(fd, name, value, 0) })
+		})
+	})
+	return makeErrno(f.sys.reportError("PathSetXattr", err))
+}
+
+// xattrat opens the file with the given name in dirfd and calls f with the
+// file descriptor. The *xattr functions that accept a path always follow
+// symbolic links (or only have l* variants on Linux), so the extended
+// attributes are accessed through a file descriptor opened with O_NOFOLLOW
+// to preserve the guarantees of beneath.
+func xattrat(dirfd int, name string, f func(int) error) error {
+	fd, err := ignoreEINTR2(func() (int, error) {
+		return unix.Openat(dirfd, name, unix.O_RDONLY|unix.O_CLOEXEC|unix.O_NOFOLLOW|unix.O_NONBLOCK, 0)
+	})
+	if err != nil {
+		return err
 	}
-	err := ignoreEINTR(func() error { return unix.UtimesNanoAt(int(fd), path, ts[:], sysFlags) })
-	return makeErrno(err)
+	defer closeTraceEBADF(fd)
+	return f(fd)
 }
 
-func (fd FD) PathLink(ctx context.Context, flags wasi.LookupFlags, oldPath string, newDir FD, newPath string) wasi.Errno {
-	var sysFlags int
-	if flags.Has(wasi.SymlinkFollow) {
-		sysFlags |= unix.AT_SYMLINK_FOLLOW
+func (f file) PathLink(ctx context.Context, flags wasi.LookupFlags, oldPath string, newDir file, newPath string) wasi.Errno {
+	if oldPath == "" {
+		// An empty path links the file itself, which is how temporary files
+		// opened with OpenTemporary are given a name.
+		err := newDir.beneath(newPath, false, func(newDirfd int, newName string) error {
+			return ignoreEINTR(func() error { return linkFD(int(f.FD), newDirfd, newName) })
+		})
+		return makeErrno(f.sys.reportError("PathLink", err))
 	}
-	err := ignoreEINTR(func() error { return unix.Linkat(int(fd), oldPath, int(newDir), newPath, sysFlags) })
-	return makeErrno(err)
+	err := f.beneath(oldPath, flags.Has(wasi.SymlinkFollow), func(oldDirfd int, oldName string) error {
+		return newDir.beneath(newPath, false, func(newDirfd int, newName string) error {
+			return ignoreEINTR(func() error { return unix.Linkat(oldDirfd, oldName, newDirfd, newName, 0) })
+		})
+	})
+	return makeErrno(f.sys.reportError("PathLink", err))
 }
 
-func (fd FD) PathOpen(ctx context.Context, lookupFlags wasi.LookupFlags, path string, openFlags wasi.OpenFlags, rightsBase, rightsInheriting wasi.Rights, fdFlags wasi.FDFlags) (FD, wasi.Errno) {
+func (f file) PathOpen(ctx context.Context, lookupFlags wasi.LookupFlags, path string, openFlags wasi.OpenFlags, rightsBase, rightsInheriting wasi.Rights, fdFlags wasi.FDFlags) (file, wasi.Errno) {
 	oflags := unix.O_CLOEXEC
+	if openFlags.Has(wasi.OpenTemporary) {
+		if openFlags != wasi.OpenTemporary || !rightsBase.Has(wasi.FDWriteRight) {
+			return file{FD: -1}, wasi.EINVAL
+		}
+		if __O_TMPFILE == 0 {
+			return f.openTemporary(ctx, path, rightsBase, rightsInheriting, fdFlags)
+		}
+		oflags |= __O_TMPFILE
+	}
 	if openFlags.Has(wasi.OpenDirectory) {
 		oflags |= unix.O_DIRECTORY
 		rightsBase &= wasi.DirectoryRights
@@ -221,9 +387,7 @@ func (fd FD) PathOpen(ctx context.Context, lookupFlags wasi.LookupFlags, path st
 		oflags |= unix.O_SYNC
 	}
 	if fdFlags.Has(wasi.RSync) {
-		// O_RSYNC is not widely supported, and in many cases is an
-		// alias for O_SYNC.
-		oflags |= unix.O_SYNC
+		oflags |= __O_RSYNC
 	}
 	if fdFlags.Has(wasi.NonBlock) {
 		oflags |= unix.O_NONBLOCK
@@ -244,22 +408,210 @@ func (fd FD) PathOpen(ctx context.Context, lookupFlags wasi.LookupFlags, path st
 		oflags |= unix.O_RDONLY
 	}
 
-	mode := uint32(0644)
-	if (oflags & unix.O_DIRECTORY) != 0 {
+	mode := f.sys.createMode()
+	if openFlags.Has(wasi.OpenDirectory) {
 		mode = 0
 	}
-	hostfd, err := ignoreEINTR2(func() (int, error) {
-		return unix.Openat(int(fd), path, oflags, mode)
-	})
-	return FD(hostfd), makeErrno(err)
+	hostfd, err := openBeneath(int(f.FD), path, oflags, mode)
+	if err == unix.ENOSYS {
+		// Symbolic links are resolved before opening the file so they cannot
+		// be used to escape the directory. The resolved path has no symbolic
+		// links left, any link found on the last component (e.g. if it was
+		// created concurrently) is rejected by the host with ELOOP.
+		path, err = resolvePath(int(f.FD), path, lookupFlags.Has(wasi.SymlinkFollow))
+		if err != nil {
+			return file{FD: -1}, makeErrno(f.sys.reportError("PathOpen", err))
+		}
+		hostfd, err = ignoreEINTR2(func() (int, error) {
+			return unix.Openat(int(f.FD), path, oflags|unix.O_NOFOLLOW, mode)
+		})
+	}
+	if err == unix.EOPNOTSUPP && openFlags.Has(wasi.OpenTemporary) {
+		// The file system does not support O_TMPFILE.
+		return f.openTemporary(ctx, path, rightsBase, rightsInheriting, fdFlags)
+	}
+	if err != nil {
+		return file{FD: -1}, makeErrno(f.sys.reportError("PathOpen", err))
+	}
+	newFile := file{FD: FD(hostfd), sys: f.sys}
+	switch {
+	case openFlags.Has(wasi.OpenDirectory):
+		newFile.typ = wasi.DirectoryType
+	case openFlags.Has(wasi.OpenTemporary), openFlags.Has(wasi.OpenCreate | wasi.OpenExclusive):
+		newFile.typ = wasi.RegularFileType
+	default:
+		// The path may not be a regular file (e.g. a FIFO, a device, or a
+		// directory opened without OpenDirectory).
+		var sysStat unix.Stat_t
+		if err := ignoreEINTR(func() error { return unix.Fstat(hostfd, &sysStat) }); err != nil {
+			closeTraceEBADF(hostfd)
+			return file{FD: -1}, makeErrno(f.sys.reportError("PathOpen", err))
+		}
+		newFile.typ = makeFileType(uint32(sysStat.Mode))
+	}
+	return newFile, wasi.ESUCCESS
+}
+
+// openTemporary emulates O_TMPFILE by creating a file with a random name in
+// the directory, which is unlinked right after it was opened. Unlike with
+// O_TMPFILE, the file cannot be given a name afterwards.
+func (f file) openTemporary(ctx context.Context, dir string, rightsBase, rightsInheriting wasi.Rights, fdFlags wasi.FDFlags) (file, wasi.Errno) {
+	for i := 0; i < 100; i++ {
+		name := path.Join(dir, ".tmp"+strconv.FormatUint(rand.Uint64(), 36))
+		f, errno := f.PathOpen(ctx, 0, name, wasi.OpenCreate|wasi.OpenExclusive, rightsBase, rightsInheriting, fdFlags)
+		switch errno {
+		case wasi.ESUCCESS:
+		case wasi.EEXIST:
+			continue
+		default:
+			return file{FD: -1}, errno
+		}
+		if errno := f.PathUnlinkFile(ctx, name); errno != wasi.ESUCCESS {
+			f.FDClose(ctx)
+			return file{FD: -1}, errno
+		}
+		return f, wasi.ESUCCESS
+	}
+	return file{FD: -1}, wasi.EEXIST
+}
+
+// followSymlinks returns true if symbolic links must be followed on the last
+// component of path. Paths with a trailing slash always resolve symbolic links.
+func followSymlinks(flags wasi.LookupFlags, path string) bool {
+	return flags.Has(wasi.SymlinkFollow) || strings.HasSuffix(path, "/")
+}
+
+// beneath calls f with a directory and the name of a file in this directory,
+// obtained by resolving path relative to fd so that it cannot escape fd. The
+// last component of path is resolved if follow is true; f must not follow
+// symbolic links on the file name it receives.
+//
+// When supported, the parent directory is opened with openBeneath, which
+// guarantees that concurrent changes to the file system (e.g. replacing a
+// directory with a symbolic link) cannot be used to escape fd after the path
+// was resolved.
+func (fd FD) beneath(path string, follow bool, f func(dirfd int, name string) error) error {
+	path, err := resolvePath(int(fd), path, follow)
+	if err != nil {
+		return err
+	}
+	i := strings.LastIndexByte(strings.TrimRight(path, "/"), '/')
+	if i < 0 {
+		return f(int(fd), path)
+	}
+	dirfd, err := openBeneath(int(fd), path[:i], unix.O_DIRECTORY|unix.O_CLOEXEC|__O_PATH, 0)
+	switch err {
+	case nil:
+		defer closeTraceEBADF(dirfd)
+		return f(dirfd, path[i+1:])
+	case unix.ENOSYS:
+		return f(int(fd), path)
+	default:
+		return err
+	}
+}
+
+// maxSymlinks is the maximum number of symbolic links followed by resolvePath,
+// it matches the limit applied by Linux.
+const maxSymlinks = 40
+
+// resolvePath resolves the symbolic links of path, one component at a time,
+// relative to the directory dirfd. The returned path contains no symbolic
+// links, except on its last component if follow is false.
+//
+// The function returns EPERM if resolving the path would escape dirfd, either
+// because it has too many ".." components or because a symbolic link targets
+// an absolute path or a location outside of the directory.
+func resolvePath(dirfd int, path string, follow bool) (string, error) {
+	resolved := make([]string, 0, 8)
+	pending := strings.Split(path, "/")
+	numLinks := 0
+
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", unix.EPERM
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		if !follow && isLastComponent(pending) {
+			resolved = append(resolved, name)
+			continue
+		}
+
+		target, err := readlinkat(dirfd, strings.Join(append(resolved, name), "/"))
+		switch err {
+		case nil:
+		case unix.EINVAL, unix.ENOENT:
+			// Not a symbolic link, or a file that does not exist yet and
+			// may be created by the caller.
+			resolved = append(resolved, name)
+			continue
+		default:
+			return "", err
+		}
+
+		if numLinks++; numLinks > maxSymlinks {
+			return "", unix.ELOOP
+		}
+		if strings.HasPrefix(target, "/") {
+			return "", unix.EPERM
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+
+	if len(resolved) == 0 {
+		return ".", nil
+	}
+	resolvedPath := strings.Join(resolved, "/")
+	if strings.HasSuffix(path, "/") {
+		resolvedPath += "/"
+	}
+	return resolvedPath, nil
+}
+
+func isLastComponent(pending []string) bool {
+	for _, name := range pending {
+		if name != "" {
+			return false
+		}
+	}
+	return true
 }
 
-func (fd FD) PathReadLink(ctx context.Context, path string, buffer []byte) (int, wasi.Errno) {
-	n, err := ignoreEINTR2(func() (int, error) {
-		return unix.Readlinkat(int(fd), path, buffer)
+func readlinkat(dirfd int, path string) (string, error) {
+	for size := 256; ; size *= 2 {
+		buf := make([]byte, size)
+		n, err := ignoreEINTR2(func() (int, error) {
+			return unix.Readlinkat(dirfd, path, buf)
+		})
+		if err != nil {
+			return "", err
+		}
+		if n < size {
+			return string(buf[:n]), nil
+		}
+	}
+}
+
+func (f file) PathReadLink(ctx context.Context, path string, buffer []byte) (int, wasi.Errno) {
+	var n int
+	err := f.beneath(path, false, func(dirfd int, name string) (err error) {
+		n, err = ignoreEINTR2(func() (int, error) {
+			return unix.Readlinkat(dirfd, name, buffer)
+		})
+		return err
 	})
 	if err != nil {
-		return n, makeErrno(err)
+		return n, makeErrno(f.sys.reportError("PathReadLink", err))
 	} else if n == len(buffer) {
 		return n, wasi.ERANGE
 	} else {
@@ -267,31 +619,171 @@ func (fd FD) PathReadLink(ctx context.Context, path string, buffer []byte) (int,
 	}
 }
 
-func (fd FD) PathRemoveDirectory(ctx context.Context, path string) wasi.Errno {
-	err := ignoreEINTR(func() error { return unix.Unlinkat(int(fd), path, unix.AT_REMOVEDIR) })
-	return makeErrno(err)
+func (f file) PathRemoveDirectory(ctx context.Context, path string) wasi.Errno {
+	err := f.beneath(path, false, func(dirfd int, name string) error {
+		return ignoreEINTR(func() error { return unix.Unlinkat(dirfd, name, unix.AT_REMOVEDIR) })
+	})
+	return makeErrno(f.sys.reportError("PathRemoveDirectory", err))
 }
 
-func (fd FD) PathRename(ctx context.Context, oldPath string, newDir FD, newPath string) wasi.Errno {
-	err := ignoreEINTR(func() error { return unix.Renameat(int(fd), oldPath, int(newDir), newPath) })
-	return makeErrno(err)
+func (f file) PathRename(ctx context.Context, oldPath string, newDir file, newPath string) wasi.Errno {
+	err := f.beneath(oldPath, false, func(oldDirfd int, oldName string) error {
+		return newDir.beneath(newPath, false, func(newDirfd int, newName string) error {
+			return ignoreEINTR(func() error { return unix.Renameat(oldDirfd, oldName, newDirfd, newName) })
+		})
+	})
+	return makeErrno(f.sys.reportError("PathRename", err))
 }
 
-func (fd FD) PathSymlink(ctx context.Context, oldPath string, newPath string) wasi.Errno {
-	err := ignoreEINTR(func() error { return unix.Symlinkat(oldPath, int(fd), newPath) })
-	return makeErrno(err)
+// pathRenameCopy moves the regular file at oldPath to newPath in newDir by
+// copying its content, then unlinking the source. It is used as a fallback of
+// PathRename when the directories are on different file systems.
+//
+// The content is copied to a temporary file in the target directory, which is
+// then renamed to newPath so the target is replaced atomically, but the move
+// as a whole is not atomic: the file exists at both locations until the source
+// is unlinked. EXDEV is returned if the source is not a regular file.
+func (fd FD) pathRenameCopy(oldPath string, newDir FD, newPath string) error {
+	return fd.beneath(oldPath, false, func(oldDirfd int, oldName string) error {
+		var stat unix.Stat_t
+		err := ignoreEINTR(func() error {
+			return unix.Fstatat(oldDirfd, oldName, &stat, unix.AT_SYMLINK_NOFOLLOW)
+		})
+		if err != nil {
+			return err
+		}
+		if (stat.Mode & unix.S_IFMT) != unix.S_IFREG {
+			return unix.EXDEV
+		}
+		mode := uint32(stat.Mode & 0777)
+
+		src, err := ignoreEINTR2(func() (int, error) {
+			return unix.Openat(oldDirfd, oldName, unix.O_RDONLY|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0)
+		})
+		if err != nil {
+			return err
+		}
+		defer closeTraceEBADF(src)
+
+		return newDir.beneath(newPath, false, func(newDirfd int, newName string) error {
+			tmpName, dst, err := createTemp(newDirfd, strings.TrimRight(newName, "/"), mode)
+			if err != nil {
+				return err
+			}
+			_, err = copyFileRange(src, 0, dst, 0, stat.Size)
+			if err == nil {
+				// The mode passed to openat(2) is subject to the umask.
+				err = ignoreEINTR(func() error { return unix.Fchmod(dst, mode) })
+			}
+			closeTraceEBADF(dst)
+			if err == nil {
+				err = ignoreEINTR(func() error { return unix.Renameat(newDirfd, tmpName, newDirfd, newName) })
+			}
+			if err != nil {
+				ignoreEINTR(func() error { return unix.Unlinkat(newDirfd, tmpName, 0) })
+				return err
+			}
+			return ignoreEINTR(func() error { return unix.Unlinkat(oldDirfd, oldName, 0) })
+		})
+	})
 }
 
-func (fd FD) PathUnlinkFile(ctx context.Context, path string) wasi.Errno {
-	err := ignoreEINTR(func() error { return unix.Unlinkat(int(fd), path, 0) })
-	return makeErrno(err)
+// createTemp creates a new file with a unique name derived from name in the
+// directory dirfd, returning its name and an open file descriptor.
+func createTemp(dirfd int, name string, mode uint32) (string, int, error) {
+	for {
+		tmpName := "." + name + "." + strconv.FormatUint(uint64(rand.Uint32()), 36)
+		fd, err := ignoreEINTR2(func() (int, error) {
+			return unix.Openat(dirfd, tmpName, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_CLOEXEC, mode)
+		})
+		if err != unix.EEXIST {
+			return tmpName, fd, err
+		}
+	}
 }
 
-func (d *dirbuf) FDReadDir(ctx context.Context, entries []wasi.DirEntry, cookie wasi.DirCookie, bufferSizeBytes int) (int, wasi.Errno) {
-	n, err := d.readDirEntries(entries, cookie, bufferSizeBytes)
-	return n, makeErrno(err)
+func (f file) PathSymlink(ctx context.Context, oldPath string, newPath string) wasi.Errno {
+	err := f.beneath(newPath, false, func(dirfd int, name string) error {
+		return ignoreEINTR(func() error { return unix.Symlinkat(oldPath, dirfd, name) })
+	})
+	return makeErrno(f.sys.reportError("PathSymlink", err))
+}
+
+func (f file) PathUnlinkFile(ctx context.Context, path string) wasi.Errno {
+	err := f.beneath(path, false, func(dirfd int, name string) error {
+		return ignoreEINTR(func() error { return unix.Unlinkat(dirfd, name, 0) })
+	})
+	return makeErrno(f.sys.reportError("PathUnlinkFile", err))
+}
+
+// dirSnapshot is the wasi.Dir implementation returned by FDOpenDir.
+//
+// The entries of the directory are all read on the first call to FDReadDir,
+// and when the guest rewinds to cookie zero. Cookies are indexes into this
+// snapshot, so they remain stable if entries are added or removed from the
+// directory while the guest iterates over it: the guest sees the directory
+// as it was when it started reading it, without skipping or duplicating
+// entries. The tradeoff is that the memory used by the snapshot grows with
+// the size of the directory, and is retained until the guest reaches the end
+// of the directory or closes the file descriptor.
+type dirSnapshot struct {
+	dir     dirbuf
+	sys     *System
+	entries []wasi.DirEntry
+	taken   bool
+}
+
+func (d *dirSnapshot) snapshot() error {
+	d.entries = d.entries[:0]
+	d.taken = false
+
+	var batch [64]wasi.DirEntry
+	var cookie wasi.DirCookie
+	for {
+		n, err := d.dir.readDirEntries(batch[:], cookie, math.MaxInt)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		for _, entry := range batch[:n] {
+			// The names returned by readDirEntries reference the read
+			// buffer, which is overwritten by the next call.
+			entry.Name = bytes.Clone(entry.Name)
+			d.entries = append(d.entries, entry)
+		}
+		cookie = batch[n-1].Next
+	}
+
+	d.taken = true
+	return nil
+}
+
+func (d *dirSnapshot) FDReadDir(ctx context.Context, entries []wasi.DirEntry, cookie wasi.DirCookie, bufferSizeBytes int) (int, wasi.Errno) {
+	if cookie == 0 || !d.taken {
+		if err := d.snapshot(); err != nil {
+			return 0, makeErrno(d.sys.reportError("FDReadDir", err))
+		}
+	}
+	if cookie >= wasi.DirCookie(len(d.entries)) {
+		return 0, wasi.ESUCCESS
+	}
+
+	n := 0
+	for _, entry := range d.entries[cookie:] {
+		if n == len(entries) || bufferSizeBytes <= 0 {
+			break
+		}
+		entries[n] = entry
+		n++
+		bufferSizeBytes -= wasi.SizeOfDirent + len(entry.Name)
+	}
+	return n, wasi.ESUCCESS
 }
 
-func (d *dirbuf) FDCloseDir(ctx context.Context) wasi.Errno {
+func (d *dirSnapshot) FDCloseDir(ctx context.Context) wasi.Errno {
+	d.entries = nil
+	d.taken = false
 	return wasi.ESUCCESS
 }
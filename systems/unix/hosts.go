@@ -0,0 +1,82 @@
+package unix
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+// Hosts is a static table of IP addresses indexed by host name, similar to
+// the content of /etc/hosts.
+//
+// The Resolve method can be installed as the NameResolver of a System so the
+// guest resolves the names of the table to fixed addresses, without querying
+// the host resolver.
+type Hosts map[string][]net.IP
+
+// ParseHosts parses a table of hosts in the format of /etc/hosts: each line
+// has an IP address followed by one or more host names, and text following
+// a '#' character is a comment.
+func ParseHosts(r io.Reader) (Hosts, error) {
+	hosts := make(Hosts)
+	s := bufio.NewScanner(r)
+	for lineno := 1; s.Scan(); lineno++ {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			return nil, fmt.Errorf("hosts line %d: invalid IP address %q", lineno, fields[0])
+		}
+		if len(fields) == 1 {
+			return nil, fmt.Errorf("hosts line %d: missing host name for %s", lineno, ip)
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(name)
+			hosts[name] = append(hosts[name], ip)
+		}
+	}
+	return hosts, s.Err()
+}
+
+// Resolve resolves names present in the hosts table. Names which are not in
+// the table, or which have no addresses of the family requested in hints,
+// are not handled.
+func (h Hosts) Resolve(ctx context.Context, name, service string, hints wasi.AddressInfo) ([]wasi.AddressInfo, bool, error) {
+	if hints.Flags.Has(wasi.NumericHost) {
+		return nil, false, nil
+	}
+	ips := make([]net.IP, 0, 8)
+	for _, ip := range h[strings.ToLower(strings.TrimSuffix(name, "."))] {
+		switch hints.Family {
+		case wasi.InetFamily:
+			if ip.To4() == nil {
+				continue
+			}
+		case wasi.Inet6Family:
+			if ip.To4() != nil {
+				continue
+			}
+		}
+		ips = append(ips, ip)
+	}
+	if len(ips) == 0 {
+		return nil, false, nil
+	}
+	network, errno := addressInfoNetwork(hints)
+	if errno != wasi.ESUCCESS {
+		return nil, true, errno
+	}
+	port, errno := addressInfoPort(ctx, network, service, hints)
+	if errno != wasi.ESUCCESS {
+		return nil, true, errno
+	}
+	return makeAddressInfos(ips, port, hints), true, nil
+}
@@ -2,6 +2,7 @@ package unix
 
 import (
 	"context"
+	"fmt"
 	"net/url"
 	"strings"
 
@@ -43,16 +44,18 @@ func (p *PathOpenSockets) PathOpen(ctx context.Context, fd wasi.FD, lookupFlags
 	}
 	errno := wasi.ESUCCESS
 	if err != nil {
-		errno = makeErrno(err)
+		errno = makeErrno(p.reportError("PathOpen", err))
 		if errno != wasi.EINPROGRESS {
 			return -1, errno
 		}
 	}
-	return p.Register(FD(sockfd), wasi.FDStat{
+	return p.RegisterOrigin(FD(sockfd), wasi.FDStat{
 		FileType:         wasi.SocketStreamType,
 		Flags:            fdFlags,
 		RightsBase:       rightsBase,
 		RightsInheriting: rightsInheriting,
+	}, func() string {
+		return fmt.Sprintf("PathOpen %q", path)
 	}), errno
 }
 
@@ -0,0 +1,193 @@
+package unix
+
+import (
+	"context"
+
+	"github.com/stealthrocket/wasi-go"
+	"golang.org/x/sys/unix"
+)
+
+// Snapshot returns the list of file descriptors open in the system, in
+// increasing order, with the metadata needed to reconstruct them with
+// Restore.
+//
+// Only the identity of the files is captured: the content of files, the
+// data buffered in sockets, and the state of directory iterators are not.
+func (s *System) Snapshot() []wasi.FDInfo {
+	var infos []wasi.FDInfo
+	s.Range(func(fd wasi.FD, f file, stat wasi.FDStat) bool {
+		info := wasi.FDInfo{FD: fd, Stat: stat}
+		info.Path, info.Preopen = s.LookupPreopen(fd)
+
+		switch stat.FileType {
+		case wasi.SocketStreamType, wasi.SocketDGramType:
+			if sa, err := ignoreEINTR2(func() (unix.Sockaddr, error) {
+				return unix.Getsockname(int(f.FD))
+			}); err == nil {
+				info.LocalAddress = makeSocketAddress(sa)
+			}
+			if sa, err := ignoreEINTR2(func() (unix.Sockaddr, error) {
+				return unix.Getpeername(int(f.FD))
+			}); err == nil {
+				info.RemoteAddress = makeSocketAddress(sa)
+			}
+			listening, err := ignoreEINTR2(func() (int, error) {
+				return unix.GetsockoptInt(int(f.FD), unix.SOL_SOCKET, unix.SO_ACCEPTCONN)
+			})
+			info.Listening = err == nil && listening != 0
+		default:
+			if !info.Preopen {
+				info.Path, _ = fdpath(int(f.FD))
+			}
+			if stat.FileType == wasi.RegularFileType {
+				if offset, err := ignoreEINTR2(func() (int64, error) {
+					return lseek(int(f.FD), 0, unix.SEEK_CUR)
+				}); err == nil {
+					info.Offset = wasi.FileSize(offset)
+				}
+			}
+		}
+
+		infos = append(infos, info)
+		return true
+	})
+	return infos
+}
+
+// Restore reconstructs a file descriptor captured by Snapshot, opening it at
+// the same guest file descriptor number.
+//
+// Files and directories are reopened at their host path, with the same
+// flags, rights and offset. Sockets are bound to the same local address, and
+// listening sockets start accepting connections again; datagram sockets are
+// reconnected to their remote address, but connected stream sockets cannot
+// be restored and yield ENOTSUP.
+//
+// Preopens are not reopened since the host recreates them when configuring
+// the system, Restore only verifies that a preopen with the same name exists
+// at the file descriptor number.
+func (s *System) Restore(ctx context.Context, info wasi.FDInfo) wasi.Errno {
+	if info.Preopen {
+		if path, ok := s.LookupPreopen(info.FD); !ok || path != info.Path {
+			return wasi.EBADF
+		}
+		return wasi.ESUCCESS
+	}
+	if _, _, errno := s.LookupFD(info.FD, 0); errno != wasi.EBADF {
+		return wasi.EEXIST
+	}
+
+	var fd wasi.FD
+	var errno wasi.Errno
+	switch info.Stat.FileType {
+	case wasi.SocketStreamType, wasi.SocketDGramType:
+		fd, errno = s.restoreSocket(ctx, info)
+	default:
+		fd, errno = s.restoreFile(ctx, info)
+	}
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	if fd != info.FD {
+		if errno := s.FDRenumber(ctx, fd, info.FD); errno != wasi.ESUCCESS {
+			s.FDClose(ctx, fd)
+			return errno
+		}
+	}
+	return wasi.ESUCCESS
+}
+
+func (s *System) restoreFile(ctx context.Context, info wasi.FDInfo) (wasi.FD, wasi.Errno) {
+	if info.Path == "" {
+		return -1, wasi.ENOENT
+	}
+	oflags := unix.O_CLOEXEC
+	switch {
+	case info.Stat.FileType == wasi.DirectoryType:
+		oflags |= unix.O_DIRECTORY | unix.O_RDONLY
+	case info.Stat.RightsBase.Has(wasi.FDReadRight | wasi.FDWriteRight):
+		oflags |= unix.O_RDWR
+	case info.Stat.RightsBase.Has(wasi.FDWriteRight):
+		oflags |= unix.O_WRONLY
+	default:
+		oflags |= unix.O_RDONLY
+	}
+	fd, err := ignoreEINTR2(func() (int, error) {
+		return unix.Open(info.Path, oflags, 0)
+	})
+	if err != nil {
+		return -1, makeErrno(s.reportError("Restore", err))
+	}
+	if errno := (file{FD: FD(fd), sys: s}).FDStatSetFlags(ctx, info.Stat.Flags); errno != wasi.ESUCCESS {
+		closeTraceEBADF(fd)
+		return -1, errno
+	}
+	if info.Stat.FileType == wasi.RegularFileType && info.Offset != 0 {
+		if _, err := ignoreEINTR2(func() (int64, error) {
+			return lseek(fd, int64(info.Offset), unix.SEEK_SET)
+		}); err != nil {
+			closeTraceEBADF(fd)
+			return -1, makeErrno(s.reportError("Restore", err))
+		}
+	}
+	return s.Register(FD(fd), info.Stat), wasi.ESUCCESS
+}
+
+func (s *System) restoreSocket(ctx context.Context, info wasi.FDInfo) (wasi.FD, wasi.Errno) {
+	if info.LocalAddress == nil {
+		return -1, wasi.EINVAL
+	}
+	socketType := wasi.StreamSocket
+	if info.Stat.FileType == wasi.SocketDGramType {
+		socketType = wasi.DatagramSocket
+	}
+	if socketType == wasi.StreamSocket && info.RemoteAddress != nil {
+		return -1, wasi.ENOTSUP
+	}
+	// The socket is opened with all rights so it can be bound and connected,
+	// the rights are then reduced to those of the original socket.
+	fd, errno := s.SockOpen(ctx, info.LocalAddress.Family(), socketType, 0, wasi.AllRights, wasi.AllRights)
+	if errno != wasi.ESUCCESS {
+		return -1, errno
+	}
+	if errno := s.restoreSocketState(ctx, fd, info); errno != wasi.ESUCCESS {
+		s.FDClose(ctx, fd)
+		return -1, errno
+	}
+	return fd, wasi.ESUCCESS
+}
+
+func (s *System) restoreSocketState(ctx context.Context, fd wasi.FD, info wasi.FDInfo) wasi.Errno {
+	if isBound(info.LocalAddress) {
+		if _, errno := s.SockBind(ctx, fd, info.LocalAddress); errno != wasi.ESUCCESS {
+			return errno
+		}
+	}
+	if info.Listening {
+		if errno := s.SockListen(ctx, fd, unix.SOMAXCONN); errno != wasi.ESUCCESS {
+			return errno
+		}
+	}
+	if info.RemoteAddress != nil {
+		if _, errno := s.SockConnect(ctx, fd, info.RemoteAddress); errno != wasi.ESUCCESS {
+			return errno
+		}
+	}
+	if errno := s.FDStatSetFlags(ctx, fd, info.Stat.Flags); errno != wasi.ESUCCESS {
+		return errno
+	}
+	return s.FDStatSetRights(ctx, fd, info.Stat.RightsBase, info.Stat.RightsInheriting)
+}
+
+func isBound(addr wasi.SocketAddress) bool {
+	switch a := addr.(type) {
+	case *wasi.Inet4Address:
+		return a.Port != 0
+	case *wasi.Inet6Address:
+		return a.Port != 0
+	case *wasi.UnixAddress:
+		return a.Name != "" && a.Name != "@"
+	default:
+		return false
+	}
+}
@@ -1,27 +1,62 @@
 package unix
 
 import (
+	"math"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/stealthrocket/wasi-go"
 	"golang.org/x/sys/unix"
 )
 
+// Darwin has no abstract namespace for unix sockets, names starting with @
+// would create files in the current directory instead.
+const abstractUnixSockets = false
+
+// Darwin has no MSG_NOSIGNAL, SIGPIPE is disabled when sockets are created
+// instead.
+const msgNoSignal = 0
+
+func setNoSigPipe(fd int) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_NOSIGPIPE, 1)
+}
+
+// openBeneath is not supported on darwin, paths are resolved with resolvePath
+// instead.
+func openBeneath(dirfd int, path string, flags int, mode uint32) (int, error) {
+	return -1, unix.ENOSYS
+}
+
 func accept(socket, flags int) (int, unix.Sockaddr, error) {
 	conn, addr, err := acceptCloseOnExec(socket)
 	if err != nil {
 		return -1, addr, err
 	}
-	if (flags & unix.O_NONBLOCK) != 0 {
-		if err := unix.SetNonblock(conn, true); err != nil {
-			closeTraceEBADF(conn)
-			return -1, addr, err
-		}
+	if err := setNoSigPipe(conn); err != nil {
+		closeTraceEBADF(conn)
+		return -1, addr, err
+	}
+	// Unlike Linux, accepted sockets inherit O_NONBLOCK from the listening
+	// socket on Darwin, the mode must always be set explicitly.
+	if err := unix.SetNonblock(conn, (flags&unix.O_NONBLOCK) != 0); err != nil {
+		closeTraceEBADF(conn)
+		return -1, addr, err
 	}
 	return conn, addr, nil
 }
 
+func socket(domain, typ, proto int) (int, error) {
+	syscall.ForkLock.Lock()
+	defer syscall.ForkLock.Unlock()
+	fd, err := unix.Socket(domain, typ, proto)
+	if err != nil {
+		return -1, err
+	}
+	unix.CloseOnExec(fd)
+	return fd, nil
+}
+
 func acceptCloseOnExec(socket int) (int, unix.Sockaddr, error) {
 	syscall.ForkLock.Lock()
 	defer syscall.ForkLock.Unlock()
@@ -35,6 +70,31 @@ func acceptCloseOnExec(socket int) (int, unix.Sockaddr, error) {
 	return conn, addr, nil
 }
 
+func copyFileRange(src int, srcOffset int64, dst int, dstOffset int64, length int64) (int64, error) {
+	// Darwin has no system call to copy ranges of files, copyfile(3) only
+	// copies whole files.
+	return copyRangeFallback(src, srcOffset, dst, dstOffset, length)
+}
+
+// splicePipe is unused on Darwin, which has no splice(2) system call.
+type splicePipe struct{}
+
+func (p *splicePipe) splice(in, out, length int) (int, error) {
+	return spliceFallback(in, out, length)
+}
+
+func (p *splicePipe) close() {}
+
+func poll(fds []unix.PollFd, timeout time.Duration) (int, error) {
+	// Darwin has no ppoll(2), the timeout is rounded up to the next
+	// millisecond so poll(2) does not return before it expired.
+	timeoutMillis := -1
+	if timeout >= 0 {
+		timeoutMillis = int((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+	return unix.Poll(fds, timeoutMillis)
+}
+
 func pipe(fds []int, flags int) error {
 	if err := pipeCloseOnExec(fds); err != nil {
 		return err
@@ -74,6 +134,29 @@ func closePipe(fds []int) {
 const (
 	__UTIME_NOW  = -1
 	__UTIME_OMIT = -2
+
+	// Darwin does not define O_RSYNC, synchronized reads are approximated
+	// with O_SYNC.
+	__O_RSYNC = unix.O_SYNC
+
+	// Darwin has no O_PATH, it is unused since openBeneath is not supported.
+	__O_PATH = 0
+
+	// Darwin has no O_TMPFILE, temporary files are created with a random
+	// name and unlinked.
+	__O_TMPFILE = 0
+
+	// Darwin has no TCP_CORK, TCP_NOPUSH is the BSD equivalent.
+	__TCP_CORK = unix.TCP_NOPUSH
+
+	// Darwin only supports TCP Fast Open on the client side with connectx(2),
+	// there is no socket option nor sendto(2) flag.
+	__TCP_FASTOPEN_CONNECT = -1
+	__MSG_FASTOPEN         = 0
+
+	// Darwin has no POLLRDHUP, sockets shut down by the peer are only
+	// reported as readable.
+	__POLLRDHUP = 0
 )
 
 func prepareTimesAndAttrs(ts *[2]unix.Timespec) (attrs, size int, times [2]unix.Timespec) {
@@ -89,6 +172,13 @@ func prepareTimesAndAttrs(ts *[2]unix.Timespec) (attrs, size int, times [2]unix.
 		times[i] = ts[0]
 		i++
 	}
+	// Unlike utimensat, setattrlist has no equivalent of UTIME_NOW, the
+	// current time is read from the realtime clock instead.
+	for j := range times[:i] {
+		if times[j].Nsec == __UTIME_NOW {
+			unix.ClockGettime(unix.CLOCK_REALTIME, &times[j])
+		}
+	}
 	return attrs, i * sizeOfTimespec, times
 }
 
@@ -130,7 +220,29 @@ func appendBytes(vecs []unix.Iovec, bs [][]byte) []unix.Iovec {
 }
 
 func fdadvise(fd int, offset, length int64, advice wasi.Advice) error {
-	// Since posix_fadvise is not available, just ignore the hint.
+	// posix_fadvise is not available, the closest equivalent is F_RDADVISE
+	// which starts reading ahead the given range of the file. Other hints
+	// are ignored.
+	switch advice {
+	case wasi.Normal, wasi.Sequential, wasi.Random, wasi.DontNeed, wasi.NoReuse:
+		return nil
+	case wasi.WillNeed:
+	default:
+		return wasi.EINVAL
+	}
+	if offset < 0 || length < 0 {
+		return wasi.EINVAL
+	}
+	// A length of zero means until the end of the file, the count of
+	// F_RDADVISE is limited to 32 bits.
+	if length == 0 || length > math.MaxInt32 {
+		length = math.MaxInt32
+	}
+	ra := unix.Radvisory_t{Offset: offset, Count: int32(length)}
+	_, _, err := unix.Syscall(unix.SYS_FCNTL, uintptr(fd), unix.F_RDADVISE, uintptr(unsafe.Pointer(&ra)))
+	if err != 0 {
+		return err
+	}
 	return nil
 }
 
@@ -243,6 +355,29 @@ func pwritev(fd int, iovs [][]byte, offset int64) (int, error) {
 	return written, nil
 }
 
+func fdpath(fd int) (string, error) {
+	var buf [unix.PathMax]byte
+	_, _, err := unix.Syscall(unix.SYS_FCNTL, uintptr(fd), unix.F_GETPATH, uintptr(unsafe.Pointer(&buf[0])))
+	if err != 0 {
+		return "", err
+	}
+	return unix.ByteSliceToString(buf[:]), nil
+}
+
 func getsocketdomain(fd int) (int, error) {
 	return 0, unix.ENOSYS
 }
+
+// getrandom(2) does not exist on Darwin.
+func getrandom(b []byte) (int, error) {
+	return 0, unix.ENOSYS
+}
+
+// Darwin has no eventfd, a pipe is used to interrupt PollOneOff.
+func openWaker() (waker, error) { return openPipeWaker() }
+
+// linkFD is not supported on Darwin, which cannot create links to files
+// from their descriptor.
+func linkFD(fd, dirfd int, name string) error {
+	return unix.ENOTSUP
+}
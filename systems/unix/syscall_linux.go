@@ -1,6 +1,11 @@
 package unix
 
 import (
+	"encoding/binary"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/stealthrocket/wasi-go"
@@ -10,8 +15,73 @@ import (
 const (
 	__UTIME_NOW  = unix.UTIME_NOW
 	__UTIME_OMIT = unix.UTIME_OMIT
+
+	__O_RSYNC = unix.O_RSYNC
+	__O_PATH  = unix.O_PATH
+
+	__O_TMPFILE = unix.O_TMPFILE
+
+	__TCP_CORK = unix.TCP_CORK
+
+	__TCP_FASTOPEN_CONNECT = unix.TCP_FASTOPEN_CONNECT
+	__MSG_FASTOPEN         = unix.MSG_FASTOPEN
+
+	__POLLRDHUP = unix.POLLRDHUP
 )
 
+// abstractUnixSockets is true on Linux, where unix sockets can be bound in the
+// abstract namespace instead of the file system.
+const abstractUnixSockets = true
+
+// msgNoSignal is passed when sending on sockets so the process does not
+// receive SIGPIPE if the peer is gone; the guest gets EPIPE instead.
+const msgNoSignal = unix.MSG_NOSIGNAL
+
+// setNoSigPipe is a no-op on Linux, where sends pass MSG_NOSIGNAL.
+func setNoSigPipe(fd int) error {
+	return nil
+}
+
+// openat2Unsupported is set when openat2(2) returned ENOSYS, which happens on
+// kernels older than 5.6.
+var openat2Unsupported atomic.Bool
+
+// openBeneath opens path relative to dirfd with openat2(2), letting the kernel
+// reject any resolution escaping the directory, including through ".." or
+// symbolic links. Escapes are reported as EPERM.
+//
+// ENOSYS is returned if openat2(2) is not supported, in which case the caller
+// must fall back to resolving the path with resolvePath.
+func openBeneath(dirfd int, path string, flags int, mode uint32) (int, error) {
+	if openat2Unsupported.Load() {
+		return -1, unix.ENOSYS
+	}
+	how := unix.OpenHow{
+		Flags:   uint64(flags),
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	// The mode must only be set when a file may be created, openat2(2) fails
+	// with EINVAL otherwise. O_TMPFILE includes the O_DIRECTORY bit, so it is
+	// only set if all its bits are.
+	if (flags&unix.O_CREAT) != 0 || (flags&unix.O_TMPFILE) == unix.O_TMPFILE {
+		how.Mode = uint64(mode)
+	}
+	for {
+		fd, err := unix.Openat2(dirfd, path, &how)
+		switch err {
+		case unix.EINTR, unix.EAGAIN:
+			// EAGAIN is returned when a concurrent rename prevented the
+			// kernel from verifying that ".." did not escape the directory.
+			continue
+		case unix.EXDEV:
+			err = unix.EPERM
+		case unix.ENOSYS:
+			openat2Unsupported.Store(true)
+		}
+		return fd, err
+	}
+}
+
 func accept(socket, flags int) (int, unix.Sockaddr, error) {
 	return unix.Accept4(socket, flags|unix.O_CLOEXEC)
 }
@@ -20,6 +90,21 @@ func pipe(fds []int, flags int) error {
 	return unix.Pipe2(fds, flags|unix.O_CLOEXEC)
 }
 
+func socket(domain, typ, proto int) (int, error) {
+	return unix.Socket(domain, typ|unix.SOCK_CLOEXEC, proto)
+}
+
+func poll(fds []unix.PollFd, timeout time.Duration) (int, error) {
+	// ppoll(2) accepts timeouts with a nanosecond resolution, which allows
+	// waiting for sub-millisecond clock subscriptions.
+	var ts *unix.Timespec
+	if timeout >= 0 {
+		t := unix.NsecToTimespec(int64(timeout))
+		ts = &t
+	}
+	return unix.Ppoll(fds, ts, nil)
+}
+
 func futimens(fd int, ts *[2]unix.Timespec) error {
 	// https://github.com/bminor/glibc/blob/master/sysdeps/unix/sysv/linux/futimens.c
 	_, _, err := unix.Syscall6(
@@ -90,6 +175,149 @@ func pwritev(fd int, iovs [][]byte, offset int64) (int, error) {
 	return unix.Pwritev(fd, iovs, offset)
 }
 
+func copyFileRange(src int, srcOffset int64, dst int, dstOffset int64, length int64) (int64, error) {
+	copied := int64(0)
+	for copied < length {
+		n, err := ignoreEINTR2(func() (int, error) {
+			return unix.CopyFileRange(src, &srcOffset, dst, &dstOffset, int(min(length-copied, maxCopyRangeSize)), 0)
+		})
+		switch err {
+		case nil:
+		case unix.EXDEV, unix.ENOSYS, unix.EOPNOTSUPP, unix.EINVAL:
+			// The kernel cannot copy between these files (e.g. they are on
+			// different file systems, or one is not a regular file), fall
+			// back to copying the data through user space.
+			if copied == 0 {
+				return copyRangeFallback(src, srcOffset, dst, dstOffset, length)
+			}
+			return copied, nil
+		default:
+			return copyRangeResult(copied, err)
+		}
+		if n == 0 {
+			break
+		}
+		copied += int64(n)
+	}
+	return copied, nil
+}
+
+// splicePipe is the pipe through which data is moved between sockets with
+// splice(2), which requires one end of each transfer to be a pipe. The pipe
+// is always empty between calls to splice.
+type splicePipe struct {
+	fds  [2]int
+	size int
+}
+
+func (p *splicePipe) splice(in, out, length int) (int, error) {
+	if p.size == 0 {
+		// The pipe must be blocking, splice(2) would otherwise not block
+		// on the input socket either.
+		if err := pipe(p.fds[:], 0); err != nil {
+			return 0, err
+		}
+		size, err := unix.FcntlInt(uintptr(p.fds[0]), unix.F_GETPIPE_SZ, 0)
+		if err != nil {
+			unix.Close(p.fds[0])
+			unix.Close(p.fds[1])
+			return 0, err
+		}
+		p.size = size
+	}
+	// The pipe is empty, and transfers are limited to its capacity, so the
+	// call only blocks if the input socket is in blocking mode.
+	n, err := ignoreEINTR2(func() (int64, error) {
+		return unix.Splice(in, nil, p.fds[1], nil, min(length, p.size), unix.SPLICE_F_MOVE)
+	})
+	if err != nil {
+		if err == unix.EINVAL {
+			// The socket does not support splicing.
+			return spliceFallback(in, out, length)
+		}
+		return 0, err
+	}
+	for moved := int64(0); moved < n; {
+		m, err := unix.Splice(p.fds[0], nil, out, nil, int(n-moved), unix.SPLICE_F_MOVE)
+		switch err {
+		case nil:
+			moved += m
+		case unix.EINTR:
+		case unix.EAGAIN:
+			err = waitWritable(out)
+		}
+		if err != nil && err != unix.EINTR {
+			// The data left in the pipe cannot be delivered; the pipe is
+			// discarded so it does not get sent on the next call. The data
+			// that was already delivered is reported like a short write.
+			p.close()
+			if moved > 0 {
+				return int(moved), nil
+			}
+			return 0, err
+		}
+	}
+	return int(n), nil
+}
+
+func (p *splicePipe) close() {
+	if p.size != 0 {
+		unix.Close(p.fds[0])
+		unix.Close(p.fds[1])
+		p.size = 0
+	}
+}
+
+func fdpath(fd int) (string, error) {
+	return os.Readlink("/proc/self/fd/" + strconv.Itoa(fd))
+}
+
 func getsocketdomain(fd int) (int, error) {
 	return unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN)
 }
+
+func getrandom(b []byte) (int, error) {
+	return unix.Getrandom(b, unix.GRND_RANDOM)
+}
+
+// On Linux, an eventfd is used to interrupt PollOneOff, which only needs a
+// single file descriptor instead of the two ends of a pipe.
+func openWaker() (waker, error) { return openEventfdWaker() }
+
+type eventfdWaker struct{ fd int }
+
+func openEventfdWaker() (*eventfdWaker, error) {
+	fd, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	return &eventfdWaker{fd}, nil
+}
+
+func (w *eventfdWaker) pollfd() int { return w.fd }
+
+func (w *eventfdWaker) wake() error {
+	// The counter is never read, the eventfd remains readable after the first
+	// call. EAGAIN is only returned if the counter would overflow.
+	var b [8]byte
+	binary.NativeEndian.PutUint64(b[:], 1)
+	_, err := ignoreEINTR2(func() (int, error) {
+		return unix.Write(w.fd, b[:])
+	})
+	if err == unix.EAGAIN {
+		err = nil
+	}
+	return err
+}
+
+func (w *eventfdWaker) close() error {
+	return closeTraceEBADF(w.fd)
+}
+
+// linkFD creates a link named name in dirfd to the file open on fd, which
+// may be an unnamed file created with O_TMPFILE. Linking with AT_EMPTY_PATH
+// requires CAP_DAC_READ_SEARCH, the file is linked through its entry in
+// /proc/self/fd instead.
+func linkFD(fd, dirfd int, name string) error {
+	return unix.Linkat(unix.AT_FDCWD, "/proc/self/fd/"+strconv.Itoa(fd), dirfd, name, unix.AT_SYMLINK_FOLLOW)
+}
@@ -1,7 +1,9 @@
 package unix
 
 import (
+	"math"
 	"runtime/debug"
+	"time"
 	"unsafe"
 
 	"github.com/stealthrocket/wasi-go"
@@ -47,6 +49,67 @@ func handleEINTR(f func() (int, error)) (int, error) {
 	}
 }
 
+// connect(2) must not be restarted when it is interrupted by a signal: the
+// connection keeps being established asynchronously, and calling connect(2)
+// again would fail with EALREADY. Instead, the function waits for the socket
+// to become writable and reports the outcome of the connection, the same way
+// an application would for a non-blocking socket which returned EINPROGRESS.
+func connect(fd int, sa unix.Sockaddr) error {
+	err := unix.Connect(fd, sa)
+	if err != unix.EINTR {
+		return err
+	}
+	return waitConnect(fd, -1)
+}
+
+// connectTimeout connects a blocking socket, failing with ETIMEDOUT if the
+// connection was not established after the timeout. The socket is put in
+// non-blocking mode for the duration of the call so the wait can be bounded
+// with poll(2), the same way an application would do it.
+func connectTimeout(fd int, sa unix.Sockaddr, timeout time.Duration) error {
+	if err := unix.SetNonblock(fd, true); err != nil {
+		return err
+	}
+	defer unix.SetNonblock(fd, false)
+
+	err := unix.Connect(fd, sa)
+	if err != unix.EINPROGRESS && err != unix.EINTR {
+		return err
+	}
+	return waitConnect(fd, timeout)
+}
+
+// waitConnect waits for a connection in progress on the socket to complete,
+// or for the timeout to expire if it is not negative.
+func waitConnect(fd int, timeout time.Duration) error {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+	deadline := time.Now().Add(timeout)
+	for {
+		n, err := poll(fds, timeout)
+		if err == unix.EINTR {
+			if timeout >= 0 {
+				timeout = max(time.Until(deadline), 0)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return unix.ETIMEDOUT
+		}
+		break
+	}
+	errno, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return unix.Errno(errno)
+	}
+	return nil
+}
+
 func closeTraceEBADF(fd int) error {
 	if fd < 0 {
 		return unix.EBADF
@@ -65,17 +128,74 @@ func makeErrno(err error) wasi.Errno {
 	return wasi.MakeErrno(err)
 }
 
-func makeFileStat(s *unix.Stat_t) wasi.FileStat {
+// makeSendErrno is like makeErrno for errors of sending data, normalizing the
+// errors reported when the peer of a stream socket is gone so they are the
+// same on all platforms: Linux returns ECONNRESET on the first send after the
+// peer reset the connection and EPIPE afterwards, while Darwin may return
+// EPROTOTYPE when the connection is being torn down. All cases are reported
+// as EPIPE.
+//
+// Errors of connected datagram sockets (e.g. ECONNREFUSED when the peer port
+// is unreachable) are already consistent and are not modified.
+func makeSendErrno(err error) wasi.Errno {
+	switch err {
+	case unix.ECONNRESET, unix.EPROTOTYPE:
+		return wasi.EPIPE
+	default:
+		return makeErrno(err)
+	}
+}
+
+// sendfile sends count bytes of the file in starting at offset on the socket
+// out. Darwin reports the number of bytes sent when the call is interrupted
+// or would block after a partial transfer, which are returned as successful
+// partial writes like on Linux.
+func sendfile(out, in int, offset int64, count int) (int, error) {
+	n, err := unix.Sendfile(out, in, &offset, count)
+	if n < 0 {
+		n = 0
+	}
+	if n > 0 && (err == unix.EAGAIN || err == unix.EINTR) {
+		err = nil
+	}
+	return n, err
+}
+
+// makeFileStat converts the result of stat(2) to a wasi.FileStat. EOVERFLOW
+// is returned if a field cannot be represented, which is the case of negative
+// sizes and of timestamps after the year 2554. Timestamps before the Unix
+// epoch are clamped to zero.
+func makeFileStat(s *unix.Stat_t) (wasi.FileStat, error) {
+	accessTime, ok1 := makeTimestamp(s.Atim)
+	modifyTime, ok2 := makeTimestamp(s.Mtim)
+	changeTime, ok3 := makeTimestamp(s.Ctim)
+	if !ok1 || !ok2 || !ok3 || s.Size < 0 {
+		return wasi.FileStat{}, unix.EOVERFLOW
+	}
 	return wasi.FileStat{
 		FileType:   makeFileType(uint32(s.Mode)),
 		Device:     wasi.Device(s.Dev),
 		INode:      wasi.INode(s.Ino),
 		NLink:      wasi.LinkCount(s.Nlink),
 		Size:       wasi.FileSize(s.Size),
-		AccessTime: wasi.Timestamp(s.Atim.Nano()),
-		ModifyTime: wasi.Timestamp(s.Mtim.Nano()),
-		ChangeTime: wasi.Timestamp(s.Ctim.Nano()),
+		AccessTime: accessTime,
+		ModifyTime: modifyTime,
+		ChangeTime: changeTime,
+	}, nil
+}
+
+// makeTimestamp converts ts to a number of nanoseconds since the Unix epoch,
+// returning false if it does not fit in a wasi.Timestamp. Times before the
+// epoch, which WASI cannot represent, are clamped to zero.
+func makeTimestamp(ts unix.Timespec) (wasi.Timestamp, bool) {
+	sec, nsec := ts.Unix()
+	if sec < 0 || nsec < 0 {
+		return 0, true
+	}
+	if uint64(sec) > (math.MaxUint64-uint64(nsec))/1e9 {
+		return 0, false
 	}
+	return wasi.Timestamp(uint64(sec)*1e9 + uint64(nsec)), true
 }
 
 func makeFileType(mode uint32) wasi.FileType {
@@ -91,9 +211,11 @@ func makeFileType(mode uint32) wasi.FileType {
 	case unix.S_IFLNK: // symbolic link
 		return wasi.SymbolicLinkType
 	case unix.S_IFSOCK: // socket
-		return wasi.SocketStreamType // or wasi.SocketDGramType?
+		// The type of socket is not known from the mode, FDFileStatGet
+		// refines it with SO_TYPE when it has a file descriptor.
+		return wasi.SocketStreamType
 	default:
-		// e.g. S_IFIFO, S_IFWHT
+		// e.g. S_IFIFO, S_IFWHT, which have no equivalent in WASI
 		return wasi.UnknownType
 	}
 }
@@ -103,3 +225,218 @@ var _ []byte = (wasi.IOVec)(nil)
 func makeIOVecs(iovecs []wasi.IOVec) [][]byte {
 	return *(*[][]byte)(unsafe.Pointer(&iovecs))
 }
+
+// iovMax is the maximum number of buffers accepted by the vectored I/O
+// system calls (IOV_MAX is 1024 on both Linux and darwin).
+const iovMax = 1024
+
+// splitIOVecs calls f with batches of at most iovMax buffers, stopping at the
+// first short transfer. If an error occurs after some bytes were transferred,
+// the error is dropped and the partial count is returned, as would happen with
+// a single system call.
+//
+// Transfers of zero bytes complete immediately without making a system call,
+// as readv(2) and writev(2) would on a regular file, so that they never block
+// or fail on other types of files.
+func splitIOVecs(iovecs [][]byte, f func([][]byte) (int, error)) (int, error) {
+	if iovecsSize(iovecs) == 0 {
+		return 0, nil
+	}
+	if len(iovecs) <= iovMax {
+		return f(iovecs)
+	}
+	total := 0
+	for len(iovecs) > 0 {
+		batch := iovecs[:min(len(iovecs), iovMax)]
+		iovecs = iovecs[len(batch):]
+
+		n, err := f(batch)
+		if err != nil {
+			if total > 0 {
+				return total, nil
+			}
+			return n, err
+		}
+		total += n
+
+		if n < iovecsSize(batch) {
+			break
+		}
+	}
+	return total, nil
+}
+
+func iovecsSize(iovecs [][]byte) (size int) {
+	for _, iov := range iovecs {
+		size += len(iov)
+	}
+	return size
+}
+
+// makeSendFlags converts WASI send flags to the flags of sendmsg(2).
+func makeSendFlags(flags wasi.SIFlags) int {
+	sysFlags := msgNoSignal
+	if flags.Has(wasi.SendDontWait) {
+		sysFlags |= unix.MSG_DONTWAIT
+	}
+	if flags.Has(wasi.SendOOB) {
+		sysFlags |= unix.MSG_OOB
+	}
+	return sysFlags
+}
+
+// makeRecvFlags converts WASI receive flags to the flags of recvmsg(2).
+func makeRecvFlags(flags wasi.RIFlags) int {
+	var sysFlags int
+	if flags.Has(wasi.RecvPeek) {
+		sysFlags |= unix.MSG_PEEK
+	}
+	if flags.Has(wasi.RecvWaitAll) {
+		sysFlags |= unix.MSG_WAITALL
+	}
+	if flags.Has(wasi.RecvDontWait) {
+		sysFlags |= unix.MSG_DONTWAIT
+	}
+	if flags.Has(wasi.RecvOOB) {
+		sysFlags |= unix.MSG_OOB
+	}
+	return sysFlags
+}
+
+// sendmsg wraps unix.SendmsgBuffers, which returns a size of zero instead of -1
+// when the system call fails. The size is adjusted so errors such as EAGAIN
+// are reported the same way as by the other system calls (e.g. recvmsg(2)).
+func sendmsg(fd int, iovs [][]byte, flags int) (int, error) {
+	n, err := unix.SendmsgBuffers(fd, iovs, nil, nil, flags)
+	if err != nil {
+		return -1, err
+	}
+	return n, nil
+}
+
+// maxCopyRangeSize is the maximum number of bytes copied by a single system
+// call when copying ranges of files.
+const maxCopyRangeSize = 1 << 30
+
+// copyRangeFallback copies a range of bytes between two files with pread(2)
+// and pwrite(2), for platforms or files which do not support copying ranges
+// in the kernel.
+func copyRangeFallback(src int, srcOffset int64, dst int, dstOffset int64, length int64) (int64, error) {
+	buf := make([]byte, min(length, 256*1024))
+	copied := int64(0)
+	for copied < length {
+		b := buf[:min(int64(len(buf)), length-copied)]
+		n, err := handleEINTR(func() (int, error) {
+			return unix.Pread(src, b, srcOffset+copied)
+		})
+		if err != nil {
+			return copyRangeResult(copied, err)
+		}
+		if n == 0 {
+			break
+		}
+		for w := 0; w < n; {
+			m, err := handleEINTR(func() (int, error) {
+				return unix.Pwrite(dst, b[w:n], dstOffset+copied+int64(w))
+			})
+			if err != nil {
+				return copyRangeResult(copied+int64(w), err)
+			}
+			w += m
+		}
+		copied += int64(n)
+	}
+	return copied, nil
+}
+
+// maxSpliceBufferSize is the size of the buffer used to move data between
+// sockets on platforms which cannot do it in the kernel.
+const maxSpliceBufferSize = 64 * 1024
+
+// spliceFallback moves up to length bytes from the socket in to the socket out
+// by reading them into a buffer, then writing the buffer in full.
+func spliceFallback(in, out, length int) (int, error) {
+	buf := make([]byte, min(length, maxSpliceBufferSize))
+	n, err := handleEINTR(func() (int, error) {
+		return unix.Read(in, buf)
+	})
+	if err != nil {
+		return 0, err
+	}
+	for w := 0; w < n; {
+		m, err := handleEINTR(func() (int, error) {
+			return unix.Write(out, buf[w:n])
+		})
+		switch err {
+		case nil:
+			w += m
+		case unix.EAGAIN:
+			err = waitWritable(out)
+		}
+		if err != nil {
+			// Like with a short write, the error is only reported if no
+			// data was delivered.
+			if w > 0 {
+				return w, nil
+			}
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// waitWritable blocks until the file descriptor is ready for writing.
+func waitWritable(fd int) error {
+	pollfds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+	return ignoreEINTR(func() error {
+		_, err := unix.Poll(pollfds, -1)
+		return err
+	})
+}
+
+// copyRangeResult reports partial copies as successful, the error is only
+// returned if no data was copied.
+func copyRangeResult(copied int64, err error) (int64, error) {
+	if copied > 0 {
+		return copied, nil
+	}
+	return 0, err
+}
+
+// waker is the file descriptor that Shutdown uses to interrupt calls to
+// PollOneOff blocked in poll(2). Once woken up, the file descriptor remains
+// readable until it is closed.
+type waker interface {
+	pollfd() int
+	wake() error
+	close() error
+}
+
+type pipeWaker struct{ fds [2]int }
+
+func openPipeWaker() (*pipeWaker, error) {
+	w := new(pipeWaker)
+	if err := pipe(w.fds[:], unix.O_NONBLOCK); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *pipeWaker) pollfd() int { return w.fds[0] }
+
+func (w *pipeWaker) wake() error {
+	// The pipe is never read, it remains readable after the first call.
+	// EAGAIN is only returned if the pipe buffer is full.
+	_, err := ignoreEINTR2(func() (int, error) {
+		return unix.Write(w.fds[1], []byte{0})
+	})
+	if err == unix.EAGAIN {
+		err = nil
+	}
+	return err
+}
+
+func (w *pipeWaker) close() error {
+	closeTraceEBADF(w.fds[1])
+	return closeTraceEBADF(w.fds[0])
+}
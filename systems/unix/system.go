@@ -3,10 +3,13 @@ package unix
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"math"
 	"net"
-	"os"
 	"runtime"
+	"slices"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -18,6 +21,11 @@ import (
 
 // System is a WASI preview 1 implementation for Unix.
 //
+// File descriptors are always opened on the host with close-on-exec set. WASI
+// has no equivalent of FD_CLOEXEC since guests cannot spawn processes, so the
+// flag is not visible to guests and cannot be changed by them: FDStatGet only
+// reports the flags of wasi.FDFlags.
+//
 // An instance of System is not safe for concurrent use.
 type System struct {
 	// Args are the environment variables accessible via ArgsGet.
@@ -26,6 +34,17 @@ type System struct {
 	// Environ is the environment variables accessible via EnvironGet.
 	Environ []string
 
+	// ArgsFunc, if set, is called to compute the arguments instead of using
+	// Args. The result of the call made by ArgsSizesGet is returned by the
+	// next call to ArgsGet, so the guest observes the same arguments when
+	// sizing its buffers and reading the values.
+	ArgsFunc func() []string
+
+	// EnvironFunc, if set, is called to compute the environment variables
+	// instead of using Environ, with the same guarantees as ArgsFunc for
+	// EnvironSizesGet and EnvironGet.
+	EnvironFunc func() []string
+
 	// Realtime returns the realtime clock value.
 	Realtime          func(context.Context) (uint64, error)
 	RealtimePrecision time.Duration
@@ -35,7 +54,7 @@ type System struct {
 	MonotonicPrecision time.Duration
 
 	// Yield is called when SchedYield is called. If Yield is nil,
-	// SchedYield is a noop.
+	// SchedYield calls runtime.Gosched to let other goroutines run.
 	Yield func(context.Context) error
 
 	// Exit is called with an exit code when ProcExit is called.
@@ -49,36 +68,181 @@ type System struct {
 	// Rand is the source for RandomGet.
 	Rand io.Reader
 
-	wasi.FileTable[FD]
+	// AllowRawSockets permits SockOpen to create raw sockets, which usually
+	// requires the host process to be privileged. When false, which is the
+	// default, opening raw sockets fails with EPERM.
+	AllowRawSockets bool
+
+	// CrossDeviceRename enables PathRename to move regular files between
+	// directories on different file systems, which the host rejects with
+	// EXDEV, by copying the file to the target and unlinking the source.
+	// The move is not atomic, the file may be observed at both locations
+	// or left at both if the source cannot be unlinked. Directories cannot
+	// be moved and still fail with EXDEV.
+	CrossDeviceRename bool
+
+	// CreateFileMode is the permission mode of files created by PathOpen
+	// with OpenCreate, before Umask is applied. Zero means 0644.
+	CreateFileMode fs.FileMode
+
+	// Umask is a set of permission bits cleared from the mode of files
+	// created by PathOpen, like the process umask but without modifying it.
+	// The process umask is still applied by the host.
+	Umask fs.FileMode
+
+	// NameResolver is consulted by SockAddressInfo before resolving names
+	// with the host resolver. If it returns handled=true, its results are
+	// returned to the guest and the host resolver is not used; errors are
+	// reported to the guest as ECANCELED, unless they are wasi.Errno values.
+	// Hosts.Resolve can be used as a name resolver.
+	NameResolver func(ctx context.Context, name, service string, hints wasi.AddressInfo) (results []wasi.AddressInfo, handled bool, err error)
+
+	// NetworkPolicy restricts the addresses that sockets may be bound to,
+	// connected to, or send datagrams to. Addresses rejected by the policy
+	// fail with EACCES. The default is to allow all addresses.
+	NetworkPolicy NetworkPolicy
+
+	// DialPolicy is consulted by SockConnect and SockSendTo with the
+	// destination address, after the NetworkPolicy permitted it. When the
+	// policy returns an error, the operation fails with that error and
+	// no data is sent to the address.
+	DialPolicy wasi.DialPolicy
+
+	// ConnectTimeout bounds the time that SockConnect waits for connections
+	// of blocking sockets to be established, after which it fails with
+	// ETIMEDOUT. Zero, the default, waits until the system gives up, which
+	// may take minutes when the peer does not respond. Non-blocking sockets
+	// are not affected.
+	ConnectTimeout time.Duration
+
+	// OnError, if set, is called with the name of the method and the error
+	// returned by the host when a system call fails, before the error is
+	// converted to the errno returned to the guest. It allows embedders to
+	// log diagnostics that errno values do not carry. EAGAIN and EINPROGRESS
+	// are not reported since they are expected on non-blocking descriptors.
+	OnError func(op string, err error)
+
+	wasi.FileTable[file]
+
+	args    stringsSnapshot
+	environ stringsSnapshot
 
 	pollfds []unix.PollFd
 	inet4   unix.SockaddrInet4
 	inet6   unix.SockaddrInet6
 	unix    unix.SockaddrUnix
 
+	splice splicePipe
+
 	mutex sync.Mutex
-	wake  [2]*os.File
+	wake  waker
 	shut  atomic.Bool
 }
 
 var _ wasi.System = (*System)(nil)
 
+// Preopen registers the host file descriptor fd as a preopen named path,
+// returning the file descriptor of the guest.
+func (s *System) Preopen(fd FD, path string, stat wasi.FDStat) wasi.FD {
+	return s.FileTable.Preopen(file{FD: fd, sys: s}, path, stat)
+}
+
+// Register registers the host file descriptor fd, returning the file
+// descriptor of the guest.
+func (s *System) Register(fd FD, stat wasi.FDStat) wasi.FD {
+	return s.FileTable.Register(file{FD: fd, sys: s}, stat)
+}
+
+// RegisterOrigin is like Register but records where the file came from, see
+// wasi.FileTable.RegisterOrigin.
+func (s *System) RegisterOrigin(fd FD, stat wasi.FDStat, origin func() string) wasi.FD {
+	return s.FileTable.RegisterOrigin(file{FD: fd, sys: s}, stat, origin)
+}
+
+// NetworkPolicy is the type of values used to configure the network access
+// of a System.
+type NetworkPolicy int
+
+const (
+	// NetworkAll allows sockets to use any address.
+	NetworkAll NetworkPolicy = iota
+	// NetworkLoopback allows sockets to use loopback and unix addresses.
+	NetworkLoopback
+	// NetworkNone allows sockets to use unix addresses only.
+	NetworkNone
+)
+
+func (p NetworkPolicy) String() string {
+	switch p {
+	case NetworkAll:
+		return "all"
+	case NetworkLoopback:
+		return "loopback"
+	case NetworkNone:
+		return "none"
+	default:
+		return fmt.Sprintf("NetworkPolicy(%d)", int(p))
+	}
+}
+
+// Allow returns true if the policy permits sockets to use addr.
+func (p NetworkPolicy) Allow(addr wasi.SocketAddress) bool {
+	switch a := addr.(type) {
+	case *wasi.UnixAddress:
+		return true
+	case *wasi.Inet4Address:
+		return p == NetworkAll || (p == NetworkLoopback && net.IP(a.Addr[:]).IsLoopback())
+	case *wasi.Inet6Address:
+		return p == NetworkAll || (p == NetworkLoopback && net.IP(a.Addr[:]).IsLoopback())
+	default:
+		return p == NetworkAll
+	}
+}
+
 func (s *System) ArgsSizesGet(ctx context.Context) (argCount, stringBytes int, errno wasi.Errno) {
-	argCount, stringBytes = wasi.SizesGet(s.Args)
+	argCount, stringBytes = wasi.SizesGet(s.args.sizes(s.Args, s.ArgsFunc))
 	return
 }
 
 func (s *System) ArgsGet(ctx context.Context) ([]string, wasi.Errno) {
-	return s.Args, wasi.ESUCCESS
+	return s.args.get(s.Args, s.ArgsFunc), wasi.ESUCCESS
 }
 
 func (s *System) EnvironSizesGet(ctx context.Context) (envCount, stringBytes int, errno wasi.Errno) {
-	envCount, stringBytes = wasi.SizesGet(s.Environ)
+	envCount, stringBytes = wasi.SizesGet(s.environ.sizes(s.Environ, s.EnvironFunc))
 	return
 }
 
 func (s *System) EnvironGet(ctx context.Context) ([]string, wasi.Errno) {
-	return s.Environ, wasi.ESUCCESS
+	return s.environ.get(s.Environ, s.EnvironFunc), wasi.ESUCCESS
+}
+
+// stringsSnapshot holds the strings computed by a call to ArgsSizesGet or
+// EnvironSizesGet until they are returned by the following call to ArgsGet
+// or EnvironGet.
+type stringsSnapshot struct {
+	values  []string
+	pending bool
+}
+
+func (s *stringsSnapshot) sizes(values []string, f func() []string) []string {
+	if f == nil {
+		return values
+	}
+	s.values, s.pending = f(), true
+	return s.values
+}
+
+func (s *stringsSnapshot) get(values []string, f func() []string) []string {
+	if f == nil {
+		return values
+	}
+	if !s.pending {
+		return f()
+	}
+	values = s.values
+	s.values, s.pending = nil, false
+	return values
 }
 
 func (s *System) ClockResGet(ctx context.Context, id wasi.ClockID) (wasi.Timestamp, wasi.Errno) {
@@ -101,13 +265,13 @@ func (s *System) ClockTimeGet(ctx context.Context, id wasi.ClockID, precision wa
 			return 0, wasi.ENOTSUP
 		}
 		t, err := s.Realtime(ctx)
-		return wasi.Timestamp(t), makeErrno(err)
+		return wasi.Timestamp(t), makeErrno(s.reportError("ClockTimeGet", err))
 	case wasi.Monotonic:
 		if s.Monotonic == nil {
 			return 0, wasi.ENOTSUP
 		}
 		t, err := s.Monotonic(ctx)
-		return wasi.Timestamp(t), makeErrno(err)
+		return wasi.Timestamp(t), makeErrno(s.reportError("ClockTimeGet", err))
 	case wasi.ProcessCPUTimeID, wasi.ThreadCPUTimeID:
 		return 0, wasi.ENOTSUP
 	default:
@@ -119,12 +283,15 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 	if len(subscriptions) == 0 || len(events) < len(subscriptions) {
 		return 0, wasi.EINVAL
 	}
-	r, _, err := s.init()
+	wake, err := s.init()
 	if err != nil {
-		return 0, makeErrno(err)
+		return 0, makeErrno(s.reportError("PollOneOff", err))
 	}
-	s.pollfds = append(s.pollfds[:0], unix.PollFd{
-		Fd:     int32(r.Fd()),
+	// The pollfds are reused across calls; growing the slice to its final
+	// size once avoids repeated reallocations when the number of
+	// subscriptions increases (e.g. a server accepting more connections).
+	s.pollfds = append(slices.Grow(s.pollfds[:0], len(subscriptions)+1), unix.PollFd{
+		Fd:     int32(wake.pollfd()),
 		Events: unix.POLLIN | unix.POLLHUP,
 	})
 
@@ -143,7 +310,7 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 	for i := range subscriptions {
 		sub := &subscriptions[i]
 
-		var pollEvent int16 = unix.POLLPRI | unix.POLLIN | unix.POLLHUP
+		var pollEvent int16 = unix.POLLPRI | unix.POLLIN | unix.POLLHUP | __POLLRDHUP
 		switch sub.EventType {
 		case wasi.FDWriteEvent:
 			pollEvent = unix.POLLOUT
@@ -156,7 +323,7 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 				continue
 			}
 			s.pollfds = append(s.pollfds, unix.PollFd{
-				Fd:     int32(fd),
+				Fd:     int32(fd.FD),
 				Events: pollEvent,
 			})
 
@@ -226,17 +393,17 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 	// This loops until either the deadline is reached or at least one event is
 	// reported.
 	for {
-		timeoutMillis := 0
+		pollTimeout := time.Duration(0)
 		switch {
 		case timeout < 0:
-			timeoutMillis = -1
+			pollTimeout = -1
 		case !deadline.IsZero():
-			timeoutMillis = int(time.Until(deadline).Milliseconds())
+			pollTimeout = max(time.Until(deadline), 0)
 		}
 
-		n, err := unix.Poll(s.pollfds, timeoutMillis)
+		n, err := poll(s.pollfds, pollTimeout)
 		if err != nil && err != unix.EINTR {
-			return 0, makeErrno(err)
+			return 0, makeErrno(s.reportError("PollOneOff", err))
 		}
 
 		// poll(2) may cause spurious wake up, so we verify that the system
@@ -261,7 +428,7 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 			return len(subscriptions), wasi.ESUCCESS
 		}
 
-		if timeoutEventIndex >= 0 && deadline.Before(time.Now().Add(time.Millisecond)) {
+		if timeoutEventIndex >= 0 && !time.Now().Before(deadline) {
 			events[timeoutEventIndex] = wasi.Event{
 				UserData:  subscriptions[timeoutEventIndex].UserData,
 				EventType: subscriptions[timeoutEventIndex].EventType + 1,
@@ -280,16 +447,31 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 				if pf.Revents == 0 {
 					continue
 				}
-				// Linux never reports POLLHUP for disconnected sockets,
-				// so there is no reliable mechanism to set wasi.Hanghup.
-				// We optimize for portability here and just report that
-				// the file descriptor is ready for reading or writing,
-				// and let the application deal with the conditions it
-				// sees from the following calles to read/write/etc...
+				// Linux never reports POLLHUP for sockets that the peer
+				// shut down, but reports POLLRDHUP for read subscriptions,
+				// which lets the guest detect half-closed connections.
+				// Other conditions are left for the application to observe
+				// from the following calls to read/write/etc...
 				events[i] = wasi.Event{
 					UserData:  sub.UserData,
 					EventType: sub.EventType + 1,
 				}
+				if sub.EventType == wasi.FDReadEvent && (pf.Revents&__POLLRDHUP) != 0 {
+					events[i].FDReadWrite.Flags |= wasi.Hangup
+				}
+				// Applications cannot observe the condition of file
+				// descriptors registered by the host with an unknown type
+				// (e.g. pidfds) since they have no rights besides polling,
+				// so POLLHUP is reported to them (e.g. a pidfd reports it
+				// after the process was reaped).
+				if sub.EventType == wasi.FDReadEvent && (pf.Revents&unix.POLLHUP) != 0 {
+					if _, stat, _ := s.LookupFD(sub.GetFDReadWrite().FD, 0); stat.FileType == wasi.UnknownType {
+						events[i].FDReadWrite.Flags |= wasi.Hangup
+					}
+				}
+				if sub.EventType == wasi.FDReadEvent && (pf.Revents&unix.POLLPRI) != 0 && sub.GetFDReadWrite().Flags.Has(wasi.PollPriority) {
+					events[i].FDReadWrite.Flags |= wasi.Priority
+				}
 			}
 		}
 
@@ -347,16 +529,119 @@ func (s *System) SchedYield(ctx context.Context) wasi.Errno {
 	if s.Yield != nil {
 		return makeErrno(s.Yield(ctx))
 	}
-	return wasi.ENOSYS
+	runtime.Gosched()
+	return wasi.ESUCCESS
 }
 
+// maxEmptyReads is the number of consecutive reads returning no data that
+// RandomGet tolerates before reporting that the source is broken.
+const maxEmptyReads = 100
+
 func (s *System) RandomGet(ctx context.Context, b []byte) wasi.Errno {
-	if _, err := io.ReadFull(s.Rand, b); err != nil {
-		return wasi.EIO
+	if s.Rand == nil {
+		return wasi.ENOSYS
+	}
+	// Readers are allowed to return partial reads, and sources backed by
+	// system calls (e.g. files like /dev/urandom) may be interrupted by
+	// signals; in both cases we keep reading until the buffer is filled.
+	//
+	// A source reaching EOF is unable to produce more random data, which we
+	// report with ENOSYS to differentiate it from I/O errors.
+	//
+	// Readers which repeatedly return no data and no error would make the
+	// loop spin forever, so we give up with EIO after too many empty reads
+	// (like bufio does).
+	emptyReads := 0
+	for len(b) > 0 {
+		n, err := s.Rand.Read(b)
+		b = b[n:]
+		switch {
+		case err == nil:
+			if n > 0 {
+				emptyReads = 0
+			} else if emptyReads++; emptyReads == maxEmptyReads {
+				return wasi.EIO
+			}
+		case errors.Is(err, unix.EINTR):
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			if len(b) > 0 {
+				return wasi.ENOSYS
+			}
+		case errors.Is(err, unix.ENOSYS):
+			return wasi.ENOSYS
+		default:
+			return wasi.EIO
+		}
 	}
 	return wasi.ESUCCESS
 }
 
+// Getrandom is a source of random data for System.Rand which calls
+// getrandom(2) with the GRND_RANDOM flag, reading from the blocking pool of
+// the kernel instead of going through crypto/rand.
+//
+// The system call is only available on Linux, reads fail with ENOSYS on other
+// platforms.
+var Getrandom io.Reader = getrandomReader{}
+
+type getrandomReader struct{}
+
+func (getrandomReader) Read(b []byte) (int, error) {
+	n, err := getrandom(b)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// createMode returns the permission mode of files created by PathOpen.
+func (s *System) createMode() uint32 {
+	mode := s.CreateFileMode.Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	return uint32(mode &^ s.Umask)
+}
+
+// PathReadLinkString returns the target of the symbolic link at path.
+//
+// Unlike PathReadLink, which returns ERANGE when the target does not fit in
+// the buffer, the buffer is grown (starting from PATH_MAX) until the whole
+// target can be read, so applications using the System directly do not have
+// to retry with larger buffers.
+func (s *System) PathReadLinkString(ctx context.Context, fd wasi.FD, path string) (string, wasi.Errno) {
+	buffer := make([]byte, unix.PathMax)
+	for {
+		n, errno := s.PathReadLink(ctx, fd, path, buffer)
+		switch errno {
+		case wasi.ESUCCESS:
+			return string(buffer[:n]), wasi.ESUCCESS
+		case wasi.ERANGE:
+			buffer = make([]byte, 2*len(buffer))
+		default:
+			return "", errno
+		}
+	}
+}
+
+func (s *System) PathRename(ctx context.Context, fd wasi.FD, oldPath string, newFD wasi.FD, newPath string) wasi.Errno {
+	errno := s.FileTable.PathRename(ctx, fd, oldPath, newFD, newPath)
+	if errno != wasi.EXDEV || !s.CrossDeviceRename {
+		return errno
+	}
+	// The rights were already verified by the call to PathRename, which
+	// reported EXDEV from the host.
+	oldDir, _, errno := s.LookupFD(fd, wasi.PathRenameSourceRight)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	newDir, _, errno := s.LookupFD(newFD, wasi.PathRenameTargetRight)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	return makeErrno(s.reportError("PathRename", oldDir.pathRenameCopy(oldPath, newDir.FD, newPath)))
+}
+
 func (s *System) SockAccept(ctx context.Context, fd wasi.FD, flags wasi.FDFlags) (wasi.FD, wasi.SocketAddress, wasi.SocketAddress, wasi.Errno) {
 	socket, stat, errno := s.LookupSocketFD(fd, wasi.SockAcceptRight)
 	if errno != wasi.ESUCCESS {
@@ -373,42 +658,61 @@ func (s *System) SockAccept(ctx context.Context, fd wasi.FD, flags wasi.FDFlags)
 	if (flags & wasi.NonBlock) != 0 {
 		connflags |= unix.O_NONBLOCK
 	}
-	connfd, sa, err := accept(int(socket), connflags)
+	var sa unix.Sockaddr
+	connfd, err := ignoreEINTR2(func() (int, error) {
+		fd, addr, err := accept(int(socket.FD), connflags)
+		sa = addr
+		return fd, err
+	})
 	if err != nil {
-		return -1, nil, nil, makeErrno(err)
+		return -1, nil, nil, makeErrno(s.reportError("SockAccept", err))
 	}
-	if s.MaxOpenFiles > 0 && s.NumOpenFiles() >= s.MaxOpenFiles {
+	if errno := s.CheckMaxOpenFiles(); errno != wasi.ESUCCESS {
 		unix.Close(connfd)
-		return -1, nil, nil, wasi.ENFILE
+		return -1, nil, nil, errno
 	}
 	peer := makeSocketAddress(sa)
 	if peer == nil {
 		_ = closeTraceEBADF(connfd)
 		return -1, nil, nil, wasi.ENOTSUP
 	}
-	guestfd := s.Register(FD(connfd), wasi.FDStat{
+	// Whether accepted sockets inherit O_NONBLOCK from the listening socket
+	// differs between platforms; the mode is read back and corrected so the
+	// socket is in the mode reported by the file descriptor flags.
+	fl, err := ignoreEINTR2(func() (int, error) {
+		return unix.FcntlInt(uintptr(connfd), unix.F_GETFL, 0)
+	})
+	if err == nil && ((fl&unix.O_NONBLOCK) != 0) != flags.Has(wasi.NonBlock) {
+		err = fcntlSetFL(FD(connfd), fl^unix.O_NONBLOCK)
+	}
+	if err != nil {
+		_ = closeTraceEBADF(connfd)
+		return -1, nil, nil, makeErrno(s.reportError("SockAccept", err))
+	}
+	guestfd := s.RegisterOrigin(FD(connfd), wasi.FDStat{
 		FileType:         wasi.SocketStreamType,
 		Flags:            flags,
 		RightsBase:       stat.RightsInheriting,
 		RightsInheriting: stat.RightsInheriting,
+	}, func() string {
+		return fmt.Sprintf("SockAccept(%d) from %s", fd, peer)
 	})
 	return guestfd, peer, addr, wasi.ESUCCESS
 }
 
 func (s *System) SockRecv(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, flags wasi.RIFlags) (wasi.Size, wasi.ROFlags, wasi.Errno) {
-	socket, _, errno := s.LookupSocketFD(fd, wasi.FDReadRight)
+	socket, stat, errno := s.LookupSocketFD(fd, wasi.FDReadRight)
 	if errno != wasi.ESUCCESS {
 		return 0, 0, errno
 	}
-	var sysIFlags int
-	if flags.Has(wasi.RecvPeek) {
-		sysIFlags |= unix.MSG_PEEK
-	}
-	if flags.Has(wasi.RecvWaitAll) {
-		sysIFlags |= unix.MSG_WAITALL
+	// Empty datagrams are valid messages, only zero-length receives on
+	// stream sockets can complete without reading from the socket.
+	if stat.FileType == wasi.SocketStreamType && iovecsSize(makeIOVecs(iovecs)) == 0 {
+		return 0, 0, wasi.ESUCCESS
 	}
+	sysIFlags := makeRecvFlags(flags)
 	for {
-		n, _, sysOFlags, _, err := unix.RecvmsgBuffers(int(socket), makeIOVecs(iovecs), nil, sysIFlags)
+		n, _, sysOFlags, _, err := unix.RecvmsgBuffers(int(socket.FD), makeIOVecs(iovecs), nil, sysIFlags)
 		if err == unix.EINTR {
 			continue
 		}
@@ -416,19 +720,65 @@ func (s *System) SockRecv(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec,
 		if (sysOFlags & unix.MSG_TRUNC) != 0 {
 			roflags |= wasi.RecvDataTruncated
 		}
-		return wasi.Size(n), roflags, makeErrno(err)
+		return wasi.Size(n), roflags, makeErrno(s.reportError("SockRecv", err))
 	}
 }
 
 func (s *System) SockSend(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, flags wasi.SIFlags) (wasi.Size, wasi.Errno) {
-	socket, _, errno := s.LookupSocketFD(fd, wasi.FDWriteRight)
+	socket, stat, errno := s.LookupSocketFD(fd, wasi.FDWriteRight)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	if stat.FileType == wasi.SocketStreamType && iovecsSize(makeIOVecs(iovecs)) == 0 {
+		return 0, wasi.ESUCCESS
+	}
+	n, err := handleEINTR(func() (int, error) {
+		return sendmsg(int(socket.FD), makeIOVecs(iovecs), makeSendFlags(flags))
+	})
+	return wasi.Size(n), makeSendErrno(s.reportError("SockSend", err))
+}
+
+func (s *System) SockSplice(ctx context.Context, inFD, outFD wasi.FD, maxBytes wasi.Size) (wasi.Size, wasi.Errno) {
+	in, inStat, errno := s.LookupSocketFD(inFD, wasi.FDReadRight)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	out, outStat, errno := s.LookupSocketFD(outFD, wasi.FDWriteRight)
 	if errno != wasi.ESUCCESS {
 		return 0, errno
 	}
+	if inStat.FileType != wasi.SocketStreamType || outStat.FileType != wasi.SocketStreamType {
+		return 0, wasi.ENOTSUP
+	}
+	if maxBytes == 0 {
+		return 0, wasi.ESUCCESS
+	}
+	n, err := s.splice.splice(int(in.FD), int(out.FD), int(maxBytes))
+	return wasi.Size(n), makeErrno(s.reportError("SockSplice", err))
+}
+
+func (s *System) FDSendFile(ctx context.Context, outFD, inFD wasi.FD, offset wasi.FileSize, count wasi.Size) (wasi.Size, wasi.Errno) {
+	out, outStat, errno := s.LookupSocketFD(outFD, wasi.FDWriteRight)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	in, inStat, errno := s.LookupFD(inFD, wasi.FDReadRight)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	if outStat.FileType != wasi.SocketStreamType || inStat.FileType != wasi.RegularFileType {
+		return 0, wasi.ENOTSUP
+	}
+	if offset > math.MaxInt64 {
+		return 0, wasi.EINVAL
+	}
+	if count == 0 {
+		return 0, wasi.ESUCCESS
+	}
 	n, err := handleEINTR(func() (int, error) {
-		return unix.SendmsgBuffers(int(socket), makeIOVecs(iovecs), nil, nil, 0)
+		return sendfile(int(out.FD), int(in.FD), int64(offset), int(count))
 	})
-	return wasi.Size(n), makeErrno(err)
+	return wasi.Size(n), makeSendErrno(s.reportError("FDSendFile", err))
 }
 
 func (s *System) SockShutdown(ctx context.Context, fd wasi.FD, flags wasi.SDFlags) wasi.Errno {
@@ -460,17 +810,17 @@ func (s *System) SockShutdown(ctx context.Context, fd wasi.FD, flags wasi.SDFlag
 	// For more context see: https://bugzilla.kernel.org/show_bug.cgi?id=106241
 	if runtime.GOOS == "linux" {
 		v, err := ignoreEINTR2(func() (int, error) {
-			return unix.GetsockoptInt(int(socket), unix.SOL_SOCKET, unix.SO_ACCEPTCONN)
+			return unix.GetsockoptInt(int(socket.FD), unix.SOL_SOCKET, unix.SO_ACCEPTCONN)
 		})
 		if err != nil {
-			return makeErrno(err)
+			return makeErrno(s.reportError("SockShutdown", err))
 		}
 		if v != 0 {
 			return wasi.ENOTCONN
 		}
 	}
-	err := ignoreEINTR(func() error { return unix.Shutdown(int(socket), sysHow) })
-	return makeErrno(err)
+	err := ignoreEINTR(func() error { return unix.Shutdown(int(socket.FD), sysHow) })
+	return makeErrno(s.reportError("SockShutdown", err))
 }
 
 func (s *System) SockOpen(ctx context.Context, pf wasi.ProtocolFamily, socketType wasi.SocketType, protocol wasi.Protocol, rightsBase, rightsInheriting wasi.Rights) (wasi.FD, wasi.Errno) {
@@ -504,6 +854,19 @@ func (s *System) SockOpen(ctx context.Context, pf wasi.ProtocolFamily, socketTyp
 	case wasi.StreamSocket:
 		sysType = unix.SOCK_STREAM
 		fdType = wasi.SocketStreamType
+	case wasi.RawSocket:
+		if !s.AllowRawSockets {
+			return -1, wasi.EPERM
+		}
+		if pf == wasi.UnixFamily {
+			return -1, wasi.EINVAL
+		}
+		// WASI has no file type for raw sockets, they preserve message
+		// boundaries like datagram sockets and are used with SockSendTo
+		// and SockRecvFrom. On IPv4, the data received includes the IP
+		// header.
+		sysType = unix.SOCK_RAW
+		fdType = wasi.SocketDGramType
 	default:
 		return -1, wasi.EINVAL
 	}
@@ -516,16 +879,26 @@ func (s *System) SockOpen(ctx context.Context, pf wasi.ProtocolFamily, socketTyp
 		sysProtocol = unix.IPPROTO_TCP
 	case wasi.UDPProtocol:
 		sysProtocol = unix.IPPROTO_UDP
+	case wasi.ICMPProtocol:
+		if pf != wasi.InetFamily {
+			return -1, wasi.EPROTONOSUPPORT
+		}
+		sysProtocol = unix.IPPROTO_ICMP
+	case wasi.ICMPv6Protocol:
+		if pf != wasi.Inet6Family {
+			return -1, wasi.EPROTONOSUPPORT
+		}
+		sysProtocol = unix.IPPROTO_ICMPV6
 	default:
 		return -1, wasi.EINVAL
 	}
 
-	if s.MaxOpenFiles > 0 && s.NumOpenFiles() >= s.MaxOpenFiles {
-		return -1, wasi.ENFILE
+	if errno := s.CheckMaxOpenFiles(); errno != wasi.ESUCCESS {
+		return -1, errno
 	}
 
 	fd, err := ignoreEINTR2(func() (int, error) {
-		return unix.Socket(sysDomain, sysType, sysProtocol)
+		return socket(sysDomain, sysType, sysProtocol)
 	})
 	if err != nil {
 		// Darwin gives EPROTOTYPE when the socket type and protocol do
@@ -537,12 +910,18 @@ func (s *System) SockOpen(ctx context.Context, pf wasi.ProtocolFamily, socketTyp
 		if err == unix.EPROTOTYPE {
 			err = unix.EPROTONOSUPPORT
 		}
-		return -1, makeErrno(err)
+		return -1, makeErrno(s.reportError("SockOpen", err))
+	}
+	if err := setNoSigPipe(fd); err != nil {
+		closeTraceEBADF(fd)
+		return -1, makeErrno(s.reportError("SockOpen", err))
 	}
-	guestfd := s.Register(FD(fd), wasi.FDStat{
+	guestfd := s.RegisterOrigin(FD(fd), wasi.FDStat{
 		FileType:         fdType,
 		RightsBase:       rightsBase,
 		RightsInheriting: rightsInheriting,
+	}, func() string {
+		return fmt.Sprintf("SockOpen(%s, %s)", pf, socketType)
 	})
 	return guestfd, wasi.ESUCCESS
 }
@@ -552,25 +931,36 @@ func (s *System) SockBind(ctx context.Context, fd wasi.FD, addr wasi.SocketAddre
 	if errno != wasi.ESUCCESS {
 		return nil, errno
 	}
-	sa, ok := s.toUnixSockAddress(addr)
-	if !ok {
-		return nil, wasi.EINVAL
+	sa, errno := s.toUnixSockAddress(addr)
+	if errno != wasi.ESUCCESS {
+		return nil, errno
 	}
-	err := ignoreEINTR(func() error { return unix.Bind(int(socket), sa) })
+	if !s.NetworkPolicy.Allow(addr) {
+		return nil, wasi.EACCES
+	}
+	err := ignoreEINTR(func() error { return unix.Bind(int(socket.FD), sa) })
 	if err != nil {
-		return nil, makeErrno(err)
+		return nil, makeErrno(s.reportError("SockBind", err))
 	}
 	return s.SockLocalAddress(ctx, fd)
 }
 
 func (s *System) SockConnect(ctx context.Context, fd wasi.FD, peer wasi.SocketAddress) (wasi.SocketAddress, wasi.Errno) {
-	socket, _, errno := s.LookupSocketFD(fd, 0)
+	socket, stat, errno := s.LookupSocketFD(fd, 0)
 	if errno != wasi.ESUCCESS {
 		return nil, errno
 	}
-	sa, ok := s.toUnixSockAddress(peer)
-	if !ok {
-		return nil, wasi.EINVAL
+	sa, errno := s.toUnixSockAddress(peer)
+	if errno != wasi.ESUCCESS {
+		return nil, errno
+	}
+	if !s.NetworkPolicy.Allow(peer) {
+		return nil, wasi.EACCES
+	}
+	if s.DialPolicy != nil {
+		if errno := s.DialPolicy(peer); errno != wasi.ESUCCESS {
+			return nil, errno
+		}
 	}
 
 	// In some cases, Linux allows sockets to be connected to addresses of a
@@ -580,10 +970,10 @@ func (s *System) SockConnect(ctx context.Context, fd wasi.FD, peer wasi.SocketAd
 	// match the socket domain.
 	if runtime.GOOS == "linux" {
 		domain, err := ignoreEINTR2(func() (int, error) {
-			return getsocketdomain(int(socket))
+			return getsocketdomain(int(socket.FD))
 		})
 		if err != nil {
-			return nil, makeErrno(err)
+			return nil, makeErrno(s.reportError("SockConnect", err))
 		}
 		family := wasi.UnspecifiedFamily
 		switch domain {
@@ -599,7 +989,12 @@ func (s *System) SockConnect(ctx context.Context, fd wasi.FD, peer wasi.SocketAd
 		}
 	}
 
-	err := ignoreEINTR(func() error { return unix.Connect(int(socket), sa) })
+	var err error
+	if s.ConnectTimeout > 0 && !stat.Flags.Has(wasi.NonBlock) {
+		err = connectTimeout(int(socket.FD), sa, s.ConnectTimeout)
+	} else {
+		err = connect(int(socket.FD), sa)
+	}
 	if err != nil && err != unix.EINPROGRESS {
 		switch err {
 		// Linux gives EINVAL only when trying to connect to an ipv4 address
@@ -614,13 +1009,13 @@ func (s *System) SockConnect(ctx context.Context, fd wasi.FD, peer wasi.SocketAd
 		case unix.EOPNOTSUPP:
 			err = unix.EISCONN
 		}
-		return nil, makeErrno(err)
+		return nil, makeErrno(s.reportError("SockConnect", err))
 	}
 	addr, errno := s.SockLocalAddress(ctx, fd)
 	if errno != wasi.ESUCCESS {
 		return nil, errno
 	}
-	return addr, makeErrno(err)
+	return addr, makeErrno(s.reportError("SockConnect", err))
 }
 
 func (s *System) SockListen(ctx context.Context, fd wasi.FD, backlog int) wasi.Errno {
@@ -628,37 +1023,67 @@ func (s *System) SockListen(ctx context.Context, fd wasi.FD, backlog int) wasi.E
 	if errno != wasi.ESUCCESS {
 		return errno
 	}
-	err := ignoreEINTR(func() error { return unix.Listen(int(socket), backlog) })
-	return makeErrno(err)
+	err := ignoreEINTR(func() error { return unix.Listen(int(socket.FD), backlog) })
+	return makeErrno(s.reportError("SockListen", err))
 }
 
 func (s *System) SockSendTo(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, flags wasi.SIFlags, addr wasi.SocketAddress) (wasi.Size, wasi.Errno) {
-	socket, _, errno := s.LookupSocketFD(fd, wasi.FDWriteRight)
+	socket, stat, errno := s.LookupSocketFD(fd, wasi.FDWriteRight)
 	if errno != wasi.ESUCCESS {
 		return 0, errno
 	}
 	// Linux is more permissive than darwin and allows the use of sendto
 	// even when the socket is connected.
 	//
-	// To align on the more restrictive darwin behavior here we make a check to
-	// verify whether the socket has a peer and proactively deny the function if
-	// that's the case.
-	if runtime.GOOS == "linux" {
-		_, err := ignoreEINTR2(func() (unix.Sockaddr, error) {
-			return unix.Getpeername(int(socket))
-		})
-		if !errors.Is(err, unix.ENOTCONN) {
-			return 0, wasi.EISCONN
+	// To align on the more restrictive darwin behavior we verify whether the
+	// socket has a peer and proactively deny the function if that's the case.
+	// The check is done on all platforms rather than relying on the kernel,
+	// so the policies below are never consulted for connected sockets and
+	// the errors do not depend on the order of the checks made by each
+	// kernel.
+	_, err := ignoreEINTR2(func() (unix.Sockaddr, error) {
+		return unix.Getpeername(int(socket.FD))
+	})
+	if !errors.Is(err, unix.ENOTCONN) {
+		return 0, wasi.EISCONN
+	}
+	sa, errno := s.toUnixSockAddress(addr)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	if !s.NetworkPolicy.Allow(addr) {
+		return 0, wasi.EACCES
+	}
+	if s.DialPolicy != nil {
+		if errno := s.DialPolicy(addr); errno != wasi.ESUCCESS {
+			return 0, errno
 		}
 	}
-	sa, ok := s.toUnixSockAddress(addr)
-	if !ok {
-		return 0, wasi.EINVAL
+	sendFlags := makeSendFlags(flags)
+	// Sending to an address on a stream socket which is not connected
+	// connects it with TCP Fast Open on Linux, carrying the data in the SYN
+	// when the peer supports it. This is only done if the guest opted into
+	// fast open by setting TcpFastOpenConnect on the socket, otherwise the
+	// send fails because the socket is not connected.
+	if stat.FileType == wasi.SocketStreamType && fastOpenConnect(int(socket.FD)) {
+		sendFlags |= __MSG_FASTOPEN
 	}
 	n, err := handleEINTR(func() (int, error) {
-		return unix.SendmsgBuffers(int(socket), makeIOVecs(iovecs), nil, sa, 0)
+		return unix.SendmsgBuffers(int(socket.FD), makeIOVecs(iovecs), nil, sa, sendFlags)
+	})
+	return wasi.Size(n), makeSendErrno(s.reportError("SockSendTo", err))
+}
+
+// fastOpenConnect returns true if TCP_FASTOPEN_CONNECT is enabled on the
+// socket. It always returns false on systems which do not support it.
+func fastOpenConnect(fd int) bool {
+	if __MSG_FASTOPEN == 0 {
+		return false
+	}
+	v, err := ignoreEINTR2(func() (int, error) {
+		return unix.GetsockoptInt(fd, unix.IPPROTO_TCP, __TCP_FASTOPEN_CONNECT)
 	})
-	return wasi.Size(n), makeErrno(err)
+	return err == nil && v != 0
 }
 
 func (s *System) SockRecvFrom(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, flags wasi.RIFlags) (wasi.Size, wasi.ROFlags, wasi.SocketAddress, wasi.Errno) {
@@ -666,15 +1091,9 @@ func (s *System) SockRecvFrom(ctx context.Context, fd wasi.FD, iovecs []wasi.IOV
 	if errno != wasi.ESUCCESS {
 		return 0, 0, nil, errno
 	}
-	var sysIFlags int
-	if flags.Has(wasi.RecvPeek) {
-		sysIFlags |= unix.MSG_PEEK
-	}
-	if flags.Has(wasi.RecvWaitAll) {
-		sysIFlags |= unix.MSG_WAITALL
-	}
+	sysIFlags := makeRecvFlags(flags)
 	for {
-		n, _, sysOFlags, sa, err := unix.RecvmsgBuffers(int(socket), makeIOVecs(iovecs), nil, sysIFlags)
+		n, _, sysOFlags, sa, err := unix.RecvmsgBuffers(int(socket.FD), makeIOVecs(iovecs), nil, sysIFlags)
 		if err == unix.EINTR {
 			continue
 		}
@@ -689,7 +1108,7 @@ func (s *System) SockRecvFrom(ctx context.Context, fd wasi.FD, iovecs []wasi.IOV
 		if (sysOFlags & unix.MSG_TRUNC) != 0 {
 			roflags |= wasi.RecvDataTruncated
 		}
-		return wasi.Size(n), roflags, addr, makeErrno(err)
+		return wasi.Size(n), roflags, addr, makeErrno(s.reportError("SockRecvFrom", err))
 	}
 }
 
@@ -703,8 +1122,12 @@ func (s *System) SockGetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 	switch option.Level() {
 	case wasi.SocketLevel:
 		sysLevel = unix.SOL_SOCKET
+	case wasi.IPLevel:
+		sysLevel = unix.IPPROTO_IP
 	case wasi.TcpLevel:
 		sysLevel = unix.IPPROTO_TCP
+	case wasi.IPv6Level:
+		sysLevel = unix.IPPROTO_IPV6
 	default:
 		return nil, wasi.EINVAL
 	}
@@ -735,6 +1158,19 @@ func (s *System) SockGetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 		sysOption = unix.SO_ACCEPTCONN
 	case wasi.TcpNoDelay:
 		sysOption = unix.TCP_NODELAY
+	case wasi.TcpCork:
+		sysOption = __TCP_CORK
+	case wasi.TcpFastOpen:
+		sysOption = unix.TCP_FASTOPEN
+	case wasi.TcpFastOpenConnect:
+		sysOption = __TCP_FASTOPEN_CONNECT
+		if sysOption < 0 {
+			return nil, wasi.ENOPROTOOPT
+		}
+	case wasi.IPTypeOfService:
+		sysOption = unix.IP_TOS
+	case wasi.IPv6TrafficClass:
+		sysOption = unix.IPV6_TCLASS
 	case wasi.Linger:
 		// This returns a struct linger value.
 		return nil, wasi.ENOTSUP // TODO: implement SO_LINGER
@@ -753,19 +1189,19 @@ func (s *System) SockGetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 	switch option {
 	case wasi.RecvTimeout, wasi.SendTimeout:
 		tv, err := ignoreEINTR2(func() (*unix.Timeval, error) {
-			return unix.GetsockoptTimeval(int(socket), sysLevel, sysOption)
+			return unix.GetsockoptTimeval(int(socket.FD), sysLevel, sysOption)
 		})
 		if err != nil {
-			return nil, makeErrno(err)
+			return nil, makeErrno(s.reportError("SockGetOpt", err))
 		}
 		return wasi.TimeValue(tv.Nano()), wasi.ESUCCESS
 	}
 
 	value, err := ignoreEINTR2(func() (int, error) {
-		return unix.GetsockoptInt(int(socket), sysLevel, sysOption)
+		return unix.GetsockoptInt(int(socket.FD), sysLevel, sysOption)
 	})
 	if err != nil {
-		return nil, makeErrno(err)
+		return nil, makeErrno(s.reportError("SockGetOpt", err))
 	}
 
 	errno = wasi.ESUCCESS
@@ -776,6 +1212,8 @@ func (s *System) SockGetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 			value = int(wasi.DatagramSocket)
 		case unix.SOCK_STREAM:
 			value = int(wasi.StreamSocket)
+		case unix.SOCK_RAW:
+			value = int(wasi.RawSocket)
 		default:
 			value = -1
 			errno = wasi.ENOTSUP
@@ -803,8 +1241,12 @@ func (s *System) SockSetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 	switch option.Level() {
 	case wasi.SocketLevel:
 		sysLevel = unix.SOL_SOCKET
+	case wasi.IPLevel:
+		sysLevel = unix.IPPROTO_IP
 	case wasi.TcpLevel:
 		sysLevel = unix.IPPROTO_TCP
+	case wasi.IPv6Level:
+		sysLevel = unix.IPPROTO_IPV6
 	default:
 		return wasi.EINVAL
 	}
@@ -835,6 +1277,19 @@ func (s *System) SockSetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 		sysOption = unix.SO_ACCEPTCONN
 	case wasi.TcpNoDelay:
 		sysOption = unix.TCP_NODELAY
+	case wasi.TcpCork:
+		sysOption = __TCP_CORK
+	case wasi.TcpFastOpen:
+		sysOption = unix.TCP_FASTOPEN
+	case wasi.TcpFastOpenConnect:
+		sysOption = __TCP_FASTOPEN_CONNECT
+		if sysOption < 0 {
+			return wasi.ENOPROTOOPT
+		}
+	case wasi.IPTypeOfService:
+		sysOption = unix.IP_TOS
+	case wasi.IPv6TrafficClass:
+		sysOption = unix.IPV6_TCLASS
 	case wasi.Linger:
 		// This accepts a struct linger value.
 		return wasi.ENOTSUP // TODO: implement SO_LINGER
@@ -893,14 +1348,14 @@ func (s *System) SockSetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 	case wasi.RecvTimeout, wasi.SendTimeout:
 		tv := unix.NsecToTimeval(int64(timeval))
 		err = ignoreEINTR(func() error {
-			return unix.SetsockoptTimeval(int(socket), sysLevel, sysOption, &tv)
+			return unix.SetsockoptTimeval(int(socket.FD), sysLevel, sysOption, &tv)
 		})
 	default:
 		err = ignoreEINTR(func() error {
-			return unix.SetsockoptInt(int(socket), sysLevel, sysOption, int(intval))
+			return unix.SetsockoptInt(int(socket.FD), sysLevel, sysOption, int(intval))
 		})
 	}
-	return makeErrno(err)
+	return makeErrno(s.reportError("SockSetOpt", err))
 }
 
 func (s *System) SockLocalAddress(ctx context.Context, fd wasi.FD) (wasi.SocketAddress, wasi.Errno) {
@@ -909,10 +1364,10 @@ func (s *System) SockLocalAddress(ctx context.Context, fd wasi.FD) (wasi.SocketA
 		return nil, errno
 	}
 	sa, err := ignoreEINTR2(func() (unix.Sockaddr, error) {
-		return unix.Getsockname(int(socket))
+		return unix.Getsockname(int(socket.FD))
 	})
 	if err != nil {
-		return nil, makeErrno(err)
+		return nil, makeErrno(s.reportError("SockLocalAddress", err))
 	}
 	addr := makeSocketAddress(sa)
 	if addr == nil {
@@ -927,10 +1382,10 @@ func (s *System) SockRemoteAddress(ctx context.Context, fd wasi.FD) (wasi.Socket
 		return nil, errno
 	}
 	sa, err := ignoreEINTR2(func() (unix.Sockaddr, error) {
-		return unix.Getpeername(int(socket))
+		return unix.Getpeername(int(socket.FD))
 	})
 	if err != nil {
-		return nil, makeErrno(err)
+		return nil, makeErrno(s.reportError("SockRemoteAddress", err))
 	}
 	addr := makeSocketAddress(sa)
 	if addr == nil {
@@ -940,69 +1395,39 @@ func (s *System) SockRemoteAddress(ctx context.Context, fd wasi.FD) (wasi.Socket
 }
 
 func (s *System) SockAddressInfo(ctx context.Context, name, service string, hints wasi.AddressInfo, results []wasi.AddressInfo) (int, wasi.Errno) {
-	if len(results) == 0 {
-		return 0, wasi.EINVAL
-	}
 	// TODO: support AI_ADDRCONFIG, AI_CANONNAME, AI_V4MAPPED, AI_V4MAPPED_CFG, AI_ALL
 
-	var network string
-	f, p, t := hints.Family, hints.Protocol, hints.SocketType
-	switch {
-	case t == wasi.StreamSocket && p != wasi.UDPProtocol:
-		switch f {
-		case wasi.UnspecifiedFamily:
-			network = "tcp"
-		case wasi.InetFamily:
-			network = "tcp4"
-		case wasi.Inet6Family:
-			network = "tcp6"
-		default:
-			return 0, wasi.ENOTSUP // EAI_FAMILY
+	if s.NameResolver != nil {
+		addrs, handled, err := s.NameResolver(ctx, name, service, hints)
+		if err != nil {
+			var errno wasi.Errno
+			if !errors.As(err, &errno) {
+				errno = wasi.ECANCELED
+			}
+			return 0, errno
 		}
-	case t == wasi.DatagramSocket && p != wasi.TCPProtocol:
-		switch f {
-		case wasi.UnspecifiedFamily:
-			network = "udp"
-		case wasi.InetFamily:
-			network = "udp4"
-		case wasi.Inet6Family:
-			network = "udp6"
-		default:
-			return 0, wasi.ENOTSUP // EAI_FAMILY
+		if handled {
+			copy(results, addrs)
+			return len(addrs), wasi.ESUCCESS
 		}
-	case t == wasi.AnySocket:
-		switch f {
-		case wasi.UnspecifiedFamily:
-			network = "ip"
-		case wasi.InetFamily:
-			network = "ip4"
-		case wasi.Inet6Family:
-			network = "ip6"
-		default:
-			return 0, wasi.ENOTSUP // EAI_FAMILY
-		}
-	default:
-		return 0, wasi.ENOTSUP // EAI_SOCKTYPE / EAI_PROTOCOL
 	}
 
-	var port int
-	var err error
-	if hints.Flags.Has(wasi.NumericService) {
-		port, err = strconv.Atoi(service)
-	} else {
-		port, err = net.DefaultResolver.LookupPort(ctx, network, service)
+	network, errno := addressInfoNetwork(hints)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
 	}
-	if err != nil || port < 0 || port > 65535 {
-		return 0, wasi.EINVAL // EAI_NONAME / EAI_SERVICE
+	port, errno := addressInfoPort(ctx, network, service, hints)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
 	}
 
+	// Like getaddrinfo(3), Passive only applies when no host name is given,
+	// it is ignored when the host is a name or a numeric address.
 	var ip net.IP
-	if hints.Flags.Has(wasi.NumericHost) {
-		ip = net.ParseIP(name)
-		if ip == nil {
-			return 0, wasi.EINVAL
+	if name == "" {
+		if service == "" {
+			return 0, wasi.EINVAL // EAI_NONAME
 		}
-	} else if name == "" {
 		if hints.Flags.Has(wasi.Passive) {
 			if hints.Family == wasi.Inet6Family {
 				ip = net.IPv6zero
@@ -1016,30 +1441,27 @@ func (s *System) SockAddressInfo(ctx context.Context, name, service string, hint
 				ip = net.IPv4(127, 0, 0, 1)
 			}
 		}
-	}
-
-	makeAddressInfo := func(ip net.IP, port int) wasi.AddressInfo {
-		addrInfo := wasi.AddressInfo{
-			Flags:      hints.Flags,
-			SocketType: hints.SocketType,
-			Protocol:   hints.Protocol,
-		}
-		if ipv4 := ip.To4(); ipv4 != nil {
-			inet4Addr := &wasi.Inet4Address{Port: port}
-			copy(inet4Addr.Addr[:], ipv4)
-			addrInfo.Family = wasi.InetFamily
-			addrInfo.Address = inet4Addr
-		} else {
-			inet6Addr := &wasi.Inet6Address{Port: port}
-			copy(inet6Addr.Addr[:], ip)
-			addrInfo.Family = wasi.Inet6Family
-			addrInfo.Address = inet6Addr
+	} else if ip = net.ParseIP(name); ip != nil {
+		// Numeric addresses are returned as-is, without resolving them,
+		// as long as they match the requested address family.
+		switch hints.Family {
+		case wasi.InetFamily:
+			if ip.To4() == nil {
+				return 0, wasi.EINVAL // EAI_ADDRFAMILY
+			}
+		case wasi.Inet6Family:
+			if ip.To4() != nil {
+				return 0, wasi.EINVAL // EAI_ADDRFAMILY
+			}
 		}
-		return addrInfo
+	} else if hints.Flags.Has(wasi.NumericHost) {
+		return 0, wasi.EINVAL // EAI_NONAME
 	}
 
 	if ip != nil {
-		results[0] = makeAddressInfo(ip, port)
+		if len(results) > 0 {
+			results[0] = makeAddressInfo(ip, port, hints)
+		}
 		return 1, wasi.ESUCCESS
 	}
 
@@ -1058,37 +1480,134 @@ func (s *System) SockAddressInfo(ctx context.Context, name, service string, hint
 		return 0, wasi.ECANCELED // TODO: better errors on name resolution failure
 	}
 
+	// The results are truncated if there are more addresses than the caller
+	// made room for, but the total count is returned so it can retry with a
+	// larger slice.
+	addrs := makeAddressInfos(ips, port, hints)
+	copy(results, addrs)
+	return len(addrs), wasi.ESUCCESS
+}
+
+// addressInfoNetwork returns the name of the network to resolve addresses
+// for, as expected by the net package, based on the hints passed to
+// SockAddressInfo.
+func addressInfoNetwork(hints wasi.AddressInfo) (string, wasi.Errno) {
+	f, p, t := hints.Family, hints.Protocol, hints.SocketType
+	switch {
+	case t == wasi.StreamSocket && p != wasi.UDPProtocol:
+		switch f {
+		case wasi.UnspecifiedFamily:
+			return "tcp", wasi.ESUCCESS
+		case wasi.InetFamily:
+			return "tcp4", wasi.ESUCCESS
+		case wasi.Inet6Family:
+			return "tcp6", wasi.ESUCCESS
+		default:
+			return "", wasi.ENOTSUP // EAI_FAMILY
+		}
+	case t == wasi.DatagramSocket && p != wasi.TCPProtocol:
+		switch f {
+		case wasi.UnspecifiedFamily:
+			return "udp", wasi.ESUCCESS
+		case wasi.InetFamily:
+			return "udp4", wasi.ESUCCESS
+		case wasi.Inet6Family:
+			return "udp6", wasi.ESUCCESS
+		default:
+			return "", wasi.ENOTSUP // EAI_FAMILY
+		}
+	case t == wasi.AnySocket:
+		switch f {
+		case wasi.UnspecifiedFamily:
+			return "ip", wasi.ESUCCESS
+		case wasi.InetFamily:
+			return "ip4", wasi.ESUCCESS
+		case wasi.Inet6Family:
+			return "ip6", wasi.ESUCCESS
+		default:
+			return "", wasi.ENOTSUP // EAI_FAMILY
+		}
+	default:
+		return "", wasi.ENOTSUP // EAI_SOCKTYPE / EAI_PROTOCOL
+	}
+}
+
+// addressInfoPort resolves the port number of service on the network. An
+// empty service resolves to port zero. Service names are looked up for the
+// protocol of the socket type in the hints, or either TCP or UDP when the
+// socket type is not specified.
+func addressInfoPort(ctx context.Context, network, service string, hints wasi.AddressInfo) (int, wasi.Errno) {
+	if service == "" {
+		return 0, wasi.ESUCCESS
+	}
+	var port int
+	var err error
+	if hints.Flags.Has(wasi.NumericService) {
+		port, err = strconv.Atoi(service)
+	} else if port, err = strconv.Atoi(service); err != nil {
+		switch network {
+		case "ip", "ip4", "ip6":
+			port, err = net.DefaultResolver.LookupPort(ctx, "tcp", service)
+			if err != nil {
+				port, err = net.DefaultResolver.LookupPort(ctx, "udp", service)
+			}
+		default:
+			port, err = net.DefaultResolver.LookupPort(ctx, network, service)
+		}
+	}
+	if err != nil || port < 0 || port > 65535 {
+		return 0, wasi.EINVAL // EAI_NONAME / EAI_SERVICE
+	}
+	return port, wasi.ESUCCESS
+}
+
+// makeAddressInfos converts the list of IP addresses to address info results,
+// IPv4 addresses are ordered before IPv6 addresses.
+func makeAddressInfos(ips []net.IP, port int, hints wasi.AddressInfo) []wasi.AddressInfo {
 	addrs4 := make([]wasi.AddressInfo, 0, 8)
 	addrs6 := make([]wasi.AddressInfo, 0, 8)
 
 	for _, ip := range ips {
 		if ip.To4() != nil {
-			addrs4 = append(addrs4, makeAddressInfo(ip, port))
+			addrs4 = append(addrs4, makeAddressInfo(ip, port, hints))
 		} else {
-			addrs6 = append(addrs6, makeAddressInfo(ip, port))
+			addrs6 = append(addrs6, makeAddressInfo(ip, port, hints))
 		}
 	}
+	return append(addrs4, addrs6...)
+}
 
-	n := copy(results[0:], addrs4)
-	n += copy(results[n:], addrs6)
-	return n, wasi.ESUCCESS
+func makeAddressInfo(ip net.IP, port int, hints wasi.AddressInfo) wasi.AddressInfo {
+	addrInfo := wasi.AddressInfo{
+		Flags:      hints.Flags,
+		SocketType: hints.SocketType,
+		Protocol:   hints.Protocol,
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		inet4Addr := &wasi.Inet4Address{Port: port}
+		copy(inet4Addr.Addr[:], ipv4)
+		addrInfo.Family = wasi.InetFamily
+		addrInfo.Address = inet4Addr
+	} else {
+		inet6Addr := &wasi.Inet6Address{Port: port}
+		copy(inet6Addr.Addr[:], ip)
+		addrInfo.Family = wasi.Inet6Family
+		addrInfo.Address = inet6Addr
+	}
+	return addrInfo
 }
 
 func (s *System) Close(ctx context.Context) error {
 	s.shut.Store(true)
 	s.mutex.Lock()
-	r := s.wake[0]
-	w := s.wake[1]
-	s.wake[0] = nil
-	s.wake[1] = nil
+	wake := s.wake
+	s.wake = nil
 	s.mutex.Unlock()
 
-	if r != nil {
-		r.Close()
-	}
-	if w != nil {
-		w.Close()
+	if wake != nil {
+		wake.close()
 	}
+	s.splice.close()
 	return s.FileTable.Close(ctx)
 }
 
@@ -1096,7 +1615,7 @@ func (s *System) Close(ctx context.Context) error {
 // the system, causing calls such as PollOneOff to unblock and return an
 // error indicating that the system is shutting down.
 func (s *System) Shutdown(ctx context.Context) error {
-	_, w, err := s.init()
+	wake, err := s.init()
 	if err != nil {
 		if err == context.Canceled {
 			err = nil // already shutdown
@@ -1104,29 +1623,28 @@ func (s *System) Shutdown(ctx context.Context) error {
 		return err
 	}
 	s.shut.Store(true)
-	return w.Close()
+	return wake.wake()
 }
 
-func (s *System) init() (*os.File, *os.File, error) {
+func (s *System) init() (waker, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if s.wake[0] == nil {
+	if s.wake == nil {
 		if s.shut.Load() {
-			return nil, nil, context.Canceled
+			return nil, context.Canceled
 		}
-		r, w, err := os.Pipe()
+		wake, err := openWaker()
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
-		s.wake[0] = r
-		s.wake[1] = w
+		s.wake = wake
 	}
 
-	return s.wake[0], s.wake[1], nil
+	return s.wake, nil
 }
 
-func (s *System) toUnixSockAddress(addr wasi.SocketAddress) (sa unix.Sockaddr, ok bool) {
+func (s *System) toUnixSockAddress(addr wasi.SocketAddress) (sa unix.Sockaddr, errno wasi.Errno) {
 	switch t := addr.(type) {
 	case *wasi.Inet4Address:
 		s.inet4.Port = t.Port
@@ -1137,12 +1655,29 @@ func (s *System) toUnixSockAddress(addr wasi.SocketAddress) (sa unix.Sockaddr, o
 		s.inet6.Addr = t.Addr
 		sa = &s.inet6
 	case *wasi.UnixAddress:
-		s.unix.Name = t.Name
+		name := t.Name
+		if isAbstractUnixSocket(name) {
+			if !abstractUnixSockets {
+				return nil, wasi.ENOTSUP
+			}
+			// The abstract namespace is selected by a leading null byte,
+			// which golang.org/x/sys/unix writes in place of @; names are
+			// normalized so both forms refer to the same socket.
+			name = "@" + name[1:]
+		}
+		s.unix.Name = name
 		sa = &s.unix
 	default:
-		return nil, false
+		return nil, wasi.EINVAL
 	}
-	return sa, true
+	return sa, wasi.ESUCCESS
+}
+
+// isAbstractUnixSocket returns true if name is the name of a unix socket in
+// the abstract namespace, starting with a null byte or with @ as in the
+// addresses returned by makeSocketAddress.
+func isAbstractUnixSocket(name string) bool {
+	return len(name) > 0 && (name[0] == 0 || name[0] == '@')
 }
 
 func makeSocketAddress(sa unix.Sockaddr) wasi.SocketAddress {
@@ -0,0 +1,521 @@
+package unix_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/systems/unix"
+	sysunix "golang.org/x/sys/unix"
+)
+
+func TestPathRenameCrossDevice(t *testing.T) {
+	ctx := context.Background()
+	srcDir := mountTmpfs(t)
+	dstDir := mountTmpfs(t)
+
+	if err := os.WriteFile(filepath.Join(srcDir, "file"), []byte("hello"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(srcDir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSystem()
+	defer s.Close(ctx)
+
+	preopen := func(path string) wasi.FD {
+		fd, err := sysunix.Open(path, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Preopen(unix.FD(fd), path, wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.DirectoryRights,
+			RightsInheriting: wasi.DirectoryRights | wasi.FileRights,
+		})
+	}
+	src := preopen(srcDir)
+	dst := preopen(dstDir)
+
+	if errno := s.PathRename(ctx, src, "file", dst, "moved"); errno != wasi.EXDEV {
+		t.Fatalf("wrong errno without cross-device renames: want EXDEV, got %s", errno)
+	}
+
+	s.CrossDeviceRename = true
+
+	if errno := s.PathRename(ctx, src, "file", dst, "moved"); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, "file")); !os.IsNotExist(err) {
+		t.Errorf("source file was not removed: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dstDir, "moved"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Errorf("wrong file content: want %q, got %q", "hello", b)
+	}
+	info, err := os.Stat(filepath.Join(dstDir, "moved"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0640 {
+		t.Errorf("wrong file mode: want %v, got %v", os.FileMode(0640), mode)
+	}
+
+	if errno := s.PathRename(ctx, src, "dir", dst, "dir"); errno != wasi.EXDEV {
+		t.Errorf("wrong errno renaming a directory: want EXDEV, got %s", errno)
+	}
+
+	entries, err := os.ReadDir(dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("unexpected files left in the target directory: %v", entries)
+	}
+}
+
+func mountTmpfs(t *testing.T) string {
+	dir := t.TempDir()
+	if err := sysunix.Mount("tmpfs", dir, "tmpfs", 0, "size=1m"); err != nil {
+		t.Skipf("mounting tmpfs: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := sysunix.Unmount(dir, 0); err != nil {
+			t.Error(err)
+		}
+	})
+	return dir
+}
+
+func TestPollOneOffPidFD(t *testing.T) {
+	// When run as a child process of the test, wait until killed.
+	if os.Getenv("WASI_GO_TEST_PIDFD_CHILD") != "" {
+		time.Sleep(time.Minute)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPollOneOffPidFD$")
+	cmd.Env = append(os.Environ(), "WASI_GO_TEST_PIDFD_CHILD=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	pidfd, err := sysunix.PidfdOpen(cmd.Process.Pid, 0)
+	if err != nil {
+		if err == sysunix.ENOSYS {
+			t.Skip("pidfd_open(2) is not supported")
+		}
+		t.Fatal(err)
+	}
+	fd := s.Register(unix.FD(pidfd), wasi.FDStat{
+		FileType:   wasi.UnknownType,
+		RightsBase: wasi.PollFDReadWriteRight,
+	})
+
+	poll := func(timeout time.Duration) []wasi.Event {
+		t.Helper()
+		subscriptions := []wasi.Subscription{
+			wasi.MakeSubscriptionFDReadWrite(1, wasi.FDReadEvent, wasi.SubscriptionFDReadWrite{FD: fd}),
+			wasi.MakeSubscriptionClock(2, wasi.SubscriptionClock{ID: wasi.Monotonic, Timeout: wasi.Timestamp(timeout)}),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+		n, errno := s.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		return events[:n]
+	}
+
+	// The pidfd is not readable while the process is running, a poll which
+	// does not block only reports the expiration of the timeout.
+	if events := poll(0); len(events) != 1 || events[0].UserData != 2 {
+		t.Fatalf("the pidfd was reported readable while the process was running: %+v", events)
+	}
+
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	if events := poll(10 * time.Second); len(events) != 1 || events[0].UserData != 1 || events[0].Errno != wasi.ESUCCESS {
+		t.Fatalf("the pidfd was not reported readable after the process exited: %+v", events)
+	}
+}
+
+// fullListener returns a listening socket bound to a loopback address, whose
+// accept queue was filled with connections. Until connections are accepted,
+// the kernel drops the SYN packets of new connections, which wait for the
+// retransmissions as if the peer was a black hole.
+func fullListener(t *testing.T) (listener, port int) {
+	t.Helper()
+	listener, err := sysunix.Socket(sysunix.AF_INET, sysunix.SOCK_STREAM|sysunix.SOCK_CLOEXEC|sysunix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sysunix.Close(listener) })
+	if err := sysunix.Bind(listener, &sysunix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sysunix.Listen(listener, 0); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := sysunix.Getsockname(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		fd, err := sysunix.Socket(sysunix.AF_INET, sysunix.SOCK_STREAM|sysunix.SOCK_CLOEXEC|sysunix.SOCK_NONBLOCK, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { sysunix.Close(fd) })
+		err = sysunix.Connect(fd, sa)
+		if err == sysunix.EINPROGRESS {
+			fds := []sysunix.PollFd{{Fd: int32(fd), Events: sysunix.POLLOUT}}
+			if n, _ := sysunix.Poll(fds, 100); n == 0 {
+				break // the queue is full
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return listener, sa.(*sysunix.SockaddrInet4).Port
+}
+
+func TestSockConnectInterrupted(t *testing.T) {
+	ctx := context.Background()
+
+	// The listener never accepts connections until the end of the test,
+	// which leaves time to deliver signals to the thread blocked in
+	// connect(2).
+	listener, port := fullListener(t)
+
+	// On Linux, connect(2) is not restarted after a signal if the socket has
+	// a send timeout, it returns EINTR instead even if the signal handler was
+	// installed with SA_RESTART, which is the case of the Go runtime.
+	fd, err := sysunix.Socket(sysunix.AF_INET, sysunix.SOCK_STREAM|sysunix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := sysunix.NsecToTimeval(int64(30 * time.Second))
+	if err := sysunix.SetsockoptTimeval(fd, sysunix.SOL_SOCKET, sysunix.SO_SNDTIMEO, &timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSystem()
+	defer s.Close(ctx)
+	sock := s.Preopen(unix.FD(fd), "socket", wasi.FDStat{
+		FileType:   wasi.SocketStreamType,
+		RightsBase: wasi.AllRights,
+	})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	tid := make(chan int, 1)
+	done := make(chan wasi.Errno, 1)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		tid <- sysunix.Gettid()
+		_, errno := s.SockConnect(ctx, sock, &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}, Port: port})
+		done <- errno
+	}()
+
+	// Interrupt the thread blocked in connect(2) until the connection was
+	// established, and start accepting the pending connections after a few
+	// signals were delivered so the next SYN retransmission succeeds.
+	thread := <-tid
+	accept := time.After(100 * time.Millisecond)
+	accepting := false
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case errno := <-done:
+			if errno != wasi.ESUCCESS {
+				t.Fatalf("sock_connect: %s", errno)
+			}
+			peer, errno := s.SockRemoteAddress(ctx, sock)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if addr := peer.(*wasi.Inet4Address); addr.Port != port {
+				t.Errorf("wrong peer port: want %d, got %d", port, addr.Port)
+			}
+			return
+		case <-accept:
+			accepting = true
+		case <-ticker.C:
+			sysunix.Tgkill(os.Getpid(), thread, syscall.SIGUSR1)
+			for accepting {
+				conn, _, err := sysunix.Accept4(listener, sysunix.SOCK_CLOEXEC)
+				if err != nil {
+					break
+				}
+				defer sysunix.Close(conn)
+			}
+		case <-signals:
+		}
+	}
+}
+
+func TestFDAdviseDontNeedWillNeed(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	const size = 4 << 20
+	fd, path := openAdviseFile(t, s, size)
+
+	// The residency of the file pages in the page cache is observed with
+	// mincore(2) on a mapping of the file, which does not fault the pages in.
+	f, err := sysunix.Open(path, sysunix.O_RDONLY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sysunix.Close(f)
+	if err := sysunix.Fsync(f); err != nil {
+		t.Fatal(err)
+	}
+	mem, err := sysunix.Mmap(f, 0, size, sysunix.PROT_READ, sysunix.MAP_SHARED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sysunix.Munmap(mem)
+
+	resident := func() (n int) {
+		vec := make([]byte, (size+os.Getpagesize()-1)/os.Getpagesize())
+		_, _, e := sysunix.Syscall(sysunix.SYS_MINCORE,
+			uintptr(unsafe.Pointer(&mem[0])),
+			uintptr(len(mem)),
+			uintptr(unsafe.Pointer(&vec[0])),
+		)
+		if e != 0 {
+			t.Fatal(e)
+		}
+		for _, v := range vec {
+			n += int(v & 1)
+		}
+		return n
+	}
+
+	if errno := s.FDAdvise(ctx, fd, 0, 0, wasi.DontNeed); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if n := resident(); n != 0 {
+		// File systems such as tmpfs keep the pages in memory.
+		t.Skipf("%d pages remain in the page cache after DontNeed", n)
+	}
+
+	if errno := s.FDAdvise(ctx, fd, 0, 0, wasi.WillNeed); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	// The readahead is asynchronous.
+	deadline := time.Now().Add(5 * time.Second)
+	for resident() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no pages were read ahead after WillNeed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSockTCPFastOpen(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	sockOpen := func() wasi.FD {
+		t.Helper()
+		fd, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		return fd
+	}
+
+	server := sockOpen()
+	defer s.FDClose(ctx, server)
+
+	switch errno := s.SockSetOpt(ctx, server, wasi.TcpFastOpen, wasi.IntValue(8)); errno {
+	case wasi.ESUCCESS:
+	case wasi.ENOPROTOOPT, wasi.ENOTSUP:
+		t.Skip("TCP Fast Open is not supported:", errno)
+	default:
+		t.Fatal(errno)
+	}
+	if v, errno := s.SockGetOpt(ctx, server, wasi.TcpFastOpen); errno != wasi.ESUCCESS || v != wasi.IntValue(8) {
+		t.Fatalf("wrong TcpFastOpen value: want=(8, ESUCCESS) got=(%v, %s)", v, errno)
+	}
+
+	addr, errno := s.SockBind(ctx, server, &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}})
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if errno := s.SockListen(ctx, server, 8); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	// exchange accepts the connection of the client and checks that the
+	// data it sent was received.
+	exchange := func(client wasi.FD, want string) {
+		t.Helper()
+		conn, _, _, errno := s.SockAccept(ctx, server, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		defer s.FDClose(ctx, conn)
+		buf := make([]byte, 32)
+		n, _, errno := s.SockRecv(ctx, conn, []wasi.IOVec{buf}, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Errorf("wrong data received: want=%q got=%q", want, got)
+		}
+	}
+
+	// Sockets which did not opt into fast open are not connected by sendto.
+	client := sockOpen()
+	if n, errno := s.SockSendTo(ctx, client, []wasi.IOVec{[]byte("sendto")}, 0, addr); errno == wasi.ESUCCESS {
+		t.Fatalf("sendto without TcpFastOpenConnect: want an error got=(%d, %s)", n, errno)
+	}
+	s.FDClose(ctx, client)
+
+	// The first connection obtains a cookie from the server, the following
+	// ones carry the data in the SYN. The kernel falls back to a regular
+	// handshake when it cannot use fast open, which is not observable here.
+	for i := 0; i < 2; i++ {
+		client := sockOpen()
+		if errno := s.SockSetOpt(ctx, client, wasi.TcpFastOpenConnect, wasi.IntValue(1)); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		n, errno := s.SockSendTo(ctx, client, []wasi.IOVec{[]byte("sendto")}, 0, addr)
+		if errno == wasi.ENOTSUP {
+			t.Skip("TCP Fast Open is not enabled for clients")
+		}
+		if errno != wasi.ESUCCESS || n != 6 {
+			t.Fatalf("sendto: want=(6, ESUCCESS) got=(%d, %s)", n, errno)
+		}
+		exchange(client, "sendto")
+		s.FDClose(ctx, client)
+	}
+
+	client = sockOpen()
+	defer s.FDClose(ctx, client)
+	if errno := s.SockSetOpt(ctx, client, wasi.TcpFastOpenConnect, wasi.IntValue(1)); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if v, errno := s.SockGetOpt(ctx, client, wasi.TcpFastOpenConnect); errno != wasi.ESUCCESS || v != wasi.IntValue(1) {
+		t.Fatalf("wrong TcpFastOpenConnect value: want=(1, ESUCCESS) got=(%v, %s)", v, errno)
+	}
+	if _, errno := s.SockConnect(ctx, client, addr); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if n, errno := s.SockSend(ctx, client, []wasi.IOVec{[]byte("connect")}, 0); errno != wasi.ESUCCESS || n != 7 {
+		t.Fatalf("send: want=(7, ESUCCESS) got=(%d, %s)", n, errno)
+	}
+	exchange(client, "connect")
+}
+
+func TestSockConnectTimeout(t *testing.T) {
+	ctx := context.Background()
+	_, port := fullListener(t)
+
+	const timeout = 100 * time.Millisecond
+	s := newSystem()
+	s.ConnectTimeout = timeout
+	defer s.Close(ctx)
+
+	sock, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	start := time.Now()
+	_, errno = s.SockConnect(ctx, sock, &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}, Port: port})
+	elapsed := time.Since(start)
+	if errno != wasi.ETIMEDOUT {
+		t.Fatalf("wrong error: want ETIMEDOUT, got %s", errno)
+	}
+	if elapsed < timeout || elapsed > 10*timeout {
+		t.Errorf("connect timed out after %s, want %s", elapsed, timeout)
+	}
+
+	// The host socket is put back in blocking mode.
+	f, _, errno := s.LookupFD(sock, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	flags, err := sysunix.FcntlInt(uintptr(f.FD), sysunix.F_GETFL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags&sysunix.O_NONBLOCK != 0 {
+		t.Error("the socket was left in non-blocking mode")
+	}
+}
+
+func TestPathOpenTemporaryLink(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	dirFD, dir := preopenTempDir(t, s)
+
+	const rights = wasi.FDWriteRight | wasi.PathLinkSourceRight
+	fd, errno := s.PathOpen(ctx, dirFD, 0, ".", wasi.OpenTemporary, rights, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	defer s.FDClose(ctx, fd)
+
+	if _, errno := s.FDWrite(ctx, fd, []wasi.IOVec{[]byte("hello")}); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if entries, err := os.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 0 {
+		t.Fatalf("the temporary file has a directory entry before being linked: %v", entries)
+	}
+
+	if errno := s.PathLink(ctx, fd, 0, "", dirFD, "linked"); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "linked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Errorf("wrong file content: want %q, got %q", "hello", b)
+	}
+
+	// The temporary file gets the same mode as files created with OpenCreate;
+	// the usual process umask (022) does not change it.
+	info, err := os.Stat(filepath.Join(dir, "linked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0644 {
+		t.Errorf("wrong file mode: want %v, got %v", os.FileMode(0644), mode)
+	}
+}
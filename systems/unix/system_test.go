@@ -1,16 +1,29 @@
 package unix_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math"
+	"math/rand"
+	mathrand "math/rand"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"testing/fstest"
+	"testing/iotest"
 	"time"
 
 	"github.com/stealthrocket/wasi-go"
@@ -204,6 +217,73 @@ func TestSystemPollAndShutdown(t *testing.T) {
 	})
 }
 
+func TestPollOneOffHangupUnknownType(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	fds, err := pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sysunix.Close(fds[1])
+
+	// A file descriptor registered by the host with an unknown type can only
+	// be polled, the hangup is reported in the event.
+	fd := s.Register(unix.FD(fds[0]), wasi.FDStat{
+		FileType:   wasi.UnknownType,
+		RightsBase: wasi.PollFDReadWriteRight,
+	})
+
+	subscriptions := []wasi.Subscription{
+		wasi.MakeSubscriptionFDReadWrite(1, wasi.FDReadEvent, wasi.SubscriptionFDReadWrite{FD: fd}),
+	}
+	events := make([]wasi.Event, len(subscriptions))
+	n, errno := s.PollOneOff(ctx, subscriptions, events)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if n != 1 || !events[0].FDReadWrite.Flags.Has(wasi.Hangup) {
+		t.Errorf("the hangup was not reported: %+v", events[:n])
+	}
+}
+
+func TestSystemPollAndConcurrentShutdown(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		subscriptions := []wasi.Subscription{
+			subscribeFDRead(0),
+			subscribeFDRead(1),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+
+		// Shutdown must unblock a call to PollOneOff which is already waiting
+		// in poll(2), not only the ones made after it returned.
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			if err := p.Shutdown(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		n, errno := p.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		if !reflect.DeepEqual(events[:n], []wasi.Event{
+			{UserData: 42, EventType: wasi.FDReadEvent, Errno: wasi.ECANCELED},
+			{UserData: 43, EventType: wasi.FDReadEvent, Errno: wasi.ECANCELED},
+		}) {
+			t.Errorf("poll_oneoff: wrong events: %+v", events)
+		}
+
+		// Shutting down again is a no-op.
+		if err := p.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
 func TestSystemPollBadFileDescriptor(t *testing.T) {
 	testSystem(func(ctx context.Context, p *unix.System) {
 		subscriptions := []wasi.Subscription{
@@ -273,156 +353,3015 @@ func TestSystemPollMissingMonotonicClock(t *testing.T) {
 	})
 }
 
-func TestSockAddressInfo(t *testing.T) {
-	testSystem(func(ctx context.Context, s *unix.System) {
-		results := make([]wasi.AddressInfo, 64)
-		tcp4Hint := wasi.AddressInfo{Family: wasi.InetFamily, SocketType: wasi.StreamSocket, Protocol: wasi.TCPProtocol}
-		tcp6Hint := wasi.AddressInfo{Family: wasi.Inet6Family, SocketType: wasi.StreamSocket, Protocol: wasi.TCPProtocol}
+func TestSystemPollSubMillisecondTimeout(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("sub-millisecond timeouts require ppoll(2)")
+	}
+	testSystem(func(ctx context.Context, p *unix.System) {
+		const timeout = 200 * time.Microsecond
 
-		// Lookup :http. It's probably 80, but let's be sure.
-		httpPort, err := net.LookupPort("tcp", "http")
-		if err != nil {
-			t.Fatal(err)
+		subscriptions := []wasi.Subscription{
+			subscribeTimeout(timeout),
 		}
+		events := make([]wasi.Event, len(subscriptions))
 
-		// Test name resolution (example.com => $IP) and port resolution (http => 80).
-		n, errno := s.SockAddressInfo(ctx, "example.com", "http", tcp4Hint, results)
-		if n <= 0 || errno != wasi.ESUCCESS {
-			t.Fatalf("SockAddressInfo => %d, %s", n, errno)
+		// Scheduling delays may only lengthen the measurement, so the shortest
+		// of a few attempts is compared against the timeout.
+		delay := time.Duration(-1)
+		for i := 0; i < 10; i++ {
+			start := time.Now()
+			n, err := p.PollOneOff(ctx, subscriptions, events)
+			elapsed := time.Since(start)
+			if err != wasi.ESUCCESS {
+				t.Fatal(err)
+			}
+			if n != 1 || events[0].EventType != wasi.ClockEvent {
+				t.Fatalf("poll_oneoff: wrong events: %+v", events[:n])
+			}
+			if delay < 0 || elapsed < delay {
+				delay = elapsed
+			}
 		}
-		var port int
-		switch a := results[0].Address.(type) {
-		case *wasi.Inet4Address:
-			port = a.Port
-		case *wasi.Inet6Address:
-			port = a.Port
-		default:
-			t.Fatalf("unexpected address: %#v", a)
+
+		if delay < timeout {
+			t.Errorf("poll_oneoff: returned before the timeout expired: %s < %s", delay, timeout)
 		}
-		if port != httpPort {
-			t.Fatalf("unexpected port: got %d, expect %d", port, httpPort)
+		if delay >= time.Millisecond {
+			t.Errorf("poll_oneoff: timeout was rounded to a millisecond: %s", delay)
 		}
+	})
+}
 
-		// Test AI_NUMERICHOST and AI_NUMERICSERV.
-		numericHint := tcp4Hint
-		numericHint.Flags |= wasi.NumericHost | wasi.NumericService
-		n, errno = s.SockAddressInfo(ctx, "1.2.3.4", "56", numericHint, results)
-		if n != 1 || errno != wasi.ESUCCESS {
-			t.Fatalf("SockAddressInfo => %d, %s", n, errno)
-		}
-		if ipv4, ok := results[0].Address.(*wasi.Inet4Address); !ok {
-			t.Fatalf("unexpected result: %#v", results[n])
-		} else if host := ipv4.String(); host != "1.2.3.4:56" {
-			t.Fatalf("unexpected result: %s", host)
+func TestSockOpenRaw(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("raw sockets are not allowed by default", func(t *testing.T) {
+		s := newSystem()
+		defer s.Close(ctx)
+
+		_, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.RawSocket, wasi.ICMPProtocol, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.EPERM {
+			t.Errorf("wrong errno: want EPERM, got %s", errno)
 		}
+	})
+
+	t.Run("raw sockets require a matching protocol", func(t *testing.T) {
+		s := newSystem()
+		s.AllowRawSockets = true
+		defer s.Close(ctx)
 
-		// Test empty name with/without AI_PASSIVE.
 		for _, test := range []struct {
-			node    string
-			service string
-			hint    wasi.AddressInfo
-			flags   wasi.AddressInfoFlags
-			expect  string
+			family   wasi.ProtocolFamily
+			protocol wasi.Protocol
+			errno    wasi.Errno
 		}{
-			{
-				service: "80",
-				hint:    tcp4Hint,
-				flags:   wasi.Passive,
-				expect:  "0.0.0.0:80",
-			},
-			{
-				service: "80",
-				hint:    tcp6Hint,
-				flags:   wasi.Passive,
-				expect:  "[::]:80",
-			},
-			{
-				service: "80",
-				hint:    tcp4Hint,
-				expect:  "127.0.0.1:80",
-			},
-			{
-				service: "80",
-				hint:    tcp6Hint,
-				expect:  "[::1]:80",
-			},
+			{wasi.UnixFamily, wasi.IPProtocol, wasi.EINVAL},
+			{wasi.InetFamily, wasi.ICMPv6Protocol, wasi.EPROTONOSUPPORT},
+			{wasi.Inet6Family, wasi.ICMPProtocol, wasi.EPROTONOSUPPORT},
 		} {
-			t.Run(fmt.Sprintf("%#v hint with %v", test.hint, test.flags), func(t *testing.T) {
-				hint := test.hint
-				hint.Flags |= test.flags
-				n, errno = s.SockAddressInfo(ctx, "", test.service, hint, results)
-				if n != 1 || errno != wasi.ESUCCESS {
-					t.Fatalf("SockAddressInfo => %d, %s", n, errno)
-				}
-				result := results[0]
+			_, errno := s.SockOpen(ctx, test.family, wasi.RawSocket, test.protocol, wasi.AllRights, wasi.AllRights)
+			if errno != test.errno {
+				t.Errorf("%s/%s: wrong errno: want %s, got %s", test.family, test.protocol, test.errno, errno)
+			}
+		}
+	})
 
-				var actual string
-				switch a := result.Address.(type) {
-				case *wasi.Inet4Address:
-					actual = a.String()
-				case *wasi.Inet6Address:
-					actual = a.String()
-				default:
-					t.Fatalf("unexpected results %T %#v", result.Address, result)
-				}
-				if actual != test.expect {
-					t.Errorf("unexpected result: got %v, expect %v", actual, test.expect)
-				}
-			})
+	t.Run("sending an icmp echo request", func(t *testing.T) {
+		s := newSystem()
+		s.AllowRawSockets = true
+		defer s.Close(ctx)
+
+		fd, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.RawSocket, wasi.ICMPProtocol, wasi.AllRights, wasi.AllRights)
+		switch errno {
+		case wasi.ESUCCESS:
+		case wasi.EPERM, wasi.EACCES:
+			t.Skip("opening raw sockets requires privileges")
+		default:
+			t.Fatal(errno)
+		}
+		if errno := s.SockSetOpt(ctx, fd, wasi.RecvTimeout, wasi.TimeValue(time.Second)); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		const id = 0x5741
+		request := []byte{8, 0, 0, 0, id >> 8, id & 0xFF, 0, 1, 'p', 'i', 'n', 'g'}
+		binary.BigEndian.PutUint16(request[2:], icmpChecksum(request))
+
+		loopback := &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}}
+		if _, errno := s.SockSendTo(ctx, fd, []wasi.IOVec{request}, 0, loopback); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		// The socket receives all the ICMP messages on the host, including
+		// the request that was just sent; the data starts with the IP header.
+		buf := make([]byte, 1500)
+		for {
+			n, _, addr, errno := s.SockRecvFrom(ctx, fd, []wasi.IOVec{buf}, 0)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			packet := buf[:n]
+			reply := packet[int(packet[0]&0x0F)*4:]
+			if len(reply) < len(request) || reply[0] != 0 || binary.BigEndian.Uint16(reply[4:]) != id {
+				continue
+			}
+			if !bytes.Equal(reply[8:len(request)], request[8:]) {
+				t.Errorf("wrong echo reply payload: %q", reply[8:])
+			}
+			if addr.String() != loopback.String() {
+				t.Errorf("wrong echo reply address: %s", addr)
+			}
+			break
 		}
 	})
 }
 
-func testSystem(f func(context.Context, *unix.System)) {
+func TestSockSendNoSignal(t *testing.T) {
 	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
 
-	p := newSystem()
-	defer p.Close(ctx)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
 
-	fds, err := pipe()
+	fd, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	addr := &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}, Port: l.Addr().(*net.TCPAddr).Port}
+	if _, errno := s.SockConnect(ctx, fd, addr); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	conn, err := l.Accept()
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
-	p.Preopen(unix.FD(fds[0]), "fd0", wasi.FDStat{RightsBase: wasi.AllRights})
-	p.Preopen(unix.FD(fds[1]), "fd1", wasi.FDStat{RightsBase: wasi.AllRights})
+	// Closing the peer with a zero linger time resets the connection.
+	conn.(*net.TCPConn).SetLinger(0)
+	conn.Close()
 
-	f(ctx, p)
-}
+	// Receiving SIGPIPE on the channel means the signal was raised by the
+	// kernel instead of being suppressed for the socket.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGPIPE)
+	defer signal.Stop(signals)
 
-func newSystem() *unix.System {
-	return &unix.System{
-		Realtime:           realtime,
-		RealtimePrecision:  time.Microsecond,
-		Monotonic:          monotonic,
-		MonotonicPrecision: time.Nanosecond,
+	// The first send observes the reset, the kernel raises SIGPIPE on the
+	// following ones.
+	deadline := time.Now().Add(time.Second)
+	for failures := 0; failures < 2; {
+		n, errno := s.SockSend(ctx, fd, []wasi.IOVec{[]byte("hello")}, 0)
+		switch errno {
+		case wasi.EPIPE:
+			if n != ^wasi.Size(0) {
+				t.Errorf("wrong size: want -1, got %d", int32(n))
+			}
+			failures++
+		case wasi.ESUCCESS:
+			if time.Now().After(deadline) {
+				t.Fatal("sending on a reset connection did not fail")
+			}
+			time.Sleep(10 * time.Millisecond)
+		default:
+			t.Fatalf("wrong errno: want EPIPE, got %s", errno)
+		}
+	}
+
+	select {
+	case sig := <-signals:
+		t.Errorf("the process received %s", sig)
+	case <-time.After(100 * time.Millisecond):
 	}
 }
 
-var epoch = time.Now()
+func TestSockAcceptNonBlock(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
 
-func realtime(context.Context) (uint64, error) {
-	return uint64(time.Now().UnixNano()), nil
+	server, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if errno := s.FDStatSetFlags(ctx, server, wasi.NonBlock); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	addr, errno := s.SockBind(ctx, server, &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}})
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if errno := s.SockListen(ctx, server, 10); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	for _, flags := range []wasi.FDFlags{0, wasi.NonBlock} {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		if n, errno := s.PollOneOff(ctx, []wasi.Subscription{subscribeFDRead(server)}, make([]wasi.Event, 1)); n != 1 || errno != wasi.ESUCCESS {
+			t.Fatalf("poll_oneoff: %d, %s", n, errno)
+		}
+		accept, _, _, errno := s.SockAccept(ctx, server, flags)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		stat, errno := s.FDStatGet(ctx, accept)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat.Flags != flags {
+			t.Errorf("wrong flags: want %s, got %s", flags, stat.Flags)
+		}
+
+		// The accepted socket is in the requested mode regardless of the
+		// mode of the listening socket.
+		fd, _, errno := s.LookupFD(accept, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		fl, err := sysunix.FcntlInt(uintptr(fd.FD), sysunix.F_GETFL, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nonBlock := (fl & sysunix.O_NONBLOCK) != 0; nonBlock != flags.Has(wasi.NonBlock) {
+			t.Errorf("wrong O_NONBLOCK flag for %s: want %t, got %t", flags, flags.Has(wasi.NonBlock), nonBlock)
+		}
+
+		if errno := s.FDClose(ctx, accept); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+	}
 }
 
-func monotonic(context.Context) (uint64, error) {
-	return uint64(time.Since(epoch)), nil
+func TestSockDontWait(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	fds := socketpair(t)
+	stat := wasi.FDStat{
+		FileType:         wasi.SocketStreamType,
+		RightsBase:       wasi.AllRights,
+		RightsInheriting: wasi.AllRights,
+	}
+	r := s.Register(unix.FD(fds[0]), stat)
+	w := s.Register(unix.FD(fds[1]), stat)
+
+	// The sockets are in blocking mode, the flags make the individual calls
+	// return EAGAIN instead of blocking.
+	buf := make([]byte, 4096)
+	n, _, errno := s.SockRecv(ctx, r, []wasi.IOVec{buf}, wasi.RecvDontWait)
+	if n != ^wasi.Size(0) || errno != wasi.EAGAIN {
+		t.Fatalf("wrong result: want -1/EAGAIN, got %d/%s", int32(n), errno)
+	}
+
+	for i := 0; ; i++ {
+		n, errno := s.SockSend(ctx, w, []wasi.IOVec{buf}, wasi.SendDontWait)
+		if errno == wasi.EAGAIN {
+			if n != ^wasi.Size(0) {
+				t.Errorf("wrong size: want -1, got %d", int32(n))
+			}
+			break
+		}
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if i == 1e6 {
+			t.Fatal("sending never returned EAGAIN")
+		}
+	}
+
+	n, _, errno = s.SockRecv(ctx, r, []wasi.IOVec{buf}, wasi.RecvDontWait)
+	if errno != wasi.ESUCCESS || n != wasi.Size(len(buf)) {
+		t.Fatalf("wrong result: want %d/ESUCCESS, got %d/%s", len(buf), int32(n), errno)
+	}
 }
 
-func subscribeFDRead(fd wasi.FD) wasi.Subscription {
-	return wasi.MakeSubscriptionFDReadWrite(
-		wasi.UserData(42+fd),
-		wasi.FDReadEvent,
-		wasi.SubscriptionFDReadWrite{FD: fd},
-	)
+func TestSystemPollHalfClosed(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("half-closed connections are reported with POLLRDHUP, which is only supported on Linux")
+	}
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	fds := socketpair(t)
+	stat := wasi.FDStat{
+		FileType:         wasi.SocketStreamType,
+		RightsBase:       wasi.AllRights,
+		RightsInheriting: wasi.AllRights,
+	}
+	local := s.Register(unix.FD(fds[0]), stat)
+	peer := s.Register(unix.FD(fds[1]), stat)
+
+	subscriptions := []wasi.Subscription{
+		subscribeFDRead(local),
+		wasi.MakeSubscriptionFDReadWrite(99, wasi.FDWriteEvent, wasi.SubscriptionFDReadWrite{FD: local}),
+	}
+	events := make([]wasi.Event, len(subscriptions))
+
+	// The socket is only writable while the peer has not shut down.
+	n, errno := s.PollOneOff(ctx, append(subscriptions, subscribeTimeout(0)), make([]wasi.Event, 3))
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if n != 2 {
+		t.Fatalf("wrong number of events before shutdown: want 2, got %d", n)
+	}
+
+	if errno := s.SockShutdown(ctx, peer, wasi.ShutdownWR); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	n, errno = s.PollOneOff(ctx, subscriptions, events)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if !reflect.DeepEqual(events[:n], []wasi.Event{
+		{UserData: subscriptions[0].UserData, EventType: wasi.FDReadEvent, FDReadWrite: wasi.EventFDReadWrite{Flags: wasi.Hangup}},
+		{UserData: 99, EventType: wasi.FDWriteEvent},
+	}) {
+		t.Errorf("poll_oneoff: wrong events after the peer shut down: %+v", events[:n])
+	}
 }
 
-func subscribeTimeout(timeout time.Duration) wasi.Subscription {
-	return wasi.MakeSubscriptionClock(
-		wasi.UserData(42),
-		wasi.SubscriptionClock{
-			ID:        wasi.Monotonic,
-			Timeout:   wasi.Timestamp(timeout),
-			Precision: wasi.Timestamp(time.Nanosecond),
-		},
-	)
+// tcpConnection returns the two ends of a blocking TCP connection on the
+// loopback interface.
+func tcpConnection(t testing.TB, ctx context.Context, s *unix.System) (client, conn wasi.FD) {
+	t.Helper()
+	sockOpen := func() wasi.FD {
+		t.Helper()
+		fd, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		return fd
+	}
+
+	server := sockOpen()
+	defer s.FDClose(ctx, server)
+
+	addr, errno := s.SockBind(ctx, server, &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}})
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if errno := s.SockListen(ctx, server, 1); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	client = sockOpen()
+	if _, errno := s.SockConnect(ctx, client, addr); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	conn, _, _, errno = s.SockAccept(ctx, server, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	return client, conn
+}
+
+func TestSystemPollPriority(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	client, conn := tcpConnection(t, ctx, s)
+
+	sock, _, errno := s.LookupSocketFD(client, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if err := sysunix.Sendto(int(sock.FD), []byte("!"), sysunix.MSG_OOB, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions := []wasi.Subscription{
+		wasi.MakeSubscriptionFDReadWrite(1, wasi.FDReadEvent, wasi.SubscriptionFDReadWrite{FD: conn}),
+		wasi.MakeSubscriptionFDReadWrite(2, wasi.FDReadEvent, wasi.SubscriptionFDReadWrite{FD: conn, Flags: wasi.PollPriority}),
+	}
+	events := make([]wasi.Event, len(subscriptions))
+
+	// Both subscriptions are triggered, only the one which requested it is
+	// notified of the priority data.
+	n, errno := s.PollOneOff(ctx, subscriptions, events)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if !reflect.DeepEqual(events[:n], []wasi.Event{
+		{UserData: 1, EventType: wasi.FDReadEvent},
+		{UserData: 2, EventType: wasi.FDReadEvent, FDReadWrite: wasi.EventFDReadWrite{Flags: wasi.Priority}},
+	}) {
+		t.Errorf("poll_oneoff: wrong events after sending out-of-band data: %+v", events[:n])
+	}
+}
+
+func TestSockSendRecvOOB(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	client, conn := tcpConnection(t, ctx, s)
+
+	for _, send := range []struct {
+		data  string
+		flags wasi.SIFlags
+	}{
+		{"a", 0},
+		{"!", wasi.SendOOB},
+		{"b", 0},
+	} {
+		if _, errno := s.SockSend(ctx, client, []wasi.IOVec{[]byte(send.data)}, send.flags); errno != wasi.ESUCCESS {
+			t.Fatalf("sending %q: %s", send.data, errno)
+		}
+	}
+
+	// Wait for the out-of-band data to be received, it is not reported by
+	// normal reads.
+	subscriptions := []wasi.Subscription{
+		wasi.MakeSubscriptionFDReadWrite(1, wasi.FDReadEvent, wasi.SubscriptionFDReadWrite{FD: conn, Flags: wasi.PollPriority}),
+	}
+	events := make([]wasi.Event, 1)
+	for !events[0].FDReadWrite.Flags.Has(wasi.Priority) {
+		if _, errno := s.PollOneOff(ctx, subscriptions, events); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+	}
+
+	buffer := make([]byte, 16)
+	n, _, errno := s.SockRecv(ctx, conn, []wasi.IOVec{buffer}, wasi.RecvOOB)
+	if errno != wasi.ESUCCESS {
+		t.Fatalf("receiving out-of-band data: %s", errno)
+	}
+	if string(buffer[:n]) != "!" {
+		t.Errorf("wrong out-of-band data: want %q, got %q", "!", buffer[:n])
+	}
+
+	// Reads stop at the position where the out-of-band byte was sent.
+	var data []byte
+	for len(data) < 2 {
+		n, _, errno = s.SockRecv(ctx, conn, []wasi.IOVec{buffer}, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if n == 0 {
+			break
+		}
+		data = append(data, buffer[:n]...)
+	}
+	if string(data) != "ab" {
+		t.Errorf("wrong data: want %q, got %q", "ab", data)
+	}
+}
+
+func TestSockAbstractUnix(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	sockOpen := func() wasi.FD {
+		t.Helper()
+		fd, errno := s.SockOpen(ctx, wasi.UnixFamily, wasi.StreamSocket, 0, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		return fd
+	}
+
+	name := fmt.Sprintf("wasi-go-test-%d", os.Getpid())
+	server := sockOpen()
+
+	if runtime.GOOS != "linux" {
+		if _, errno := s.SockBind(ctx, server, &wasi.UnixAddress{Name: "@" + name}); errno != wasi.ENOTSUP {
+			t.Errorf("binding an abstract unix socket: want ENOTSUP, got %s", errno)
+		}
+		return
+	}
+
+	addr, errno := s.SockBind(ctx, server, &wasi.UnixAddress{Name: "@" + name})
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if want := (&wasi.UnixAddress{Name: "@" + name}); !reflect.DeepEqual(addr, want) {
+		t.Errorf("wrong address of the abstract unix socket: want %s, got %s", want, addr)
+	}
+	if errno := s.SockListen(ctx, server, 1); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	// Names starting with a null byte refer to the same socket.
+	client := sockOpen()
+	if _, errno := s.SockConnect(ctx, client, &wasi.UnixAddress{Name: "\x00" + name}); errno != wasi.ESUCCESS && errno != wasi.EINPROGRESS {
+		t.Fatalf("connecting to the abstract unix socket: %s", errno)
+	}
+	conn, _, _, errno := s.SockAccept(ctx, server, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	if _, errno := s.SockSend(ctx, client, []wasi.IOVec{[]byte("hello")}, 0); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	buffer := make([]byte, 16)
+	n, _, errno := s.SockRecv(ctx, conn, []wasi.IOVec{buffer}, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if string(buffer[:n]) != "hello" {
+		t.Errorf("wrong data received: %q", buffer[:n])
+	}
+
+	// The socket was not created on the file system.
+	if _, err := os.Lstat("@" + name); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("abstract unix socket created a file: %v", err)
+	}
+}
+
+func TestSockNetworkPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	loopback := &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}, Port: l.Addr().(*net.TCPAddr).Port}
+	external := &wasi.Inet4Address{Addr: [4]byte{192, 0, 2, 1}, Port: 80}
+
+	for _, test := range []struct {
+		policy   unix.NetworkPolicy
+		loopback wasi.Errno
+		external wasi.Errno
+	}{
+		{unix.NetworkAll, wasi.ESUCCESS, wasi.ESUCCESS},
+		{unix.NetworkLoopback, wasi.ESUCCESS, wasi.EACCES},
+		{unix.NetworkNone, wasi.EACCES, wasi.EACCES},
+	} {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			s := newSystem()
+			s.NetworkPolicy = test.policy
+			defer s.Close(ctx)
+
+			sockOpen := func(st wasi.SocketType, proto wasi.Protocol) wasi.FD {
+				fd, errno := s.SockOpen(ctx, wasi.InetFamily, st, proto, wasi.AllRights, wasi.AllRights)
+				if errno != wasi.ESUCCESS {
+					t.Fatal(errno)
+				}
+				return fd
+			}
+
+			sock := sockOpen(wasi.StreamSocket, wasi.TCPProtocol)
+			if _, errno := s.SockConnect(ctx, sock, loopback); errno != test.loopback {
+				t.Errorf("connecting to %s: want %s, got %s", loopback, test.loopback, errno)
+			}
+
+			// Connections to external addresses are not attempted when the
+			// policy denies them, and may not succeed in the test environment
+			// otherwise.
+			if test.external == wasi.EACCES {
+				sock := sockOpen(wasi.StreamSocket, wasi.TCPProtocol)
+				if _, errno := s.SockConnect(ctx, sock, external); errno != wasi.EACCES {
+					t.Errorf("connecting to %s: want EACCES, got %s", external, errno)
+				}
+				sock = sockOpen(wasi.StreamSocket, wasi.TCPProtocol)
+				if _, errno := s.SockBind(ctx, sock, &wasi.Inet4Address{}); errno != wasi.EACCES {
+					t.Errorf("binding to the wildcard address: want EACCES, got %s", errno)
+				}
+				sock = sockOpen(wasi.DatagramSocket, wasi.UDPProtocol)
+				if _, errno := s.SockSendTo(ctx, sock, []wasi.IOVec{[]byte("hello")}, 0, external); errno != wasi.EACCES {
+					t.Errorf("sending to %s: want EACCES, got %s", external, errno)
+				}
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		addr   wasi.SocketAddress
+		policy unix.NetworkPolicy
+		allow  bool
+	}{
+		{&wasi.Inet4Address{Addr: [4]byte{127, 1, 2, 3}}, unix.NetworkLoopback, true},
+		{&wasi.Inet4Address{Addr: [4]byte{10, 0, 0, 1}}, unix.NetworkLoopback, false},
+		{&wasi.Inet6Address{Addr: [16]byte{15: 1}}, unix.NetworkLoopback, true},
+		{&wasi.Inet6Address{Addr: [16]byte{0: 0x20, 1: 0x01, 2: 0x0d, 3: 0xb8, 15: 1}}, unix.NetworkLoopback, false},
+		{&wasi.UnixAddress{Name: "/tmp/socket"}, unix.NetworkLoopback, true},
+		{&wasi.UnixAddress{Name: "/tmp/socket"}, unix.NetworkNone, true},
+		{&wasi.Inet6Address{Addr: [16]byte{15: 1}}, unix.NetworkNone, false},
+	} {
+		if allow := test.policy.Allow(test.addr); allow != test.allow {
+			t.Errorf("%s policy allowing %s: want %t, got %t", test.policy, test.addr, test.allow, allow)
+		}
+	}
+}
+
+func TestSockDialPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	rule, err := wasi.ParseDialRule(fmt.Sprintf("127.0.0.0/8:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSystem()
+	s.DialPolicy = wasi.AllowDial(rule)
+	defer s.Close(ctx)
+
+	for _, test := range []struct {
+		addr wasi.SocketAddress
+		want wasi.Errno
+	}{
+		{&wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}, Port: port}, wasi.ESUCCESS},
+		{&wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}, Port: port + 1}, wasi.EACCES},
+		{&wasi.Inet4Address{Addr: [4]byte{192, 0, 2, 1}, Port: port}, wasi.EACCES},
+	} {
+		sock, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := s.SockConnect(ctx, sock, test.addr); errno != test.want {
+			t.Errorf("connecting to %s: want %s, got %s", test.addr, test.want, errno)
+		}
+
+		sock, errno = s.SockOpen(ctx, wasi.InetFamily, wasi.DatagramSocket, wasi.UDPProtocol, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := s.SockSendTo(ctx, sock, []wasi.IOVec{[]byte("hello")}, 0, test.addr); errno != test.want {
+			t.Errorf("sending to %s: want %s, got %s", test.addr, test.want, errno)
+		}
+	}
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 != 0 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return ^uint16(sum)
+}
+
+func TestSockAddressInfo(t *testing.T) {
+	testSystem(func(ctx context.Context, s *unix.System) {
+		results := make([]wasi.AddressInfo, 64)
+		tcp4Hint := wasi.AddressInfo{Family: wasi.InetFamily, SocketType: wasi.StreamSocket, Protocol: wasi.TCPProtocol}
+		tcp6Hint := wasi.AddressInfo{Family: wasi.Inet6Family, SocketType: wasi.StreamSocket, Protocol: wasi.TCPProtocol}
+
+		// Lookup :http. It's probably 80, but let's be sure.
+		httpPort, err := net.LookupPort("tcp", "http")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Test name resolution (example.com => $IP) and port resolution (http => 80).
+		n, errno := s.SockAddressInfo(ctx, "example.com", "http", tcp4Hint, results)
+		if n <= 0 || errno != wasi.ESUCCESS {
+			t.Fatalf("SockAddressInfo => %d, %s", n, errno)
+		}
+		var port int
+		switch a := results[0].Address.(type) {
+		case *wasi.Inet4Address:
+			port = a.Port
+		case *wasi.Inet6Address:
+			port = a.Port
+		default:
+			t.Fatalf("unexpected address: %#v", a)
+		}
+		if port != httpPort {
+			t.Fatalf("unexpected port: got %d, expect %d", port, httpPort)
+		}
+
+		// Test AI_NUMERICHOST and AI_NUMERICSERV.
+		numericHint := tcp4Hint
+		numericHint.Flags |= wasi.NumericHost | wasi.NumericService
+		n, errno = s.SockAddressInfo(ctx, "1.2.3.4", "56", numericHint, results)
+		if n != 1 || errno != wasi.ESUCCESS {
+			t.Fatalf("SockAddressInfo => %d, %s", n, errno)
+		}
+		if ipv4, ok := results[0].Address.(*wasi.Inet4Address); !ok {
+			t.Fatalf("unexpected result: %#v", results[n])
+		} else if host := ipv4.String(); host != "1.2.3.4:56" {
+			t.Fatalf("unexpected result: %s", host)
+		}
+
+		// Test empty name with/without AI_PASSIVE.
+		for _, test := range []struct {
+			node    string
+			service string
+			hint    wasi.AddressInfo
+			flags   wasi.AddressInfoFlags
+			expect  string
+		}{
+			{
+				service: "80",
+				hint:    tcp4Hint,
+				flags:   wasi.Passive,
+				expect:  "0.0.0.0:80",
+			},
+			{
+				service: "80",
+				hint:    tcp6Hint,
+				flags:   wasi.Passive,
+				expect:  "[::]:80",
+			},
+			{
+				service: "80",
+				hint:    tcp4Hint,
+				expect:  "127.0.0.1:80",
+			},
+			{
+				service: "80",
+				hint:    tcp6Hint,
+				expect:  "[::1]:80",
+			},
+		} {
+			t.Run(fmt.Sprintf("%#v hint with %v", test.hint, test.flags), func(t *testing.T) {
+				hint := test.hint
+				hint.Flags |= test.flags
+				n, errno = s.SockAddressInfo(ctx, "", test.service, hint, results)
+				if n != 1 || errno != wasi.ESUCCESS {
+					t.Fatalf("SockAddressInfo => %d, %s", n, errno)
+				}
+				result := results[0]
+
+				var actual string
+				switch a := result.Address.(type) {
+				case *wasi.Inet4Address:
+					actual = a.String()
+				case *wasi.Inet6Address:
+					actual = a.String()
+				default:
+					t.Fatalf("unexpected results %T %#v", result.Address, result)
+				}
+				if actual != test.expect {
+					t.Errorf("unexpected result: got %v, expect %v", actual, test.expect)
+				}
+			})
+		}
+	})
+}
+
+func TestSockAddressInfoPassive(t *testing.T) {
+	tcp4 := wasi.AddressInfo{Family: wasi.InetFamily, SocketType: wasi.StreamSocket, Protocol: wasi.TCPProtocol}
+	tcp6 := wasi.AddressInfo{Family: wasi.Inet6Family, SocketType: wasi.StreamSocket, Protocol: wasi.TCPProtocol}
+	udp4 := wasi.AddressInfo{Family: wasi.InetFamily, SocketType: wasi.DatagramSocket, Protocol: wasi.UDPProtocol}
+	any4 := wasi.AddressInfo{Family: wasi.InetFamily, SocketType: wasi.AnySocket}
+
+	withFlags := func(hints wasi.AddressInfo, flags wasi.AddressInfoFlags) wasi.AddressInfo {
+		hints.Flags = flags
+		return hints
+	}
+
+	testSystem(func(ctx context.Context, s *unix.System) {
+		for _, test := range []struct {
+			scenario string
+			name     string
+			service  string
+			hints    wasi.AddressInfo
+			expect   string
+			errno    wasi.Errno
+		}{
+			{
+				scenario: "passive with a null host returns the wildcard address",
+				service:  "80",
+				hints:    withFlags(tcp4, wasi.Passive|wasi.NumericHost),
+				expect:   "0.0.0.0:80",
+			},
+			{
+				scenario: "passive with a null host returns the ipv6 wildcard address",
+				service:  "80",
+				hints:    withFlags(tcp6, wasi.Passive|wasi.NumericHost),
+				expect:   "[::]:80",
+			},
+			{
+				scenario: "passive is ignored with a numeric host",
+				name:     "10.1.2.3",
+				service:  "80",
+				hints:    withFlags(tcp4, wasi.Passive|wasi.NumericHost),
+				expect:   "10.1.2.3:80",
+			},
+			{
+				scenario: "passive is ignored with a numeric host which is not flagged",
+				name:     "::1",
+				service:  "80",
+				hints:    withFlags(tcp6, wasi.Passive),
+				expect:   "[::1]:80",
+			},
+			{
+				scenario: "numeric hosts must match the address family",
+				name:     "::1",
+				service:  "80",
+				hints:    withFlags(tcp4, wasi.Passive|wasi.NumericHost),
+				errno:    wasi.EINVAL,
+			},
+			{
+				scenario: "host names are rejected with numeric hosts",
+				name:     "localhost",
+				service:  "80",
+				hints:    withFlags(tcp4, wasi.NumericHost),
+				errno:    wasi.EINVAL,
+			},
+			{
+				scenario: "passive with a null host and a named stream service",
+				service:  "http",
+				hints:    withFlags(tcp4, wasi.Passive),
+				expect:   "0.0.0.0:80",
+			},
+			{
+				scenario: "named datagram service",
+				name:     "127.0.0.1",
+				service:  "domain",
+				hints:    withFlags(udp4, wasi.Passive),
+				expect:   "127.0.0.1:53",
+			},
+			{
+				scenario: "named service for any socket type",
+				service:  "domain",
+				hints:    withFlags(any4, wasi.Passive),
+				expect:   "0.0.0.0:53",
+			},
+			{
+				scenario: "named services are rejected with numeric services",
+				service:  "http",
+				hints:    withFlags(tcp4, wasi.Passive|wasi.NumericService),
+				errno:    wasi.EINVAL,
+			},
+			{
+				scenario: "a null service resolves to port zero",
+				name:     "10.1.2.3",
+				hints:    withFlags(tcp4, wasi.NumericService),
+				expect:   "10.1.2.3:0",
+			},
+			{
+				scenario: "the host and service cannot both be null",
+				hints:    withFlags(tcp4, wasi.Passive),
+				errno:    wasi.EINVAL,
+			},
+		} {
+			t.Run(test.scenario, func(t *testing.T) {
+				results := make([]wasi.AddressInfo, 4)
+				n, errno := s.SockAddressInfo(ctx, test.name, test.service, test.hints, results)
+				if errno != test.errno {
+					t.Fatalf("wrong error: want %s, got %s", test.errno, errno)
+				}
+				if errno != wasi.ESUCCESS {
+					return
+				}
+				if n != 1 {
+					t.Fatalf("wrong number of results: want 1, got %d", n)
+				}
+				if got := results[0].Address.String(); got != test.expect {
+					t.Errorf("wrong address: want %s, got %s", test.expect, got)
+				}
+			})
+		}
+	})
+}
+
+func TestSockAddressInfoTruncated(t *testing.T) {
+	ips := make([]net.IP, 10)
+	for i := range ips {
+		ips[i] = net.IPv4(10, 0, 0, byte(i+1))
+	}
+	withResolver(t, ips)
+
+	testSystem(func(ctx context.Context, s *unix.System) {
+		const name = "many.test."
+		hint := wasi.AddressInfo{
+			Flags:      wasi.NumericService,
+			Family:     wasi.InetFamily,
+			SocketType: wasi.StreamSocket,
+			Protocol:   wasi.TCPProtocol,
+		}
+
+		addresses := func(t *testing.T, results []wasi.AddressInfo) map[string]bool {
+			t.Helper()
+			seen := make(map[string]bool)
+			for _, result := range results {
+				addr, ok := result.Address.(*wasi.Inet4Address)
+				if !ok {
+					t.Fatalf("unexpected address: %#v", result.Address)
+				}
+				seen[addr.String()] = true
+			}
+			return seen
+		}
+
+		// The buffer is too small, the count of all results is returned
+		// and the buffer holds a subset of them.
+		results := make([]wasi.AddressInfo, 4)
+		n, errno := s.SockAddressInfo(ctx, name, "80", hint, results)
+		if n != len(ips) || errno != wasi.ESUCCESS {
+			t.Fatalf("SockAddressInfo => %d, %s", n, errno)
+		}
+		if seen := addresses(t, results); len(seen) != len(results) {
+			t.Errorf("wrong number of distinct addresses: want %d, got %d", len(results), len(seen))
+		}
+
+		// An empty buffer can be used to query the number of results.
+		n, errno = s.SockAddressInfo(ctx, name, "80", hint, nil)
+		if n != len(ips) || errno != wasi.ESUCCESS {
+			t.Fatalf("SockAddressInfo => %d, %s", n, errno)
+		}
+
+		// Retrying with a buffer large enough receives all the results.
+		results = make([]wasi.AddressInfo, n)
+		n, errno = s.SockAddressInfo(ctx, name, "80", hint, results)
+		if n != len(ips) || errno != wasi.ESUCCESS {
+			t.Fatalf("SockAddressInfo => %d, %s", n, errno)
+		}
+		seen := addresses(t, results)
+		for _, ip := range ips {
+			if addr := net.JoinHostPort(ip.String(), "80"); !seen[addr] {
+				t.Errorf("missing address: %s", addr)
+			}
+		}
+	})
+}
+
+func TestSockAddressInfoNameResolver(t *testing.T) {
+	withResolver(t, []net.IP{net.IPv4(10, 0, 0, 1)})
+
+	hosts, err := unix.ParseHosts(strings.NewReader(`
+# comments and blank lines are ignored
+
+10.1.2.3 example.test Alias.Test
+::1      example.test
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSystem(func(ctx context.Context, s *unix.System) {
+		s.NameResolver = hosts.Resolve
+
+		hint := wasi.AddressInfo{
+			Flags:      wasi.NumericService,
+			SocketType: wasi.StreamSocket,
+			Protocol:   wasi.TCPProtocol,
+		}
+
+		lookup := func(t *testing.T, name string, family wasi.ProtocolFamily) []string {
+			t.Helper()
+			hint := hint
+			hint.Family = family
+			results := make([]wasi.AddressInfo, 8)
+			n, errno := s.SockAddressInfo(ctx, name, "80", hint, results)
+			if errno != wasi.ESUCCESS {
+				t.Fatalf("SockAddressInfo(%q) => %s", name, errno)
+			}
+			addrs := make([]string, n)
+			for i, result := range results[:n] {
+				addrs[i] = result.Address.String()
+			}
+			return addrs
+		}
+
+		check := func(t *testing.T, name string, family wasi.ProtocolFamily, want ...string) {
+			t.Helper()
+			if addrs := lookup(t, name, family); !reflect.DeepEqual(addrs, want) {
+				t.Errorf("wrong addresses for %q: want %v, got %v", name, want, addrs)
+			}
+		}
+
+		t.Run("override hit", func(t *testing.T) {
+			check(t, "example.test", wasi.UnspecifiedFamily, "10.1.2.3:80", "[::1]:80")
+			check(t, "example.test.", wasi.InetFamily, "10.1.2.3:80")
+			check(t, "example.test", wasi.Inet6Family, "[::1]:80")
+			check(t, "alias.test", wasi.UnspecifiedFamily, "10.1.2.3:80")
+		})
+
+		t.Run("pass-through miss", func(t *testing.T) {
+			check(t, "other.test", wasi.InetFamily, "10.0.0.1:80")
+		})
+	})
+}
+
+// withResolver configures the default resolver to answer A queries with ips,
+// and AAAA queries with no addresses, for the duration of the test.
+func withResolver(t *testing.T, ips []net.IP) {
+	preferGo, dial := net.DefaultResolver.PreferGo, net.DefaultResolver.Dial
+	t.Cleanup(func() {
+		net.DefaultResolver.PreferGo, net.DefaultResolver.Dial = preferGo, dial
+	})
+	net.DefaultResolver.PreferGo = true
+	net.DefaultResolver.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serveDNS(server, ips)
+		return client, nil
+	}
+}
+
+// serveDNS answers a single DNS query received on conn, using the TCP framing
+// since conn is not a net.PacketConn.
+func serveDNS(conn net.Conn, ips []net.IP) {
+	defer conn.Close()
+
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return
+	}
+	query := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, query); err != nil || len(query) < 12 {
+		return
+	}
+	// Skip the labels of the question name, followed by its type and class.
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += 1 + int(query[end])
+	}
+	end += 5
+	if end > len(query) {
+		return
+	}
+	question := query[12:end]
+	qtype := binary.BigEndian.Uint16(question[len(question)-4:])
+
+	var answers []net.IP
+	if qtype == 1 { // A
+		answers = ips
+	}
+
+	msg := make([]byte, 12, 512)
+	copy(msg, query[:2])                        // ID
+	binary.BigEndian.PutUint16(msg[2:], 0x8180) // response, recursion desired and available
+	binary.BigEndian.PutUint16(msg[4:], 1)      // questions
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	msg = append(msg, question...)
+	for _, ip := range answers {
+		msg = append(msg, 0xC0, 12) // pointer to the question name
+		msg = binary.BigEndian.AppendUint16(msg, qtype)
+		msg = binary.BigEndian.AppendUint16(msg, 1) // IN
+		msg = binary.BigEndian.AppendUint32(msg, 60)
+		msg = binary.BigEndian.AppendUint16(msg, 4)
+		msg = append(msg, ip.To4()...)
+	}
+
+	binary.BigEndian.PutUint16(size[:], uint16(len(msg)))
+	conn.Write(append(size[:], msg...))
+}
+
+func testSystem(f func(context.Context, *unix.System)) {
+	ctx := context.Background()
+
+	p := newSystem()
+	defer p.Close(ctx)
+
+	fds, err := pipe()
+	if err != nil {
+		panic(err)
+	}
+	p.Preopen(unix.FD(fds[0]), "fd0", wasi.FDStat{RightsBase: wasi.AllRights})
+	p.Preopen(unix.FD(fds[1]), "fd1", wasi.FDStat{RightsBase: wasi.AllRights})
+
+	f(ctx, p)
+}
+
+func newSystem() *unix.System {
+	return &unix.System{
+		Realtime:           realtime,
+		RealtimePrecision:  time.Microsecond,
+		Monotonic:          monotonic,
+		MonotonicPrecision: time.Nanosecond,
+	}
+}
+
+var epoch = time.Now()
+
+func realtime(context.Context) (uint64, error) {
+	return uint64(time.Now().UnixNano()), nil
+}
+
+func monotonic(context.Context) (uint64, error) {
+	return uint64(time.Since(epoch)), nil
+}
+
+func subscribeFDRead(fd wasi.FD) wasi.Subscription {
+	return wasi.MakeSubscriptionFDReadWrite(
+		wasi.UserData(42+fd),
+		wasi.FDReadEvent,
+		wasi.SubscriptionFDReadWrite{FD: fd},
+	)
+}
+
+func subscribeTimeout(timeout time.Duration) wasi.Subscription {
+	return wasi.MakeSubscriptionClock(
+		wasi.UserData(42),
+		wasi.SubscriptionClock{
+			ID:        wasi.Monotonic,
+			Timeout:   wasi.Timestamp(timeout),
+			Precision: wasi.Timestamp(time.Nanosecond),
+		},
+	)
+}
+
+func TestEnvironFunc(t *testing.T) {
+	ctx := context.Background()
+
+	envCalls, argsCalls := 0, 0
+	s := newSystem()
+	s.Environ = []string{"IGNORED=1"}
+	s.EnvironFunc = func() []string {
+		envCalls++
+		return []string{"TOKEN=" + strings.Repeat("x", envCalls)}
+	}
+	s.ArgsFunc = func() []string {
+		argsCalls++
+		return []string{"app", fmt.Sprintf("--call=%d", argsCalls)}
+	}
+	defer s.Close(ctx)
+
+	// Each pair of calls made by the guest observes fresh values, which are
+	// the same when computing the sizes and reading the values.
+	for i := 1; i <= 3; i++ {
+		envCount, envBytes, errno := s.EnvironSizesGet(ctx)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		env, errno := s.EnvironGet(ctx)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if want := []string{"TOKEN=" + strings.Repeat("x", i)}; !reflect.DeepEqual(env, want) {
+			t.Errorf("wrong environment: want %q, got %q", want, env)
+		}
+		if wantCount, wantBytes := wasi.SizesGet(env); envCount != wantCount || envBytes != wantBytes {
+			t.Errorf("environment sizes do not match: want %d/%d, got %d/%d", wantCount, wantBytes, envCount, envBytes)
+		}
+
+		argCount, argBytes, errno := s.ArgsSizesGet(ctx)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		args, errno := s.ArgsGet(ctx)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if want := []string{"app", fmt.Sprintf("--call=%d", i)}; !reflect.DeepEqual(args, want) {
+			t.Errorf("wrong arguments: want %q, got %q", want, args)
+		}
+		if wantCount, wantBytes := wasi.SizesGet(args); argCount != wantCount || argBytes != wantBytes {
+			t.Errorf("argument sizes do not match: want %d/%d, got %d/%d", wantCount, wantBytes, argCount, argBytes)
+		}
+	}
+
+	// Without a prior call to get the sizes, the function is called directly.
+	env, _ := s.EnvironGet(ctx)
+	if want := []string{"TOKEN=xxxx"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("wrong environment: want %q, got %q", want, env)
+	}
+	if envCalls != 4 {
+		t.Errorf("wrong number of calls to EnvironFunc: want 4, got %d", envCalls)
+	}
+}
+
+func TestSchedYield(t *testing.T) {
+	ctx := context.Background()
+
+	s := newSystem()
+	defer s.Close(ctx)
+
+	if errno := s.SchedYield(ctx); errno != wasi.ESUCCESS {
+		t.Errorf("sched_yield without a hook: want ESUCCESS, got %s", errno)
+	}
+
+	yields := 0
+	s.Yield = func(context.Context) error {
+		yields++
+		return syscall.EAGAIN
+	}
+	if errno := s.SchedYield(ctx); errno != wasi.EAGAIN {
+		t.Errorf("sched_yield with a hook: want EAGAIN, got %s", errno)
+	}
+	if yields != 1 {
+		t.Errorf("wrong number of calls to the yield hook: want 1, got %d", yields)
+	}
+}
+
+func TestRandomGet(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("failing reader", func(t *testing.T) {
+		s := &unix.System{Rand: iotest.ErrReader(errors.New("oops"))}
+		if errno := s.RandomGet(ctx, make([]byte, 16)); errno != wasi.EIO {
+			t.Errorf("wrong errno: want=%s got=%s", wasi.EIO, errno)
+		}
+	})
+
+	t.Run("exhausted reader", func(t *testing.T) {
+		s := &unix.System{Rand: bytes.NewReader([]byte("12345678"))}
+		if errno := s.RandomGet(ctx, make([]byte, 16)); errno != wasi.ENOSYS {
+			t.Errorf("wrong errno: want=%s got=%s", wasi.ENOSYS, errno)
+		}
+	})
+
+	t.Run("interrupted reader", func(t *testing.T) {
+		s := &unix.System{Rand: &interruptedReader{r: bytes.NewReader([]byte("0123456789"))}}
+		b := make([]byte, 10)
+		if errno := s.RandomGet(ctx, b); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if string(b) != "0123456789" {
+			t.Errorf("wrong random data: %q", b)
+		}
+	})
+
+	t.Run("short reader", func(t *testing.T) {
+		s := &unix.System{Rand: iotest.OneByteReader(bytes.NewReader([]byte("0123456789")))}
+		b := make([]byte, 10)
+		if errno := s.RandomGet(ctx, b); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if string(b) != "0123456789" {
+			t.Errorf("wrong random data: %q", b)
+		}
+
+		// A reader which never makes progress must not block the guest.
+		s = &unix.System{Rand: emptyReader{}}
+		if errno := s.RandomGet(ctx, b); errno != wasi.EIO {
+			t.Errorf("wrong errno: want=%s got=%s", wasi.EIO, errno)
+		}
+	})
+
+	t.Run("getrandom", func(t *testing.T) {
+		s := &unix.System{Rand: unix.Getrandom}
+		b := make([]byte, 64)
+		switch errno := s.RandomGet(ctx, b); errno {
+		case wasi.ESUCCESS:
+		case wasi.ENOSYS:
+			t.Skip("getrandom(2) is not available")
+		default:
+			t.Fatal(errno)
+		}
+		if bytes.Equal(b, make([]byte, len(b))) {
+			t.Error("no random data was produced")
+		}
+	})
+
+	t.Run("deterministic reader", func(t *testing.T) {
+		s1 := &unix.System{Rand: mathrand.New(mathrand.NewSource(42))}
+		s2 := &unix.System{Rand: mathrand.New(mathrand.NewSource(42))}
+		b1 := make([]byte, 64)
+		b2 := make([]byte, 64)
+		if errno := s1.RandomGet(ctx, b1); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if errno := s2.RandomGet(ctx, b2); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if !bytes.Equal(b1, b2) {
+			t.Errorf("random data mismatch:\n%x\n%x", b1, b2)
+		}
+	})
+}
+
+// emptyReader is a reader which always returns zero bytes and no error.
+type emptyReader struct{}
+
+func (emptyReader) Read([]byte) (int, error) { return 0, nil }
+
+// interruptedReader returns EINTR every other call to Read.
+type interruptedReader struct {
+	r    io.Reader
+	intr bool
+}
+
+func (r *interruptedReader) Read(b []byte) (int, error) {
+	if r.intr = !r.intr; r.intr {
+		return 0, syscall.EINTR
+	}
+	return r.r.Read(b[:1])
+}
+
+func TestFDRenumber(t *testing.T) {
+	ctx := context.Background()
+
+	const maxOpenFiles = 16
+	setup := func(t *testing.T) (s *unix.System, r, w wasi.FD) {
+		s = newSystem()
+		s.MaxOpenFiles = maxOpenFiles
+		t.Cleanup(func() { s.Close(ctx) })
+		fds, err := pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = s.Register(unix.FD(fds[0]), wasi.FDStat{RightsBase: wasi.AllRights})
+		w = s.Register(unix.FD(fds[1]), wasi.FDStat{RightsBase: wasi.AllRights})
+		return s, r, w
+	}
+
+	transfer := func(t *testing.T, s *unix.System, r, w wasi.FD) {
+		t.Helper()
+		if _, errno := s.FDWrite(ctx, w, []wasi.IOVec{[]byte("hello")}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		buf := make([]byte, 16)
+		n, errno := s.FDRead(ctx, r, []wasi.IOVec{buf})
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if string(buf[:n]) != "hello" {
+			t.Errorf("wrong data: %q", buf[:n])
+		}
+	}
+
+	t.Run("renumbering moves the file descriptor", func(t *testing.T) {
+		s, r, w := setup(t)
+		const to = maxOpenFiles - 1
+		if errno := s.FDRenumber(ctx, r, to); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := s.FDStatGet(ctx, r); errno != wasi.EBADF {
+			t.Errorf("the old file descriptor is still open: %s", errno)
+		}
+		transfer(t, s, to, w)
+	})
+
+	t.Run("renumbering onto itself is a no-op", func(t *testing.T) {
+		s, r, w := setup(t)
+		if errno := s.FDRenumber(ctx, r, r); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		transfer(t, s, r, w)
+	})
+
+	t.Run("renumbering onto an open file descriptor closes it", func(t *testing.T) {
+		s, r, w := setup(t)
+		fds, err := pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sysunix.Close(fds[1])
+		other := s.Register(unix.FD(fds[0]), wasi.FDStat{RightsBase: wasi.AllRights})
+
+		if errno := s.FDRenumber(ctx, r, other); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, err := sysunix.FcntlInt(uintptr(fds[0]), sysunix.F_GETFD, 0); err != sysunix.EBADF {
+			t.Errorf("the replaced file descriptor was not closed: %v", err)
+		}
+		transfer(t, s, other, w)
+	})
+
+	t.Run("preopens cannot be renumbered", func(t *testing.T) {
+		s, r, w := setup(t)
+		fds, err := pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sysunix.Close(fds[1])
+		preopen := s.Preopen(unix.FD(fds[0]), "preopen", wasi.FDStat{RightsBase: wasi.AllRights})
+
+		if errno := s.FDRenumber(ctx, r, preopen); errno != wasi.ENOTSUP {
+			t.Errorf("renumbering onto a preopen: want ENOTSUP, got %s", errno)
+		}
+		if errno := s.FDRenumber(ctx, preopen, maxOpenFiles-1); errno != wasi.ENOTSUP {
+			t.Errorf("renumbering a preopen: want ENOTSUP, got %s", errno)
+		}
+		if _, errno := s.FDStatGet(ctx, preopen); errno != wasi.ESUCCESS {
+			t.Errorf("the preopen was closed: %s", errno)
+		}
+		transfer(t, s, r, w)
+	})
+
+	t.Run("file descriptor numbers are limited by the maximum number of open files", func(t *testing.T) {
+		s, r, w := setup(t)
+		for _, to := range []wasi.FD{maxOpenFiles, maxOpenFiles + 1, 1 << 30, -1} {
+			if errno := s.FDRenumber(ctx, r, to); errno != wasi.EBADF {
+				t.Errorf("renumbering to %d: want EBADF, got %s", to, errno)
+			}
+		}
+		transfer(t, s, r, w)
+	})
+}
+
+func TestFDStatSwapNonBlock(t *testing.T) {
+	ctx := context.Background()
+
+	p := newSystem()
+	defer p.Close(ctx)
+
+	fds, err := pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sysunix.Close(fds[1])
+	fd := p.Preopen(unix.FD(fds[0]), "fd0", wasi.FDStat{RightsBase: wasi.AllRights})
+
+	for i, test := range []struct {
+		nonBlock bool
+		prev     bool
+	}{
+		{nonBlock: true, prev: false},
+		{nonBlock: true, prev: true},
+		{nonBlock: false, prev: true},
+		{nonBlock: false, prev: false},
+		{nonBlock: true, prev: false},
+	} {
+		prev, errno := p.FDStatSwapNonBlock(ctx, fd, test.nonBlock)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if prev != test.prev {
+			t.Errorf("%d: wrong previous mode: want %t, got %t", i, test.prev, prev)
+		}
+		fl, err := sysunix.FcntlInt(uintptr(fds[0]), sysunix.F_GETFL, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nonBlock := (fl & sysunix.O_NONBLOCK) != 0; nonBlock != test.nonBlock {
+			t.Errorf("%d: wrong O_NONBLOCK flag: want %t, got %t", i, test.nonBlock, nonBlock)
+		}
+	}
+
+	if _, errno := p.FDStatSwapNonBlock(ctx, fd+1, true); errno != wasi.EBADF {
+		t.Errorf("wrong error for invalid file descriptor: %s", errno)
+	}
+}
+
+func BenchmarkFDStatSwapNonBlock(b *testing.B) {
+	ctx := context.Background()
+
+	p := newSystem()
+	defer p.Close(ctx)
+
+	fds, err := pipe()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sysunix.Close(fds[1])
+	fd := p.Preopen(unix.FD(fds[0]), "fd0", wasi.FDStat{RightsBase: wasi.AllRights})
+
+	b.Run("get+set", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			stat, errno := p.FDStatGet(ctx, fd)
+			if errno != wasi.ESUCCESS {
+				b.Fatal(errno)
+			}
+			if errno := p.FDStatSetFlags(ctx, fd, stat.Flags^wasi.NonBlock); errno != wasi.ESUCCESS {
+				b.Fatal(errno)
+			}
+		}
+	})
+
+	b.Run("swap", func(b *testing.B) {
+		stat, errno := p.FDStatGet(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			b.Fatal(errno)
+		}
+		nonBlock := stat.Flags.Has(wasi.NonBlock)
+		for i := 0; i < b.N; i++ {
+			prev, errno := p.FDStatSwapNonBlock(ctx, fd, !nonBlock)
+			if errno != wasi.ESUCCESS {
+				b.Fatal(errno)
+			}
+			if prev != nonBlock {
+				b.Fatal("wrong previous mode")
+			}
+			nonBlock = !nonBlock
+		}
+	})
+}
+
+func BenchmarkFDCopyRange(b *testing.B) {
+	ctx := context.Background()
+
+	p := newSystem()
+	defer p.Close(ctx)
+
+	tmp := b.TempDir()
+	data := make([]byte, 256<<20)
+	if err := os.WriteFile(filepath.Join(tmp, "src"), data, 0644); err != nil {
+		b.Fatal(err)
+	}
+	// The file descriptors are owned by the system, which closes them.
+	src, err := sysunix.Open(filepath.Join(tmp, "src"), sysunix.O_RDONLY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	dst, err := sysunix.Open(filepath.Join(tmp, "dst"), sysunix.O_RDWR|sysunix.O_CREAT|sysunix.O_CLOEXEC, 0644)
+	if err != nil {
+		sysunix.Close(src)
+		b.Fatal(err)
+	}
+
+	const rights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDSeekRight
+	srcFD := p.Preopen(unix.FD(src), "src", wasi.FDStat{RightsBase: rights})
+	dstFD := p.Preopen(unix.FD(dst), "dst", wasi.FDStat{RightsBase: rights})
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		n, errno := p.FDCopyRange(ctx, srcFD, dstFD, 0, 0, wasi.FileSize(len(data)))
+		if errno != wasi.ESUCCESS {
+			b.Fatal(errno)
+		}
+		if n != wasi.FileSize(len(data)) {
+			b.Fatalf("short copy: %d/%d", n, len(data))
+		}
+	}
+}
+
+func BenchmarkPollOneOff(b *testing.B) {
+	ctx := context.Background()
+
+	p := newSystem()
+	defer p.Close(ctx)
+
+	// The subscriptions all wait on the same socket, which is ready for
+	// writing, so every call reports as many events as there are
+	// subscriptions.
+	fds := socketpair(b)
+	defer sysunix.Close(fds[1])
+	fd := p.Preopen(unix.FD(fds[0]), "fd0", wasi.FDStat{RightsBase: wasi.AllRights})
+
+	for _, n := range []int{1, 100, 10000} {
+		b.Run(fmt.Sprintf("subscriptions=%d", n), func(b *testing.B) {
+			subscriptions := make([]wasi.Subscription, n)
+			for i := range subscriptions {
+				subscriptions[i] = wasi.MakeSubscriptionFDReadWrite(wasi.UserData(i), wasi.FDWriteEvent, wasi.SubscriptionFDReadWrite{FD: fd})
+			}
+			events := make([]wasi.Event, n)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				numEvents, errno := p.PollOneOff(ctx, subscriptions, events)
+				if errno != wasi.ESUCCESS {
+					b.Fatal(errno)
+				}
+				if numEvents != n {
+					b.Fatalf("wrong number of events: want %d, got %d", n, numEvents)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPollOneOffShutdown(b *testing.B) {
+	ctx := context.Background()
+
+	fds := socketpair(b)
+	defer sysunix.Close(fds[0])
+	defer sysunix.Close(fds[1])
+
+	subscriptions := []wasi.Subscription{
+		wasi.MakeSubscriptionFDReadWrite(42, wasi.FDReadEvent, wasi.SubscriptionFDReadWrite{FD: 0}),
+	}
+	events := make([]wasi.Event, len(subscriptions))
+	done := make(chan wasi.Errno)
+	b.ReportAllocs()
+
+	// Each iteration measures the time to set up the wake up mechanism of a
+	// new system and to interrupt a call to PollOneOff with Shutdown.
+	for i := 0; i < b.N; i++ {
+		// Closing the system closes the preopened socket, a duplicate is
+		// given to each system.
+		fd, err := sysunix.Dup(fds[0])
+		if err != nil {
+			b.Fatal(err)
+		}
+		p := newSystem()
+		p.Preopen(unix.FD(fd), "fd0", wasi.FDStat{RightsBase: wasi.AllRights})
+
+		go func() {
+			_, errno := p.PollOneOff(ctx, subscriptions, events)
+			done <- errno
+		}()
+
+		if err := p.Shutdown(ctx); err != nil {
+			b.Fatal(err)
+		}
+		if errno := <-done; errno != wasi.ESUCCESS {
+			b.Fatal(errno)
+		}
+		if events[0].Errno != wasi.ECANCELED {
+			b.Fatalf("wrong event: %+v", events[0])
+		}
+		p.Close(ctx)
+	}
+}
+
+func socketpair(t testing.TB) [2]int {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	fds, err := sysunix.Socketpair(sysunix.AF_UNIX, sysunix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sysunix.CloseOnExec(fds[0])
+	sysunix.CloseOnExec(fds[1])
+	return fds
+}
+
+// spliceSockets returns a system with the two sockets that it proxies between
+// preopened, and the host ends of the sockets used to write to the first one
+// and read from the second one.
+func spliceSockets(t testing.TB, inNonBlock, outNonBlock bool) (p *unix.System, in, out wasi.FD, w, r *os.File) {
+	p = newSystem()
+	t.Cleanup(func() { p.Close(context.Background()) })
+
+	src, dst := socketpair(t), socketpair(t)
+	w = os.NewFile(uintptr(src[0]), "w")
+	r = os.NewFile(uintptr(dst[1]), "r")
+	t.Cleanup(func() { w.Close(); r.Close() })
+
+	preopen := func(fd int, nonBlock bool) wasi.FD {
+		stat := wasi.FDStat{
+			FileType:   wasi.SocketStreamType,
+			RightsBase: wasi.SockConnectionRights,
+		}
+		if nonBlock {
+			if err := sysunix.SetNonblock(fd, true); err != nil {
+				t.Fatal(err)
+			}
+			stat.Flags |= wasi.NonBlock
+		}
+		return p.Preopen(unix.FD(fd), "socket", stat)
+	}
+	return p, preopen(src[1], inNonBlock), preopen(dst[0], outNonBlock), w, r
+}
+
+func TestPathOperationsWithSignals(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	dir, err := sysunix.Open(tmp, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSystem()
+	defer s.Close(ctx)
+	fd := s.Preopen(unix.FD(dir), "tmp", wasi.FDStat{RightsBase: wasi.AllRights, RightsInheriting: wasi.AllRights})
+
+	// Deliver signals to the process continuously while the path operations
+	// run, none of them may fail with EINTR.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+			default:
+				syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	check := func(op string, errno wasi.Errno) {
+		t.Helper()
+		if errno != wasi.ESUCCESS {
+			t.Fatalf("%s: %s", op, errno)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		check("path_create_directory", s.PathCreateDirectory(ctx, fd, "dir"))
+		f, errno := s.PathOpen(ctx, fd, 0, "dir/file", wasi.OpenCreate, wasi.AllRights, 0, 0)
+		check("path_open", errno)
+		_, errno = s.FDFileStatGet(ctx, f)
+		check("fd_filestat_get", errno)
+		check("fd_close", s.FDClose(ctx, f))
+		check("path_symlink", s.PathSymlink(ctx, "file", fd, "dir/link"))
+		_, errno = s.PathReadLink(ctx, fd, "dir/link", make([]byte, 64))
+		check("path_readlink", errno)
+		_, errno = s.PathFileStatGet(ctx, fd, wasi.SymlinkFollow, "dir/link")
+		check("path_filestat_get", errno)
+		check("path_rename", s.PathRename(ctx, fd, "dir/file", fd, "dir/renamed"))
+		check("path_unlink_file", s.PathUnlinkFile(ctx, fd, "dir/renamed"))
+		check("path_unlink_file", s.PathUnlinkFile(ctx, fd, "dir/link"))
+		check("path_remove_directory", s.PathRemoveDirectory(ctx, fd, "dir"))
+	}
+}
+
+func TestFDReadDirModified(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	const numFiles = 200
+	want := map[string]bool{".": true, "..": true}
+	for i := 0; i < numFiles; i++ {
+		name := fmt.Sprintf("file-%03d", i)
+		if err := os.WriteFile(filepath.Join(tmp, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		want[name] = true
+	}
+
+	dir, err := sysunix.Open(tmp, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSystem()
+	defer s.Close(ctx)
+	fd := s.Preopen(unix.FD(dir), "tmp", wasi.FDStat{RightsBase: wasi.FDReadDirRight})
+
+	seen := make(map[string]bool)
+	var cookie wasi.DirCookie
+	readDir := func(limit int) {
+		var entries [8]wasi.DirEntry
+		for len(seen) < limit {
+			n, errno := s.FDReadDir(ctx, fd, entries[:], cookie, 4096)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if n == 0 {
+				return
+			}
+			for _, entry := range entries[:n] {
+				name := string(entry.Name)
+				if seen[name] {
+					t.Errorf("duplicate directory entry: %q", name)
+				}
+				seen[name] = true
+			}
+			cookie = entries[n-1].Next
+		}
+	}
+
+	readDir(len(want) / 2)
+
+	// Add and remove entries while the directory is being read; the guest
+	// keeps seeing the directory as it was when it started reading it.
+	for i := 0; i < numFiles; i++ {
+		name := fmt.Sprintf("file-%03d", i)
+		if seen[name] {
+			continue
+		}
+		if i%2 == 0 {
+			if err := os.Remove(filepath.Join(tmp, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(tmp, "new-"+name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	readDir(math.MaxInt)
+
+	for name := range want {
+		if !seen[name] {
+			t.Errorf("missing directory entry: %q", name)
+		}
+	}
+	for name := range seen {
+		if !want[name] {
+			t.Errorf("unexpected directory entry: %q", name)
+		}
+	}
+}
+
+func TestSynchronized(t *testing.T) {
+	ctx := context.Background()
+
+	p := newSystem()
+	s := wasi.Synchronized(p)
+	defer s.Close(ctx)
+
+	const numGoroutines = 8
+	const numIterations = 100
+
+	// Each goroutine uses its own pipe, while the system is shared by all
+	// of them; the table of file descriptors is modified concurrently by the
+	// calls to SockOpen and FDClose.
+	pipes := make([][2]wasi.FD, numGoroutines)
+	for i := range pipes {
+		fds, err := pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, fd := range fds {
+			if err := sysunix.SetNonblock(fd, true); err != nil {
+				t.Fatal(err)
+			}
+			pipes[i][j] = p.Preopen(unix.FD(fd), fmt.Sprintf("pipe-%d-%d", i, j), wasi.FDStat{
+				FileType:   wasi.UnknownType,
+				Flags:      wasi.NonBlock,
+				RightsBase: wasi.FileRights,
+			})
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, numGoroutines)
+	for _, fds := range pipes {
+		wg.Add(1)
+		go func(r, w wasi.FD) {
+			defer wg.Done()
+			buffer := make([]byte, 8)
+			for i := 0; i < numIterations; i++ {
+				if _, errno := s.FDWrite(ctx, w, []wasi.IOVec{[]byte("message")}); errno != wasi.ESUCCESS {
+					errs <- fmt.Errorf("fd_write: %w", errno)
+					return
+				}
+				n, errno := s.FDRead(ctx, r, []wasi.IOVec{buffer})
+				if errno != wasi.ESUCCESS {
+					errs <- fmt.Errorf("fd_read: %w", errno)
+					return
+				}
+				if string(buffer[:n]) != "message" {
+					errs <- fmt.Errorf("fd_read: wrong data: %q", buffer[:n])
+					return
+				}
+				sock, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.DatagramSocket, wasi.UDPProtocol, wasi.SockConnectionRights, 0)
+				if errno != wasi.ESUCCESS {
+					errs <- fmt.Errorf("sock_open: %w", errno)
+					return
+				}
+				if errno := s.FDClose(ctx, sock); errno != wasi.ESUCCESS {
+					errs <- fmt.Errorf("fd_close: %w", errno)
+					return
+				}
+			}
+		}(fds[0], fds[1])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestFDStatHostOnlyFlags(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	dir, err := sysunix.Open(tmp, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSystem()
+	defer s.Close(ctx)
+	dirfd := s.Preopen(unix.FD(dir), "tmp", wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsBase:       wasi.PathOpenRight | wasi.PathCreateFileRight,
+		RightsInheriting: wasi.FileRights,
+	})
+
+	file, errno := s.PathOpen(ctx, dirfd, 0, "file", wasi.OpenCreate, wasi.FileRights, 0, wasi.Append)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	sock, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.SockConnectionRights|wasi.FDStatSetFlagsRight, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	// checkFlags verifies that the host file descriptor is close-on-exec,
+	// and that the guest only sees the WASI flags.
+	checkFlags := func(fd wasi.FD, want wasi.FDFlags) {
+		t.Helper()
+		hostfd, _, errno := s.LookupFD(fd, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		fdflags, err := sysunix.FcntlInt(uintptr(hostfd.FD), sysunix.F_GETFD, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (fdflags & sysunix.FD_CLOEXEC) == 0 {
+			t.Errorf("fd %d: host file descriptor is not close-on-exec", fd)
+		}
+		stat, errno := s.FDStatGet(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat.Flags != want {
+			t.Errorf("fd %d: wrong flags: want %v, got %v", fd, want, stat.Flags)
+		}
+	}
+
+	checkFlags(file, wasi.Append)
+	checkFlags(sock, 0)
+
+	if errno := s.FDStatSetFlags(ctx, sock, wasi.NonBlock); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	checkFlags(sock, wasi.NonBlock)
+
+	// There are no flags beyond those of wasi.FDFlags, the host flags cannot
+	// be changed by passing unknown bits.
+	if errno := s.FDStatSetFlags(ctx, sock, wasi.NonBlock|1<<7); errno != wasi.EINVAL {
+		t.Errorf("fd_fdstat_set_flags: want EINVAL, got %s", errno)
+	}
+	checkFlags(sock, wasi.NonBlock)
+}
+
+func TestFDReadDirRewind(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	const numFiles = 100
+	want := []string{".", ".."}
+	for i := 0; i < numFiles; i++ {
+		name := fmt.Sprintf("file-%03d", i)
+		if err := os.WriteFile(filepath.Join(tmp, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, name)
+	}
+	slices.Sort(want)
+
+	dir, err := sysunix.Open(tmp, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSystem()
+	defer s.Close(ctx)
+	fd := s.Preopen(unix.FD(dir), "tmp", wasi.FDStat{RightsBase: wasi.FDReadDirRight})
+
+	// readDir reads at most limit entries starting at cookie zero, which
+	// rewinds the directory.
+	readDir := func(limit int) []string {
+		var names []string
+		var entries [8]wasi.DirEntry
+		var cookie wasi.DirCookie
+		for len(names) < limit {
+			n, errno := s.FDReadDir(ctx, fd, entries[:], cookie, 4096)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if n == 0 {
+				break
+			}
+			for _, entry := range entries[:n] {
+				names = append(names, string(entry.Name))
+			}
+			cookie = entries[n-1].Next
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	if names := readDir(10); len(names) < 10 || len(names) >= len(want) {
+		t.Fatalf("wrong number of entries in partial read: %d", len(names))
+	}
+	// Reading from cookie zero after a partial read restarts from the
+	// beginning, and so does reading again after the end of the directory
+	// was reached.
+	for i := 0; i < 2; i++ {
+		if names := readDir(math.MaxInt); !slices.Equal(names, want) {
+			t.Errorf("wrong directory entries after rewinding:\nwant: %q\ngot:  %q", want, names)
+		}
+	}
+}
+
+func TestFDWriteManyIOVecs(t *testing.T) {
+	ctx := context.Background()
+
+	// More buffers than IOV_MAX, which the system has to split across
+	// multiple system calls.
+	const numIOVecs = 2000
+	want := make([]byte, 0, 4*numIOVecs)
+	iovecs := make([]wasi.IOVec, numIOVecs)
+	for i := range iovecs {
+		iovecs[i] = []byte(fmt.Sprintf("%04d", i))
+		want = append(want, iovecs[i]...)
+	}
+
+	path := filepath.Join(t.TempDir(), "file")
+	f, err := sysunix.Open(path, sysunix.O_RDWR|sysunix.O_CREAT|sysunix.O_CLOEXEC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSystem()
+	defer s.Close(ctx)
+	fd := s.Register(unix.FD(f), wasi.FDStat{RightsBase: wasi.AllRights})
+
+	n, errno := s.FDWrite(ctx, fd, iovecs)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if int(n) != len(want) {
+		t.Fatalf("wrong number of bytes written: want %d, got %d", len(want), n)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("wrong file content after fd_write")
+	}
+
+	// Write the same buffers at an offset, then read everything back with
+	// vectored reads of the same size.
+	if n, errno := s.FDPwrite(ctx, fd, iovecs, wasi.FileSize(len(want))); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	} else if int(n) != len(want) {
+		t.Fatalf("wrong number of bytes written: want %d, got %d", len(want), n)
+	}
+	want = append(want, want...)
+
+	readIOVecs := make([]wasi.IOVec, 2*numIOVecs)
+	for i := range readIOVecs {
+		readIOVecs[i] = make([]byte, 4)
+	}
+	readBack := func(iovecs []wasi.IOVec) []byte {
+		b := make([]byte, 0, len(want))
+		for _, iov := range iovecs {
+			b = append(b, iov...)
+		}
+		return b
+	}
+
+	if n, errno := s.FDPread(ctx, fd, readIOVecs, 0); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	} else if int(n) != len(want) {
+		t.Fatalf("wrong number of bytes read: want %d, got %d", len(want), n)
+	}
+	if !bytes.Equal(readBack(readIOVecs), want) {
+		t.Fatal("wrong data returned by fd_pread")
+	}
+
+	if _, errno := s.FDSeek(ctx, fd, 0, wasi.SeekStart); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	for i := range readIOVecs {
+		readIOVecs[i] = make([]byte, 4)
+	}
+	if n, errno := s.FDRead(ctx, fd, readIOVecs); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	} else if int(n) != len(want) {
+		t.Fatalf("wrong number of bytes read: want %d, got %d", len(want), n)
+	}
+	if !bytes.Equal(readBack(readIOVecs), want) {
+		t.Fatal("wrong data returned by fd_read")
+	}
+}
+
+func TestPathReadLinkString(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	// The longest target that can be stored in a symbolic link, one byte
+	// less than PATH_MAX.
+	longTarget := strings.Repeat("long-path-component/", sysunix.PathMax/20)
+	longTarget += strings.Repeat("x", sysunix.PathMax-1-len(longTarget))
+
+	links := map[string]string{
+		"short": "target",
+		"long":  longTarget,
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(tmp, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := sysunix.Open(tmp, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSystem()
+	defer s.Close(ctx)
+	fd := s.Preopen(unix.FD(dir), "tmp", wasi.FDStat{RightsBase: wasi.PathReadLinkRight})
+
+	// Buffers that the target fills entirely are reported as too small.
+	buffer := make([]byte, len(longTarget))
+	if _, errno := s.PathReadLink(ctx, fd, "long", buffer); errno != wasi.ERANGE {
+		t.Errorf("path_readlink: want ERANGE, got %s", errno)
+	}
+
+	for name, want := range links {
+		got, errno := s.PathReadLinkString(ctx, fd, name)
+		if errno != wasi.ESUCCESS {
+			t.Fatalf("%s: %s", name, errno)
+		}
+		if got != want {
+			t.Errorf("%s: wrong symbolic link target: want %d bytes, got %d bytes", name, len(want), len(got))
+		}
+	}
+
+	if _, errno := s.PathReadLinkString(ctx, fd, "missing"); errno != wasi.ENOENT {
+		t.Errorf("missing link: want ENOENT, got %s", errno)
+	}
+}
+
+func TestFileStatSpecialFiles(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(tmp, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sysunix.Mkfifo(filepath.Join(tmp, "fifo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSystem()
+	defer s.Close(ctx)
+
+	preopen := func(path string) wasi.FD {
+		dir, err := sysunix.Open(path, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Preopen(unix.FD(dir), path, wasi.FDStat{
+			FileType:   wasi.DirectoryType,
+			RightsBase: wasi.PathFileStatGetRight,
+		})
+	}
+	tmpFD := preopen(tmp)
+	devFD := preopen("/dev")
+
+	for _, test := range []struct {
+		fd    wasi.FD
+		path  string
+		flags wasi.LookupFlags
+		want  wasi.FileType
+	}{
+		{fd: tmpFD, path: "fifo", want: wasi.UnknownType},
+		{fd: tmpFD, path: "link", want: wasi.SymbolicLinkType},
+		{fd: tmpFD, path: "link", flags: wasi.SymlinkFollow, want: wasi.RegularFileType},
+		{fd: devFD, path: "null", want: wasi.CharacterDeviceType},
+	} {
+		stat, errno := s.PathFileStatGet(ctx, test.fd, test.flags, test.path)
+		if errno != wasi.ESUCCESS {
+			t.Fatalf("%s: %s", test.path, errno)
+		}
+		if stat.FileType != test.want {
+			t.Errorf("%s (flags=%v): wrong file type: want %s, got %s", test.path, test.flags, test.want, stat.FileType)
+		}
+		if test.want == wasi.RegularFileType && stat.Size != 5 {
+			t.Errorf("%s: wrong size of the symbolic link target: want 5, got %d", test.path, stat.Size)
+		}
+	}
+
+	// The file type of sockets is only known from their file descriptor.
+	for sockType, want := range map[int]wasi.FileType{
+		sysunix.SOCK_STREAM: wasi.SocketStreamType,
+		sysunix.SOCK_DGRAM:  wasi.SocketDGramType,
+	} {
+		fds, err := sysunix.Socketpair(sysunix.AF_UNIX, sockType, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sysunix.Close(fds[1])
+		fd := s.Preopen(unix.FD(fds[0]), "socket", wasi.FDStat{
+			FileType:   want,
+			RightsBase: wasi.FDFileStatGetRight,
+		})
+		stat, errno := s.FDFileStatGet(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat.FileType != want {
+			t.Errorf("wrong socket file type: want %s, got %s", want, stat.FileType)
+		}
+	}
+
+	// Timestamps before the Unix epoch cannot be represented, they are
+	// clamped to zero.
+	old := time.Unix(-3600, 0)
+	if err := os.Chtimes(filepath.Join(tmp, "file"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	stat, errno := s.PathFileStatGet(ctx, tmpFD, 0, "file")
+	if errno != wasi.ESUCCESS {
+		t.Fatalf("path_filestat_get: want ESUCCESS, got %s", errno)
+	}
+	if stat.AccessTime != 0 || stat.ModifyTime != 0 {
+		t.Errorf("path_filestat_get: wrong times: atime=%d mtime=%d", stat.AccessTime, stat.ModifyTime)
+	}
+}
+
+func TestPathOpenFIFO(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	if err := sysunix.Mkfifo(filepath.Join(tmp, "fifo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := sysunix.Open(tmp, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSystem()
+	defer s.Close(ctx)
+	fd := s.Preopen(unix.FD(dir), "tmp", wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsBase:       wasi.PathOpenRight,
+		RightsInheriting: wasi.FDReadRight | wasi.FDWriteRight,
+	})
+
+	// Opening the write end of a FIFO without blocking fails if there are
+	// no readers, while the read end can be opened right away.
+	if _, errno := s.PathOpen(ctx, fd, 0, "fifo", 0, wasi.FDWriteRight, 0, wasi.NonBlock); errno != wasi.ENXIO {
+		t.Fatalf("path_open: want ENXIO, got %s", errno)
+	}
+	r, errno := s.PathOpen(ctx, fd, 0, "fifo", 0, wasi.FDReadRight, 0, wasi.NonBlock)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	w, errno := s.PathOpen(ctx, fd, 0, "fifo", 0, wasi.FDWriteRight, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	for _, f := range []wasi.FD{r, w} {
+		stat, errno := s.FDStatGet(ctx, f)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat.FileType != wasi.UnknownType {
+			t.Errorf("wrong file type of the FIFO: want %s, got %s", wasi.UnknownType, stat.FileType)
+		}
+	}
+
+	buffer := make([]byte, 32)
+	if _, errno := s.FDRead(ctx, r, []wasi.IOVec{buffer}); errno != wasi.EAGAIN {
+		t.Errorf("fd_read: want EAGAIN, got %s", errno)
+	}
+	if n, errno := s.FDWrite(ctx, w, []wasi.IOVec{[]byte("hello")}); errno != wasi.ESUCCESS || n != 5 {
+		t.Fatalf("fd_write: %d, %s", n, errno)
+	}
+	n, errno := s.FDRead(ctx, r, []wasi.IOVec{buffer})
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if string(buffer[:n]) != "hello" {
+		t.Errorf("fd_read: want %q, got %q", "hello", buffer[:n])
+	}
+}
+
+func TestPathOpenCreateFileMode(t *testing.T) {
+	ctx := context.Background()
+
+	// The modes are chosen so that the usual process umask (022) does not
+	// change the result.
+	tests := []struct {
+		scenario string
+		mode     fs.FileMode
+		umask    fs.FileMode
+		want     fs.FileMode
+	}{
+		{scenario: "default mode", want: 0644},
+		{scenario: "custom mode", mode: 0600, want: 0600},
+		{scenario: "custom umask", umask: 0077, want: 0600},
+		{scenario: "custom mode and umask", mode: 0660, umask: 0060, want: 0600},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			tmp := t.TempDir()
+			dir, err := sysunix.Open(tmp, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := newSystem()
+			s.CreateFileMode = test.mode
+			s.Umask = test.umask
+			defer s.Close(ctx)
+
+			const rights = wasi.PathOpenRight | wasi.PathCreateFileRight | wasi.FDWriteRight
+			d := s.Preopen(unix.FD(dir), "tmp", wasi.FDStat{RightsBase: rights, RightsInheriting: rights})
+
+			fd, errno := s.PathOpen(ctx, d, 0, "file", wasi.OpenCreate, wasi.FDWriteRight, 0, 0)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if errno := s.FDClose(ctx, fd); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+
+			info, err := os.Stat(filepath.Join(tmp, "file"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := info.Mode().Perm(); mode != test.want {
+				t.Errorf("wrong file mode: want %v, got %v", test.want, mode)
+			}
+		})
+	}
+}
+
+func TestPathXattr(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(tmp, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := sysunix.Open(tmp, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSystem()
+	defer s.Close(ctx)
+	fd := s.Preopen(unix.FD(dir), "tmp", wasi.FDStat{RightsBase: wasi.PathXattrRight})
+
+	const name, value = "user.wasi-go.test", "hello"
+	switch errno := s.PathSetXattr(ctx, fd, 0, "file", name, []byte(value)); errno {
+	case wasi.ESUCCESS:
+	case wasi.ENOTSUP:
+		t.Skip("extended attributes are not supported by the file system")
+	default:
+		t.Fatalf("path_setxattr: %s", errno)
+	}
+
+	buffer := make([]byte, 64)
+	n, errno := s.PathGetXattr(ctx, fd, 0, "file", name, buffer)
+	if errno != wasi.ESUCCESS {
+		t.Fatalf("path_getxattr: %s", errno)
+	}
+	if got := string(buffer[:n]); got != value {
+		t.Errorf("wrong extended attribute value: want %q, got %q", value, got)
+	}
+	if _, errno := s.PathGetXattr(ctx, fd, 0, "file", name, buffer[:1]); errno != wasi.ERANGE {
+		t.Errorf("path_getxattr: want ERANGE, got %s", errno)
+	}
+
+	n, errno = s.PathListXattr(ctx, fd, 0, "file", buffer)
+	if errno != wasi.ESUCCESS {
+		t.Fatalf("path_listxattr: %s", errno)
+	}
+	if names := strings.Split(string(buffer[:n]), "\x00"); !slices.Contains(names, name) {
+		t.Errorf("extended attribute missing from the list: %q", names)
+	}
+
+	if _, errno := s.PathGetXattr(ctx, fd, 0, "../file", name, buffer); errno != wasi.EPERM {
+		t.Errorf("path_getxattr outside of the directory: want EPERM, got %s", errno)
+	}
+
+	// The right to access extended attributes must be granted explicitly.
+	other, err := sysunix.Open(tmp, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd = s.Preopen(unix.FD(other), "tmp", wasi.FDStat{RightsBase: wasi.AllRights})
+	if _, errno := s.PathGetXattr(ctx, fd, 0, "file", name, buffer); errno != wasi.ENOTCAPABLE {
+		t.Errorf("path_getxattr without PathXattrRight: want ENOTCAPABLE, got %s", errno)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "input"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(tmp, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	preopen := func(s *unix.System) wasi.FD {
+		dir, err := sysunix.Open(tmp, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Preopen(unix.FD(dir), "/tmp", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+	}
+
+	s1 := newSystem()
+	defer s1.Close(ctx)
+	root := preopen(s1)
+
+	const fileRights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDSeekRight | wasi.FDTellRight
+	input, errno := s1.PathOpen(ctx, root, 0, "input", 0, wasi.FDReadRight|wasi.FDSeekRight|wasi.FDTellRight, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if _, errno := s1.FDRead(ctx, input, []wasi.IOVec{make([]byte, 4)}); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	output, errno := s1.PathOpen(ctx, root, 0, "output", wasi.OpenCreate, fileRights, 0, wasi.Append)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if _, errno := s1.FDWrite(ctx, output, []wasi.IOVec{[]byte("hello")}); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	dir, errno := s1.PathOpen(ctx, root, 0, "dir", wasi.OpenDirectory, wasi.DirectoryRights, wasi.FileRights, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	server, errno := s1.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.SockListenRights, wasi.SockConnectionRights)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	addr, errno := s1.SockBind(ctx, server, &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}})
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if errno := s1.SockListen(ctx, server, 10); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	snapshot := s1.Snapshot()
+	want := []struct {
+		fd     wasi.FD
+		path   string
+		offset wasi.FileSize
+	}{
+		{root, "/tmp", 0},
+		{input, filepath.Join(tmp, "input"), 4},
+		{output, filepath.Join(tmp, "output"), 5},
+		{dir, filepath.Join(tmp, "dir"), 0},
+		{server, "", 0},
+	}
+	if len(snapshot) != len(want) {
+		t.Fatalf("wrong number of file descriptors: want %d, got %d", len(want), len(snapshot))
+	}
+	for i, w := range want {
+		info := snapshot[i]
+		if info.FD != w.fd || info.Path != w.path || info.Offset != w.offset {
+			t.Errorf("wrong snapshot of fd %d: want %d/%q/%d, got %d/%q/%d", w.fd, w.fd, w.path, w.offset, info.FD, info.Path, info.Offset)
+		}
+	}
+	if info := snapshot[0]; !info.Preopen {
+		t.Error("the preopen was not reported")
+	}
+	if info := snapshot[4]; !info.Listening || !reflect.DeepEqual(info.LocalAddress, addr) || info.RemoteAddress != nil {
+		t.Errorf("wrong snapshot of the listening socket: %+v", info)
+	}
+
+	// Close the original system so the address of the socket is available.
+	s1.Close(ctx)
+
+	s2 := newSystem()
+	defer s2.Close(ctx)
+	preopen(s2)
+	for _, info := range snapshot {
+		if errno := s2.Restore(ctx, info); errno != wasi.ESUCCESS {
+			t.Fatalf("restoring fd %d: %s", info.FD, errno)
+		}
+	}
+	if restored := s2.Snapshot(); !reflect.DeepEqual(restored, snapshot) {
+		t.Errorf("snapshots mismatch after restore:\nwant: %+v\ngot:  %+v", snapshot, restored)
+	}
+	if errno := s2.Restore(ctx, snapshot[1]); errno != wasi.EEXIST {
+		t.Errorf("restoring an open file descriptor: want EEXIST, got %s", errno)
+	}
+
+	buf := make([]byte, 4)
+	if n, errno := s2.FDRead(ctx, input, []wasi.IOVec{buf}); errno != wasi.ESUCCESS || string(buf[:n]) != "4567" {
+		t.Errorf("wrong read after restore: %q, %s", buf[:n], errno)
+	}
+	if _, errno := s2.FDWrite(ctx, output, []wasi.IOVec{[]byte(", world")}); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if b, err := os.ReadFile(filepath.Join(tmp, "output")); err != nil || string(b) != "hello, world" {
+		t.Errorf("wrong output after restore: %q, %v", b, err)
+	}
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	accept, _, _, errno := s2.SockAccept(ctx, server, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	s2.FDClose(ctx, accept)
+}
+
+func TestSockSplice(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("data is proxied between the sockets", func(t *testing.T) {
+		// The output socket is non-blocking, and the data is larger than its
+		// send buffer, which exercises waiting for the socket to be writable.
+		p, in, out, w, r := spliceSockets(t, false, true)
+
+		data := make([]byte, 4<<20)
+		prng := rand.New(rand.NewSource(0))
+		prng.Read(data)
+
+		go func() {
+			w.Write(data)
+			w.Close()
+		}()
+
+		recv := make(chan []byte)
+		go func() {
+			b, _ := io.ReadAll(r)
+			recv <- b
+		}()
+
+		moved := 0
+		for {
+			n, errno := p.SockSplice(ctx, in, out, 1<<20)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if n == 0 {
+				break
+			}
+			moved += int(n)
+		}
+		if moved != len(data) {
+			t.Errorf("wrong number of bytes moved: want %d, got %d", len(data), moved)
+		}
+		if errno := p.FDClose(ctx, out); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if b := <-recv; !bytes.Equal(b, data) {
+			t.Error("data received does not match the data sent")
+		}
+	})
+
+	t.Run("non-blocking sockets with no data return EAGAIN", func(t *testing.T) {
+		p, in, out, _, _ := spliceSockets(t, true, true)
+
+		if _, errno := p.SockSplice(ctx, in, out, 4096); errno != wasi.EAGAIN {
+			t.Errorf("wrong error: want EAGAIN, got %s", errno)
+		}
+	})
+
+	t.Run("rights are enforced on both sockets", func(t *testing.T) {
+		p, in, out, w, _ := spliceSockets(t, true, true)
+
+		if _, err := w.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if _, errno := p.SockSplice(ctx, out, in, 4096); errno != wasi.ESUCCESS && errno != wasi.EAGAIN {
+			t.Fatal(errno)
+		}
+		if errno := p.FDStatSetRights(ctx, out, wasi.SockConnectionRights&^wasi.FDWriteRight, 0); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := p.SockSplice(ctx, in, out, 4096); errno != wasi.ENOTCAPABLE {
+			t.Errorf("wrong error for output socket without the write right: %s", errno)
+		}
+		if errno := p.FDStatSetRights(ctx, in, wasi.SockConnectionRights&^wasi.FDReadRight, 0); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := p.SockSplice(ctx, in, in, 4096); errno != wasi.ENOTCAPABLE {
+			t.Errorf("wrong error for input socket without the read right: %s", errno)
+		}
+	})
+}
+
+func BenchmarkSockSplice(b *testing.B) {
+	ctx := context.Background()
+	p, in, out, w, r := spliceSockets(b, false, false)
+
+	const size = 64 * 1024
+	buf := make([]byte, size)
+	go func() {
+		for {
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+	go io.Copy(io.Discard, r)
+
+	b.SetBytes(size)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for n := 0; n < size; {
+			m, errno := p.SockSplice(ctx, in, out, wasi.Size(size-n))
+			if errno != wasi.ESUCCESS {
+				b.Fatal(errno)
+			}
+			n += int(m)
+		}
+	}
+}
+
+func TestSystemOnError(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := sysunix.Open(t.TempDir(), sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type hostError struct {
+		op  string
+		err error
+	}
+	var reported []hostError
+
+	s := &unix.System{
+		OnError: func(op string, err error) {
+			reported = append(reported, hostError{op, err})
+		},
+	}
+	defer s.Close(ctx)
+	fd := s.Preopen(unix.FD(dir), "tmp", wasi.FDStat{RightsBase: wasi.AllRights, RightsInheriting: wasi.AllRights})
+
+	// Errors detected without calling the host are not reported.
+	if _, errno := s.PathOpen(ctx, fd+1, 0, "missing", 0, wasi.AllRights, 0, 0); errno != wasi.EBADF {
+		t.Fatalf("wrong errno: want=%s got=%s", wasi.EBADF, errno)
+	}
+	if len(reported) != 0 {
+		t.Fatalf("unexpected errors reported: %v", reported)
+	}
+
+	if _, errno := s.PathOpen(ctx, fd, 0, "missing", 0, wasi.AllRights, 0, 0); errno != wasi.ENOENT {
+		t.Fatalf("wrong errno: want=%s got=%s", wasi.ENOENT, errno)
+	}
+	if len(reported) != 1 {
+		t.Fatalf("wrong number of errors reported: want=1 got=%d", len(reported))
+	}
+	if reported[0].op != "PathOpen" {
+		t.Errorf("wrong operation: want=PathOpen got=%s", reported[0].op)
+	}
+	if reported[0].err != syscall.ENOENT {
+		t.Errorf("wrong error: want=%v got=%v", syscall.ENOENT, reported[0].err)
+	}
+
+	// Files opened by the system report their errors as well.
+	dirfd, errno := s.PathOpen(ctx, fd, 0, ".", wasi.OpenDirectory, wasi.AllRights, wasi.AllRights, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if errno := s.PathRemoveDirectory(ctx, dirfd, "missing"); errno != wasi.ENOENT {
+		t.Fatalf("wrong errno: want=%s got=%s", wasi.ENOENT, errno)
+	}
+	if len(reported) != 2 {
+		t.Fatalf("wrong number of errors reported: want=2 got=%d", len(reported))
+	}
+	if reported[1].op != "PathRemoveDirectory" {
+		t.Errorf("wrong operation: want=PathRemoveDirectory got=%s", reported[1].op)
+	}
+}
+
+func TestSockStatSetFlagsNonBlock(t *testing.T) {
+	ctx := context.Background()
+
+	s := &unix.System{}
+	defer s.Close(ctx)
+
+	sock, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.SockConnectionRights|wasi.FDStatSetFlagsRight, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	hostfd, _, errno := s.LookupFD(sock, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	for _, flags := range []wasi.FDFlags{0, wasi.NonBlock, 0} {
+		if errno := s.FDStatSetFlags(ctx, sock, flags); errno != wasi.ESUCCESS {
+			t.Fatalf("setting flags to %s: %s", flags, errno)
+		}
+		stat, errno := s.FDStatGet(ctx, sock)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat.Flags != flags {
+			t.Errorf("wrong socket flags: want=%s got=%s", flags, stat.Flags)
+		}
+		fl, err := sysunix.FcntlInt(uintptr(hostfd.FD), sysunix.F_GETFL, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nonBlock := (fl & sysunix.O_NONBLOCK) != 0; nonBlock != flags.Has(wasi.NonBlock) {
+			t.Errorf("wrong O_NONBLOCK flag of the host socket: want=%t got=%t", flags.Has(wasi.NonBlock), nonBlock)
+		}
+	}
+}
+
+// sendFileSetup creates a file holding size bytes of data and a TCP
+// connection. The data received on the connection is copied to w by a
+// goroutine reading from the host socket, which stops when the peer shuts
+// down its write end.
+func sendFileSetup(t testing.TB, ctx context.Context, s *unix.System, size int, w io.Writer) (file, client wasi.FD, data []byte, done <-chan error) {
+	t.Helper()
+	data = make([]byte, size)
+	mathrand.New(mathrand.NewSource(0)).Read(data)
+
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := sysunix.Open(path, sysunix.O_RDONLY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file = s.Register(unix.FD(fd), wasi.FDStat{
+		FileType:   wasi.RegularFileType,
+		RightsBase: wasi.FileRights,
+	})
+
+	client, conn := tcpConnection(t, ctx, s)
+	sock, _, errno := s.LookupSocketFD(conn, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	dup, err := sysunix.Dup(int(sock.FD))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := os.NewFile(uintptr(dup), "conn")
+	errs := make(chan error, 1)
+	go func() {
+		defer r.Close()
+		_, err := io.Copy(w, r)
+		errs <- err
+	}()
+	return file, client, data, errs
+}
+
+func TestFDSendFile(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	var received bytes.Buffer
+	file, client, data, done := sendFileSetup(t, ctx, s, 1<<20, &received)
+
+	const offset = 10
+	for sent := offset; sent < len(data); {
+		n, errno := s.FDSendFile(ctx, client, file, wasi.FileSize(sent), wasi.Size(len(data)))
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if n == 0 {
+			t.Fatalf("no data sent at offset %d", sent)
+		}
+		sent += int(n)
+	}
+
+	// Sending from the end of the file returns zero.
+	n, errno := s.FDSendFile(ctx, client, file, wasi.FileSize(len(data)), 1)
+	if errno != wasi.ESUCCESS || n != 0 {
+		t.Errorf("sending at the end of the file: want=(0, ESUCCESS) got=(%d, %s)", n, errno)
+	}
+	// The offset of the file is not changed.
+	if pos, errno := s.FDTell(ctx, file); errno != wasi.ESUCCESS || pos != 0 {
+		t.Errorf("wrong file offset: want=(0, ESUCCESS) got=(%d, %s)", pos, errno)
+	}
+	// Data can only be sent from files to stream sockets.
+	if _, errno := s.FDSendFile(ctx, file, file, 0, 1); errno != wasi.ENOTSOCK {
+		t.Errorf("sending to a file: want=%s got=%s", wasi.ENOTSOCK, errno)
+	}
+	if _, errno := s.FDSendFile(ctx, client, client, 0, 1); errno != wasi.ENOTSUP {
+		t.Errorf("sending from a socket: want=%s got=%s", wasi.ENOTSUP, errno)
+	}
+
+	if errno := s.SockShutdown(ctx, client, wasi.ShutdownWR); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received.Bytes(), data[offset:]) {
+		t.Errorf("wrong data received: want %d bytes, got %d bytes", len(data)-offset, received.Len())
+	}
+}
+
+func BenchmarkFDSendFile(b *testing.B) {
+	ctx := context.Background()
+	const size = 64 * 1024
+
+	b.Run("sendfile", func(b *testing.B) {
+		s := newSystem()
+		defer s.Close(ctx)
+		file, client, _, done := sendFileSetup(b, ctx, s, size, io.Discard)
+		b.SetBytes(size)
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			for sent := 0; sent < size; {
+				n, errno := s.FDSendFile(ctx, client, file, wasi.FileSize(sent), size)
+				if errno != wasi.ESUCCESS {
+					b.Fatal(errno)
+				}
+				sent += int(n)
+			}
+		}
+
+		b.StopTimer()
+		s.SockShutdown(ctx, client, wasi.ShutdownWR)
+		<-done
+	})
+
+	b.Run("pread+send", func(b *testing.B) {
+		s := newSystem()
+		defer s.Close(ctx)
+		file, client, _, done := sendFileSetup(b, ctx, s, size, io.Discard)
+		buffer := make([]byte, size)
+		b.SetBytes(size)
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			n, errno := s.FDPread(ctx, file, []wasi.IOVec{buffer}, 0)
+			if errno != wasi.ESUCCESS {
+				b.Fatal(errno)
+			}
+			for sent := 0; sent < int(n); {
+				m, errno := s.SockSend(ctx, client, []wasi.IOVec{buffer[sent:n]}, 0)
+				if errno != wasi.ESUCCESS {
+					b.Fatal(errno)
+				}
+				sent += int(m)
+			}
+		}
+
+		b.StopTimer()
+		s.SockShutdown(ctx, client, wasi.ShutdownWR)
+		<-done
+	})
+}
+
+// openAdviseFile creates a file of the given size in a temporary directory
+// and registers it in the system with the rights of regular files.
+func openAdviseFile(t *testing.T, s *unix.System, size int) (wasi.FD, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, bytes.Repeat([]byte{'x'}, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := sysunix.Open(path, sysunix.O_RDONLY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := s.Preopen(unix.FD(f), "file", wasi.FDStat{
+		FileType:   wasi.RegularFileType,
+		RightsBase: wasi.FileRights,
+	})
+	return fd, path
+}
+
+func TestFDAdvise(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	fd, _ := openAdviseFile(t, s, 64*1024)
+
+	for _, advice := range []wasi.Advice{
+		wasi.Normal,
+		wasi.Sequential,
+		wasi.Random,
+		wasi.WillNeed,
+		wasi.DontNeed,
+		wasi.NoReuse,
+	} {
+		for _, length := range []wasi.FileSize{0, 4096} {
+			if errno := s.FDAdvise(ctx, fd, 0, length, advice); errno != wasi.ESUCCESS {
+				t.Errorf("%s (length=%d): %s", advice, length, errno)
+			}
+		}
+	}
+
+	if errno := s.FDAdvise(ctx, fd, 0, 0, wasi.NoReuse+1); errno != wasi.EINVAL {
+		t.Errorf("invalid advice: want EINVAL, got %s", errno)
+	}
+}
+
+// preopenTempDir preopens a temporary directory of the host on s, returning
+// its file descriptor and path.
+func preopenTempDir(t *testing.T, s *unix.System) (wasi.FD, string) {
+	t.Helper()
+	dir := t.TempDir()
+	fd, err := sysunix.Open(dir, sysunix.O_RDONLY|sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Preopen(unix.FD(fd), dir, wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsBase:       wasi.DirectoryRights,
+		RightsInheriting: wasi.DirectoryRights | wasi.FileRights,
+	}), dir
+}
+
+func TestPathOpenTemporary(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	dirFD, dir := preopenTempDir(t, s)
+
+	const rights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDSeekRight | wasi.FDFileStatGetRight
+	fd, errno := s.PathOpen(ctx, dirFD, 0, ".", wasi.OpenTemporary, rights, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	if _, errno := s.FDWrite(ctx, fd, []wasi.IOVec{[]byte("hello")}); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	buf := make([]byte, 8)
+	n, errno := s.FDPread(ctx, fd, []wasi.IOVec{buf}, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("wrong file content: %q", buf[:n])
+	}
+	stat, errno := s.FDStatGet(ctx, fd)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if stat.FileType != wasi.RegularFileType {
+		t.Errorf("wrong file type: want %s, got %s", wasi.RegularFileType, stat.FileType)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("the temporary file has a directory entry: %v", entries)
+	}
+
+	if errno := s.FDClose(ctx, fd); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	// Temporary files must be writable and cannot be combined with the
+	// other flags.
+	if _, errno := s.PathOpen(ctx, dirFD, 0, ".", wasi.OpenTemporary, wasi.FDReadRight, 0, 0); errno != wasi.EINVAL {
+		t.Errorf("opening a read-only temporary file: want EINVAL, got %s", errno)
+	}
+	if _, errno := s.PathOpen(ctx, dirFD, 0, ".", wasi.OpenTemporary|wasi.OpenCreate, rights, 0, 0); errno != wasi.EINVAL {
+		t.Errorf("opening a temporary file with OpenCreate: want EINVAL, got %s", errno)
+	}
+}
+
+func TestFDLeakDiagnostics(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	defer s.Close(ctx)
+
+	var report strings.Builder
+	s.FDLeakDiagnostics = &report
+	s.MaxOpenFiles = 13
+
+	dirFD, dir := preopenTempDir(t, s)
+
+	sockFD, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.SockConnectionRights, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	want := fmt.Sprintf("  fd %d: SockOpen(InetFamily, StreamSocket)\n", sockFD)
+
+	for i := 0; i < 11; i++ {
+		name := fmt.Sprintf("file-%02d", i)
+		fd, errno := s.PathOpen(ctx, dirFD, 0, name, wasi.OpenCreate, wasi.FDWriteRight, 0, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if i < 9 {
+			want += fmt.Sprintf("  fd %d: PathOpen %q\n", fd, filepath.Join(dir, name))
+		}
+	}
+	if report.Len() != 0 {
+		t.Fatalf("report written before reaching the limit of open files: %q", report.String())
+	}
+
+	if _, errno := s.PathOpen(ctx, dirFD, 0, "one-too-many", wasi.OpenCreate, wasi.FDWriteRight, 0, 0); errno != wasi.ENFILE {
+		t.Fatalf("opening more files than the limit: want ENFILE, got %s", errno)
+	}
+	want = "warning: the limit of 13 open files was reached (ENFILE), 12 file descriptors were opened by the guest and not closed, the 10 oldest are:\n" + want
+	if got := report.String(); got != want {
+		t.Errorf("wrong report:\nwant:\n%s\ngot:\n%s", want, got)
+	}
+
+	// The report is only written once.
+	report.Reset()
+	if _, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.SockConnectionRights, 0); errno != wasi.ENFILE {
+		t.Fatalf("opening more sockets than the limit: want ENFILE, got %s", errno)
+	}
+	if report.Len() != 0 {
+		t.Errorf("report written twice: %q", report.String())
+	}
 }
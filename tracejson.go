@@ -0,0 +1,697 @@
+package wasi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"reflect"
+)
+
+// TraceJSON wraps a System to write a JSON record of each call to w, one
+// record per line. Unlike the output of Trace, the records can be decoded to
+// call the methods of another System with the same arguments, and compare the
+// results (see TraceRecord.Replay and cmd/wasitrace).
+//
+// Each record has the name of the method ("call"), its arguments except for
+// the context ("args"), and the values it returned ("results"), in the order
+// of the method signature. Values are encoded with encoding/json, except for
+// the following arguments:
+//
+//   - Buffers that the system writes to, such as the iovecs of FDRead or the
+//     events of PollOneOff, are recorded as their length.
+//   - Socket addresses are recorded as an object with the network as the key,
+//     e.g. {"ip4":"127.0.0.1:80"} or {"unix":"/tmp/socket"}.
+//   - Socket option values are recorded as an object with the kind of value
+//     as the key, e.g. {"int":1}, {"time":1000000} or {"bytes":"ZXRoMA=="}.
+//   - Subscriptions are recorded with their variant, in the FDReadWrite or
+//     Clock field.
+//
+// The Close method is not recorded.
+func TraceJSON(w io.Writer, system System) System {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonTracer{enc: enc, system: system}
+}
+
+// TraceRecord is a call to a method of System recorded by TraceJSON.
+type TraceRecord struct {
+	Call    string            `json:"call"`
+	Args    []json.RawMessage `json:"args"`
+	Results []json.RawMessage `json:"results"`
+}
+
+type jsonTracer struct {
+	enc    *json.Encoder
+	system System
+}
+
+func (t *jsonTracer) record(call string, args []any, results ...any) {
+	r := TraceRecord{
+		Call:    call,
+		Args:    make([]json.RawMessage, len(args)),
+		Results: make([]json.RawMessage, len(results)),
+	}
+	for i, arg := range args {
+		r.Args[i] = marshalTraceValue(arg)
+	}
+	for i, result := range results {
+		r.Results[i] = marshalTraceValue(result)
+	}
+	t.enc.Encode(&r)
+}
+
+func marshalTraceValue(v any) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return b
+}
+
+// traceArgs is a helper to construct the list of arguments of a record.
+func traceArgs(values ...any) []any { return values }
+
+func iovecSizes(iovecs []IOVec) []int {
+	sizes := make([]int, len(iovecs))
+	for i, iov := range iovecs {
+		sizes[i] = len(iov)
+	}
+	return sizes
+}
+
+func traceSocketAddress(addr SocketAddress) any {
+	if addr == nil {
+		return nil
+	}
+	return map[string]string{addr.Network(): addr.String()}
+}
+
+func traceSocketOptionValue(value SocketOptionValue) any {
+	switch v := value.(type) {
+	case IntValue:
+		return map[string]int{"int": int(v)}
+	case TimeValue:
+		return map[string]TimeValue{"time": v}
+	case BytesValue:
+		return map[string][]byte{"bytes": v}
+	default:
+		return nil
+	}
+}
+
+// traceAddressInfo is the representation of AddressInfo in JSON traces, where
+// the address is encoded like socket address arguments.
+type traceAddressInfo struct {
+	Flags         AddressInfoFlags
+	Family        ProtocolFamily
+	SocketType    SocketType
+	Protocol      Protocol
+	Address       json.RawMessage
+	CanonicalName string
+}
+
+func makeTraceAddressInfo(info AddressInfo) traceAddressInfo {
+	return traceAddressInfo{
+		Flags:         info.Flags,
+		Family:        info.Family,
+		SocketType:    info.SocketType,
+		Protocol:      info.Protocol,
+		Address:       marshalTraceValue(traceSocketAddress(info.Address)),
+		CanonicalName: info.CanonicalName,
+	}
+}
+
+// traceSubscription is the representation of Subscription in JSON traces,
+// with the variant of the subscription in the field matching its event type.
+type traceSubscription struct {
+	UserData    UserData
+	EventType   EventType
+	FDReadWrite *SubscriptionFDReadWrite `json:",omitempty"`
+	Clock       *SubscriptionClock       `json:",omitempty"`
+}
+
+func traceSubscriptions(subscriptions []Subscription) []traceSubscription {
+	s := make([]traceSubscription, len(subscriptions))
+	for i := range subscriptions {
+		sub := &subscriptions[i]
+		s[i] = traceSubscription{UserData: sub.UserData, EventType: sub.EventType}
+		switch sub.EventType {
+		case ClockEvent:
+			c := sub.GetClock()
+			s[i].Clock = &c
+		default:
+			fdrw := sub.GetFDReadWrite()
+			s[i].FDReadWrite = &fdrw
+		}
+	}
+	return s
+}
+
+func (t *jsonTracer) ArgsSizesGet(ctx context.Context) (int, int, Errno) {
+	argCount, stringBytes, errno := t.system.ArgsSizesGet(ctx)
+	t.record("ArgsSizesGet", traceArgs(), argCount, stringBytes, errno)
+	return argCount, stringBytes, errno
+}
+
+func (t *jsonTracer) ArgsGet(ctx context.Context) ([]string, Errno) {
+	a, errno := t.system.ArgsGet(ctx)
+	t.record("ArgsGet", traceArgs(), a, errno)
+	return a, errno
+}
+
+func (t *jsonTracer) EnvironSizesGet(ctx context.Context) (int, int, Errno) {
+	envCount, stringBytes, errno := t.system.EnvironSizesGet(ctx)
+	t.record("EnvironSizesGet", traceArgs(), envCount, stringBytes, errno)
+	return envCount, stringBytes, errno
+}
+
+func (t *jsonTracer) EnvironGet(ctx context.Context) ([]string, Errno) {
+	env, errno := t.system.EnvironGet(ctx)
+	t.record("EnvironGet", traceArgs(), env, errno)
+	return env, errno
+}
+
+func (t *jsonTracer) ClockResGet(ctx context.Context, id ClockID) (Timestamp, Errno) {
+	res, errno := t.system.ClockResGet(ctx, id)
+	t.record("ClockResGet", traceArgs(id), res, errno)
+	return res, errno
+}
+
+func (t *jsonTracer) ClockTimeGet(ctx context.Context, id ClockID, precision Timestamp) (Timestamp, Errno) {
+	now, errno := t.system.ClockTimeGet(ctx, id, precision)
+	t.record("ClockTimeGet", traceArgs(id, precision), now, errno)
+	return now, errno
+}
+
+func (t *jsonTracer) FDAdvise(ctx context.Context, fd FD, offset, length FileSize, advice Advice) Errno {
+	errno := t.system.FDAdvise(ctx, fd, offset, length, advice)
+	t.record("FDAdvise", traceArgs(fd, offset, length, advice), errno)
+	return errno
+}
+
+func (t *jsonTracer) FDAllocate(ctx context.Context, fd FD, offset, length FileSize) Errno {
+	errno := t.system.FDAllocate(ctx, fd, offset, length)
+	t.record("FDAllocate", traceArgs(fd, offset, length), errno)
+	return errno
+}
+
+func (t *jsonTracer) FDClose(ctx context.Context, fd FD) Errno {
+	errno := t.system.FDClose(ctx, fd)
+	t.record("FDClose", traceArgs(fd), errno)
+	return errno
+}
+
+func (t *jsonTracer) FDDataSync(ctx context.Context, fd FD) Errno {
+	errno := t.system.FDDataSync(ctx, fd)
+	t.record("FDDataSync", traceArgs(fd), errno)
+	return errno
+}
+
+func (t *jsonTracer) FDStatGet(ctx context.Context, fd FD) (FDStat, Errno) {
+	stat, errno := t.system.FDStatGet(ctx, fd)
+	t.record("FDStatGet", traceArgs(fd), stat, errno)
+	return stat, errno
+}
+
+func (t *jsonTracer) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags) Errno {
+	errno := t.system.FDStatSetFlags(ctx, fd, flags)
+	t.record("FDStatSetFlags", traceArgs(fd, flags), errno)
+	return errno
+}
+
+func (t *jsonTracer) FDStatSwapNonBlock(ctx context.Context, fd FD, nonBlock bool) (bool, Errno) {
+	prev, errno := t.system.FDStatSwapNonBlock(ctx, fd, nonBlock)
+	t.record("FDStatSwapNonBlock", traceArgs(fd, nonBlock), prev, errno)
+	return prev, errno
+}
+
+func (t *jsonTracer) FDStatSetRights(ctx context.Context, fd FD, rightsBase, rightsInheriting Rights) Errno {
+	errno := t.system.FDStatSetRights(ctx, fd, rightsBase, rightsInheriting)
+	t.record("FDStatSetRights", traceArgs(fd, rightsBase, rightsInheriting), errno)
+	return errno
+}
+
+func (t *jsonTracer) FDFileStatGet(ctx context.Context, fd FD) (FileStat, Errno) {
+	stat, errno := t.system.FDFileStatGet(ctx, fd)
+	t.record("FDFileStatGet", traceArgs(fd), stat, errno)
+	return stat, errno
+}
+
+func (t *jsonTracer) FDFileStatSetSize(ctx context.Context, fd FD, size FileSize) Errno {
+	errno := t.system.FDFileStatSetSize(ctx, fd, size)
+	t.record("FDFileStatSetSize", traceArgs(fd, size), errno)
+	return errno
+}
+
+func (t *jsonTracer) FDFileStatSetTimes(ctx context.Context, fd FD, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	errno := t.system.FDFileStatSetTimes(ctx, fd, accessTime, modifyTime, flags)
+	t.record("FDFileStatSetTimes", traceArgs(fd, accessTime, modifyTime, flags), errno)
+	return errno
+}
+
+func (t *jsonTracer) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	n, errno := t.system.FDPread(ctx, fd, iovecs, offset)
+	t.record("FDPread", traceArgs(fd, iovecSizes(iovecs), offset), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) FDPreStatGet(ctx context.Context, fd FD) (PreStat, Errno) {
+	stat, errno := t.system.FDPreStatGet(ctx, fd)
+	t.record("FDPreStatGet", traceArgs(fd), stat, errno)
+	return stat, errno
+}
+
+func (t *jsonTracer) FDPreStatDirName(ctx context.Context, fd FD) (string, Errno) {
+	name, errno := t.system.FDPreStatDirName(ctx, fd)
+	t.record("FDPreStatDirName", traceArgs(fd), name, errno)
+	return name, errno
+}
+
+func (t *jsonTracer) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	n, errno := t.system.FDPwrite(ctx, fd, iovecs, offset)
+	t.record("FDPwrite", traceArgs(fd, iovecs, offset), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) FDCopyRange(ctx context.Context, srcFD, dstFD FD, srcOffset, dstOffset, length FileSize) (FileSize, Errno) {
+	n, errno := t.system.FDCopyRange(ctx, srcFD, dstFD, srcOffset, dstOffset, length)
+	t.record("FDCopyRange", traceArgs(srcFD, dstFD, srcOffset, dstOffset, length), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	n, errno := t.system.FDRead(ctx, fd, iovecs)
+	t.record("FDRead", traceArgs(fd, iovecSizes(iovecs)), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) FDReadDir(ctx context.Context, fd FD, entries []DirEntry, cookie DirCookie, bufferSizeBytes int) (int, Errno) {
+	n, errno := t.system.FDReadDir(ctx, fd, entries, cookie, bufferSizeBytes)
+	t.record("FDReadDir", traceArgs(fd, len(entries), cookie, bufferSizeBytes), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) FDRenumber(ctx context.Context, from, to FD) Errno {
+	errno := t.system.FDRenumber(ctx, from, to)
+	t.record("FDRenumber", traceArgs(from, to), errno)
+	return errno
+}
+
+func (t *jsonTracer) FDSeek(ctx context.Context, fd FD, offset FileDelta, whence Whence) (FileSize, Errno) {
+	n, errno := t.system.FDSeek(ctx, fd, offset, whence)
+	t.record("FDSeek", traceArgs(fd, offset, whence), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) FDSync(ctx context.Context, fd FD) Errno {
+	errno := t.system.FDSync(ctx, fd)
+	t.record("FDSync", traceArgs(fd), errno)
+	return errno
+}
+
+func (t *jsonTracer) FDTell(ctx context.Context, fd FD) (FileSize, Errno) {
+	n, errno := t.system.FDTell(ctx, fd)
+	t.record("FDTell", traceArgs(fd), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	n, errno := t.system.FDWrite(ctx, fd, iovecs)
+	t.record("FDWrite", traceArgs(fd, iovecs), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) PathCreateDirectory(ctx context.Context, fd FD, path string) Errno {
+	errno := t.system.PathCreateDirectory(ctx, fd, path)
+	t.record("PathCreateDirectory", traceArgs(fd, path), errno)
+	return errno
+}
+
+func (t *jsonTracer) PathFileStatGet(ctx context.Context, fd FD, lookupFlags LookupFlags, path string) (FileStat, Errno) {
+	stat, errno := t.system.PathFileStatGet(ctx, fd, lookupFlags, path)
+	t.record("PathFileStatGet", traceArgs(fd, lookupFlags, path), stat, errno)
+	return stat, errno
+}
+
+func (t *jsonTracer) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	errno := t.system.PathFileStatSetTimes(ctx, fd, lookupFlags, path, accessTime, modifyTime, flags)
+	t.record("PathFileStatSetTimes", traceArgs(fd, lookupFlags, path, accessTime, modifyTime, flags), errno)
+	return errno
+}
+
+func (t *jsonTracer) PathGetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, buffer []byte) (int, Errno) {
+	n, errno := t.system.PathGetXattr(ctx, fd, lookupFlags, path, name, buffer)
+	t.record("PathGetXattr", traceArgs(fd, lookupFlags, path, name, len(buffer)), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) PathListXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, buffer []byte) (int, Errno) {
+	n, errno := t.system.PathListXattr(ctx, fd, lookupFlags, path, buffer)
+	t.record("PathListXattr", traceArgs(fd, lookupFlags, path, len(buffer)), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) PathSetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, value []byte) Errno {
+	errno := t.system.PathSetXattr(ctx, fd, lookupFlags, path, name, value)
+	t.record("PathSetXattr", traceArgs(fd, lookupFlags, path, name, value), errno)
+	return errno
+}
+
+func (t *jsonTracer) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
+	errno := t.system.PathLink(ctx, oldFD, oldFlags, oldPath, newFD, newPath)
+	t.record("PathLink", traceArgs(oldFD, oldFlags, oldPath, newFD, newPath), errno)
+	return errno
+}
+
+func (t *jsonTracer) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
+	newfd, errno := t.system.PathOpen(ctx, fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+	t.record("PathOpen", traceArgs(fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags), newfd, errno)
+	return newfd, errno
+}
+
+func (t *jsonTracer) PathReadLink(ctx context.Context, fd FD, path string, buffer []byte) (int, Errno) {
+	n, errno := t.system.PathReadLink(ctx, fd, path, buffer)
+	t.record("PathReadLink", traceArgs(fd, path, len(buffer)), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) PathRemoveDirectory(ctx context.Context, fd FD, path string) Errno {
+	errno := t.system.PathRemoveDirectory(ctx, fd, path)
+	t.record("PathRemoveDirectory", traceArgs(fd, path), errno)
+	return errno
+}
+
+func (t *jsonTracer) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD, newPath string) Errno {
+	errno := t.system.PathRename(ctx, fd, oldPath, newFD, newPath)
+	t.record("PathRename", traceArgs(fd, oldPath, newFD, newPath), errno)
+	return errno
+}
+
+func (t *jsonTracer) PathSymlink(ctx context.Context, oldPath string, fd FD, newPath string) Errno {
+	errno := t.system.PathSymlink(ctx, oldPath, fd, newPath)
+	t.record("PathSymlink", traceArgs(oldPath, fd, newPath), errno)
+	return errno
+}
+
+func (t *jsonTracer) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
+	errno := t.system.PathUnlinkFile(ctx, fd, path)
+	t.record("PathUnlinkFile", traceArgs(fd, path), errno)
+	return errno
+}
+
+func (t *jsonTracer) PollOneOff(ctx context.Context, subscriptions []Subscription, events []Event) (int, Errno) {
+	n, errno := t.system.PollOneOff(ctx, subscriptions, events)
+	t.record("PollOneOff", traceArgs(traceSubscriptions(subscriptions), len(events)), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) ProcExit(ctx context.Context, exitCode ExitCode) Errno {
+	// Record the call before the system exits, which may never return.
+	t.record("ProcExit", traceArgs(exitCode))
+	return t.system.ProcExit(ctx, exitCode)
+}
+
+func (t *jsonTracer) ProcRaise(ctx context.Context, signal Signal) Errno {
+	errno := t.system.ProcRaise(ctx, signal)
+	t.record("ProcRaise", traceArgs(signal), errno)
+	return errno
+}
+
+func (t *jsonTracer) SchedYield(ctx context.Context) Errno {
+	errno := t.system.SchedYield(ctx)
+	t.record("SchedYield", traceArgs(), errno)
+	return errno
+}
+
+func (t *jsonTracer) RandomGet(ctx context.Context, b []byte) Errno {
+	errno := t.system.RandomGet(ctx, b)
+	t.record("RandomGet", traceArgs(len(b)), errno)
+	return errno
+}
+
+func (t *jsonTracer) SockOpen(ctx context.Context, family ProtocolFamily, socketType SocketType, protocol Protocol, rightsBase, rightsInheriting Rights) (FD, Errno) {
+	fd, errno := t.system.SockOpen(ctx, family, socketType, protocol, rightsBase, rightsInheriting)
+	t.record("SockOpen", traceArgs(family, socketType, protocol, rightsBase, rightsInheriting), fd, errno)
+	return fd, errno
+}
+
+func (t *jsonTracer) SockBind(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
+	bound, errno := t.system.SockBind(ctx, fd, addr)
+	t.record("SockBind", traceArgs(fd, traceSocketAddress(addr)), bound, errno)
+	return bound, errno
+}
+
+func (t *jsonTracer) SockConnect(ctx context.Context, fd FD, peer SocketAddress) (SocketAddress, Errno) {
+	addr, errno := t.system.SockConnect(ctx, fd, peer)
+	t.record("SockConnect", traceArgs(fd, traceSocketAddress(peer)), addr, errno)
+	return addr, errno
+}
+
+func (t *jsonTracer) SockListen(ctx context.Context, fd FD, backlog int) Errno {
+	errno := t.system.SockListen(ctx, fd, backlog)
+	t.record("SockListen", traceArgs(fd, backlog), errno)
+	return errno
+}
+
+func (t *jsonTracer) SockAccept(ctx context.Context, fd FD, flags FDFlags) (FD, SocketAddress, SocketAddress, Errno) {
+	newfd, peer, addr, errno := t.system.SockAccept(ctx, fd, flags)
+	t.record("SockAccept", traceArgs(fd, flags), newfd, peer, addr, errno)
+	return newfd, peer, addr, errno
+}
+
+func (t *jsonTracer) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, Errno) {
+	n, oflags, errno := t.system.SockRecv(ctx, fd, iovecs, flags)
+	t.record("SockRecv", traceArgs(fd, iovecSizes(iovecs), flags), n, oflags, errno)
+	return n, oflags, errno
+}
+
+func (t *jsonTracer) SockSend(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags) (Size, Errno) {
+	n, errno := t.system.SockSend(ctx, fd, iovecs, flags)
+	t.record("SockSend", traceArgs(fd, iovecs, flags), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags, addr SocketAddress) (Size, Errno) {
+	n, errno := t.system.SockSendTo(ctx, fd, iovecs, flags, addr)
+	t.record("SockSendTo", traceArgs(fd, iovecs, flags, traceSocketAddress(addr)), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, SocketAddress, Errno) {
+	n, oflags, addr, errno := t.system.SockRecvFrom(ctx, fd, iovecs, flags)
+	t.record("SockRecvFrom", traceArgs(fd, iovecSizes(iovecs), flags), n, oflags, addr, errno)
+	return n, oflags, addr, errno
+}
+
+func (t *jsonTracer) SockGetOpt(ctx context.Context, fd FD, option SocketOption) (SocketOptionValue, Errno) {
+	value, errno := t.system.SockGetOpt(ctx, fd, option)
+	t.record("SockGetOpt", traceArgs(fd, option), value, errno)
+	return value, errno
+}
+
+func (t *jsonTracer) SockSetOpt(ctx context.Context, fd FD, option SocketOption, value SocketOptionValue) Errno {
+	errno := t.system.SockSetOpt(ctx, fd, option, value)
+	t.record("SockSetOpt", traceArgs(fd, option, traceSocketOptionValue(value)), errno)
+	return errno
+}
+
+func (t *jsonTracer) SockLocalAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	addr, errno := t.system.SockLocalAddress(ctx, fd)
+	t.record("SockLocalAddress", traceArgs(fd), addr, errno)
+	return addr, errno
+}
+
+func (t *jsonTracer) SockRemoteAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	addr, errno := t.system.SockRemoteAddress(ctx, fd)
+	t.record("SockRemoteAddress", traceArgs(fd), addr, errno)
+	return addr, errno
+}
+
+func (t *jsonTracer) SockAddressInfo(ctx context.Context, name, service string, hints AddressInfo, results []AddressInfo) (int, Errno) {
+	n, errno := t.system.SockAddressInfo(ctx, name, service, hints, results)
+	t.record("SockAddressInfo", traceArgs(name, service, makeTraceAddressInfo(hints), len(results)), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) SockSplice(ctx context.Context, inFD, outFD FD, maxBytes Size) (Size, Errno) {
+	n, errno := t.system.SockSplice(ctx, inFD, outFD, maxBytes)
+	t.record("SockSplice", traceArgs(inFD, outFD, maxBytes), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) FDSendFile(ctx context.Context, outFD, inFD FD, offset FileSize, count Size) (Size, Errno) {
+	n, errno := t.system.FDSendFile(ctx, outFD, inFD, offset, count)
+	t.record("FDSendFile", traceArgs(outFD, inFD, offset, count), n, errno)
+	return n, errno
+}
+
+func (t *jsonTracer) SockShutdown(ctx context.Context, fd FD, flags SDFlags) Errno {
+	errno := t.system.SockShutdown(ctx, fd, flags)
+	t.record("SockShutdown", traceArgs(fd, flags), errno)
+	return errno
+}
+
+func (t *jsonTracer) Close(ctx context.Context) error {
+	return t.system.Close(ctx)
+}
+
+var (
+	systemType            = reflect.TypeOf((*System)(nil)).Elem()
+	contextType           = reflect.TypeOf((*context.Context)(nil)).Elem()
+	socketAddressType     = reflect.TypeOf((*SocketAddress)(nil)).Elem()
+	socketOptionValueType = reflect.TypeOf((*SocketOptionValue)(nil)).Elem()
+	addressInfoType       = reflect.TypeOf(AddressInfo{})
+	subscriptionsType     = reflect.TypeOf([]Subscription(nil))
+	iovecsType            = reflect.TypeOf([]IOVec(nil))
+)
+
+// Replay calls the method of system recorded in r with the recorded arguments.
+// Buffers recorded by their length are allocated and zeroed. The values
+// returned by the method are encoded like the results of r, so they can be
+// compared to detect differences between the recorded and replayed calls.
+//
+// An error is returned if r is not a record of a method of System, or if its
+// arguments cannot be decoded.
+func (r *TraceRecord) Replay(ctx context.Context, system System) ([]json.RawMessage, error) {
+	m, ok := systemType.MethodByName(r.Call)
+	if !ok || m.Type.NumIn() == 0 || m.Type.In(0) != contextType || r.Call == "Close" {
+		return nil, fmt.Errorf("%s: not a method of wasi.System which can be replayed", r.Call)
+	}
+	if len(r.Args) != m.Type.NumIn()-1 {
+		return nil, fmt.Errorf("%s: wrong number of arguments: want %d, got %d", r.Call, m.Type.NumIn()-1, len(r.Args))
+	}
+	in := make([]reflect.Value, m.Type.NumIn())
+	in[0] = reflect.ValueOf(ctx)
+	for i, arg := range r.Args {
+		v, err := decodeTraceArg(m.Type.In(i+1), arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: argument %d: %w", r.Call, i, err)
+		}
+		in[i+1] = v
+	}
+	out := reflect.ValueOf(&system).Elem().Method(m.Index).Call(in)
+	results := make([]json.RawMessage, len(out))
+	for i, v := range out {
+		results[i] = marshalTraceValue(v.Interface())
+	}
+	return results, nil
+}
+
+func decodeTraceArg(t reflect.Type, data json.RawMessage) (reflect.Value, error) {
+	switch t {
+	case socketAddressType:
+		addr, err := decodeTraceSocketAddress(data)
+		return reflect.ValueOf(&addr).Elem(), err
+
+	case socketOptionValueType:
+		value, err := decodeTraceSocketOptionValue(data)
+		return reflect.ValueOf(&value).Elem(), err
+
+	case addressInfoType:
+		var info traceAddressInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			return reflect.Value{}, err
+		}
+		addr, err := decodeTraceSocketAddress(info.Address)
+		return reflect.ValueOf(AddressInfo{
+			Flags:         info.Flags,
+			Family:        info.Family,
+			SocketType:    info.SocketType,
+			Protocol:      info.Protocol,
+			Address:       addr,
+			CanonicalName: info.CanonicalName,
+		}), err
+
+	case subscriptionsType:
+		var subs []traceSubscription
+		if err := json.Unmarshal(data, &subs); err != nil {
+			return reflect.Value{}, err
+		}
+		subscriptions := make([]Subscription, len(subs))
+		for i, sub := range subs {
+			subscriptions[i] = Subscription{UserData: sub.UserData, EventType: sub.EventType}
+			switch {
+			case sub.Clock != nil:
+				subscriptions[i].SetClock(*sub.Clock)
+			case sub.FDReadWrite != nil:
+				subscriptions[i].SetFDReadWrite(*sub.FDReadWrite)
+			}
+		}
+		return reflect.ValueOf(subscriptions), nil
+
+	case iovecsType:
+		// The iovecs of reads are recorded as the list of buffer sizes.
+		var sizes []int
+		if json.Unmarshal(data, &sizes) == nil {
+			iovecs := make([]IOVec, len(sizes))
+			for i, size := range sizes {
+				iovecs[i] = make(IOVec, size)
+			}
+			return reflect.ValueOf(iovecs), nil
+		}
+	}
+
+	// Other buffers that the system writes to are recorded as their length.
+	if t.Kind() == reflect.Slice {
+		var size int
+		if json.Unmarshal(data, &size) == nil {
+			return reflect.MakeSlice(t, size, size), nil
+		}
+	}
+
+	v := reflect.New(t)
+	if err := json.Unmarshal(data, v.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return v.Elem(), nil
+}
+
+func decodeTraceSocketAddress(data json.RawMessage) (SocketAddress, error) {
+	var addr map[string]string
+	if err := json.Unmarshal(data, &addr); err != nil {
+		return nil, err
+	}
+	if len(addr) == 0 {
+		return nil, nil
+	}
+	for network, address := range addr {
+		switch network {
+		case "unix":
+			return &UnixAddress{Name: address}, nil
+		case "ip4", "ip6":
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return nil, err
+			}
+			if network == "ip4" {
+				return &Inet4Address{Port: int(addrPort.Port()), Addr: addrPort.Addr().As4()}, nil
+			}
+			return &Inet6Address{Port: int(addrPort.Port()), Addr: addrPort.Addr().As16()}, nil
+		}
+		return nil, fmt.Errorf("unsupported socket address network: %q", network)
+	}
+	panic("unreachable")
+}
+
+func decodeTraceSocketOptionValue(data json.RawMessage) (SocketOptionValue, error) {
+	var value struct {
+		Int   *IntValue
+		Time  *TimeValue
+		Bytes BytesValue
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	switch {
+	case value.Int != nil:
+		return *value.Int, nil
+	case value.Time != nil:
+		return *value.Time, nil
+	case value.Bytes != nil:
+		return value.Bytes, nil
+	default:
+		return nil, nil
+	}
+}
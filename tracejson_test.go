@@ -0,0 +1,106 @@
+package wasi_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+// newReplaySystem returns a fakeSystem with a stream socket open on fd 3.
+func newReplaySystem() *fakeSystem {
+	s := newFakeSystem()
+	s.open(3, wasi.SocketStreamType, "")
+	return s
+}
+
+func TestTraceJSON(t *testing.T) {
+	ctx := context.Background()
+	buf := new(bytes.Buffer)
+	s := wasi.TraceJSON(buf, newReplaySystem())
+
+	s.FDWrite(ctx, 3, []wasi.IOVec{[]byte("hello"), []byte(" world")})
+	s.FDRead(ctx, 3, []wasi.IOVec{make([]byte, 4), make([]byte, 8)})
+	s.FDRead(ctx, 4, []wasi.IOVec{make([]byte, 4)})
+	s.SockBind(ctx, 3, &wasi.Inet4Address{Port: 80, Addr: [4]byte{127, 0, 0, 1}})
+	s.SockBind(ctx, 3, &wasi.Inet6Address{Port: 443, Addr: [16]byte{15: 1}})
+	s.SockBind(ctx, 3, &wasi.UnixAddress{Name: "/tmp/socket"})
+	s.SockSetOpt(ctx, 3, wasi.ReuseAddress, wasi.IntValue(1))
+	s.SockGetOpt(ctx, 3, wasi.ReuseAddress)
+	s.SockSetOpt(ctx, 3, wasi.RecvTimeout, wasi.TimeValue(1e9))
+	s.SockGetOpt(ctx, 3, wasi.RecvTimeout)
+	s.SockSetOpt(ctx, 3, wasi.BindToDevice, wasi.BytesValue("eth0"))
+	s.SockGetOpt(ctx, 3, wasi.BindToDevice)
+	s.PollOneOff(ctx, []wasi.Subscription{
+		wasi.MakeSubscriptionClock(1, wasi.SubscriptionClock{ID: wasi.Monotonic, Timeout: 10}),
+		wasi.MakeSubscriptionFDReadWrite(2, wasi.FDReadEvent, wasi.SubscriptionFDReadWrite{FD: 3}),
+	}, make([]wasi.Event, 2))
+
+	var records []wasi.TraceRecord
+	lines := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for lines.Scan() {
+		var r wasi.TraceRecord
+		if err := json.Unmarshal(lines.Bytes(), &r); err != nil {
+			t.Fatalf("invalid record %q: %v", lines.Text(), err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 13 {
+		t.Fatalf("wrong number of records: want 13, got %d\n%s", len(records), buf)
+	}
+
+	for i, want := range []string{
+		`{"call":"FDWrite","args":[3,["aGVsbG8=","IHdvcmxk"]],"results":[11,0]}`,
+		`{"call":"FDRead","args":[3,[4,8]],"results":[11,0]}`,
+		`{"call":"FDRead","args":[4,[4]],"results":[4294967295,8]}`,
+		`{"call":"SockBind","args":[3,{"ip4":"127.0.0.1:80"}],"results":["127.0.0.1:80",0]}`,
+	} {
+		got, _ := json.Marshal(&records[i])
+		if string(got) != want {
+			t.Errorf("wrong record %d:\nwant: %s\ngot:  %s", i, want, got)
+		}
+	}
+
+	// Replaying the records against a fresh system must produce the same
+	// results.
+	replay := newReplaySystem()
+	for _, r := range records {
+		results, err := r.Replay(ctx, replay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := json.Marshal(r.Results)
+		got, _ := json.Marshal(results)
+		if !bytes.Equal(want, got) {
+			t.Errorf("wrong results replaying %s:\nwant: %s\ngot:  %s", r.Call, want, got)
+		}
+	}
+
+	// Replaying the read without the write must yield a different result.
+	results, err := records[1].Replay(ctx, newReplaySystem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := json.Marshal(results); string(got) != `[0,0]` {
+		t.Errorf("wrong results replaying FDRead on an empty file: %s", got)
+	}
+}
+
+func TestTraceRecordReplayInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	for _, r := range []wasi.TraceRecord{
+		{Call: "Close"},
+		{Call: "NotAMethod"},
+		{Call: "FDClose", Args: nil},
+		{Call: "FDClose", Args: []json.RawMessage{json.RawMessage(`"3"`)}},
+		{Call: "SockBind", Args: []json.RawMessage{json.RawMessage(`3`), json.RawMessage(`{"ip5":"::1"}`)}},
+	} {
+		if _, err := r.Replay(ctx, newReplaySystem()); err == nil {
+			t.Errorf("replaying %s%s did not fail", r.Call, r.Args)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 )
 
 // Trace wraps a System to log all calls to its methods in a human-readable
@@ -33,10 +34,23 @@ func WithTracerStringSize(stringSize int) TracerOption {
 	return func(t *tracer) { t.stringSize = stringSize }
 }
 
+// WithTracerShortIOVecs enables annotating vectored reads and writes which
+// transferred fewer bytes than the size of their buffers with the buffer
+// where the transfer stopped, for example:
+//
+//	FDWrite(1, [2]IOVec{...}) => 6 (short write: iovec 1 of 2 stopped at byte 2 of 4)
+//
+// This helps debugging guests which mismanage their buffers after short
+// reads or writes. It is disabled by default.
+func WithTracerShortIOVecs(enable bool) TracerOption {
+	return func(t *tracer) { t.shortIOVecs = enable }
+}
+
 type tracer struct {
-	writer     io.Writer
-	system     System
-	stringSize int
+	writer      io.Writer
+	system      System
+	stringSize  int
+	shortIOVecs bool
 }
 
 func (t *tracer) ArgsSizesGet(ctx context.Context) (int, int, Errno) {
@@ -183,6 +197,18 @@ func (t *tracer) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags) Errno
 	return errno
 }
 
+func (t *tracer) FDStatSwapNonBlock(ctx context.Context, fd FD, nonBlock bool) (bool, Errno) {
+	t.printf("FDStatSwapNonBlock(%d, %t) => ", fd, nonBlock)
+	prev, errno := t.system.FDStatSwapNonBlock(ctx, fd, nonBlock)
+	if errno == ESUCCESS {
+		t.printf("%t", prev)
+	} else {
+		t.printErrno(errno)
+	}
+	t.printf("\n")
+	return prev, errno
+}
+
 func (t *tracer) FDStatSetRights(ctx context.Context, fd FD, rightsBase, rightsInheriting Rights) Errno {
 	t.printf("FDStatSetRights(%d, %s, %s) => ", fd, rightsBase, rightsInheriting)
 	errno := t.system.FDStatSetRights(ctx, fd, rightsBase, rightsInheriting)
@@ -239,6 +265,7 @@ func (t *tracer) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset File
 	if errno == ESUCCESS {
 		t.printf("[%d]byte: ", n)
 		t.printIOVecs(iovecs, int(n))
+		t.printShortIOVecs("read", iovecs, n)
 	} else {
 		t.printErrno(errno)
 	}
@@ -275,6 +302,19 @@ func (t *tracer) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset Fil
 	t.printIOVecs(iovecs, -1)
 	t.printf(", %d) => ", offset)
 	n, errno := t.system.FDPwrite(ctx, fd, iovecs, offset)
+	if errno == ESUCCESS {
+		t.printf("%d", n)
+		t.printShortIOVecs("write", iovecs, n)
+	} else {
+		t.printErrno(errno)
+	}
+	t.printf("\n")
+	return n, errno
+}
+
+func (t *tracer) FDCopyRange(ctx context.Context, srcFD, dstFD FD, srcOffset, dstOffset, length FileSize) (FileSize, Errno) {
+	t.printf("FDCopyRange(%d, %d, %d, %d, %d) => ", srcFD, dstFD, srcOffset, dstOffset, length)
+	n, errno := t.system.FDCopyRange(ctx, srcFD, dstFD, srcOffset, dstOffset, length)
 	if errno == ESUCCESS {
 		t.printf("%d", n)
 	} else {
@@ -292,6 +332,7 @@ func (t *tracer) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno
 	if errno == ESUCCESS {
 		t.printf("[%d]byte: ", n)
 		t.printIOVecs(iovecs, int(n))
+		t.printShortIOVecs("read", iovecs, n)
 	} else {
 		t.printErrno(errno)
 	}
@@ -366,6 +407,7 @@ func (t *tracer) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errn
 	n, errno := t.system.FDWrite(ctx, fd, iovecs)
 	if errno == ESUCCESS {
 		t.printf("%d", n)
+		t.printShortIOVecs("write", iovecs, n)
 	} else {
 		t.printErrno(errno)
 	}
@@ -409,6 +451,44 @@ func (t *tracer) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags Lo
 	return errno
 }
 
+func (t *tracer) PathGetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, buffer []byte) (int, Errno) {
+	t.printf("PathGetXattr(%d, %s, %q, %q, [%d]byte) => ", fd, lookupFlags, path, name, len(buffer))
+	n, errno := t.system.PathGetXattr(ctx, fd, lookupFlags, path, name, buffer)
+	if errno == ESUCCESS {
+		t.printBytes(buffer[:n])
+	} else {
+		t.printErrno(errno)
+	}
+	t.printf("\n")
+	return n, errno
+}
+
+func (t *tracer) PathListXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, buffer []byte) (int, Errno) {
+	t.printf("PathListXattr(%d, %s, %q, [%d]byte) => ", fd, lookupFlags, path, len(buffer))
+	n, errno := t.system.PathListXattr(ctx, fd, lookupFlags, path, buffer)
+	if errno == ESUCCESS {
+		t.printBytes(buffer[:n])
+	} else {
+		t.printErrno(errno)
+	}
+	t.printf("\n")
+	return n, errno
+}
+
+func (t *tracer) PathSetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, value []byte) Errno {
+	t.printf("PathSetXattr(%d, %s, %q, %q, ", fd, lookupFlags, path, name)
+	t.printBytes(value)
+	t.printf(") => ")
+	errno := t.system.PathSetXattr(ctx, fd, lookupFlags, path, name, value)
+	if errno == ESUCCESS {
+		t.printf("ok")
+	} else {
+		t.printErrno(errno)
+	}
+	t.printf("\n")
+	return errno
+}
+
 func (t *tracer) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
 	t.printf("PathLink(%d, %s, %q, %d, %q) => ", oldFD, oldFlags, oldPath, newFD, newPath)
 	errno := t.system.PathLink(ctx, oldFD, oldFlags, oldPath, newFD, newPath)
@@ -580,6 +660,30 @@ func (t *tracer) SockAccept(ctx context.Context, fd FD, flags FDFlags) (FD, Sock
 	return newfd, peer, addr, errno
 }
 
+func (t *tracer) SockSplice(ctx context.Context, inFD, outFD FD, maxBytes Size) (Size, Errno) {
+	t.printf("SockSplice(%d, %d, %d) => ", inFD, outFD, maxBytes)
+	n, errno := t.system.SockSplice(ctx, inFD, outFD, maxBytes)
+	if errno == ESUCCESS {
+		t.printf("%d", n)
+	} else {
+		t.printErrno(errno)
+	}
+	t.printf("\n")
+	return n, errno
+}
+
+func (t *tracer) FDSendFile(ctx context.Context, outFD, inFD FD, offset FileSize, count Size) (Size, Errno) {
+	t.printf("FDSendFile(%d, %d, %d, %d) => ", outFD, inFD, offset, count)
+	n, errno := t.system.FDSendFile(ctx, outFD, inFD, offset, count)
+	if errno == ESUCCESS {
+		t.printf("%d", n)
+	} else {
+		t.printErrno(errno)
+	}
+	t.printf("\n")
+	return n, errno
+}
+
 func (t *tracer) SockShutdown(ctx context.Context, fd FD, flags SDFlags) Errno {
 	t.printf("SockShutdown(%d, %s) => ", fd, flags)
 	errno := t.system.SockShutdown(ctx, fd, flags)
@@ -600,6 +704,7 @@ func (t *tracer) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, iflags RIF
 	if errno == ESUCCESS {
 		t.printf("[%d]byte: ", n)
 		t.printIOVecs(iovecs, int(n))
+		t.printShortIOVecs("read", iovecs, n)
 		t.printf(", %s", oflags)
 	} else {
 		t.printErrno(errno)
@@ -615,6 +720,7 @@ func (t *tracer) SockSend(ctx context.Context, fd FD, iovecs []IOVec, iflags SIF
 	n, errno := t.system.SockSend(ctx, fd, iovecs, iflags)
 	if errno == ESUCCESS {
 		t.printf("%d", n)
+		t.printShortIOVecs("write", iovecs, n)
 	} else {
 		t.printErrno(errno)
 	}
@@ -679,6 +785,7 @@ func (t *tracer) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, iflags S
 	n, errno := t.system.SockSendTo(ctx, fd, iovecs, iflags, addr)
 	if errno == ESUCCESS {
 		t.printf("%d", n)
+		t.printShortIOVecs("write", iovecs, n)
 	} else {
 		t.printErrno(errno)
 	}
@@ -694,6 +801,7 @@ func (t *tracer) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, iflags
 	if errno == ESUCCESS {
 		t.printf("[%d]byte: ", n)
 		t.printIOVecs(iovecs, int(n))
+		t.printShortIOVecs("read", iovecs, n)
 		t.printf(", %s, %s", oflags, addr)
 	} else {
 		t.printErrno(errno)
@@ -706,7 +814,7 @@ func (t *tracer) SockGetOpt(ctx context.Context, fd FD, option SocketOption) (So
 	t.printf("SockGetOpt(%d, %s) => ", fd, option)
 	value, errno := t.system.SockGetOpt(ctx, fd, option)
 	if errno == ESUCCESS {
-		t.printf("%d", value)
+		t.printSocketOptionValue(option, value)
 	} else {
 		t.printErrno(errno)
 	}
@@ -715,7 +823,9 @@ func (t *tracer) SockGetOpt(ctx context.Context, fd FD, option SocketOption) (So
 }
 
 func (t *tracer) SockSetOpt(ctx context.Context, fd FD, option SocketOption, value SocketOptionValue) Errno {
-	t.printf("SockSetOpt(%d, %s, %s) => ", fd, option, value)
+	t.printf("SockSetOpt(%d, %s, ", fd, option)
+	t.printSocketOptionValue(option, value)
+	t.printf(") => ")
 	errno := t.system.SockSetOpt(ctx, fd, option, value)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -757,13 +867,16 @@ func (t *tracer) SockAddressInfo(ctx context.Context, name, service string, hint
 	n, errno := t.system.SockAddressInfo(ctx, name, service, hints, results)
 	if errno == ESUCCESS {
 		t.printf("[")
-		for i := range results[:n] {
+		for i := range results[:min(n, len(results))] {
 			if i > 0 {
 				t.printf(", ")
 			}
 			t.printAddressInfo(results[i])
 		}
 		t.printf("]")
+		if n > len(results) {
+			t.printf(" (%d total)", n)
+		}
 	} else {
 		t.printErrno(errno)
 	}
@@ -801,6 +914,9 @@ func (t *tracer) printSubscription(s Subscription) {
 		t.printf("Timeout:%d,Precision:%d}", c.Timeout, c.Precision)
 	} else {
 		fdrw := s.GetFDReadWrite()
+		if fdrw.Flags != 0 {
+			t.printf("Flags:%s,", fdrw.Flags)
+		}
 		t.printf("FD:%d}", fdrw.FD)
 	}
 }
@@ -868,6 +984,22 @@ func (t *tracer) printIOVecs(iovecs []IOVec, size int) {
 	t.printf("}")
 }
 
+// printShortIOVecs annotates a read or write of n bytes with the buffer where
+// it stopped if it did not fill all the buffers, when enabled.
+func (t *tracer) printShortIOVecs(op string, iovecs []IOVec, n Size) {
+	if !t.shortIOVecs {
+		return
+	}
+	size := int(n)
+	for i, iovec := range iovecs {
+		if size < len(iovec) {
+			t.printf(" (short %s: iovec %d of %d stopped at byte %d of %d)", op, i, len(iovecs), size, len(iovec))
+			return
+		}
+		size -= len(iovec)
+	}
+}
+
 func (t *tracer) printDirEntries(dirEntries []DirEntry, bufferSizeBytes int) {
 	t.printf("{")
 	for i, e := range dirEntries {
@@ -898,6 +1030,29 @@ func (t *tracer) printAddressInfo(a AddressInfo) {
 	t.printf("}")
 }
 
+func (t *tracer) printSocketOptionValue(option SocketOption, value SocketOptionValue) {
+	switch v := value.(type) {
+	case IntValue:
+		// Some options return values which have symbolic names.
+		switch option {
+		case QuerySocketType:
+			t.printf("%s", SocketType(v))
+		case QuerySocketError:
+			t.printf("%s", Errno(v).Name())
+		default:
+			t.printf("%d", int(v))
+		}
+	case TimeValue:
+		t.printf("%s", time.Duration(v))
+	case BytesValue:
+		t.printBytes(v)
+	case nil:
+		t.printf("<nil>")
+	default:
+		t.printf("%s", v)
+	}
+}
+
 func (t *tracer) printBytes(b []byte) {
 	t.printf("[%d]byte(\"", len(b))
 
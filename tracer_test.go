@@ -0,0 +1,126 @@
+package wasi_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+func TestTracerShortIOVecs(t *testing.T) {
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		scenario string
+		options  []wasi.TracerOption
+		want     string
+	}{
+		{
+			scenario: "default",
+			want: `FDWrite(1, [2]IOVec{[4]byte("abcd"),[4]byte("efgh")}) => 6
+FDWrite(1, [1]IOVec{[4]byte("abcd")}) => 4
+FDRead(0, [3]IOVec{[4]Byte,[0]Byte,[4]Byte}) => [6]byte: [3]IOVec{[4]byte("abcd"),[0]byte(""),[2]byte("ef")}
+`,
+		},
+		{
+			scenario: "short iovecs",
+			options:  []wasi.TracerOption{wasi.WithTracerShortIOVecs(true)},
+			want: `FDWrite(1, [2]IOVec{[4]byte("abcd"),[4]byte("efgh")}) => 6 (short write: iovec 1 of 2 stopped at byte 2 of 4)
+FDWrite(1, [1]IOVec{[4]byte("abcd")}) => 4
+FDRead(0, [3]IOVec{[4]Byte,[0]Byte,[4]Byte}) => [6]byte: [3]IOVec{[4]byte("abcd"),[0]byte(""),[2]byte("ef")} (short read: iovec 2 of 3 stopped at byte 2 of 4)
+`,
+		},
+	} {
+		t.Run(test.scenario, func(t *testing.T) {
+			var trace strings.Builder
+			base := newFakeSystem()
+			base.open(0, wasi.CharacterDeviceType, "abcdefgh")
+			base.maxIO = 6
+			s := wasi.Trace(&trace, base, test.options...)
+
+			s.FDWrite(ctx, 1, []wasi.IOVec{[]byte("abcd"), []byte("efgh")})
+			s.FDWrite(ctx, 1, []wasi.IOVec{[]byte("abcd")})
+			s.FDRead(ctx, 0, []wasi.IOVec{make([]byte, 4), nil, make([]byte, 4)})
+
+			if got := trace.String(); got != test.want {
+				t.Errorf("wrong trace:\nwant:\n%s\ngot:\n%s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestTracerSocketOptions(t *testing.T) {
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		scenario string
+		option   wasi.SocketOption
+		value    wasi.SocketOptionValue
+		want     string
+	}{
+		{
+			scenario: "int value",
+			option:   wasi.RecvBufferSize,
+			value:    wasi.IntValue(4096),
+			want: `SockSetOpt(3, RecvBufferSize, 4096) => ok
+SockGetOpt(3, RecvBufferSize) => 4096
+`,
+		},
+		{
+			scenario: "time value",
+			option:   wasi.RecvTimeout,
+			value:    wasi.TimeValue(1500 * time.Millisecond),
+			want: `SockSetOpt(3, RecvTimeout, 1.5s) => ok
+SockGetOpt(3, RecvTimeout) => 1.5s
+`,
+		},
+		{
+			scenario: "bytes value",
+			option:   wasi.BindToDevice,
+			value:    wasi.BytesValue("eth0"),
+			want: `SockSetOpt(3, BindToDevice, [4]byte("eth0")) => ok
+SockGetOpt(3, BindToDevice) => [4]byte("eth0")
+`,
+		},
+		{
+			scenario: "socket type",
+			option:   wasi.QuerySocketType,
+			value:    wasi.IntValue(wasi.StreamSocket),
+			want: `SockSetOpt(3, QuerySocketType, StreamSocket) => ok
+SockGetOpt(3, QuerySocketType) => StreamSocket
+`,
+		},
+		{
+			scenario: "socket error",
+			option:   wasi.QuerySocketError,
+			value:    wasi.IntValue(wasi.ECONNREFUSED),
+			want: `SockSetOpt(3, QuerySocketError, ECONNREFUSED) => ok
+SockGetOpt(3, QuerySocketError) => ECONNREFUSED
+`,
+		},
+		{
+			scenario: "unknown option",
+			option:   wasi.SocketOption(wasi.SocketLevel)<<32 | 99,
+			value:    wasi.IntValue(1),
+			want: `SockSetOpt(3, SocketOption(0|99), 1) => ok
+SockGetOpt(3, SocketOption(0|99)) => 1
+`,
+		},
+	} {
+		t.Run(test.scenario, func(t *testing.T) {
+			var trace strings.Builder
+			base := newFakeSystem()
+			base.open(3, wasi.SocketStreamType, "")
+			s := wasi.Trace(&trace, base)
+
+			s.SockSetOpt(ctx, 3, test.option, test.value)
+			s.SockGetOpt(ctx, 3, test.option)
+
+			if got := trace.String(); got != test.want {
+				t.Errorf("wrong trace:\nwant:\n%s\ngot:\n%s", test.want, got)
+			}
+		})
+	}
+}
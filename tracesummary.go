@@ -0,0 +1,425 @@
+package wasi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// TraceSummary wraps a System to count the calls made to each of its methods,
+// the number of bytes read and written, and the time spent waiting in
+// PollOneOff. Unlike Trace, nothing is written while the system is used; the
+// summary is written to the given io.Writer when the system is closed, listing
+// methods by decreasing number of calls.
+func TraceSummary(w io.Writer, s System) System {
+	return &traceSummary{
+		writer: w,
+		system: s,
+		calls:  make(map[string]int),
+	}
+}
+
+type traceSummary struct {
+	writer       io.Writer
+	system       System
+	calls        map[string]int
+	bytesRead    uint64
+	bytesWritten uint64
+	pollTime     time.Duration
+	closed       bool
+}
+
+func (s *traceSummary) ArgsSizesGet(ctx context.Context) (int, int, Errno) {
+	s.calls["ArgsSizesGet"]++
+	return s.system.ArgsSizesGet(ctx)
+}
+
+func (s *traceSummary) ArgsGet(ctx context.Context) ([]string, Errno) {
+	s.calls["ArgsGet"]++
+	return s.system.ArgsGet(ctx)
+}
+
+func (s *traceSummary) EnvironSizesGet(ctx context.Context) (int, int, Errno) {
+	s.calls["EnvironSizesGet"]++
+	return s.system.EnvironSizesGet(ctx)
+}
+
+func (s *traceSummary) EnvironGet(ctx context.Context) ([]string, Errno) {
+	s.calls["EnvironGet"]++
+	return s.system.EnvironGet(ctx)
+}
+
+func (s *traceSummary) ClockResGet(ctx context.Context, id ClockID) (Timestamp, Errno) {
+	s.calls["ClockResGet"]++
+	return s.system.ClockResGet(ctx, id)
+}
+
+func (s *traceSummary) ClockTimeGet(ctx context.Context, id ClockID, precision Timestamp) (Timestamp, Errno) {
+	s.calls["ClockTimeGet"]++
+	return s.system.ClockTimeGet(ctx, id, precision)
+}
+
+func (s *traceSummary) FDAdvise(ctx context.Context, fd FD, offset FileSize, length FileSize, advice Advice) Errno {
+	s.calls["FDAdvise"]++
+	return s.system.FDAdvise(ctx, fd, offset, length, advice)
+}
+
+func (s *traceSummary) FDAllocate(ctx context.Context, fd FD, offset FileSize, length FileSize) Errno {
+	s.calls["FDAllocate"]++
+	return s.system.FDAllocate(ctx, fd, offset, length)
+}
+
+func (s *traceSummary) FDClose(ctx context.Context, fd FD) Errno {
+	s.calls["FDClose"]++
+	return s.system.FDClose(ctx, fd)
+}
+
+func (s *traceSummary) FDDataSync(ctx context.Context, fd FD) Errno {
+	s.calls["FDDataSync"]++
+	return s.system.FDDataSync(ctx, fd)
+}
+
+func (s *traceSummary) FDStatGet(ctx context.Context, fd FD) (FDStat, Errno) {
+	s.calls["FDStatGet"]++
+	return s.system.FDStatGet(ctx, fd)
+}
+
+func (s *traceSummary) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags) Errno {
+	s.calls["FDStatSetFlags"]++
+	return s.system.FDStatSetFlags(ctx, fd, flags)
+}
+
+func (s *traceSummary) FDStatSwapNonBlock(ctx context.Context, fd FD, nonBlock bool) (bool, Errno) {
+	s.calls["FDStatSwapNonBlock"]++
+	return s.system.FDStatSwapNonBlock(ctx, fd, nonBlock)
+}
+
+func (s *traceSummary) FDStatSetRights(ctx context.Context, fd FD, rightsBase, rightsInheriting Rights) Errno {
+	s.calls["FDStatSetRights"]++
+	return s.system.FDStatSetRights(ctx, fd, rightsBase, rightsInheriting)
+}
+
+func (s *traceSummary) FDFileStatGet(ctx context.Context, fd FD) (FileStat, Errno) {
+	s.calls["FDFileStatGet"]++
+	return s.system.FDFileStatGet(ctx, fd)
+}
+
+func (s *traceSummary) FDFileStatSetSize(ctx context.Context, fd FD, size FileSize) Errno {
+	s.calls["FDFileStatSetSize"]++
+	return s.system.FDFileStatSetSize(ctx, fd, size)
+}
+
+func (s *traceSummary) FDFileStatSetTimes(ctx context.Context, fd FD, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	s.calls["FDFileStatSetTimes"]++
+	return s.system.FDFileStatSetTimes(ctx, fd, accessTime, modifyTime, flags)
+}
+
+func (s *traceSummary) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	s.calls["FDPread"]++
+	n, errno := s.system.FDPread(ctx, fd, iovecs, offset)
+	if errno == ESUCCESS {
+		s.bytesRead += uint64(n)
+	}
+	return n, errno
+}
+
+func (s *traceSummary) FDPreStatGet(ctx context.Context, fd FD) (PreStat, Errno) {
+	s.calls["FDPreStatGet"]++
+	return s.system.FDPreStatGet(ctx, fd)
+}
+
+func (s *traceSummary) FDPreStatDirName(ctx context.Context, fd FD) (string, Errno) {
+	s.calls["FDPreStatDirName"]++
+	return s.system.FDPreStatDirName(ctx, fd)
+}
+
+func (s *traceSummary) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	s.calls["FDPwrite"]++
+	n, errno := s.system.FDPwrite(ctx, fd, iovecs, offset)
+	if errno == ESUCCESS {
+		s.bytesWritten += uint64(n)
+	}
+	return n, errno
+}
+
+func (s *traceSummary) FDCopyRange(ctx context.Context, srcFD, dstFD FD, srcOffset, dstOffset, length FileSize) (FileSize, Errno) {
+	s.calls["FDCopyRange"]++
+	return s.system.FDCopyRange(ctx, srcFD, dstFD, srcOffset, dstOffset, length)
+}
+
+func (s *traceSummary) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	s.calls["FDRead"]++
+	n, errno := s.system.FDRead(ctx, fd, iovecs)
+	if errno == ESUCCESS {
+		s.bytesRead += uint64(n)
+	}
+	return n, errno
+}
+
+func (s *traceSummary) FDReadDir(ctx context.Context, fd FD, entries []DirEntry, cookie DirCookie, bufferSizeBytes int) (int, Errno) {
+	s.calls["FDReadDir"]++
+	return s.system.FDReadDir(ctx, fd, entries, cookie, bufferSizeBytes)
+}
+
+func (s *traceSummary) FDRenumber(ctx context.Context, from, to FD) Errno {
+	s.calls["FDRenumber"]++
+	return s.system.FDRenumber(ctx, from, to)
+}
+
+func (s *traceSummary) FDSeek(ctx context.Context, fd FD, offset FileDelta, whence Whence) (FileSize, Errno) {
+	s.calls["FDSeek"]++
+	return s.system.FDSeek(ctx, fd, offset, whence)
+}
+
+func (s *traceSummary) FDSync(ctx context.Context, fd FD) Errno {
+	s.calls["FDSync"]++
+	return s.system.FDSync(ctx, fd)
+}
+
+func (s *traceSummary) FDTell(ctx context.Context, fd FD) (FileSize, Errno) {
+	s.calls["FDTell"]++
+	return s.system.FDTell(ctx, fd)
+}
+
+func (s *traceSummary) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	s.calls["FDWrite"]++
+	n, errno := s.system.FDWrite(ctx, fd, iovecs)
+	if errno == ESUCCESS {
+		s.bytesWritten += uint64(n)
+	}
+	return n, errno
+}
+
+func (s *traceSummary) PathCreateDirectory(ctx context.Context, fd FD, path string) Errno {
+	s.calls["PathCreateDirectory"]++
+	return s.system.PathCreateDirectory(ctx, fd, path)
+}
+
+func (s *traceSummary) PathFileStatGet(ctx context.Context, fd FD, lookupFlags LookupFlags, path string) (FileStat, Errno) {
+	s.calls["PathFileStatGet"]++
+	return s.system.PathFileStatGet(ctx, fd, lookupFlags, path)
+}
+
+func (s *traceSummary) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	s.calls["PathFileStatSetTimes"]++
+	return s.system.PathFileStatSetTimes(ctx, fd, lookupFlags, path, accessTime, modifyTime, flags)
+}
+
+func (s *traceSummary) PathGetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, buffer []byte) (int, Errno) {
+	s.calls["PathGetXattr"]++
+	return s.system.PathGetXattr(ctx, fd, lookupFlags, path, name, buffer)
+}
+
+func (s *traceSummary) PathListXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, buffer []byte) (int, Errno) {
+	s.calls["PathListXattr"]++
+	return s.system.PathListXattr(ctx, fd, lookupFlags, path, buffer)
+}
+
+func (s *traceSummary) PathSetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, value []byte) Errno {
+	s.calls["PathSetXattr"]++
+	return s.system.PathSetXattr(ctx, fd, lookupFlags, path, name, value)
+}
+
+func (s *traceSummary) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
+	s.calls["PathLink"]++
+	return s.system.PathLink(ctx, oldFD, oldFlags, oldPath, newFD, newPath)
+}
+
+func (s *traceSummary) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
+	s.calls["PathOpen"]++
+	return s.system.PathOpen(ctx, fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+}
+
+func (s *traceSummary) PathReadLink(ctx context.Context, fd FD, path string, buffer []byte) (int, Errno) {
+	s.calls["PathReadLink"]++
+	return s.system.PathReadLink(ctx, fd, path, buffer)
+}
+
+func (s *traceSummary) PathRemoveDirectory(ctx context.Context, fd FD, path string) Errno {
+	s.calls["PathRemoveDirectory"]++
+	return s.system.PathRemoveDirectory(ctx, fd, path)
+}
+
+func (s *traceSummary) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD, newPath string) Errno {
+	s.calls["PathRename"]++
+	return s.system.PathRename(ctx, fd, oldPath, newFD, newPath)
+}
+
+func (s *traceSummary) PathSymlink(ctx context.Context, oldPath string, fd FD, newPath string) Errno {
+	s.calls["PathSymlink"]++
+	return s.system.PathSymlink(ctx, oldPath, fd, newPath)
+}
+
+func (s *traceSummary) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
+	s.calls["PathUnlinkFile"]++
+	return s.system.PathUnlinkFile(ctx, fd, path)
+}
+
+func (s *traceSummary) PollOneOff(ctx context.Context, subscriptions []Subscription, events []Event) (int, Errno) {
+	s.calls["PollOneOff"]++
+	start := time.Now()
+	defer func() { s.pollTime += time.Since(start) }()
+	return s.system.PollOneOff(ctx, subscriptions, events)
+}
+
+func (s *traceSummary) ProcExit(ctx context.Context, exitCode ExitCode) Errno {
+	s.calls["ProcExit"]++
+	return s.system.ProcExit(ctx, exitCode)
+}
+
+func (s *traceSummary) ProcRaise(ctx context.Context, signal Signal) Errno {
+	s.calls["ProcRaise"]++
+	return s.system.ProcRaise(ctx, signal)
+}
+
+func (s *traceSummary) SchedYield(ctx context.Context) Errno {
+	s.calls["SchedYield"]++
+	return s.system.SchedYield(ctx)
+}
+
+func (s *traceSummary) RandomGet(ctx context.Context, b []byte) Errno {
+	s.calls["RandomGet"]++
+	return s.system.RandomGet(ctx, b)
+}
+
+func (s *traceSummary) SockOpen(ctx context.Context, family ProtocolFamily, socketType SocketType, protocol Protocol, rightsBase, rightsInheriting Rights) (FD, Errno) {
+	s.calls["SockOpen"]++
+	return s.system.SockOpen(ctx, family, socketType, protocol, rightsBase, rightsInheriting)
+}
+
+func (s *traceSummary) SockBind(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
+	s.calls["SockBind"]++
+	return s.system.SockBind(ctx, fd, addr)
+}
+
+func (s *traceSummary) SockConnect(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
+	s.calls["SockConnect"]++
+	return s.system.SockConnect(ctx, fd, addr)
+}
+
+func (s *traceSummary) SockListen(ctx context.Context, fd FD, backlog int) Errno {
+	s.calls["SockListen"]++
+	return s.system.SockListen(ctx, fd, backlog)
+}
+
+func (s *traceSummary) SockAccept(ctx context.Context, fd FD, flags FDFlags) (FD, SocketAddress, SocketAddress, Errno) {
+	s.calls["SockAccept"]++
+	return s.system.SockAccept(ctx, fd, flags)
+}
+
+func (s *traceSummary) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, Errno) {
+	s.calls["SockRecv"]++
+	n, rflags, errno := s.system.SockRecv(ctx, fd, iovecs, flags)
+	if errno == ESUCCESS {
+		s.bytesRead += uint64(n)
+	}
+	return n, rflags, errno
+}
+
+func (s *traceSummary) SockSend(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags) (Size, Errno) {
+	s.calls["SockSend"]++
+	n, errno := s.system.SockSend(ctx, fd, iovecs, flags)
+	if errno == ESUCCESS {
+		s.bytesWritten += uint64(n)
+	}
+	return n, errno
+}
+
+func (s *traceSummary) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags, addr SocketAddress) (Size, Errno) {
+	s.calls["SockSendTo"]++
+	n, errno := s.system.SockSendTo(ctx, fd, iovecs, flags, addr)
+	if errno == ESUCCESS {
+		s.bytesWritten += uint64(n)
+	}
+	return n, errno
+}
+
+func (s *traceSummary) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, SocketAddress, Errno) {
+	s.calls["SockRecvFrom"]++
+	n, rflags, addr, errno := s.system.SockRecvFrom(ctx, fd, iovecs, flags)
+	if errno == ESUCCESS {
+		s.bytesRead += uint64(n)
+	}
+	return n, rflags, addr, errno
+}
+
+func (s *traceSummary) SockGetOpt(ctx context.Context, fd FD, option SocketOption) (SocketOptionValue, Errno) {
+	s.calls["SockGetOpt"]++
+	return s.system.SockGetOpt(ctx, fd, option)
+}
+
+func (s *traceSummary) SockSetOpt(ctx context.Context, fd FD, option SocketOption, value SocketOptionValue) Errno {
+	s.calls["SockSetOpt"]++
+	return s.system.SockSetOpt(ctx, fd, option, value)
+}
+
+func (s *traceSummary) SockLocalAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	s.calls["SockLocalAddress"]++
+	return s.system.SockLocalAddress(ctx, fd)
+}
+
+func (s *traceSummary) SockRemoteAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	s.calls["SockRemoteAddress"]++
+	return s.system.SockRemoteAddress(ctx, fd)
+}
+
+func (s *traceSummary) SockAddressInfo(ctx context.Context, name, service string, hints AddressInfo, results []AddressInfo) (int, Errno) {
+	s.calls["SockAddressInfo"]++
+	return s.system.SockAddressInfo(ctx, name, service, hints, results)
+}
+
+func (s *traceSummary) SockSplice(ctx context.Context, inFD, outFD FD, maxBytes Size) (Size, Errno) {
+	s.calls["SockSplice"]++
+	return s.system.SockSplice(ctx, inFD, outFD, maxBytes)
+}
+
+func (s *traceSummary) FDSendFile(ctx context.Context, outFD, inFD FD, offset FileSize, count Size) (Size, Errno) {
+	s.calls["FDSendFile"]++
+	return s.system.FDSendFile(ctx, outFD, inFD, offset, count)
+}
+
+func (s *traceSummary) SockShutdown(ctx context.Context, fd FD, flags SDFlags) Errno {
+	s.calls["SockShutdown"]++
+	return s.system.SockShutdown(ctx, fd, flags)
+}
+
+func (s *traceSummary) Close(ctx context.Context) error {
+	err := s.system.Close(ctx)
+	// The system may be closed multiple times (e.g. by the host module and
+	// the application), the summary is only written once.
+	if !s.closed {
+		s.closed = true
+		s.writeSummary()
+	}
+	return err
+}
+
+func (s *traceSummary) writeSummary() {
+	type count struct {
+		name  string
+		calls int
+	}
+	counts := make([]count, 0, len(s.calls))
+	total := 0
+	for name, calls := range s.calls {
+		counts = append(counts, count{name, calls})
+		total += calls
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].calls != counts[j].calls {
+			return counts[i].calls > counts[j].calls
+		}
+		return counts[i].name < counts[j].name
+	})
+
+	fmt.Fprintf(s.writer, "%10s  %s\n", "calls", "function")
+	for _, c := range counts {
+		fmt.Fprintf(s.writer, "%10d  %s\n", c.calls, c.name)
+	}
+	fmt.Fprintf(s.writer, "%10d  %s\n", total, "total")
+	fmt.Fprintf(s.writer, "\nbytes read:    %d\n", s.bytesRead)
+	fmt.Fprintf(s.writer, "bytes written: %d\n", s.bytesWritten)
+	fmt.Fprintf(s.writer, "poll time:     %s\n", s.pollTime)
+}
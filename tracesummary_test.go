@@ -0,0 +1,66 @@
+package wasi_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+func TestTraceSummary(t *testing.T) {
+	ctx := context.Background()
+	buf := new(bytes.Buffer)
+	sys := newFakeSystem()
+	sys.open(0, wasi.CharacterDeviceType, "0123456789")
+	sys.open(3, wasi.SocketStreamType, "").stat.Flags = wasi.NonBlock
+	s := wasi.TraceSummary(buf, sys)
+
+	for i := 0; i < 3; i++ {
+		s.FDWrite(ctx, 1, []wasi.IOVec{[]byte("abcd")})
+	}
+	s.FDRead(ctx, 0, []wasi.IOVec{make([]byte, 16)})
+	s.FDRead(ctx, 3, []wasi.IOVec{make([]byte, 16)}) // EAGAIN, no bytes read
+	s.PollOneOff(ctx, []wasi.Subscription{
+		wasi.MakeSubscriptionClock(0, wasi.SubscriptionClock{ID: wasi.Monotonic, Timeout: wasi.Timestamp(10 * time.Millisecond)}),
+	}, make([]wasi.Event, 1))
+
+	if buf.Len() != 0 {
+		t.Fatalf("summary written before the system was closed:\n%s", buf)
+	}
+	s.Close(ctx)
+	s.Close(ctx)
+	if sys.closed != 2 {
+		t.Errorf("wrong number of calls to Close: want 2, got %d", sys.closed)
+	}
+
+	lines := strings.Split(buf.String(), "\n")
+	want := []string{
+		"     calls  function",
+		"         3  FDWrite",
+		"         2  FDRead",
+		"         1  PollOneOff",
+		"         6  total",
+		"",
+		"bytes read:    10",
+		"bytes written: 12",
+	}
+	if len(lines) < len(want) {
+		t.Fatalf("summary is too short:\n%s", buf)
+	}
+	for i, line := range want {
+		if lines[i] != line {
+			t.Errorf("wrong line %d of the summary: want %q, got %q", i, line, lines[i])
+		}
+	}
+
+	pollTime, ok := strings.CutPrefix(lines[len(want)], "poll time:")
+	if !ok {
+		t.Fatalf("missing poll time: %q", lines[len(want)])
+	}
+	if d, err := time.ParseDuration(strings.TrimSpace(pollTime)); err != nil || d < 10*time.Millisecond {
+		t.Errorf("wrong poll time: %q", pollTime)
+	}
+}
@@ -2,7 +2,10 @@ package wasi
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/stealthrocket/wasi-go/internal/descriptor"
@@ -33,6 +36,8 @@ type File[T any] interface {
 
 	FDPwrite(ctx context.Context, iovecs []IOVec, offset FileSize) (Size, Errno)
 
+	FDCopyRange(ctx context.Context, dst T, srcOffset, dstOffset, length FileSize) (FileSize, Errno)
+
 	FDRead(ctx context.Context, iovecs []IOVec) (Size, Errno)
 
 	FDWrite(ctx context.Context, iovecs []IOVec) (Size, Errno)
@@ -49,6 +54,12 @@ type File[T any] interface {
 
 	PathFileStatSetTimes(ctx context.Context, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno
 
+	PathGetXattr(ctx context.Context, lookupFlags LookupFlags, path, name string, buffer []byte) (int, Errno)
+
+	PathListXattr(ctx context.Context, lookupFlags LookupFlags, path string, buffer []byte) (int, Errno)
+
+	PathSetXattr(ctx context.Context, lookupFlags LookupFlags, path, name string, value []byte) Errno
+
 	PathLink(ctx context.Context, flags LookupFlags, oldPath string, newFile T, newPath string) Errno
 
 	PathOpen(ctx context.Context, lookupFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (T, Errno)
@@ -64,6 +75,14 @@ type File[T any] interface {
 	PathUnlinkFile(ctx context.Context, path string) Errno
 }
 
+// fileTyper is implemented by files which can open paths that are not
+// regular files. The file type is determined by File.PathOpen when it opens
+// the file, files which do not implement the interface are assumed to be
+// regular files unless opened with OpenDirectory.
+type fileTyper interface {
+	FileType() FileType
+}
+
 // Dir instances are returned by File.FDOpenDir and used to iterate over
 type Dir interface {
 	FDReadDir(ctx context.Context, entries []DirEntry, cookie DirCookie, bufferSizeBytes int) (int, Errno)
@@ -71,6 +90,36 @@ type Dir interface {
 	FDCloseDir(ctx context.Context) Errno
 }
 
+// FDInfo describes a file descriptor open in a System, with the information
+// needed to reconstruct it, for example when restoring a checkpoint of a
+// guest in a different process.
+type FDInfo struct {
+	// FD is the guest file descriptor number.
+	FD FD
+
+	// Stat holds the file type, flags and rights of the file descriptor.
+	Stat FDStat
+
+	// Preopen is true if the file descriptor was preopened by the host, in
+	// which case Path is the name of the preopen.
+	Preopen bool
+
+	// Path is the host path of files and directories. It is empty if the
+	// path could not be resolved, for example because the file was removed.
+	Path string
+
+	// Offset is the current offset of regular files.
+	Offset FileSize
+
+	// LocalAddress and RemoteAddress are the addresses of sockets. The
+	// remote address is nil if the socket is not connected.
+	LocalAddress  SocketAddress
+	RemoteAddress SocketAddress
+
+	// Listening is true for sockets that are accepting connections.
+	Listening bool
+}
+
 // FileTable is a building block used to construct implementations of the System
 // interface.
 //
@@ -103,14 +152,29 @@ type FileTable[T File[T]] struct {
 	// Zero means no limit.
 	MaxOpenDirs int
 
+	// FDLeakDiagnostics, if set, receives the list of the oldest files that
+	// the guest left open the first time the limit of open files is reached,
+	// to help find where the guest leaks file descriptors.
+	FDLeakDiagnostics io.Writer
+
 	files    descriptor.Table[FD, fileEntry[T]]
 	preopens descriptor.Table[FD, string]
 	dirs     map[FD]Dir
+
+	// Sequence number of the last registered file, and whether file
+	// descriptor leaks were reported, when FDLeakDiagnostics is set.
+	seq          uint64
+	leaksWritten bool
 }
 
 type fileEntry[T File[T]] struct {
 	file T
 	stat FDStat
+
+	// The origin and sequence number of the file are only recorded when
+	// FDLeakDiagnostics is set.
+	origin string
+	seq    uint64
 }
 
 func (t *FileTable[T]) Close(ctx context.Context) error {
@@ -140,9 +204,79 @@ func (t *FileTable[T]) PreopenFD(fd FD) {
 }
 
 func (t *FileTable[T]) Register(file T, stat FDStat) FD {
-	stat.RightsBase &= AllRights
+	return t.RegisterOrigin(file, stat, nil)
+}
+
+// RegisterOrigin is like Register but records where the file came from (e.g.
+// the path that it was opened from), which is reported when the limit of open
+// files is reached. The origin function is only called if FDLeakDiagnostics
+// is set.
+func (t *FileTable[T]) RegisterOrigin(file T, stat FDStat, origin func() string) FD {
+	stat.RightsBase &= AllRights | PathXattrRight
 	stat.RightsInheriting &= AllRights
-	return t.files.Insert(fileEntry[T]{file: file, stat: stat})
+	entry := fileEntry[T]{file: file, stat: stat}
+	if t.FDLeakDiagnostics != nil {
+		t.seq++
+		entry.seq = t.seq
+		if origin != nil {
+			entry.origin = origin()
+		}
+	}
+	return t.files.Insert(entry)
+}
+
+// CheckMaxOpenFiles returns ENFILE if the limit of open files was reached.
+//
+// The first time the limit is reached, the oldest files opened by the guest
+// that are still open are written to FDLeakDiagnostics.
+func (t *FileTable[T]) CheckMaxOpenFiles() Errno {
+	if t.MaxOpenFiles <= 0 || t.NumOpenFiles() < t.MaxOpenFiles {
+		return ESUCCESS
+	}
+	if t.FDLeakDiagnostics != nil && !t.leaksWritten {
+		t.leaksWritten = true
+		t.writeFDLeaks(t.FDLeakDiagnostics)
+	}
+	return ENFILE
+}
+
+// maxFDLeaks is the number of files listed by the diagnostics of file
+// descriptor leaks.
+const maxFDLeaks = 10
+
+func (t *FileTable[T]) writeFDLeaks(w io.Writer) {
+	type openFile struct {
+		fd     FD
+		origin string
+		seq    uint64
+	}
+	var files []openFile
+	t.files.Range(func(fd FD, f fileEntry[T]) bool {
+		// Preopens are open for the lifetime of the guest, they are not
+		// leaks.
+		if !t.isPreopen(fd) {
+			files = append(files, openFile{fd, f.origin, f.seq})
+		}
+		return true
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].seq < files[j].seq
+	})
+
+	fmt.Fprintf(w, "warning: the limit of %d open files was reached (ENFILE), %d file descriptors were opened by the guest and not closed", t.MaxOpenFiles, len(files))
+	if len(files) > maxFDLeaks {
+		fmt.Fprintf(w, ", the %d oldest are:\n", maxFDLeaks)
+		files = files[:maxFDLeaks]
+	} else {
+		fmt.Fprintf(w, ":\n")
+	}
+	for _, f := range files {
+		origin := f.origin
+		if origin == "" {
+			origin = "unknown origin"
+		}
+		fmt.Fprintf(w, "  fd %d: %s\n", f.fd, origin)
+	}
 }
 
 func (t *FileTable[T]) NumPreopens() int {
@@ -175,6 +309,20 @@ func (t *FileTable[T]) LookupSocketFD(fd FD, rights Rights) (file T, stat FDStat
 	return file, stat, errno
 }
 
+// LookupPreopen returns the path that fd was preopened at, and whether it is
+// a preopen.
+func (t *FileTable[T]) LookupPreopen(fd FD) (path string, ok bool) {
+	return t.preopens.Lookup(fd)
+}
+
+// Range calls f for each file descriptor open in the table, in increasing
+// order. The function f may return false to interrupt the iteration.
+func (t *FileTable[T]) Range(f func(fd FD, file T, stat FDStat) bool) {
+	t.files.Range(func(fd FD, e fileEntry[T]) bool {
+		return f(fd, e.file, e.stat)
+	})
+}
+
 func (t *FileTable[T]) isPreopen(fd FD) bool {
 	return t.preopens.Access(fd) != nil
 }
@@ -190,6 +338,25 @@ func (t *FileTable[T]) lookupFD(fd FD, rights Rights) (*fileEntry[T], Errno) {
 	return f, ESUCCESS
 }
 
+// lookupDataFD is like lookupFD for the functions reading or writing the data
+// of a file. Directories are rejected with EISDIR before checking the rights,
+// so guests observe the same error on all platforms instead of whatever the
+// host returns when reading or writing a directory (or ENOTCAPABLE, since
+// directories are not usually granted read and write rights).
+func (t *FileTable[T]) lookupDataFD(fd FD, rights Rights) (*fileEntry[T], Errno) {
+	f := t.files.Access(fd)
+	if f == nil {
+		return nil, EBADF
+	}
+	if f.stat.FileType == DirectoryType {
+		return nil, EISDIR
+	}
+	if !f.stat.RightsBase.Has(rights) {
+		return nil, ENOTCAPABLE
+	}
+	return f, ESUCCESS
+}
+
 func (t *FileTable[T]) lookupPreopenPath(fd FD) (string, Errno) {
 	path, ok := t.preopens.Lookup(fd)
 	if !ok {
@@ -278,12 +445,17 @@ func (t *FileTable[T]) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags)
 	if errno != ESUCCESS {
 		return errno
 	}
+	if (flags &^ (Append | DSync | NonBlock | RSync | Sync)) != 0 {
+		return EINVAL
+	}
 	changes := flags ^ f.stat.Flags
 	if changes == 0 {
 		return ESUCCESS
 	}
-	if changes.Has(Sync | DSync | RSync) {
-		return ENOSYS // TODO: support changing {Sync,DSync,Rsync}
+	if (changes & RSync) != 0 {
+		// Neither Linux nor Darwin support changing O_RSYNC with fcntl(2),
+		// the flag can only be set when opening files.
+		return ENOTSUP
 	}
 	if errno := f.file.FDStatSetFlags(ctx, flags); errno != ESUCCESS {
 		return errno
@@ -292,6 +464,30 @@ func (t *FileTable[T]) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags)
 	return ESUCCESS
 }
 
+// FDStatSwapNonBlock sets or clears the NonBlock flag of fd, returning whether
+// the flag was set before the call.
+//
+// The previous mode is served from the flags cached in the file table, which
+// combines the FDStatGet and FDStatSetFlags round-trips made by applications
+// which frequently toggle the blocking mode of their sockets. The underlying
+// file is left untouched when the mode does not change.
+func (t *FileTable[T]) FDStatSwapNonBlock(ctx context.Context, fd FD, nonBlock bool) (bool, Errno) {
+	f, errno := t.lookupFD(fd, FDStatSetFlagsRight)
+	if errno != ESUCCESS {
+		return false, errno
+	}
+	prev := f.stat.Flags.Has(NonBlock)
+	if prev == nonBlock {
+		return prev, ESUCCESS
+	}
+	flags := f.stat.Flags ^ NonBlock
+	if errno := f.file.FDStatSetFlags(ctx, flags); errno != ESUCCESS {
+		return prev, errno
+	}
+	f.stat.Flags = flags
+	return prev, ESUCCESS
+}
+
 func (t *FileTable[T]) FDStatSetRights(ctx context.Context, fd FD, rightsBase, rightsInheriting Rights) Errno {
 	f, errno := t.lookupFD(fd, 0)
 	if errno != ESUCCESS {
@@ -367,7 +563,7 @@ func (t *FileTable[T]) FDPreStatDirName(ctx context.Context, fd FD) (string, Err
 }
 
 func (t *FileTable[T]) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
-	f, errno := t.lookupFD(fd, FDReadRight|FDSeekRight)
+	f, errno := t.lookupDataFD(fd, FDReadRight|FDSeekRight)
 	if errno != ESUCCESS {
 		return 0, errno
 	}
@@ -375,15 +571,27 @@ func (t *FileTable[T]) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offse
 }
 
 func (t *FileTable[T]) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
-	f, errno := t.lookupFD(fd, FDWriteRight|FDSeekRight)
+	f, errno := t.lookupDataFD(fd, FDWriteRight|FDSeekRight)
 	if errno != ESUCCESS {
 		return 0, errno
 	}
 	return f.file.FDPwrite(ctx, iovecs, offset)
 }
 
+func (t *FileTable[T]) FDCopyRange(ctx context.Context, srcFD, dstFD FD, srcOffset, dstOffset, length FileSize) (FileSize, Errno) {
+	src, errno := t.lookupDataFD(srcFD, FDReadRight|FDSeekRight)
+	if errno != ESUCCESS {
+		return 0, errno
+	}
+	dst, errno := t.lookupDataFD(dstFD, FDWriteRight|FDSeekRight)
+	if errno != ESUCCESS {
+		return 0, errno
+	}
+	return src.file.FDCopyRange(ctx, dst.file, srcOffset, dstOffset, length)
+}
+
 func (t *FileTable[T]) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
-	f, errno := t.lookupFD(fd, FDReadRight)
+	f, errno := t.lookupDataFD(fd, FDReadRight)
 	if errno != ESUCCESS {
 		return 0, errno
 	}
@@ -391,7 +599,7 @@ func (t *FileTable[T]) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size,
 }
 
 func (t *FileTable[T]) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
-	f, errno := t.lookupFD(fd, FDWriteRight)
+	f, errno := t.lookupDataFD(fd, FDWriteRight)
 	if errno != ESUCCESS {
 		return 0, errno
 	}
@@ -438,12 +646,20 @@ func (t *FileTable[T]) FDRenumber(ctx context.Context, from, to FD) Errno {
 	if errno != ESUCCESS {
 		return errno
 	}
+	// Like dup2(2) with RLIMIT_NOFILE, file descriptor numbers are limited
+	// to the maximum number of open files, which bounds the size of the table.
+	if to < 0 || (t.MaxOpenFiles > 0 && int(to) >= t.MaxOpenFiles) {
+		return EBADF
+	}
+	if from == to {
+		return ESUCCESS
+	}
 	d := t.dirs[from]
-	// TODO: limit max file descriptor number
 	g, replaced := t.files.Assign(to, *f)
 	if replaced {
 		g.file.FDClose(ctx)
 		if dir := t.dirs[to]; dir != nil {
+			delete(t.dirs, to)
 			dir.FDCloseDir(ctx)
 		}
 	}
@@ -508,6 +724,30 @@ func (t *FileTable[T]) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFl
 	return d.file.PathFileStatSetTimes(ctx, lookupFlags, path, accessTime, modifyTime, fstFlags)
 }
 
+func (t *FileTable[T]) PathGetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, buffer []byte) (int, Errno) {
+	d, errno := t.lookupFD(fd, PathXattrRight)
+	if errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.file.PathGetXattr(ctx, lookupFlags, path, name, buffer)
+}
+
+func (t *FileTable[T]) PathListXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, buffer []byte) (int, Errno) {
+	d, errno := t.lookupFD(fd, PathXattrRight)
+	if errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.file.PathListXattr(ctx, lookupFlags, path, buffer)
+}
+
+func (t *FileTable[T]) PathSetXattr(ctx context.Context, fd FD, lookupFlags LookupFlags, path, name string, value []byte) Errno {
+	d, errno := t.lookupFD(fd, PathXattrRight)
+	if errno != ESUCCESS {
+		return errno
+	}
+	return d.file.PathSetXattr(ctx, lookupFlags, path, name, value)
+}
+
 func (t *FileTable[T]) PathLink(ctx context.Context, fd FD, flags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
 	oldDir, errno := t.lookupFD(fd, PathLinkSourceRight)
 	if errno != ESUCCESS {
@@ -530,7 +770,10 @@ func (t *FileTable[T]) PathOpen(ctx context.Context, fd FD, lookupFlags LookupFl
 		return -1, EPERM
 	}
 
-	// Rights can only be preserved or removed, not added.
+	// Rights can only be preserved or removed, not added. Missing rights are
+	// reported as ENOTCAPABLE before the file is opened on the host, so they
+	// can be told apart from host errors like EACCES, which are returned
+	// unchanged.
 	rightsBase &= AllRights
 	rightsInheriting &= AllRights
 	if (rightsBase &^ d.stat.RightsInheriting) != 0 {
@@ -544,7 +787,7 @@ func (t *FileTable[T]) PathOpen(ctx context.Context, fd FD, lookupFlags LookupFl
 	if openFlags.Has(OpenDirectory) {
 		rightsBase &= DirectoryRights
 	}
-	if openFlags.Has(OpenCreate) {
+	if openFlags.Has(OpenCreate) || openFlags.Has(OpenTemporary) {
 		if !d.stat.RightsBase.Has(PathCreateFileRight) {
 			return -1, ENOTCAPABLE
 		}
@@ -555,8 +798,8 @@ func (t *FileTable[T]) PathOpen(ctx context.Context, fd FD, lookupFlags LookupFl
 		}
 	}
 
-	if t.MaxOpenFiles > 0 && t.NumOpenFiles() >= t.MaxOpenFiles {
-		return -1, ENFILE
+	if errno := t.CheckMaxOpenFiles(); errno != ESUCCESS {
+		return -1, errno
 	}
 
 	newFile, errno := d.file.PathOpen(ctx, lookupFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
@@ -567,13 +810,27 @@ func (t *FileTable[T]) PathOpen(ctx context.Context, fd FD, lookupFlags LookupFl
 	fileType := RegularFileType
 	if openFlags.Has(OpenDirectory) {
 		fileType = DirectoryType
+	} else if f, ok := any(newFile).(fileTyper); ok {
+		// The path may not be a regular file (e.g. a FIFO or a device), the
+		// file type is the one of the file that was opened.
+		fileType = f.FileType()
+		// Directories may be opened without OpenDirectory, in which case the
+		// rights must still be restricted to those that apply to directories.
+		if fileType == DirectoryType {
+			rightsBase &= DirectoryRights
+		}
 	}
 
-	newFD := t.Register(newFile, FDStat{
+	newFD := t.RegisterOrigin(newFile, FDStat{
 		FileType:         fileType,
 		Flags:            fdFlags,
 		RightsBase:       rightsBase,
 		RightsInheriting: rightsInheriting,
+	}, func() string {
+		if dir, ok := t.preopens.Lookup(fd); ok {
+			return fmt.Sprintf("PathOpen %q", filepath.Join(dir, path))
+		}
+		return fmt.Sprintf("PathOpen(%d, %q)", fd, path)
 	})
 	return newFD, ESUCCESS
 }
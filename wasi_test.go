@@ -457,6 +457,7 @@ func TestFile(t *testing.T) {
 	assertEqual(t, PollFDReadWriteRight, 1<<27)
 	assertEqual(t, SockShutdownRight, 1<<28)
 	assertEqual(t, SockAcceptRight, 1<<29)
+	assertEqual(t, PathXattrRight, 1<<30)
 	for i := 0; i <= 29; i++ {
 		assertEqual(t, AllRights.Has(1<<i), true)
 	}
@@ -493,6 +494,7 @@ func TestFile(t *testing.T) {
 	assertEqual(t, PollFDReadWriteRight.String(), "PollFDReadWriteRight")
 	assertEqual(t, SockShutdownRight.String(), "SockShutdownRight")
 	assertEqual(t, SockAcceptRight.String(), "SockAcceptRight")
+	assertEqual(t, PathXattrRight.String(), "PathXattrRight")
 	assertEqual(t, (FDFileStatGetRight | PathSymlinkRight).String(), "FDFileStatGetRight|PathSymlinkRight")
 	assertEqual(t, Rights(0).String(), "Rights(0)")
 	assertEqual(t, AllRights.String(), "AllRights")
@@ -566,10 +568,16 @@ func TestSubscription(t *testing.T) {
 	assertEqual(t, unsafe.Offsetof(Subscription{}.variant), 16)
 	assertEqual(t, unsafe.Sizeof(Subscription{}.variant), 32)
 
-	assertEqual(t, unsafe.Sizeof(SubscriptionFDReadWrite{}), 4)
+	assertEqual(t, unsafe.Sizeof(SubscriptionFDReadWrite{}), 8)
 	assertEqual(t, unsafe.Offsetof(SubscriptionFDReadWrite{}.FD), 0)
 	assertEqual(t, unsafe.Sizeof(SubscriptionFDReadWrite{}.FD), 4)
+	assertEqual(t, unsafe.Offsetof(SubscriptionFDReadWrite{}.Flags), 4)
 	assertEqual(t, format(SubscriptionFDReadWrite{FD: 42}), `{FD:42}`)
+	assertEqual(t, format(SubscriptionFDReadWrite{FD: 42, Flags: PollPriority}), `{FD:42,Flags:PollPriority}`)
+
+	assertEqual(t, unsafe.Sizeof(SubscriptionFDReadWriteFlags(0)), 2)
+	assertEqual(t, PollPriority, 0x1)
+	assertEqual(t, PollPriority.String(), "PollPriority")
 
 	now := Timestamp(time.Date(2023, 5, 2, 12, 2, 0, 0, time.UTC).UnixNano())
 	assertEqual(t, unsafe.Sizeof(SubscriptionClock{}), 32)
@@ -592,7 +600,7 @@ func format(v types.Formatter) string {
 }
 
 func TestSubscriptionFDReadWrite(t *testing.T) {
-	actual := MakeSubscriptionFDReadWrite(0xFEEDF4CED00DCAFE, FDReadEvent, SubscriptionFDReadWrite{FD(0xABCD)})
+	actual := MakeSubscriptionFDReadWrite(0xFEEDF4CED00DCAFE, FDReadEvent, SubscriptionFDReadWrite{FD: FD(0xABCD)})
 
 	expected := Subscription{
 		UserData:  0xFEEDF4CED00DCAFE,
@@ -656,15 +664,22 @@ func TestEvent(t *testing.T) {
 
 	assertEqual(t, unsafe.Sizeof(EventFDReadWriteFlags(0)), 2)
 	assertEqual(t, Hangup, 0x1)
+	assertEqual(t, Priority, 0x2)
 	assertEqual(t, Hangup.String(), "Hangup")
+	assertEqual(t, Priority.String(), "Priority")
+	assertEqual(t, (Hangup | Priority).String(), "Hangup|Priority")
+	assertEqual(t, EventFDReadWriteFlags(0).String(), "EventFDReadWriteFlags(0)")
 }
 
 func TestSocket(t *testing.T) {
 	assertEqual(t, unsafe.Sizeof(RIFlags(0)), 2)
 	assertEqual(t, RecvPeek, 1<<0)
 	assertEqual(t, RecvWaitAll, 1<<1)
+	assertEqual(t, RecvDontWait, 1<<2)
+	assertEqual(t, RecvOOB, 1<<3)
 	assertEqual(t, RecvPeek.String(), "RecvPeek")
 	assertEqual(t, RecvWaitAll.String(), "RecvWaitAll")
+	assertEqual(t, RecvOOB.String(), "RecvOOB")
 	assertEqual(t, (RecvPeek | RecvWaitAll).String(), "RecvPeek|RecvWaitAll")
 
 	assertEqual(t, unsafe.Sizeof(ROFlags(0)), 2)
@@ -672,6 +687,9 @@ func TestSocket(t *testing.T) {
 	assertEqual(t, RecvDataTruncated.String(), "RecvDataTruncated")
 
 	assertEqual(t, unsafe.Sizeof(SIFlags(0)), 2)
+	assertEqual(t, SendDontWait, 1<<0)
+	assertEqual(t, SendOOB, 1<<1)
+	assertEqual(t, (SendDontWait | SendOOB).String(), "SendDontWait|SendOOB")
 
 	assertEqual(t, unsafe.Sizeof(SDFlags(0)), 2)
 	assertEqual(t, ShutdownRD, 1<<0)
@@ -0,0 +1,150 @@
+// Package wasiio provides adapters to access the file descriptors of a
+// wasi.System with the interfaces of the standard io package.
+//
+// The adapters are intended for embedders that need to bridge files opened by
+// the guest into host Go code, for example to pass a guest file to a function
+// accepting an io.ReaderAt.
+package wasiio
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+// File wraps a file descriptor of a wasi.System and implements io.Reader,
+// io.Writer, io.ReaderAt, io.WriterAt and io.Seeker by delegating to the
+// FDRead, FDWrite, FDPread, FDPwrite and FDSeek methods of the system.
+//
+// File does not own the file descriptor; closing it is the responsibility of
+// the caller, who must also ensure that the descriptor has the rights needed
+// by the methods being called.
+type File struct {
+	ctx context.Context
+	sys wasi.System
+	fd  wasi.FD
+}
+
+// NewFile constructs a File for the file descriptor fd of the system sys.
+// The context is passed to all method calls of the system.
+func NewFile(ctx context.Context, sys wasi.System, fd wasi.FD) *File {
+	return &File{ctx: ctx, sys: sys, fd: fd}
+}
+
+// FD returns the wrapped file descriptor.
+func (f *File) FD() wasi.FD { return f.fd }
+
+// Read reads up to len(b) bytes from the current offset of the file. It
+// returns io.EOF when the end of the file has been reached.
+func (f *File) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, errno := f.sys.FDRead(f.ctx, f.fd, []wasi.IOVec{b})
+	if errno != wasi.ESUCCESS {
+		return int(n), f.error("read", errno)
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return int(n), nil
+}
+
+// ReadAt reads len(b) bytes from the file starting at offset off. As required
+// by io.ReaderAt, a non-nil error is returned when fewer than len(b) bytes are
+// read; the error is io.EOF if the end of the file was reached.
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, f.error("pread", wasi.EINVAL)
+	}
+	var n int
+	for n < len(b) {
+		rn, errno := f.sys.FDPread(f.ctx, f.fd, []wasi.IOVec{b[n:]}, wasi.FileSize(off+int64(n)))
+		n += int(rn)
+		if errno != wasi.ESUCCESS {
+			return n, f.error("pread", errno)
+		}
+		if rn == 0 {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+// Write writes b at the current offset of the file. Partial writes are
+// retried until all of b has been written, or io.ErrShortWrite is returned
+// if the system makes no progress.
+func (f *File) Write(b []byte) (int, error) {
+	var n int
+	for n < len(b) {
+		wn, errno := f.sys.FDWrite(f.ctx, f.fd, []wasi.IOVec{b[n:]})
+		n += int(wn)
+		if errno != wasi.ESUCCESS {
+			return n, f.error("write", errno)
+		}
+		if wn == 0 {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// WriteAt writes b to the file starting at offset off, without modifying the
+// current offset of the file. Partial writes are handled like in Write.
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, f.error("pwrite", wasi.EINVAL)
+	}
+	var n int
+	for n < len(b) {
+		wn, errno := f.sys.FDPwrite(f.ctx, f.fd, []wasi.IOVec{b[n:]}, wasi.FileSize(off+int64(n)))
+		n += int(wn)
+		if errno != wasi.ESUCCESS {
+			return n, f.error("pwrite", errno)
+		}
+		if wn == 0 {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// Seek sets the offset of the next Read or Write on the file. The values of
+// whence defined by the io package match those of wasi.Whence.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	seek, errno := f.sys.FDSeek(f.ctx, f.fd, wasi.FileDelta(offset), wasi.Whence(whence))
+	if errno != wasi.ESUCCESS {
+		return int64(seek), f.error("seek", errno)
+	}
+	return int64(seek), nil
+}
+
+func (f *File) error(op string, errno wasi.Errno) error {
+	return &Error{Op: op, FD: f.fd, Errno: errno}
+}
+
+// Error is the type of errors returned by the methods of File when the
+// underlying system returns an error number.
+type Error struct {
+	Op    string
+	FD    wasi.FD
+	Errno wasi.Errno
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s fd %d: %s", e.Op, e.FD, e.Errno)
+}
+
+// Unwrap returns the wasi.Errno, allowing callers to use errors.Is to test
+// for specific error numbers.
+func (e *Error) Unwrap() error { return e.Errno }
+
+var (
+	_ io.Reader   = (*File)(nil)
+	_ io.Writer   = (*File)(nil)
+	_ io.ReaderAt = (*File)(nil)
+	_ io.WriterAt = (*File)(nil)
+	_ io.Seeker   = (*File)(nil)
+)
@@ -0,0 +1,121 @@
+//go:build unix
+
+package wasiio_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/systems/unix"
+	"github.com/stealthrocket/wasi-go/wasiio"
+	sysunix "golang.org/x/sys/unix"
+)
+
+func openFile(t *testing.T, s *unix.System, rights wasi.Rights) wasi.FD {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file")
+	f, err := sysunix.Open(path, sysunix.O_RDWR|sysunix.O_CREAT|sysunix.O_CLOEXEC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Preopen(unix.FD(f), "file", wasi.FDStat{
+		FileType:   wasi.RegularFileType,
+		RightsBase: rights,
+	})
+}
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	s := &unix.System{}
+	defer s.Close(ctx)
+
+	f := wasiio.NewFile(ctx, s, openFile(t, s, wasi.FileRights))
+
+	if _, err := io.WriteString(f, "hello, world!"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("WORLD"), 7); err != nil {
+		t.Fatal(err)
+	}
+
+	// WriteAt does not change the offset of the file.
+	if offset, err := f.Seek(0, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	} else if offset != 13 {
+		t.Errorf("wrong offset after WriteAt: want 13, got %d", offset)
+	}
+
+	b := make([]byte, 5)
+	if n, err := f.ReadAt(b, 7); err != nil {
+		t.Fatal(err)
+	} else if string(b[:n]) != "WORLD" {
+		t.Errorf("wrong data read at offset 7: %q", b[:n])
+	}
+
+	// A read past the end of the file returns the available bytes and EOF.
+	b = make([]byte, 10)
+	n, err := f.ReadAt(b, 7)
+	if err != io.EOF {
+		t.Errorf("ReadAt past the end of the file: want io.EOF, got %v", err)
+	}
+	if string(b[:n]) != "WORLD!" {
+		t.Errorf("wrong data read at offset 7: %q", b[:n])
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello, WORLD!" {
+		t.Errorf("wrong file content: %q", data)
+	}
+
+	// io.SectionReader only requires an io.ReaderAt.
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.NewSectionReader(f, 0, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hello" {
+		t.Errorf("wrong section content: %q", buf.String())
+	}
+}
+
+func TestFileErrors(t *testing.T) {
+	ctx := context.Background()
+	s := &unix.System{}
+	defer s.Close(ctx)
+
+	f := wasiio.NewFile(ctx, s, openFile(t, s, wasi.FDReadRight|wasi.FDSeekRight))
+
+	_, err := f.Write([]byte("hello"))
+	if !errors.Is(err, wasi.ENOTCAPABLE) {
+		t.Errorf("write without rights: want ENOTCAPABLE, got %v", err)
+	}
+	var e *wasiio.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("wrong error type: %T", err)
+	}
+	if e.Op != "write" || e.FD != f.FD() {
+		t.Errorf("wrong error: %v", e)
+	}
+
+	if _, err := f.ReadAt(make([]byte, 1), -1); !errors.Is(err, wasi.EINVAL) {
+		t.Errorf("read at negative offset: want EINVAL, got %v", err)
+	}
+
+	closed := wasiio.NewFile(ctx, s, 42)
+	if _, err := closed.Read(make([]byte, 1)); !errors.Is(err, wasi.EBADF) {
+		t.Errorf("read from invalid fd: want EBADF, got %v", err)
+	}
+	if _, err := closed.Seek(0, io.SeekStart); !errors.Is(err, wasi.EBADF) {
+		t.Errorf("seek on invalid fd: want EBADF, got %v", err)
+	}
+}
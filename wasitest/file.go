@@ -2,9 +2,13 @@ package wasitest
 
 import (
 	"context"
+	"crypto/sha256"
+	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stealthrocket/wasi-go"
 )
@@ -12,6 +16,255 @@ import (
 var file = testSuite{
 	"exceeding the limit of open files":       testMaxOpenFiles,
 	"exceeding the limit of open directories": testMaxOpenDirs,
+
+	"opening a file that the host denies access to returns EACCES":            testPathOpenPermissionDenied,
+	"opening a file with rights that the directory lacks returns ENOTCAPABLE": testPathOpenNotCapable,
+
+	"directories opened with or without the directory flag have directory rights": testPathOpenDirectoryRights,
+
+	"following symbolic links cannot escape the preopened directory": testPathOpenSymlinkEscape,
+	"path operations cannot escape the preopened directory":          testPathSymlinkEscape,
+
+	"writes go to the end of the file after enabling the append flag": testFDStatSetFlagsAppend,
+
+	"setting unknown fdflags returns EINVAL": testFDStatSetFlagsUnknown,
+
+	"the offset of files opened in append mode moves to the end after writes": testFDTellAppend,
+
+	"files opened with the rsync flag report it in their fdstat": testPathOpenRSync,
+
+	"the dsync flag can be toggled on regular files when supported": testFDStatSetFlagsDSync,
+
+	"setting one timestamp to the current time leaves the other unchanged": testFileStatSetTimesNow,
+
+	"reading or writing at offsets larger than the maximum int64 returns EINVAL": testFDPreadPwriteOffsetOverflow,
+
+	"reading or writing empty buffers returns zero": testFDReadWriteEmpty,
+
+	"reading or writing a directory returns EISDIR": testFDReadWriteDirectory,
+
+	"copying ranges of files preserves their content":        testFDCopyRange,
+	"copying ranges of files requires read and write rights": testFDCopyRangeRights,
+}
+
+func testPathOpenPermissionDenied(t *testing.T, ctx context.Context, newSystem newSystem) {
+	if os.Geteuid() == 0 {
+		t.Skip("file permissions are not enforced for the root user")
+	}
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "secret"), []byte("hello"), 0); err != nil {
+		t.Fatal(err)
+	}
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	// The rights are granted by the preopen, the host rejects the open.
+	_, errno := sys.PathOpen(ctx, 3, 0, "secret", 0, wasi.FDReadRight, 0, 0)
+	assertEqual(t, errno, wasi.EACCES)
+}
+
+func testPathOpenNotCapable(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "secret"), []byte("hello"), 0); err != nil {
+		t.Fatal(err)
+	}
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	dir, errno := sys.PathOpen(ctx, 3, 0, ".", wasi.OpenDirectory, wasi.DirectoryRights, wasi.FileRights&^wasi.ReadRights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	f, errno := sys.PathOpen(ctx, dir, 0, "file", 0, wasi.FDWriteRight, 0, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, sys.FDClose(ctx, f), wasi.ESUCCESS)
+
+	_, errno = sys.PathOpen(ctx, dir, 0, "file", 0, wasi.FDReadRight, 0, 0)
+	assertEqual(t, errno, wasi.ENOTCAPABLE)
+
+	// The capability check happens before the file is opened on the host,
+	// the host permissions are not observable.
+	_, errno = sys.PathOpen(ctx, dir, 0, "secret", 0, wasi.FDReadRight, 0, 0)
+	assertEqual(t, errno, wasi.ENOTCAPABLE)
+
+	assertEqual(t, sys.FDClose(ctx, dir), wasi.ESUCCESS)
+}
+
+func testPathOpenSymlinkEscape(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+	outside := filepath.Join(tmp, "outside")
+	assertOK(t, os.Mkdir(root, 0755))
+	assertOK(t, os.Mkdir(filepath.Join(root, "dir"), 0755))
+	assertOK(t, os.WriteFile(filepath.Join(root, "file"), []byte("inside"), 0644))
+	assertOK(t, os.WriteFile(outside, []byte("outside"), 0644))
+
+	assertOK(t, os.Symlink(outside, filepath.Join(root, "absolute")))
+	assertOK(t, os.Symlink("../outside", filepath.Join(root, "relative")))
+	assertOK(t, os.Symlink("../../outside", filepath.Join(root, "dir", "nested")))
+	assertOK(t, os.Symlink("..", filepath.Join(root, "parent")))
+	assertOK(t, os.Symlink("dir/../file", filepath.Join(root, "inside")))
+	assertOK(t, os.Symlink("../dir", filepath.Join(root, "dir", "self")))
+
+	sys := newSystem(TestConfig{
+		RootFS: root,
+	})
+
+	for _, path := range []string{
+		"absolute",
+		"relative",
+		"dir/nested",
+		"parent/outside",
+		"dir/self/nested",
+	} {
+		_, errno := sys.PathOpen(ctx, 3, wasi.SymlinkFollow, path, 0, wasi.FDReadRight, 0, 0)
+		if errno != wasi.EPERM && errno != wasi.ENOTCAPABLE {
+			t.Errorf("%s: wrong errno: want EPERM or ENOTCAPABLE, got %s", path, errno)
+		}
+	}
+
+	// Symbolic links which resolve to a location within the directory can
+	// still be followed.
+	for _, path := range []string{
+		"inside",
+		"dir/self/../file",
+	} {
+		fd, errno := sys.PathOpen(ctx, 3, wasi.SymlinkFollow, path, 0, wasi.FDReadRight, 0, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		buf := make([]byte, 16)
+		n, errno := sys.FDRead(ctx, fd, []wasi.IOVec{buf})
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, string(buf[:n]), "inside")
+		assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+	}
+}
+
+func testPathSymlinkEscape(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+	outside := filepath.Join(tmp, "outside")
+	assertOK(t, os.Mkdir(root, 0755))
+	assertOK(t, os.Mkdir(outside, 0755))
+	assertOK(t, os.Mkdir(filepath.Join(outside, "dir"), 0755))
+	assertOK(t, os.WriteFile(filepath.Join(outside, "file"), []byte("outside"), 0644))
+	assertOK(t, os.Symlink("file", filepath.Join(outside, "link")))
+	assertOK(t, os.WriteFile(filepath.Join(root, "file"), []byte("inside"), 0644))
+
+	assertOK(t, os.Symlink("../outside", filepath.Join(root, "relative")))
+	assertOK(t, os.Symlink(outside, filepath.Join(root, "absolute")))
+	assertOK(t, os.Symlink("../outside/file", filepath.Join(root, "file-link")))
+
+	outsideStat, err := os.Stat(filepath.Join(outside, "file"))
+	assertOK(t, err)
+
+	sys := newSystem(TestConfig{
+		RootFS: root,
+	})
+
+	for _, dir := range []string{"relative", "absolute"} {
+		assertEscape := func(op string, errno wasi.Errno) {
+			t.Helper()
+			if errno != wasi.EPERM && errno != wasi.ENOTCAPABLE {
+				t.Errorf("%s through %s: wrong errno: want EPERM or ENOTCAPABLE, got %s", op, dir, errno)
+			}
+		}
+
+		_, errno := sys.PathFileStatGet(ctx, 3, 0, dir+"/file")
+		assertEscape("PathFileStatGet", errno)
+
+		errno = sys.PathFileStatSetTimes(ctx, 3, 0, dir+"/file", 0, 0, wasi.AccessTimeNow|wasi.ModifyTimeNow)
+		assertEscape("PathFileStatSetTimes", errno)
+
+		errno = sys.PathCreateDirectory(ctx, 3, dir+"/new-dir")
+		assertEscape("PathCreateDirectory", errno)
+
+		errno = sys.PathRemoveDirectory(ctx, 3, dir+"/dir")
+		assertEscape("PathRemoveDirectory", errno)
+
+		errno = sys.PathUnlinkFile(ctx, 3, dir+"/file")
+		assertEscape("PathUnlinkFile", errno)
+
+		errno = sys.PathSymlink(ctx, "file", 3, dir+"/new-link")
+		assertEscape("PathSymlink", errno)
+
+		_, errno = sys.PathReadLink(ctx, 3, dir+"/link", make([]byte, 64))
+		assertEscape("PathReadLink", errno)
+
+		errno = sys.PathLink(ctx, 3, 0, dir+"/file", 3, "hard-link")
+		assertEscape("PathLink (source)", errno)
+
+		errno = sys.PathLink(ctx, 3, 0, "file", 3, dir+"/hard-link")
+		assertEscape("PathLink (target)", errno)
+
+		errno = sys.PathRename(ctx, 3, dir+"/file", 3, "stolen")
+		assertEscape("PathRename (source)", errno)
+
+		errno = sys.PathRename(ctx, 3, "file", 3, dir+"/file")
+		assertEscape("PathRename (target)", errno)
+	}
+
+	// Following a symbolic link on the last component cannot escape either,
+	// while operating on the link itself is permitted.
+	_, errno := sys.PathFileStatGet(ctx, 3, wasi.SymlinkFollow, "file-link")
+	assertEqual(t, errno, wasi.EPERM)
+	stat, errno := sys.PathFileStatGet(ctx, 3, 0, "file-link")
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, stat.FileType, wasi.SymbolicLinkType)
+
+	errno = sys.PathFileStatSetTimes(ctx, 3, wasi.SymlinkFollow, "file-link", 0, 0, wasi.AccessTimeNow|wasi.ModifyTimeNow)
+	assertEqual(t, errno, wasi.EPERM)
+
+	errno = sys.PathLink(ctx, 3, wasi.SymlinkFollow, "file-link", 3, "hard-link")
+	assertEqual(t, errno, wasi.EPERM)
+
+	_, errno = sys.PathFileStatGet(ctx, 3, 0, "relative/")
+	assertEqual(t, errno, wasi.EPERM)
+
+	// The files outside of the preopened directory were left untouched.
+	entries, err := os.ReadDir(outside)
+	assertOK(t, err)
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	assertDeepEqual(t, names, []string{"dir", "file", "link"})
+
+	b, err := os.ReadFile(filepath.Join(outside, "file"))
+	assertOK(t, err)
+	assertEqual(t, string(b), "outside")
+
+	outsideStatAfter, err := os.Stat(filepath.Join(outside, "file"))
+	assertOK(t, err)
+	assertEqual(t, outsideStatAfter.ModTime(), outsideStat.ModTime())
+}
+
+func testPathOpenDirectoryRights(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	assertOK(t, os.Mkdir(filepath.Join(tmp, "dir"), 0755))
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	const rights = wasi.FDReadRight | wasi.FDSeekRight | wasi.FDTellRight | wasi.FDReadDirRight | wasi.FDFileStatGetRight
+
+	for _, openFlags := range []wasi.OpenFlags{wasi.OpenDirectory, 0} {
+		fd, errno := sys.PathOpen(ctx, 3, 0, "dir", openFlags, rights, rights, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		stat, errno := sys.FDStatGet(ctx, fd)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, stat.FileType, wasi.DirectoryType)
+		assertEqual(t, stat.RightsBase.Has(wasi.FDSeekRight), false)
+		assertEqual(t, stat.RightsBase.Has(wasi.FDReadDirRight), true)
+
+		_, errno = sys.FDSeek(ctx, fd, 0, wasi.SeekStart)
+		assertEqual(t, errno, wasi.ENOTCAPABLE)
+		assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+	}
 }
 
 func testMaxOpenFiles(t *testing.T, ctx context.Context, newSystem newSystem) {
@@ -71,3 +324,443 @@ func testMaxOpenDirs(t *testing.T, ctx context.Context, newSystem newSystem) {
 		assertEqual(t, sys.FDClose(ctx, d), wasi.ESUCCESS)
 	}
 }
+
+func testFDStatSetFlagsAppend(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	const rights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDSeekRight | wasi.FDTellRight | wasi.FDStatSetFlagsRight
+
+	fd, errno := sys.PathOpen(ctx, 3, 0, "file", wasi.OpenCreate, rights, rights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	n, errno := sys.FDWrite(ctx, fd, []wasi.IOVec{[]byte("hello")})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, n, 5)
+
+	offset, errno := sys.FDSeek(ctx, fd, 0, wasi.SeekStart)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, offset, 0)
+
+	assertEqual(t, sys.FDStatSetFlags(ctx, fd, wasi.Append), wasi.ESUCCESS)
+
+	stat, errno := sys.FDStatGet(ctx, fd)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, stat.Flags, wasi.Append)
+
+	n, errno = sys.FDWrite(ctx, fd, []wasi.IOVec{[]byte(", world!")})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, n, 8)
+
+	offset, errno = sys.FDTell(ctx, fd)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, offset, 13)
+
+	// Disabling the flag must restore writes at the current offset.
+	assertEqual(t, sys.FDStatSetFlags(ctx, fd, 0), wasi.ESUCCESS)
+
+	offset, errno = sys.FDSeek(ctx, fd, 0, wasi.SeekStart)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, offset, 0)
+
+	n, errno = sys.FDWrite(ctx, fd, []wasi.IOVec{[]byte("H")})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, n, 1)
+	assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+
+	b, err := os.ReadFile(filepath.Join(tmp, "file"))
+	assertOK(t, err)
+	assertEqual(t, string(b), "Hello, world!")
+}
+
+func testFDStatSetFlagsUnknown(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	const rights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDStatSetFlagsRight
+
+	fd, errno := sys.PathOpen(ctx, 3, 0, "file", wasi.OpenCreate, rights, rights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	for _, flags := range []wasi.FDFlags{1 << 5, 1 << 15, wasi.Append | 1<<15} {
+		assertEqual(t, sys.FDStatSetFlags(ctx, fd, flags), wasi.EINVAL)
+	}
+
+	// The flags were left unchanged by the invalid calls.
+	stat, errno := sys.FDStatGet(ctx, fd)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, stat.Flags, 0)
+
+	for _, flags := range []wasi.FDFlags{wasi.Append, wasi.NonBlock, wasi.Append | wasi.NonBlock, 0} {
+		assertEqual(t, sys.FDStatSetFlags(ctx, fd, flags), wasi.ESUCCESS)
+
+		stat, errno := sys.FDStatGet(ctx, fd)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, stat.Flags, flags)
+	}
+
+	assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+}
+
+func testFDTellAppend(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	const rights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDSeekRight | wasi.FDTellRight
+
+	fd, errno := sys.PathOpen(ctx, 3, 0, "file", 0, rights, rights, wasi.Append)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	// The offset is only moved to the end of the file by writes.
+	offset, errno := sys.FDTell(ctx, fd)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, offset, 0)
+
+	n, errno := sys.FDWrite(ctx, fd, []wasi.IOVec{[]byte(", world")})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, n, 7)
+
+	offset, errno = sys.FDTell(ctx, fd)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, offset, 12)
+
+	// Seeking changes the offset that reads start from, but writes still go
+	// to the end of the file.
+	offset, errno = sys.FDSeek(ctx, fd, 0, wasi.SeekStart)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, offset, 0)
+
+	n, errno = sys.FDWrite(ctx, fd, []wasi.IOVec{[]byte("!")})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, n, 1)
+
+	offset, errno = sys.FDTell(ctx, fd)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, offset, 13)
+
+	current, errno := sys.FDSeek(ctx, fd, 0, wasi.SeekCurrent)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, current, offset)
+
+	buf := make([]byte, 16)
+	n, errno = sys.FDPread(ctx, fd, []wasi.IOVec{buf}, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, string(buf[:n]), "hello, world!")
+
+	assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+}
+
+func testPathOpenRSync(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	const rights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDStatSetFlagsRight
+
+	fd, errno := sys.PathOpen(ctx, 3, 0, "file", wasi.OpenCreate, rights, rights, wasi.RSync)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	stat, errno := sys.FDStatGet(ctx, fd)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, stat.Flags, wasi.RSync)
+
+	// Synchronized reads cannot be disabled after opening the file.
+	assertEqual(t, sys.FDStatSetFlags(ctx, fd, 0), wasi.ENOTSUP)
+
+	stat, errno = sys.FDStatGet(ctx, fd)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, stat.Flags, wasi.RSync)
+
+	assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+}
+
+func testFDStatSetFlagsDSync(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	const rights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDStatSetFlagsRight
+
+	fd, errno := sys.PathOpen(ctx, 3, 0, "file", wasi.OpenCreate, rights, rights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	// Not all platforms allow changing O_DSYNC after opening a file, the
+	// flag reported by fd_fdstat_get must only change if the call succeeds.
+	switch errno := sys.FDStatSetFlags(ctx, fd, wasi.DSync); errno {
+	case wasi.ESUCCESS:
+		stat, errno := sys.FDStatGet(ctx, fd)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, stat.Flags, wasi.DSync)
+
+		assertEqual(t, sys.FDStatSetFlags(ctx, fd, 0), wasi.ESUCCESS)
+	case wasi.ENOTSUP:
+	default:
+		t.Fatalf("unexpected error changing the dsync flag: %s", errno)
+	}
+
+	stat, errno := sys.FDStatGet(ctx, fd)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, stat.Flags, 0)
+
+	n, errno := sys.FDWrite(ctx, fd, []wasi.IOVec{[]byte("hello")})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, n, 5)
+
+	assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+}
+
+func testFDCopyRange(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	const rights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDSeekRight
+
+	src, errno := sys.PathOpen(ctx, 3, 0, "src", wasi.OpenCreate, rights, rights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	dst, errno := sys.PathOpen(ctx, 3, 0, "dst", wasi.OpenCreate, rights, rights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	// Use a size which is not a multiple of the buffer sizes used by the
+	// implementations to exercise the handling of the last chunk.
+	data := make([]byte, 3<<20+123)
+	prng := rand.New(rand.NewSource(0))
+	prng.Read(data)
+
+	n, errno := sys.FDWrite(ctx, src, []wasi.IOVec{data})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, n, wasi.Size(len(data)))
+
+	size := wasi.FileSize(len(data))
+	copied, errno := sys.FDCopyRange(ctx, src, dst, 0, 0, size)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, copied, size)
+	assertEqual(t, readChecksum(t, ctx, sys, dst, size), sha256.Sum256(data))
+
+	// Copies past the end of the source file are short.
+	copied, errno = sys.FDCopyRange(ctx, src, dst, size-100, size, 1000)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, copied, 100)
+	assertEqual(t, readChecksum(t, ctx, sys, dst, size+100), sha256.Sum256(append(data, data[len(data)-100:]...)))
+
+	copied, errno = sys.FDCopyRange(ctx, src, dst, size, 0, 1000)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, copied, 0)
+
+	// The copy does not change the file offsets.
+	offset, errno := sys.FDSeek(ctx, dst, 0, wasi.SeekCurrent)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, offset, 0)
+
+	assertEqual(t, sys.FDClose(ctx, src), wasi.ESUCCESS)
+	assertEqual(t, sys.FDClose(ctx, dst), wasi.ESUCCESS)
+}
+
+func testFDCopyRangeRights(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	const rights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDSeekRight
+
+	rw, errno := sys.PathOpen(ctx, 3, 0, "rw", wasi.OpenCreate, rights, rights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	ro, errno := sys.PathOpen(ctx, 3, 0, "rw", 0, wasi.FDReadRight|wasi.FDSeekRight, 0, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	wo, errno := sys.PathOpen(ctx, 3, 0, "rw", 0, wasi.FDWriteRight|wasi.FDSeekRight, 0, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	_, errno = sys.FDWrite(ctx, rw, []wasi.IOVec{[]byte("hello")})
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	_, errno = sys.FDCopyRange(ctx, wo, rw, 0, 5, 5)
+	assertEqual(t, errno, wasi.ENOTCAPABLE)
+
+	_, errno = sys.FDCopyRange(ctx, rw, ro, 0, 5, 5)
+	assertEqual(t, errno, wasi.ENOTCAPABLE)
+
+	copied, errno := sys.FDCopyRange(ctx, ro, wo, 0, 5, 5)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, copied, 5)
+
+	buf := make([]byte, 16)
+	n, errno := sys.FDPread(ctx, rw, []wasi.IOVec{buf}, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, string(buf[:n]), "hellohello")
+
+	assertEqual(t, sys.FDClose(ctx, rw), wasi.ESUCCESS)
+	assertEqual(t, sys.FDClose(ctx, ro), wasi.ESUCCESS)
+	assertEqual(t, sys.FDClose(ctx, wo), wasi.ESUCCESS)
+}
+
+func testFileStatSetTimesNow(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	const rights = wasi.FDFileStatGetRight | wasi.FDFileStatSetTimesRight
+	fd, errno := sys.PathOpen(ctx, 3, 0, "file", wasi.OpenCreate, rights, rights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	past := wasi.Timestamp(time.Date(2001, 9, 9, 1, 46, 40, 0, time.UTC).UnixNano())
+
+	for _, setTimes := range []func(accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) wasi.Errno{
+		func(accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) wasi.Errno {
+			return sys.FDFileStatSetTimes(ctx, fd, accessTime, modifyTime, flags)
+		},
+		func(accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) wasi.Errno {
+			return sys.PathFileStatSetTimes(ctx, 3, 0, "file", accessTime, modifyTime, flags)
+		},
+	} {
+		for _, test := range []struct {
+			flags         wasi.FSTFlags
+			accessChanged bool
+			modifyChanged bool
+		}{
+			{flags: wasi.AccessTimeNow, accessChanged: true},
+			{flags: wasi.ModifyTimeNow, modifyChanged: true},
+			{flags: wasi.AccessTimeNow | wasi.ModifyTime, accessChanged: true},
+			{flags: wasi.AccessTime | wasi.ModifyTimeNow, modifyChanged: true},
+		} {
+			assertEqual(t, setTimes(past, past, wasi.AccessTime|wasi.ModifyTime), wasi.ESUCCESS)
+			assertEqual(t, setTimes(past, past, test.flags), wasi.ESUCCESS)
+
+			stat, errno := sys.FDFileStatGet(ctx, fd)
+			assertEqual(t, errno, wasi.ESUCCESS)
+			assertEqual(t, stat.AccessTime != past, test.accessChanged)
+			assertEqual(t, stat.ModifyTime != past, test.modifyChanged)
+		}
+
+		// A timestamp cannot be set to both a value and the current time.
+		assertEqual(t, setTimes(past, past, wasi.AccessTime|wasi.AccessTimeNow), wasi.EINVAL)
+		assertEqual(t, setTimes(past, past, wasi.ModifyTime|wasi.ModifyTimeNow), wasi.EINVAL)
+	}
+}
+
+func testFDPreadPwriteOffsetOverflow(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	const rights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDSeekRight | wasi.FDFileStatGetRight
+
+	fd, errno := sys.PathOpen(ctx, 3, 0, "file", wasi.OpenCreate, rights, rights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	buf := []byte("hello")
+	for _, offset := range []wasi.FileSize{1 << 63, math.MaxUint64} {
+		n, errno := sys.FDPread(ctx, fd, []wasi.IOVec{buf}, offset)
+		assertEqual(t, errno, wasi.EINVAL)
+		assertEqual(t, n, 0)
+
+		n, errno = sys.FDPwrite(ctx, fd, []wasi.IOVec{buf}, offset)
+		assertEqual(t, errno, wasi.EINVAL)
+		assertEqual(t, n, 0)
+	}
+
+	// The file was not modified by the failed writes.
+	stat, errno := sys.FDFileStatGet(ctx, fd)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, stat.Size, 0)
+
+	assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+}
+
+func testFDReadWriteEmpty(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	const rights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDFileStatGetRight
+
+	fd, errno := sys.PathOpen(ctx, 3, 0, "file", 0, rights, rights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	for _, iovecs := range [][]wasi.IOVec{nil, {}, {nil}, {{}, {}, {}}} {
+		n, errno := sys.FDRead(ctx, fd, iovecs)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, n, 0)
+
+		n, errno = sys.FDWrite(ctx, fd, iovecs)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, n, 0)
+	}
+
+	// The empty reads and writes did not move the file offset.
+	buf := make([]byte, 8)
+	n, errno := sys.FDRead(ctx, fd, []wasi.IOVec{buf})
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, string(buf[:n]), "hello")
+
+	stat, errno := sys.FDFileStatGet(ctx, fd)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, stat.Size, 5)
+
+	assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+}
+
+func testFDReadWriteDirectory(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	assertOK(t, os.Mkdir(filepath.Join(tmp, "dir"), 0755))
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	fd, errno := sys.PathOpen(ctx, 3, 0, "dir", wasi.OpenDirectory, wasi.DirectoryRights, wasi.DirectoryRights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	buf := make([]byte, 8)
+	iovecs := []wasi.IOVec{buf}
+
+	_, errno = sys.FDRead(ctx, fd, iovecs)
+	assertEqual(t, errno, wasi.EISDIR)
+	_, errno = sys.FDPread(ctx, fd, iovecs, 0)
+	assertEqual(t, errno, wasi.EISDIR)
+	_, errno = sys.FDWrite(ctx, fd, iovecs)
+	assertEqual(t, errno, wasi.EISDIR)
+	_, errno = sys.FDPwrite(ctx, fd, iovecs, 0)
+	assertEqual(t, errno, wasi.EISDIR)
+
+	// The preopened directory is rejected the same way.
+	_, errno = sys.FDRead(ctx, 3, iovecs)
+	assertEqual(t, errno, wasi.EISDIR)
+
+	// Directories can be neither the source nor the destination of copies.
+	file, errno := sys.PathOpen(ctx, 3, 0, "file", wasi.OpenCreate, wasi.FileRights, 0, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	_, errno = sys.FDCopyRange(ctx, fd, file, 0, 0, 8)
+	assertEqual(t, errno, wasi.EISDIR)
+	_, errno = sys.FDCopyRange(ctx, file, fd, 0, 0, 8)
+	assertEqual(t, errno, wasi.EISDIR)
+
+	assertEqual(t, sys.FDClose(ctx, file), wasi.ESUCCESS)
+	assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+}
+
+func readChecksum(t *testing.T, ctx context.Context, sys wasi.System, fd wasi.FD, size wasi.FileSize) [sha256.Size]byte {
+	t.Helper()
+	buf := make([]byte, size+1)
+	n, errno := sys.FDPread(ctx, fd, []wasi.IOVec{buf}, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, wasi.FileSize(n), size)
+	return sha256.Sum256(buf[:n])
+}
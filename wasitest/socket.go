@@ -258,6 +258,62 @@ var socket = testSuite{
 		wasi.Inet6Family, wasi.StreamSocket, &wasi.Inet6Address{Addr: localIPv6},
 	),
 
+	"blocking connects on ipv4 stream sockets complete synchronously": testSocketConnectBlockingCompletes(
+		wasi.InetFamily, wasi.StreamSocket, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"blocking connects on ipv6 stream sockets complete synchronously": testSocketConnectBlockingCompletes(
+		wasi.Inet6Family, wasi.StreamSocket, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
+	"writing to an ipv4 stream socket with a full send buffer returns EAGAIN": testSocketSendBufferFull(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"writing to an ipv6 stream socket with a full send buffer returns EAGAIN": testSocketSendBufferFull(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
+	"sending on an ipv4 stream socket reset by the peer returns EPIPE": testSocketSendAfterPeerReset(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4}, sockSend,
+	),
+
+	"sending on an ipv6 stream socket reset by the peer returns EPIPE": testSocketSendAfterPeerReset(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6}, sockSend,
+	),
+
+	"writing to an ipv4 stream socket reset by the peer returns EPIPE": testSocketSendAfterPeerReset(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4}, fdWrite,
+	),
+
+	"writing to an ipv6 stream socket reset by the peer returns EPIPE": testSocketSendAfterPeerReset(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6}, fdWrite,
+	),
+
+	"sending on an ipv4 datagram socket connected to a closed port returns ECONNREFUSED": testSocketSendConnectionRefused(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"sending on an ipv6 datagram socket connected to a closed port returns ECONNREFUSED": testSocketSendConnectionRefused(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
+	"receiving with RecvWaitAll on an ipv4 stream socket waits for the full buffer": testSocketRecvWaitAllStream(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"receiving with RecvWaitAll on an ipv6 stream socket waits for the full buffer": testSocketRecvWaitAllStream(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
+	"receiving with RecvWaitAll on an ipv4 datagram socket returns a single datagram": testSocketRecvWaitAllDatagram(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"receiving with RecvWaitAll on an ipv6 datagram socket returns a single datagram": testSocketRecvWaitAllDatagram(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
 	"can connect a ipv4 datagram socket": testSocketConnectOK(
 		wasi.InetFamily, wasi.DatagramSocket, &wasi.Inet4Address{Addr: localIPv4, Port: nextPort()},
 	),
@@ -434,6 +490,14 @@ var socket = testSuite{
 		wasi.Inet6Family, wasi.StreamSocket, &wasi.Inet6Address{Addr: localIPv6},
 	),
 
+	"shutting down an ipv4 listening socket returns ENOTCONN on all platforms": testSocketShutdownListeningPortability(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"shutting down an ipv6 listening socket returns ENOTCONN on all platforms": testSocketShutdownListeningPortability(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
 	"can shutdown ipv4 stream socket after accepting": testSocketConnectAndShutdown(
 		wasi.InetFamily, wasi.StreamSocket, &wasi.Inet4Address{Addr: localIPv4},
 	),
@@ -454,6 +518,22 @@ var socket = testSuite{
 		wasi.Inet6Family, wasi.DatagramSocket,
 	),
 
+	"setting the type of service of ipv4 datagram sockets": testSocketSetTrafficClass(
+		wasi.InetFamily, wasi.IPTypeOfService,
+	),
+
+	"setting the traffic class of ipv6 datagram sockets": testSocketSetTrafficClass(
+		wasi.Inet6Family, wasi.IPv6TrafficClass,
+	),
+
+	"corked ipv4 stream sockets send pending data when uncorked": testSocketCork(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"corked ipv6 stream sockets send pending data when uncorked": testSocketCork(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
 	"cannot set option of ipv4 stream socket with invalid level": testSocketSetOptionInvalidLevel(
 		wasi.InetFamily, wasi.StreamSocket,
 	),
@@ -494,6 +574,14 @@ var socket = testSuite{
 		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
 	),
 
+	"zero-length sends and receives on ipv4 stream sockets return immediately": testSocketSendAndReceiveEmptyStream(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"zero-length sends and receives on ipv6 stream sockets return immediately": testSocketSendAndReceiveEmptyStream(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
 	"connected ipv4 stream sockets can send and peek data": testSocketSendAndPeekStream(
 		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
 	),
@@ -534,6 +622,22 @@ var socket = testSuite{
 		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
 	),
 
+	"connected ipv4 datagram sockets cannot send data to another address": testSocketSendToConnected(
+		wasi.InetFamily, wasi.DatagramSocket, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"connected ipv6 datagram sockets cannot send data to another address": testSocketSendToConnected(
+		wasi.Inet6Family, wasi.DatagramSocket, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
+	"connected ipv4 stream sockets cannot send data to a specific address": testSocketSendToConnected(
+		wasi.InetFamily, wasi.StreamSocket, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"connected ipv6 stream sockets cannot send data to a specific address": testSocketSendToConnected(
+		wasi.Inet6Family, wasi.StreamSocket, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
 	"connected ipv4 datagram sockets cannot send data to a specific address": testSocketSendToConnectedDatagram(
 		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
 	),
@@ -602,6 +706,14 @@ var socket = testSuite{
 		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6, Port: nextPort()},
 	),
 
+	"connected ipv4 datagram sockets report and clear errors of messages sent to closed ports": testSocketSendDatagramRefused(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4, Port: nextPort()},
+	),
+
+	"connected ipv6 datagram sockets report and clear errors of messages sent to closed ports": testSocketSendDatagramRefused(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6, Port: nextPort()},
+	),
+
 	"cannot bind an ipv4 datagram socket after sending a message": testSocketBindAfterSendDatagram(
 		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4, Port: nextPort()},
 	),
@@ -1014,6 +1126,241 @@ func testSocketConnectAndAccept(family wasi.ProtocolFamily, typ wasi.SocketType,
 	}
 }
 
+func testSocketSendBufferFull(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+
+		server, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		serverAddr, errno := sys.SockBind(ctx, server, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.SockListen(ctx, server, 10), wasi.ESUCCESS)
+
+		client, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		_, errno = sys.SockConnect(ctx, client, serverAddr)
+		assertEqual(t, errno, wasi.EINPROGRESS)
+		sockPoll(t, ctx, sys, client, wasi.FDWriteEvent)
+		sockPoll(t, ctx, sys, server, wasi.FDReadEvent)
+
+		accept, _, _, errno := sys.SockAccept(ctx, server, wasi.NonBlock)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		// The peer never reads, the client fills the send buffer of its
+		// socket and the receive buffer of the peer until writes fail.
+		buffer := make([]byte, 64*1024)
+		for i := 0; ; i++ {
+			if i == 1024 {
+				t.Fatal("the send buffer never filled up")
+			}
+			n, errno := sys.SockSend(ctx, client, []wasi.IOVec{buffer}, 0)
+			if errno == wasi.ESUCCESS {
+				assertEqual(t, n > 0, true)
+				continue
+			}
+			assertEqual(t, n, ^wasi.Size(0))
+			assertEqual(t, errno, wasi.EAGAIN)
+			break
+		}
+
+		n, errno := sys.FDWrite(ctx, client, []wasi.IOVec{buffer})
+		assertEqual(t, n, ^wasi.Size(0))
+		assertEqual(t, errno, wasi.EAGAIN)
+
+		assertEqual(t, sys.FDClose(ctx, accept), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, client), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, server), wasi.ESUCCESS)
+	}
+}
+
+type sendFunc func(context.Context, wasi.System, wasi.FD, []wasi.IOVec) (wasi.Size, wasi.Errno)
+
+func sockSend(ctx context.Context, sys wasi.System, fd wasi.FD, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	return sys.SockSend(ctx, fd, iovecs, 0)
+}
+
+func fdWrite(ctx context.Context, sys wasi.System, fd wasi.FD, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	return sys.FDWrite(ctx, fd, iovecs)
+}
+
+func testSocketSendAfterPeerReset(family wasi.ProtocolFamily, bind wasi.SocketAddress, send sendFunc) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+
+		server, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		serverAddr, errno := sys.SockBind(ctx, server, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.SockListen(ctx, server, 10), wasi.ESUCCESS)
+
+		client, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		_, errno = sys.SockConnect(ctx, client, serverAddr)
+		assertEqual(t, errno, wasi.EINPROGRESS)
+		sockPoll(t, ctx, sys, client, wasi.FDWriteEvent)
+		sockPoll(t, ctx, sys, server, wasi.FDReadEvent)
+
+		accept, _, _, errno := sys.SockAccept(ctx, server, wasi.NonBlock)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		// Closing a socket with unread data causes the connection to be
+		// reset instead of gracefully shut down.
+		message := []byte("hello")
+		n, errno := send(ctx, sys, client, []wasi.IOVec{message})
+		assertEqual(t, n, wasi.Size(len(message)))
+		assertEqual(t, errno, wasi.ESUCCESS)
+		sockPoll(t, ctx, sys, accept, wasi.FDReadEvent)
+		assertEqual(t, sys.FDClose(ctx, accept), wasi.ESUCCESS)
+
+		// The socket becomes readable when the reset is received.
+		sockPoll(t, ctx, sys, client, wasi.FDReadEvent)
+
+		for i := 0; i < 2; i++ {
+			n, errno = send(ctx, sys, client, []wasi.IOVec{message})
+			assertEqual(t, n, ^wasi.Size(0))
+			assertEqual(t, errno, wasi.EPIPE)
+		}
+
+		assertEqual(t, sys.FDClose(ctx, client), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, server), wasi.ESUCCESS)
+	}
+}
+
+func testSocketSendConnectionRefused(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+
+		// Reserve an address and close the socket so nothing listens on it.
+		peer, errno := sockOpen(t, ctx, sys, family, wasi.DatagramSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		peerAddr, errno := sys.SockBind(ctx, peer, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, peer), wasi.ESUCCESS)
+
+		sock, errno := sockOpen(t, ctx, sys, family, wasi.DatagramSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		_, errno = sys.SockConnect(ctx, sock, peerAddr)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		message := []byte("hello")
+		n, errno := sys.SockSend(ctx, sock, []wasi.IOVec{message}, 0)
+		assertEqual(t, n, wasi.Size(len(message)))
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		// The socket becomes readable when the ICMP port unreachable error
+		// is received.
+		sockPoll(t, ctx, sys, sock, wasi.FDReadEvent)
+
+		n, errno = sys.SockSend(ctx, sock, []wasi.IOVec{message}, 0)
+		assertEqual(t, n, ^wasi.Size(0))
+		assertEqual(t, errno, wasi.ECONNREFUSED)
+
+		assertEqual(t, sys.FDClose(ctx, sock), wasi.ESUCCESS)
+	}
+}
+
+func testSocketRecvWaitAllStream(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+
+		server, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		serverAddr, errno := sys.SockBind(ctx, server, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.SockListen(ctx, server, 10), wasi.ESUCCESS)
+
+		client, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		setNonBlock(t, ctx, sys, client, false)
+
+		_, errno = sys.SockConnect(ctx, client, serverAddr)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		sockPoll(t, ctx, sys, server, wasi.FDReadEvent)
+
+		// MSG_WAITALL only has an effect on blocking sockets.
+		accept, _, _, errno := sys.SockAccept(ctx, server, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		// Guard against the test hanging forever if the second chunk is
+		// never received.
+		errno = sys.SockSetOpt(ctx, accept, wasi.RecvTimeout, wasi.TimeValue(5*time.Second))
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		chunk1 := []byte("Hello, ")
+		chunk2 := []byte("World!")
+		n, errno := sys.SockSend(ctx, client, []wasi.IOVec{chunk1}, 0)
+		assertEqual(t, n, wasi.Size(len(chunk1)))
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		const delay = 50 * time.Millisecond
+		sent := make(chan wasi.Errno, 1)
+		go func() {
+			time.Sleep(delay)
+			_, errno := sys.SockSend(ctx, client, []wasi.IOVec{chunk2}, 0)
+			sent <- errno
+		}()
+
+		start := time.Now()
+		buffer := make([]byte, len(chunk1)+len(chunk2))
+		n, _, errno = sys.SockRecv(ctx, accept, []wasi.IOVec{buffer}, wasi.RecvWaitAll)
+		elapsed := time.Since(start)
+		assertEqual(t, <-sent, wasi.ESUCCESS)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, n, wasi.Size(len(buffer)))
+		assertEqual(t, string(buffer), "Hello, World!")
+		assertEqual(t, elapsed >= delay, true)
+
+		assertEqual(t, sys.FDClose(ctx, accept), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, client), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, server), wasi.ESUCCESS)
+	}
+}
+
+func testSocketRecvWaitAllDatagram(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+
+		sock, errno := sockOpen(t, ctx, sys, family, wasi.DatagramSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		setNonBlock(t, ctx, sys, sock, false)
+
+		addr, errno := sys.SockBind(ctx, sock, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		// Guard against the test hanging forever if the flag causes the
+		// receive to wait for more datagrams.
+		errno = sys.SockSetOpt(ctx, sock, wasi.RecvTimeout, wasi.TimeValue(5*time.Second))
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		peer, errno := sockOpen(t, ctx, sys, family, wasi.DatagramSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		for _, message := range []string{"Hello, ", "World!"} {
+			n, errno := sys.SockSendTo(ctx, peer, []wasi.IOVec{[]byte(message)}, 0, addr)
+			assertEqual(t, n, wasi.Size(len(message)))
+			assertEqual(t, errno, wasi.ESUCCESS)
+		}
+
+		// Datagram sockets preserve message boundaries, POSIX specifies that
+		// MSG_WAITALL has no effect on them: each receive returns a single
+		// datagram, even if the buffer could hold more data.
+		buffer := make([]byte, 64)
+		for _, message := range []string{"Hello, ", "World!"} {
+			n, _, errno := sys.SockRecv(ctx, sock, []wasi.IOVec{buffer}, wasi.RecvWaitAll)
+			assertEqual(t, errno, wasi.ESUCCESS)
+			assertEqual(t, string(buffer[:n]), message)
+		}
+
+		assertEqual(t, sys.FDClose(ctx, peer), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, sock), wasi.ESUCCESS)
+	}
+}
+
 func testSocketConnectAndAcceptBlocking(family wasi.ProtocolFamily, typ wasi.SocketType, bind wasi.SocketAddress) testFunc {
 	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
 		sys := newSystem(TestConfig{})
@@ -1058,6 +1405,50 @@ func testSocketConnectAndAcceptBlocking(family wasi.ProtocolFamily, typ wasi.Soc
 	}
 }
 
+func testSocketConnectBlockingCompletes(family wasi.ProtocolFamily, typ wasi.SocketType, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+
+		server, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		serverAddr, errno := sys.SockBind(ctx, server, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.SockListen(ctx, server, 10), wasi.ESUCCESS)
+
+		for _, acceptFlags := range []wasi.FDFlags{0, wasi.NonBlock} {
+			client, errno := sockOpen(t, ctx, sys, family, typ, 0)
+			assertEqual(t, errno, wasi.ESUCCESS)
+			setNonBlock(t, ctx, sys, client, false)
+
+			// A blocking connect must wait for the connection to be
+			// established, it never reports EINPROGRESS.
+			clientAddr, errno := sys.SockConnect(ctx, client, serverAddr)
+			assertEqual(t, errno, wasi.ESUCCESS)
+			assertNotEqual(t, clientAddr, nil)
+			assertEqual(t, sockErrno(t, ctx, sys, client), wasi.ESUCCESS)
+			assertEqual(t, sockIsNonBlocking(t, ctx, sys, client), false)
+
+			remoteAddr, errno := sys.SockRemoteAddress(ctx, client)
+			assertEqual(t, errno, wasi.ESUCCESS)
+			assertDeepEqual(t, remoteAddr, serverAddr)
+
+			sockPoll(t, ctx, sys, server, wasi.FDReadEvent)
+
+			// The accepted socket is in the mode requested by the flags,
+			// regardless of the mode of the listening socket.
+			accept, _, _, errno := sys.SockAccept(ctx, server, acceptFlags)
+			assertEqual(t, errno, wasi.ESUCCESS)
+			assertEqual(t, sockIsNonBlocking(t, ctx, sys, accept), acceptFlags.Has(wasi.NonBlock))
+
+			assertEqual(t, sys.FDClose(ctx, accept), wasi.ESUCCESS)
+			assertEqual(t, sys.FDClose(ctx, client), wasi.ESUCCESS)
+		}
+
+		assertEqual(t, sys.FDClose(ctx, server), wasi.ESUCCESS)
+	}
+}
+
 func testSocketConnectAndShutdown(family wasi.ProtocolFamily, typ wasi.SocketType, bind wasi.SocketAddress) testFunc {
 	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
 		sys := newSystem(TestConfig{})
@@ -1455,6 +1846,53 @@ func testSocketShutdownAfterListen(family wasi.ProtocolFamily, typ wasi.SocketTy
 	}
 }
 
+// testSocketShutdownListeningPortability verifies that shutting down any
+// direction of a listening socket fails with ENOTCONN, even with a pending
+// connection, while Linux would otherwise allow it. The listener must not be
+// affected by the attempts, and the connection accepted from it can then be
+// shut down.
+func testSocketShutdownListeningPortability(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+
+		server, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		addr, errno := sys.SockBind(ctx, server, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.SockListen(ctx, server, 10), wasi.ESUCCESS)
+
+		for _, how := range []wasi.SDFlags{wasi.ShutdownRD, wasi.ShutdownWR, wasi.ShutdownRD | wasi.ShutdownWR} {
+			assertEqual(t, sys.SockShutdown(ctx, server, how), wasi.ENOTCONN)
+		}
+
+		client, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		_, errno = sys.SockConnect(ctx, client, addr)
+		assertEqual(t, errno, wasi.EINPROGRESS)
+
+		sockPoll(t, ctx, sys, client, wasi.FDWriteEvent)
+		sockPoll(t, ctx, sys, server, wasi.FDReadEvent)
+
+		// A pending connection does not change the result.
+		for _, how := range []wasi.SDFlags{wasi.ShutdownRD, wasi.ShutdownWR, wasi.ShutdownRD | wasi.ShutdownWR} {
+			assertEqual(t, sys.SockShutdown(ctx, server, how), wasi.ENOTCONN)
+		}
+
+		accept, _, _, errno := sys.SockAccept(ctx, server, wasi.NonBlock)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		assertEqual(t, sys.SockShutdown(ctx, accept, wasi.ShutdownWR), wasi.ESUCCESS)
+		sockPoll(t, ctx, sys, client, wasi.FDReadEvent)
+		assertEqual(t, sys.SockShutdown(ctx, client, wasi.ShutdownRD|wasi.ShutdownWR), wasi.ESUCCESS)
+
+		assertEqual(t, sys.FDClose(ctx, accept), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, client), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, server), wasi.ESUCCESS)
+	}
+}
+
 func testSocketSendAndReceiveStream(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
 	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
 		sys := newSystem(TestConfig{})
@@ -1515,6 +1953,70 @@ func testSocketSendAndReceiveStream(family wasi.ProtocolFamily, bind wasi.Socket
 	}
 }
 
+func testSocketSendAndReceiveEmptyStream(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+		typ := wasi.StreamSocket
+
+		sock, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		addr, errno := sys.SockBind(ctx, sock, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.SockListen(ctx, sock, 10), wasi.ESUCCESS)
+
+		conn1, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		_, errno = sys.SockConnect(ctx, conn1, addr)
+		assertEqual(t, errno, wasi.EINPROGRESS)
+
+		sockPoll(t, ctx, sys, conn1, wasi.FDWriteEvent)
+		sockPoll(t, ctx, sys, sock, wasi.FDReadEvent)
+
+		conn2, _, _, errno := sys.SockAccept(ctx, sock, wasi.NonBlock)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		// No data is available on the socket, zero-length receives do not
+		// report EAGAIN.
+		for _, iovecs := range [][]wasi.IOVec{nil, {}, {nil}, {{}, {}, {}}} {
+			size, errno := sys.SockSend(ctx, conn1, iovecs, 0)
+			assertEqual(t, errno, wasi.ESUCCESS)
+			assertEqual(t, size, 0)
+
+			size, _, errno = sys.SockRecv(ctx, conn2, iovecs, 0)
+			assertEqual(t, errno, wasi.ESUCCESS)
+			assertEqual(t, size, 0)
+
+			size, errno = sys.FDWrite(ctx, conn1, iovecs)
+			assertEqual(t, errno, wasi.ESUCCESS)
+			assertEqual(t, size, 0)
+
+			size, errno = sys.FDRead(ctx, conn2, iovecs)
+			assertEqual(t, errno, wasi.ESUCCESS)
+			assertEqual(t, size, 0)
+		}
+
+		// The data sent after the empty buffers is received unchanged.
+		buffer1 := []byte("Hello, World!")
+		buffer2 := make([]byte, 32)
+
+		size1, errno := sys.FDWrite(ctx, conn1, []wasi.IOVec{buffer1})
+		assertEqual(t, size1, wasi.Size(len(buffer1)))
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		sockPoll(t, ctx, sys, conn2, wasi.FDReadEvent)
+		size2, errno := sys.FDRead(ctx, conn2, []wasi.IOVec{buffer2})
+		assertEqual(t, size2, size1)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, string(buffer2[:size2]), string(buffer1))
+
+		assertEqual(t, sys.FDClose(ctx, conn2), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, conn1), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, sock), wasi.ESUCCESS)
+	}
+}
+
 func testSocketSendAndReceiveStreamBlocking(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
 	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
 		sys := newSystem(TestConfig{})
@@ -1811,6 +2313,51 @@ func testSocketSendToConnectedDatagram(family wasi.ProtocolFamily, bind wasi.Soc
 	}
 }
 
+// testSocketSendToConnected verifies that sending to an address on connected
+// sockets fails with EISCONN on all platforms, whether the address is the one
+// of the peer or another one.
+func testSocketSendToConnected(family wasi.ProtocolFamily, typ wasi.SocketType, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+
+		server, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		addr, errno := sys.SockBind(ctx, server, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		other, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		otherAddr, errno := sys.SockBind(ctx, other, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		client, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		if typ == wasi.StreamSocket {
+			assertEqual(t, sys.SockListen(ctx, server, 1), wasi.ESUCCESS)
+			_, errno = sys.SockConnect(ctx, client, addr)
+			assertEqual(t, errno, wasi.EINPROGRESS)
+			sockPoll(t, ctx, sys, client, wasi.FDWriteEvent)
+		} else {
+			_, errno = sys.SockConnect(ctx, client, addr)
+			assertEqual(t, errno, wasi.ESUCCESS)
+		}
+
+		buffer := []byte("Hello, World!")
+		for _, to := range []wasi.SocketAddress{addr, otherAddr} {
+			size, errno := sys.SockSendTo(ctx, client, []wasi.IOVec{buffer}, 0, to)
+			assertEqual(t, size, wasi.Size(0))
+			assertEqual(t, errno, wasi.EISCONN)
+		}
+
+		assertEqual(t, sys.FDClose(ctx, client), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, other), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, server), wasi.ESUCCESS)
+	}
+}
+
 func testSocketSendAndReceiveConnectedDatagramBlocking(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
 	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
 		sys := newSystem(TestConfig{})
@@ -2088,6 +2635,35 @@ func testSocketSendDatagramToNowhere(family wasi.ProtocolFamily, addr wasi.Socke
 	}
 }
 
+func testSocketSendDatagramRefused(family wasi.ProtocolFamily, addr wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+		typ := wasi.DatagramSocket
+		msg := []byte("Hello, World!")
+
+		sock, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		_, errno = sys.SockConnect(ctx, sock, addr)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		size, errno := sys.SockSend(ctx, sock, []wasi.IOVec{msg}, 0)
+		assertEqual(t, size, wasi.Size(len(msg)))
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		// Nothing listens on the port, the ICMP error received in response
+		// to the message is reported asynchronously on the socket, which
+		// becomes ready for reading.
+		sockPoll(t, ctx, sys, sock, wasi.FDReadEvent)
+
+		// Reading the error clears it.
+		assertEqual(t, sockErrno(t, ctx, sys, sock), wasi.ECONNREFUSED)
+		assertEqual(t, sockErrno(t, ctx, sys, sock), wasi.ESUCCESS)
+
+		assertEqual(t, sys.FDClose(ctx, sock), wasi.ESUCCESS)
+	}
+}
+
 func testSocketBindAfterSendDatagram(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
 	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
 		sys := newSystem(TestConfig{})
@@ -2213,13 +2789,30 @@ func testSocketSendAndReceiveLargerThanSendBufferSize(family wasi.ProtocolFamily
 		conn, errno := sockOpen(t, ctx, sys, family, typ, 0)
 		assertEqual(t, errno, wasi.ESUCCESS)
 
-		sendBufferSize := sockOption[wasi.IntValue](t, ctx, sys, conn, wasi.RecvBufferSize)
-		buffer1 := bytes.Repeat([]byte{'@'}, int(sendBufferSize+1))
+		// The message is larger than both the send buffer and the maximum
+		// size of IP packets, so the kernel rejects it whatever the buffer
+		// configuration is.
+		const maxDatagramSize = 65535
+		sendBufferSize := sockOption[wasi.IntValue](t, ctx, sys, conn, wasi.SendBufferSize)
+		buffer1 := bytes.Repeat([]byte{'@'}, max(int(sendBufferSize), maxDatagramSize)+1)
 
 		size1, errno := sys.SockSendTo(ctx, conn, []wasi.IOVec{buffer1}, 0, sockAddr)
 		assertEqual(t, size1, 0)
 		assertEqual(t, errno, wasi.EMSGSIZE)
 
+		// The error is the same when the buffer is split in multiple iovecs
+		// or sent on a connected socket.
+		half := len(buffer1) / 2
+		size2, errno := sys.SockSendTo(ctx, conn, []wasi.IOVec{buffer1[:half], buffer1[half:]}, 0, sockAddr)
+		assertEqual(t, size2, 0)
+		assertEqual(t, errno, wasi.EMSGSIZE)
+
+		_, errno = sys.SockConnect(ctx, conn, sockAddr)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		_, errno = sys.SockSend(ctx, conn, []wasi.IOVec{buffer1}, 0)
+		assertEqual(t, errno, wasi.EMSGSIZE)
+
 		assertEqual(t, sys.FDClose(ctx, conn), wasi.ESUCCESS)
 		assertEqual(t, sys.FDClose(ctx, sock), wasi.ESUCCESS)
 	}
@@ -2320,6 +2913,78 @@ func testSocketSetBufferSizes(family wasi.ProtocolFamily, typ wasi.SocketType) t
 	}
 }
 
+func testSocketSetTrafficClass(family wasi.ProtocolFamily, option wasi.SocketOption) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+		sock, errno := sockOpen(t, ctx, sys, family, wasi.DatagramSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		// DSCP class selector 0x10 (low delay).
+		const tos = wasi.IntValue(0x10)
+		assertEqual(t, sys.SockSetOpt(ctx, sock, option, tos), wasi.ESUCCESS)
+		assertEqual(t, sockOption[wasi.IntValue](t, ctx, sys, sock, option), tos)
+		assertEqual(t, sys.FDClose(ctx, sock), wasi.ESUCCESS)
+	}
+}
+
+func testSocketCork(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+		typ := wasi.StreamSocket
+
+		sock, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		addr, errno := sys.SockBind(ctx, sock, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.SockListen(ctx, sock, 10), wasi.ESUCCESS)
+
+		conn1, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		_, errno = sys.SockConnect(ctx, conn1, addr)
+		assertEqual(t, errno, wasi.EINPROGRESS)
+
+		sockPoll(t, ctx, sys, conn1, wasi.FDWriteEvent)
+		sockPoll(t, ctx, sys, sock, wasi.FDReadEvent)
+
+		conn2, _, _, errno := sys.SockAccept(ctx, sock, wasi.NonBlock)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		errno = sys.SockSetOpt(ctx, conn1, wasi.TcpCork, wasi.IntValue(1))
+		if errno == wasi.ENOPROTOOPT {
+			t.Skip("TCP cork is not supported on this system")
+		}
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertNotEqual(t, sockOption[wasi.IntValue](t, ctx, sys, conn1, wasi.TcpCork), 0)
+
+		const message = "Hello, World!"
+		for _, b := range []string{"Hello", ", ", "World!"} {
+			n, errno := sys.FDWrite(ctx, conn1, []wasi.IOVec{[]byte(b)})
+			assertEqual(t, errno, wasi.ESUCCESS)
+			assertEqual(t, n, wasi.Size(len(b)))
+		}
+
+		assertEqual(t, sys.SockSetOpt(ctx, conn1, wasi.TcpCork, wasi.IntValue(0)), wasi.ESUCCESS)
+		assertEqual(t, sockOption[wasi.IntValue](t, ctx, sys, conn1, wasi.TcpCork), 0)
+
+		buffer := make([]byte, 32)
+		received := 0
+		for received < len(message) {
+			sockPoll(t, ctx, sys, conn2, wasi.FDReadEvent)
+			n, errno := sys.FDRead(ctx, conn2, []wasi.IOVec{buffer[received:]})
+			assertEqual(t, errno, wasi.ESUCCESS)
+			assertNotEqual(t, n, 0)
+			received += int(n)
+		}
+		assertEqual(t, string(buffer[:received]), message)
+
+		assertEqual(t, sys.FDClose(ctx, conn2), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, conn1), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, sock), wasi.ESUCCESS)
+	}
+}
+
 func testSocketSetOptionInvalidLevel(family wasi.ProtocolFamily, typ wasi.SocketType) testFunc {
 	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
 		sys := newSystem(TestConfig{})
@@ -2395,6 +3060,11 @@ func sockPoll(t *testing.T, ctx context.Context, sys wasi.System, sock wasi.FD,
 	numEvents, errno := sys.PollOneOff(ctx, subs, evs)
 	assertEqual(t, numEvents, 1)
 	assertEqual(t, errno, wasi.ESUCCESS)
+	// Whether read events on sockets shut down by the peer have the Hangup
+	// flag set depends on the platform, it is not verified here.
+	if eventType == wasi.FDReadEvent {
+		evs[0].FDReadWrite.Flags &^= wasi.Hangup
+	}
 	assertEqual(t, evs[0], wasi.Event{
 		UserData:  wasi.UserData(sock + 1),
 		EventType: eventType,
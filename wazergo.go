@@ -98,7 +98,11 @@ func (t Timestamp) Format(w io.Writer) {
 }
 
 func (c SubscriptionFDReadWrite) Format(w io.Writer) {
-	fmt.Fprintf(w, `{FD:%d}`, c.FD)
+	if c.Flags != 0 {
+		fmt.Fprintf(w, `{FD:%d,Flags:%s}`, c.FD, c.Flags)
+	} else {
+		fmt.Fprintf(w, `{FD:%d}`, c.FD)
+	}
 }
 
 func (c SubscriptionClock) Format(w io.Writer) {